@@ -7,6 +7,18 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"metrio.net/fougere-lite/internal/client"
+	_ "metrio.net/fougere-lite/internal/gcp/bigquery"
+	_ "metrio.net/fougere-lite/internal/gcp/cloudpubsub"
+	_ "metrio.net/fougere-lite/internal/gcp/cloudrun"
+	_ "metrio.net/fougere-lite/internal/gcp/cloudscheduler"
+	_ "metrio.net/fougere-lite/internal/gcp/cloudstorage"
+	_ "metrio.net/fougere-lite/internal/gcp/cloudtasks"
+	_ "metrio.net/fougere-lite/internal/gcp/firestore"
+	_ "metrio.net/fougere-lite/internal/gcp/iam"
+	_ "metrio.net/fougere-lite/internal/gcp/kms"
+	_ "metrio.net/fougere-lite/internal/gcp/logging"
+	_ "metrio.net/fougere-lite/internal/gcp/monitoring"
+	_ "metrio.net/fougere-lite/internal/gcp/secretmanager"
 	"metrio.net/fougere-lite/internal/utils"
 )
 
@@ -36,6 +48,7 @@ func initCobra() {
 	cobra.OnInitialize(initConfig)
 	root.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "config file")
 	root.AddCommand(client.NewClientsCommand())
+	root.AddCommand(client.NewStateCommand())
 }
 
 func initConfig() {
@@ -3,83 +3,378 @@ package client
 import (
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"google.golang.org/api/option"
-	"metrio.net/fougere-lite/internal/gcp/cloudstorage"
-	"metrio.net/fougere-lite/internal/gcp/cloudtasks"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/plan"
+	"metrio.net/fougere-lite/internal/provider"
+	"metrio.net/fougere-lite/internal/state"
 	"metrio.net/fougere-lite/internal/utils"
 )
 
 type ClientsCommand struct {
-	cloudStorageClient *cloudstorage.Client
-	cloudtasksClient   *cloudtasks.Client
-	clientConfigs      []ProductConfig
+	clientConfigs []ProductConfig
+	state         *state.State
 }
 
+// ProductConfig is the config of a client, indexed by product key. Products
+// absent from the client's config have no entry.
 type ProductConfig struct {
-	Client        string               `json:"client" validate:"omitempty"`
-	StorageBucket *cloudstorage.Config `json:"storageBucket" validate:"omitempty,dive"`
-	TaskQueue     *cloudtasks.Config   `json:"cloudTasks" validate:"omitempty,dive"`
+	Client   string
+	Products map[string]provider.Config
 }
 
 func NewClientsCommand() *cobra.Command {
 	c := &ClientsCommand{}
+	var planOut string
+	var pruneMode string
+	var driftOutput string
+	var exportOut string
+	var applyOptions provider.ApplyOptions
+	var importOptions struct {
+		projectId string
+		region    string
+		client    string
+	}
+	var deleteOptions struct {
+		force       bool
+		destroyKeys bool
+		dryRun      bool
+		yes         bool
+	}
 	cmd := &cobra.Command{
 		Use:   "clients",
 		Short: "interacts with the GCP infrastructure components",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			common.SetRunLabels(viper.ConfigFileUsed(), cmd.Root().Version)
+		},
 	}
+	addStateFlag(cmd)
 	createCmd := &cobra.Command{
 		Use:   "create",
 		Short: "create the client components",
+		Long: `Creates or updates the client components of the config.
+
+With --prune, the buckets labeled with a client of the config in the projects of
+the config that are no longer declared are reported (report) or deleted (delete).`,
+		Run: func(cmd *cobra.Command, args []string) {
+			utils.CheckErr(validatePruneMode(pruneMode))
+			utils.CheckErr(c.getConfig())
+			utils.CheckErr(c.initClients())
+			c.setApplyOptions(applyOptions)
+			utils.CheckErr(c.withState(func() error {
+				applied, err := c.createClients()
+				if err := c.recordApplied(applied); err != nil {
+					return err
+				}
+				if err != nil {
+					return err
+				}
+				return c.pruneClients(pruneMode)
+			}))
+		},
+	}
+	createCmd.Flags().StringVar(&pruneMode, "prune", pruneOff, "off, report or delete the managed resources that are no longer declared")
+	createCmd.Flags().BoolVar(&applyOptions.ConfirmRetentionLock, "confirm-retention-lock", false, "lock the retention policies declared as locked, this cannot be undone")
+	createCmd.Flags().BoolVar(&applyOptions.EnforceSecureDefaults, "enforce-secure-defaults", false, "fail if a bucket would be public without allowPublic")
+
+	planCmd := &cobra.Command{
+		Use:   "plan",
+		Short: "show the changes create would make to the client components",
+		Long: `Compares the client components of the config with their live state in GCP
+and prints the resources that would be created or updated.
+
+Exits with code 0 when nothing would change, 2 when there are changes and 1 on error.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			utils.CheckErr(c.getConfig())
+			utils.CheckErr(c.initClients())
+			c.setApplyOptions(applyOptions)
+			changes, err := c.planClients()
+			utils.CheckErr(err)
+			printPlan(os.Stdout, changes)
+			if planOut != "" {
+				savedPlan, err := plan.New(viper.ConfigFileUsed(), changes)
+				utils.CheckErr(err)
+				utils.CheckErr(savedPlan.Write(planOut))
+				fmt.Printf("Saved the plan to %s\n", planOut)
+			}
+			if code := planExitCode(changes); code != 0 {
+				os.Exit(code)
+			}
+		},
+	}
+	planCmd.Flags().StringVarP(&planOut, "out", "o", "", "write the plan to this file so it can be run by apply")
+	planCmd.Flags().BoolVar(&applyOptions.EnforceSecureDefaults, "enforce-secure-defaults", false, "fail if a bucket would be public without allowPublic")
+
+	applyCmd := &cobra.Command{
+		Use:   "apply PLAN_FILE",
+		Short: "run the operations of a plan saved by plan --out",
+		Long: `Runs exactly the operations of a saved plan.
+
+Refuses to run if the config file or the live state of any resource of the plan
+changed since the plan was made.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			savedPlan, err := plan.Read(args[0])
+			utils.CheckErr(err)
+			utils.CheckErr(c.initClients())
+			c.setApplyOptions(applyOptions)
+			utils.CheckErr(c.withState(func() error {
+				return c.applyPlan(savedPlan)
+			}))
+		},
+	}
+	applyCmd.Flags().BoolVar(&applyOptions.ConfirmRetentionLock, "confirm-retention-lock", false, "lock the retention policies planned to be locked, this cannot be undone")
+
+	deleteCmd := &cobra.Command{
+		Use:   "delete [CLIENT...]",
+		Short: "delete the client components",
+		Long: `Deletes every bucket and queue declared for the given clients, or for every
+client of the config when none is given.
+
+Buckets, datasets and secrets that are not empty are only deleted with --force,
+which deletes their objects, tables and versions first.
+
+Key rings and crypto keys are never deleted by GCP. --destroy-keys schedules the
+destruction of the versions of the crypto keys, after which the data encrypted
+with them cannot be decrypted anymore.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			utils.CheckErr(c.getConfig())
+			clientConfigs, err := c.selectClients(args)
+			utils.CheckErr(err)
+			opts := provider.DeleteOptions{Force: deleteOptions.force, DestroyKeys: deleteOptions.destroyKeys}
+			printDeletion(os.Stdout, clientConfigs, opts)
+			if deleteOptions.dryRun {
+				return
+			}
+			if !deleteOptions.yes && !confirm(os.Stdin, os.Stdout, "Do you really want to delete these resources? This cannot be undone.") {
+				fmt.Println("Delete cancelled.")
+				return
+			}
+			utils.CheckErr(c.initClients())
+			utils.CheckErr(c.withState(func() error {
+				return c.deleteClients(clientConfigs, opts)
+			}))
+		},
+	}
+	deleteCmd.Flags().BoolVar(&deleteOptions.force, "force", false, "delete the objects, tables and versions of the buckets, datasets and secrets before deleting them")
+	deleteCmd.Flags().BoolVar(&deleteOptions.destroyKeys, "destroy-keys", false, "destroy the versions of the crypto keys, the data encrypted with them is lost")
+	deleteCmd.Flags().BoolVar(&deleteOptions.dryRun, "dry-run", false, "only list the resources that would be deleted")
+	deleteCmd.Flags().BoolVarP(&deleteOptions.yes, "yes", "y", false, "do not ask for confirmation")
+
+	driftCmd := &cobra.Command{
+		Use:   "drift",
+		Short: "report the client components changed outside of fougere-lite",
+		Long: `Compares every declared bucket and queue with its live state in GCP and lists
+the properties that differ from the config, for example a rate limit edited in
+the console.
+
+Exits with code 0 when everything matches the config, 3 when a resource is
+missing or drifted and 1 on error.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			utils.CheckErr(validateOutput(driftOutput))
+			utils.CheckErr(c.getConfig())
+			utils.CheckErr(c.initClients())
+			changes, err := c.planClients()
+			utils.CheckErr(err)
+			report := newDriftReport(changes)
+			utils.CheckErr(printDriftReport(os.Stdout, report, driftOutput))
+			if code := driftExitCode(report); code != 0 {
+				os.Exit(code)
+			}
+		},
+	}
+	driftCmd.Flags().StringVarP(&driftOutput, "output", "o", outputTable, "output format, table or json")
+
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "print the config of the existing buckets and queues of a project",
+		Long: `Lists the buckets of a project and the queues of a region and prints them as a
+clients config that can be merged into the config file.
+
+Bucket names are mapped back to their client and key with the
+<client>-<key>-<project> naming convention. Queue names do not carry their
+client, so queues are only imported with --client.`,
 		Run: func(cmd *cobra.Command, args []string) {
-			c.getConfig()
-			c.initClients()
-			c.createClients()
+			utils.CheckErr(c.initClients())
+			clients, err := c.importResources(importOptions.projectId, importOptions.region, importOptions.client)
+			utils.CheckErr(err)
+			utils.CheckErr(writeYAML(os.Stdout, clients))
 		},
 	}
+	importCmd.Flags().StringVar(&importOptions.projectId, "project", "", "project to import the resources of")
+	importCmd.Flags().StringVar(&importOptions.region, "region", "", "region to import the queues of")
+	importCmd.Flags().StringVar(&importOptions.client, "client", "", "only import the resources of this client")
+	utils.CheckErr(importCmd.MarkFlagRequired("project"))
+	utils.CheckErr(importCmd.MarkFlagRequired("region"))
+
+	exportCmd := &cobra.Command{
+		Use:   "export CLIENT",
+		Short: "print the live config of the components of a client",
+		Long: `Reads the live state of every bucket and queue declared for a client and prints
+it as a clients config, with the values as deployed in GCP.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			utils.CheckErr(c.getConfig())
+			clientConfigs, err := c.selectClients(args)
+			utils.CheckErr(err)
+			utils.CheckErr(c.initClients())
+			clients, err := c.exportClient(clientConfigs[0])
+			utils.CheckErr(err)
+			out := os.Stdout
+			if exportOut != "" {
+				out, err = os.Create(exportOut)
+				utils.CheckErr(err)
+				defer out.Close()
+			}
+			utils.CheckErr(writeYAML(out, clients))
+		},
+	}
+	exportCmd.Flags().StringVarP(&exportOut, "out", "o", "", "write the config to this file instead of the standard output")
 
 	cmd.AddCommand(createCmd)
+	cmd.AddCommand(planCmd)
+	cmd.AddCommand(driftCmd)
+	cmd.AddCommand(applyCmd)
+	cmd.AddCommand(deleteCmd)
+	cmd.AddCommand(importCmd)
+	cmd.AddCommand(exportCmd)
 	return cmd
 }
 
-func (c *ClientsCommand) createClients() {
+// createClients returns the resources that were applied, including when it
+// stops on an error, so that they can be recorded in the state.
+func (c *ClientsCommand) createClients() ([]common.AppliedResource, error) {
+	var applied []common.AppliedResource
 	for _, clientConfig := range c.clientConfigs {
-		clientConfig := clientConfig
-		if clientConfig.StorageBucket != nil {
-			utils.CheckErr(cloudstorage.ValidateConfig(clientConfig.StorageBucket))
-			if err := c.cloudStorageClient.Create(clientConfig.StorageBucket); err != nil {
-				utils.CheckErr(err)
+		for _, p := range provider.All() {
+			config, ok := clientConfig.Products[p.Key()]
+			if !ok {
+				continue
+			}
+			if err := p.ValidateConfig(config); err != nil {
+				return applied, err
+			}
+			productApplied, err := p.Create(config)
+			applied = append(applied, productApplied...)
+			if err != nil {
+				return applied, err
 			}
 		}
-		if clientConfig.TaskQueue != nil {
-			utils.CheckErr(cloudtasks.ValidateConfig(clientConfig.TaskQueue))
-			if err := c.cloudtasksClient.Create(clientConfig.TaskQueue); err != nil {
-				utils.CheckErr(err)
+	}
+	return applied, nil
+}
+
+// deleteClients deletes the products in the reverse order of their creation,
+// so that no resource is deleted while another one still references it.
+func (c *ClientsCommand) deleteClients(clientConfigs []ProductConfig, opts provider.DeleteOptions) error {
+	providers := provider.All()
+	for _, clientConfig := range clientConfigs {
+		for i := len(providers) - 1; i >= 0; i-- {
+			p := providers[i]
+			config, ok := clientConfig.Products[p.Key()]
+			if !ok {
+				continue
+			}
+			if err := p.Delete(config, opts); err != nil {
+				return err
+			}
+			for _, resource := range p.Resources(config) {
+				c.state.Remove(clientConfig.Client, state.ResourceID(resource.Product, resource.Key))
 			}
 		}
 	}
+	return nil
 }
 
-func (c *ClientsCommand) initClients() error {
-	ctx := context.Background()
-	var options []option.ClientOption
+func (c *ClientsCommand) planClients() ([]common.ResourceChange, error) {
+	var changes []common.ResourceChange
+	for _, clientConfig := range c.clientConfigs {
+		for _, p := range provider.All() {
+			config, ok := clientConfig.Products[p.Key()]
+			if !ok {
+				continue
+			}
+			if err := p.ValidateConfig(config); err != nil {
+				return nil, err
+			}
+			productChanges, err := p.Plan(config)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, productChanges...)
+		}
+	}
+	sortChanges(changes)
+	return changes, nil
+}
 
-	cloudStorageClient, err := cloudstorage.NewClient(ctx, options...)
-	if err != nil {
+func (c *ClientsCommand) applyPlan(savedPlan *plan.Plan) error {
+	configFile := viper.ConfigFileUsed()
+	if configFile == "" {
+		configFile = savedPlan.ConfigFile
+	}
+	if err := savedPlan.VerifyConfig(configFile); err != nil {
 		return err
 	}
-	c.cloudStorageClient = cloudStorageClient
+	for _, change := range savedPlan.Changes {
+		if err := c.verifyChange(change); err != nil {
+			return err
+		}
+	}
+	for _, change := range savedPlan.Changes {
+		applied, err := c.applyChange(change)
+		if err != nil {
+			return err
+		}
+		if applied != nil {
+			if err := c.recordApplied([]common.AppliedResource{*applied}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
 
-	cloudtasksClient, err := cloudtasks.NewClient(ctx, options...)
-	if err != nil {
-		return err
+func (c *ClientsCommand) verifyChange(change common.ResourceChange) error {
+	p, ok := provider.Get(change.Product)
+	if !ok {
+		return fmt.Errorf("unknown product %s in plan", change.Product)
 	}
-	c.cloudtasksClient = cloudtasksClient
+	return p.Verify(change)
+}
 
+func (c *ClientsCommand) applyChange(change common.ResourceChange) (*common.AppliedResource, error) {
+	p, ok := provider.Get(change.Product)
+	if !ok {
+		return nil, fmt.Errorf("unknown product %s in plan", change.Product)
+	}
+	return p.Apply(change)
+}
+
+func (c *ClientsCommand) initClients() error {
+	ctx := context.Background()
+	var options []option.ClientOption
+
+	for _, p := range provider.All() {
+		if err := p.Init(ctx, options...); err != nil {
+			return err
+		}
+	}
 	return nil
+}
 
+// setApplyOptions passes the options of create, plan and apply to the providers
+// that use them.
+func (c *ClientsCommand) setApplyOptions(opts provider.ApplyOptions) {
+	for _, p := range provider.All() {
+		if configurable, ok := p.(provider.Configurable); ok {
+			configurable.SetApplyOptions(opts)
+		}
+	}
 }
 
 func (c *ClientsCommand) getConfig() error {
@@ -90,14 +385,16 @@ func (c *ClientsCommand) getConfig() error {
 	for client := range clients {
 		clientViper := viper.Sub(fmt.Sprintf("clients.%s", client))
 		config := ProductConfig{
-			Client: client,
+			Client:   client,
+			Products: map[string]provider.Config{},
+		}
+		for _, p := range provider.All() {
+			productConfig, err := p.GetConfig(clientViper, client)
+			utils.CheckErr(err)
+			if productConfig != nil {
+				config.Products[p.Key()] = productConfig
+			}
 		}
-		storageConfig, err := cloudstorage.GetStorageConfig(clientViper, client)
-		utils.CheckErr(err)
-		config.StorageBucket = storageConfig
-		taskConfig, err := cloudtasks.GetTaskConfig(clientViper, client)
-		utils.CheckErr(err)
-		config.TaskQueue = taskConfig
 		c.clientConfigs = append(c.clientConfigs, config)
 	}
 	return nil
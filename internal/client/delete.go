@@ -0,0 +1,68 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"metrio.net/fougere-lite/internal/provider"
+)
+
+// selectClients returns the configs of the named clients, or of every client
+// when no name is given.
+func (c *ClientsCommand) selectClients(names []string) ([]ProductConfig, error) {
+	if len(names) == 0 {
+		return c.clientConfigs, nil
+	}
+	byName := map[string]ProductConfig{}
+	for _, clientConfig := range c.clientConfigs {
+		byName[clientConfig.Client] = clientConfig
+	}
+	var selected []ProductConfig
+	for _, name := range names {
+		clientConfig, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("client %s is not defined in the config", name)
+		}
+		selected = append(selected, clientConfig)
+	}
+	return selected, nil
+}
+
+// printDeletion lists the resources that deleting the clients would remove,
+// and what happens to the ones that are not simply deleted.
+func printDeletion(w io.Writer, clientConfigs []ProductConfig, opts provider.DeleteOptions) {
+	var lines []string
+	for _, clientConfig := range clientConfigs {
+		for _, p := range provider.All() {
+			config, ok := clientConfig.Products[p.Key()]
+			if !ok {
+				continue
+			}
+			describer, describes := p.(provider.DeleteDescriber)
+			for _, resource := range p.Resources(config) {
+				action := "delete"
+				if describes {
+					action = describer.DescribeDelete(resource, opts)
+				}
+				lines = append(lines, fmt.Sprintf("- %s %s/%s.%s (%s)", action, clientConfig.Client, resource.Product, resource.Key, resource.Name))
+			}
+		}
+	}
+	sort.Strings(lines)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// confirm asks the user to type "yes" to go on.
+func confirm(in io.Reader, out io.Writer, prompt string) bool {
+	fmt.Fprintf(out, "%s\nOnly 'yes' will be accepted to confirm: ", prompt)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false
+	}
+	return strings.TrimSpace(answer) == "yes"
+}
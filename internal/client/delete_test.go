@@ -0,0 +1,65 @@
+package client
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"metrio.net/fougere-lite/internal/gcp/cloudstorage"
+	"metrio.net/fougere-lite/internal/gcp/kms"
+	"metrio.net/fougere-lite/internal/provider"
+)
+
+var _ = Describe("delete", func() {
+	var clientConfigs []ProductConfig
+
+	BeforeEach(func() {
+		keyRingName := "projects/projet-123/locations/us/keyRings/banane-main"
+		clientConfigs = []ProductConfig{{
+			Client: "banane",
+			Products: map[string]provider.Config{
+				cloudstorage.Product: &cloudstorage.Config{StorageBuckets: map[string]cloudstorage.StorageBucket{
+					"patate": {Name: "banane-patate-projet-123", ProjectId: "projet-123", ClientName: "banane"},
+				}},
+				kms.Product: &kms.Config{Kms: kms.Kms{
+					KeyRings: map[string]kms.KeyRing{
+						"main": {Name: "banane-main", ProjectId: "projet-123", Location: "us", ClientName: "banane"},
+					},
+					CryptoKeys: map[string]kms.CryptoKey{
+						"buckets": {Name: "buckets", KeyRing: "main", KeyRingName: keyRingName, ProjectId: "projet-123", ClientName: "banane"},
+					},
+				}},
+			},
+		}}
+	})
+
+	Describe("printDeletion", func() {
+		It("shows that the key rings and crypto keys are kept", func() {
+			var out bytes.Buffer
+			printDeletion(&out, clientConfigs, provider.DeleteOptions{Force: true})
+			Expect(out.String()).To(Equal("- delete banane/storageBucket.patate (banane-patate-projet-123)\n" +
+				"- keep banane/kms.cryptoKeys.buckets (projects/projet-123/locations/us/keyRings/banane-main/cryptoKeys/buckets)\n" +
+				"- keep banane/kms.keyRings.main (projects/projet-123/locations/us/keyRings/banane-main)\n"))
+		})
+		It("shows the destruction of the versions of the crypto keys with DestroyKeys", func() {
+			var out bytes.Buffer
+			printDeletion(&out, clientConfigs, provider.DeleteOptions{DestroyKeys: true})
+			Expect(out.String()).To(ContainSubstring("- destroy the versions of banane/kms.cryptoKeys.buckets"))
+			Expect(out.String()).To(ContainSubstring("- keep banane/kms.keyRings.main"))
+		})
+	})
+	Describe("selectClients", func() {
+		It("returns the named clients or every client", func() {
+			c := &ClientsCommand{clientConfigs: append(clientConfigs, ProductConfig{Client: "pomme"})}
+			selected, err := c.selectClients([]string{"pomme"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(selected).To(HaveLen(1))
+			Expect(selected[0].Client).To(Equal("pomme"))
+			selected, err = c.selectClients(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(selected).To(HaveLen(2))
+			_, err = c.selectClients([]string{"kiwi"})
+			Expect(err).To(MatchError("client kiwi is not defined in the config"))
+		})
+	})
+})
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"metrio.net/fougere-lite/internal/common"
+)
+
+// exitCodeDrift is the exit code of `clients drift` when at least one resource
+// is missing or differs from the config.
+const exitCodeDrift = 3
+
+// Statuses of a resource in a drift report.
+const (
+	driftInSync  = "in-sync"
+	driftDrifted = "drifted"
+	driftMissing = "missing"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+type driftReport struct {
+	CheckedAt time.Time       `json:"checkedAt"`
+	Drifted   bool            `json:"drifted"`
+	Resources []driftResource `json:"resources"`
+}
+
+type driftResource struct {
+	Client     string             `json:"client"`
+	Product    string             `json:"product"`
+	Key        string             `json:"key"`
+	Name       string             `json:"name"`
+	Status     string             `json:"status"`
+	Properties []common.FieldDiff `json:"properties,omitempty"`
+}
+
+// newDriftReport turns the changes create would make into the differences
+// between GCP and the config.
+func newDriftReport(changes []common.ResourceChange) driftReport {
+	report := driftReport{
+		CheckedAt: time.Now().UTC(),
+		Resources: []driftResource{},
+	}
+	for _, change := range changes {
+		resource := driftResource{
+			Client:     change.Client,
+			Product:    change.Product,
+			Key:        change.Key,
+			Name:       change.Name,
+			Status:     driftInSync,
+			Properties: change.Diffs,
+		}
+		switch change.Action {
+		case common.ActionCreate:
+			resource.Status = driftMissing
+		case common.ActionUpdate:
+			resource.Status = driftDrifted
+		}
+		if resource.Status != driftInSync {
+			report.Drifted = true
+		}
+		report.Resources = append(report.Resources, resource)
+	}
+	return report
+}
+
+// driftExitCode returns exitCodeDrift when a resource is missing or drifted,
+// and 0 otherwise.
+func driftExitCode(report driftReport) int {
+	if report.Drifted {
+		return exitCodeDrift
+	}
+	return 0
+}
+
+func validateOutput(output string) error {
+	switch output {
+	case outputTable, outputJSON:
+		return nil
+	}
+	return fmt.Errorf("invalid output %s, expected %s or %s", output, outputTable, outputJSON)
+}
+
+func printDriftReport(w io.Writer, report driftReport, output string) error {
+	if output == outputJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(data))
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLIENT\tRESOURCE\tSTATUS\tPROPERTY\tCONFIG\tGCP")
+	for _, resource := range report.Resources {
+		id := resource.Product + "." + resource.Key
+		if len(resource.Properties) == 0 {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t\t\t\n", resource.Client, id, resource.Status)
+			continue
+		}
+		for _, property := range resource.Properties {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%v\t%v\n", resource.Client, id, resource.Status, property.Field, property.Desired, property.Current)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"metrio.net/fougere-lite/internal/common"
+)
+
+var _ = Describe("drift", func() {
+	var changes []common.ResourceChange
+
+	BeforeEach(func() {
+		changes = []common.ResourceChange{
+			{Client: "banane", Product: "storageBucket", Key: "patate", Name: "banane-patate-projet-123", Action: common.ActionNoop},
+			{Client: "banane", Product: "cloudTasks", Key: "queue1", Name: "banane-queue1", Action: common.ActionUpdate,
+				Diffs: []common.FieldDiff{{Field: "rateLimits.maxDispatchesPerSecond", Current: 100, Desired: 500}}},
+			{Client: "banane", Product: "pubsub", Key: "topics.events", Name: "banane-events", Action: common.ActionCreate},
+		}
+	})
+
+	Describe("newDriftReport", func() {
+		It("reports the missing and drifted resources", func() {
+			report := newDriftReport(changes)
+			Expect(report.Drifted).To(BeTrue())
+			Expect(report.Resources).To(HaveLen(3))
+			Expect(report.Resources[0].Status).To(Equal(driftInSync))
+			Expect(report.Resources[1].Status).To(Equal(driftDrifted))
+			Expect(report.Resources[1].Properties).To(Equal(changes[1].Diffs))
+			Expect(report.Resources[2].Status).To(Equal(driftMissing))
+		})
+		It("is not drifted when every resource is in sync", func() {
+			report := newDriftReport(changes[:1])
+			Expect(report.Drifted).To(BeFalse())
+		})
+	})
+	Describe("driftExitCode", func() {
+		It("returns exitCodeDrift when a resource is missing or drifted", func() {
+			Expect(driftExitCode(newDriftReport(changes))).To(Equal(exitCodeDrift))
+			Expect(driftExitCode(newDriftReport(changes[2:]))).To(Equal(exitCodeDrift))
+		})
+		It("returns 0 when every resource is in sync", func() {
+			Expect(driftExitCode(newDriftReport(changes[:1]))).To(Equal(0))
+			Expect(driftExitCode(newDriftReport(nil))).To(Equal(0))
+		})
+	})
+	Describe("validateOutput", func() {
+		It("accepts table and json only", func() {
+			Expect(validateOutput(outputTable)).To(Succeed())
+			Expect(validateOutput(outputJSON)).To(Succeed())
+			Expect(validateOutput("yaml")).To(MatchError("invalid output yaml, expected table or json"))
+		})
+	})
+	Describe("printDriftReport", func() {
+		It("prints a row per property in a table", func() {
+			var out bytes.Buffer
+			Expect(printDriftReport(&out, newDriftReport(changes[:2]), outputTable)).To(Succeed())
+			Expect(out.String()).To(Equal(
+				"CLIENT  RESOURCE              STATUS   PROPERTY                           CONFIG  GCP\n" +
+					"banane  storageBucket.patate  in-sync                                             \n" +
+					"banane  cloudTasks.queue1     drifted  rateLimits.maxDispatchesPerSecond  500     100\n"))
+		})
+		It("prints the report as JSON", func() {
+			var out bytes.Buffer
+			report := newDriftReport(changes)
+			Expect(printDriftReport(&out, report, outputJSON)).To(Succeed())
+			var printed map[string]interface{}
+			Expect(json.Unmarshal(out.Bytes(), &printed)).To(Succeed())
+			Expect(printed["drifted"]).To(BeTrue())
+			Expect(printed["resources"]).To(HaveLen(3))
+			Expect(printed["resources"].([]interface{})[1]).To(Equal(map[string]interface{}{
+				"client":     "banane",
+				"product":    "cloudTasks",
+				"key":        "queue1",
+				"name":       "banane-queue1",
+				"status":     "drifted",
+				"properties": []interface{}{map[string]interface{}{"field": "rateLimits.maxDispatchesPerSecond", "current": float64(100), "desired": float64(500)}},
+			}))
+		})
+	})
+})
@@ -0,0 +1,29 @@
+package client
+
+import (
+	"metrio.net/fougere-lite/internal/provider"
+)
+
+// exportClient reads the live state of every declared resource of a client and
+// returns it in the schema of the config.
+func (c *ClientsCommand) exportClient(clientConfig ProductConfig) (yamlClients, error) {
+	clients := yamlClients{clientConfig.Client: map[string]interface{}{}}
+	for _, p := range provider.All() {
+		exporter, ok := p.(provider.Exporter)
+		if !ok {
+			continue
+		}
+		config, ok := clientConfig.Products[p.Key()]
+		if !ok {
+			continue
+		}
+		resources, err := exporter.Export(config)
+		if err != nil {
+			return nil, err
+		}
+		for key, resource := range resources {
+			clients.add(clientConfig.Client, p.Key(), key, resource)
+		}
+	}
+	return clients, nil
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"io"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+	"metrio.net/fougere-lite/internal/provider"
+)
+
+// yamlClients is the `clients` section of a config, indexed by client then by
+// product key.
+type yamlClients map[string]map[string]interface{}
+
+func (y yamlClients) add(clientName string, product string, key string, resource interface{}) {
+	if y[clientName] == nil {
+		y[clientName] = map[string]interface{}{}
+	}
+	if y[clientName][product] == nil {
+		y[clientName][product] = map[string]interface{}{}
+	}
+	y[clientName][product].(map[string]interface{})[key] = resource
+}
+
+func writeYAML(w io.Writer, clients yamlClients) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(map[string]interface{}{"clients": clients}); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// configClientNames returns the clients of the config, if one was given.
+func configClientNames() []string {
+	if !viper.InConfig("clients") {
+		return nil
+	}
+	var names []string
+	for name := range viper.GetStringMap("clients") {
+		names = append(names, name)
+	}
+	return names
+}
+
+// importResources lists the existing resources of the project and region and
+// maps them back to their client and key.
+func (c *ClientsCommand) importResources(projectId string, region string, clientName string) (yamlClients, error) {
+	clients := yamlClients{}
+	clientNames := configClientNames()
+	for _, p := range provider.All() {
+		importer, ok := p.(provider.Importer)
+		if !ok {
+			continue
+		}
+		imported, err := importer.Import(projectId, region, clientNames, clientName)
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range imported {
+			clients.add(resource.Client, p.Key(), resource.Key, resource.Config)
+		}
+	}
+	return clients, nil
+}
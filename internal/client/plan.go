@@ -0,0 +1,76 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/provider"
+)
+
+// exitCodeChanges is the exit code of `clients plan` when at least one resource
+// would be created or updated.
+const exitCodeChanges = 2
+
+var actionSymbols = map[common.Action]string{
+	common.ActionCreate: "+",
+	common.ActionUpdate: "~",
+	common.ActionNoop:   " ",
+	common.ActionDelete: "-",
+}
+
+// sortChanges orders the changes by client, then in the order the products
+// must be applied in, so that a saved plan can be run from top to bottom.
+func sortChanges(changes []common.ResourceChange) {
+	ranks := map[string]int{}
+	for _, change := range changes {
+		ranks[change.Product] = provider.Rank(change.Product)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Client != changes[j].Client {
+			return changes[i].Client < changes[j].Client
+		}
+		if changes[i].Product != changes[j].Product {
+			if ranks[changes[i].Product] != ranks[changes[j].Product] {
+				return ranks[changes[i].Product] < ranks[changes[j].Product]
+			}
+			return changes[i].Product < changes[j].Product
+		}
+		if p, ok := provider.Get(changes[i].Product); ok {
+			if orderer, ok := p.(provider.Orderer); ok {
+				if orderer.Before(changes[i], changes[j]) {
+					return true
+				}
+				if orderer.Before(changes[j], changes[i]) {
+					return false
+				}
+			}
+		}
+		return changes[i].Key < changes[j].Key
+	})
+}
+
+// planExitCode returns exitCodeChanges when a change is not a no-op, and 0
+// otherwise.
+func planExitCode(changes []common.ResourceChange) int {
+	for _, change := range changes {
+		if change.Action != common.ActionNoop {
+			return exitCodeChanges
+		}
+	}
+	return 0
+}
+
+func printPlan(w io.Writer, changes []common.ResourceChange) {
+	counts := map[common.Action]int{}
+	for _, change := range changes {
+		counts[change.Action]++
+		fmt.Fprintf(w, "%s %s %s/%s.%s (%s)\n", actionSymbols[change.Action], change.Action, change.Client, change.Product, change.Key, change.Name)
+		for _, diff := range change.Diffs {
+			fmt.Fprintf(w, "      %s: %v -> %v\n", diff.Field, diff.Current, diff.Desired)
+		}
+	}
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d unchanged.\n",
+		counts[common.ActionCreate], counts[common.ActionUpdate], counts[common.ActionNoop])
+}
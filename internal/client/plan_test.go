@@ -0,0 +1,85 @@
+package client
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"metrio.net/fougere-lite/internal/common"
+	_ "metrio.net/fougere-lite/internal/gcp/cloudpubsub"
+	_ "metrio.net/fougere-lite/internal/gcp/cloudstorage"
+	_ "metrio.net/fougere-lite/internal/gcp/iam"
+	_ "metrio.net/fougere-lite/internal/gcp/kms"
+)
+
+// ids returns the client/product.key of the changes, in their order.
+func ids(changes []common.ResourceChange) []string {
+	var ids []string
+	for _, change := range changes {
+		ids = append(ids, change.Client+"/"+change.Product+"."+change.Key)
+	}
+	return ids
+}
+
+var _ = Describe("plan", func() {
+	Describe("sortChanges", func() {
+		It("orders the changes by client first", func() {
+			changes := []common.ResourceChange{
+				{Client: "pomme", Product: "storageBucket", Key: "tarte"},
+				{Client: "banane", Product: "storageBucket", Key: "patate"},
+			}
+			sortChanges(changes)
+			Expect(ids(changes)).To(Equal([]string{"banane/storageBucket.patate", "pomme/storageBucket.tarte"}))
+		})
+		It("orders the products of a client in the order they are created in", func() {
+			changes := []common.ResourceChange{
+				{Client: "banane", Product: "iam", Key: "serviceAccounts.runner"},
+				{Client: "banane", Product: "storageBucket", Key: "patate"},
+				{Client: "banane", Product: "kms", Key: "keyRings.main"},
+			}
+			sortChanges(changes)
+			Expect(ids(changes)).To(Equal([]string{
+				"banane/kms.keyRings.main",
+				"banane/storageBucket.patate",
+				"banane/iam.serviceAccounts.runner",
+			}))
+		})
+		It("orders the resources of a product with its orderer, then by key", func() {
+			changes := []common.ResourceChange{
+				{Client: "banane", Product: "pubsub", Key: "subscriptions.b"},
+				{Client: "banane", Product: "pubsub", Key: "topics.events"},
+				{Client: "banane", Product: "pubsub", Key: "subscriptions.a"},
+			}
+			sortChanges(changes)
+			Expect(ids(changes)).To(Equal([]string{
+				"banane/pubsub.topics.events",
+				"banane/pubsub.subscriptions.a",
+				"banane/pubsub.subscriptions.b",
+			}))
+		})
+	})
+	Describe("planExitCode", func() {
+		It("returns 0 when every change is a no-op", func() {
+			Expect(planExitCode([]common.ResourceChange{{Action: common.ActionNoop}})).To(Equal(0))
+			Expect(planExitCode(nil)).To(Equal(0))
+		})
+		It("returns exitCodeChanges when a resource would be created or updated", func() {
+			Expect(planExitCode([]common.ResourceChange{{Action: common.ActionNoop}, {Action: common.ActionUpdate}})).To(Equal(exitCodeChanges))
+			Expect(planExitCode([]common.ResourceChange{{Action: common.ActionCreate}})).To(Equal(exitCodeChanges))
+		})
+	})
+	Describe("printPlan", func() {
+		It("prints the changes, their diffs and the counts", func() {
+			var out bytes.Buffer
+			printPlan(&out, []common.ResourceChange{
+				{Client: "banane", Product: "storageBucket", Key: "patate", Name: "banane-patate-projet-123", Action: common.ActionCreate},
+				{Client: "banane", Product: "pubsub", Key: "topics.events", Name: "banane-events", Action: common.ActionUpdate,
+					Diffs: []common.FieldDiff{{Field: "labels.team", Current: "data", Desired: "web"}}},
+			})
+			Expect(out.String()).To(Equal("+ create banane/storageBucket.patate (banane-patate-projet-123)\n" +
+				"~ update banane/pubsub.topics.events (banane-events)\n" +
+				"      labels.team: data -> web\n" +
+				"\nPlan: 1 to create, 1 to update, 0 unchanged.\n"))
+		})
+	})
+})
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/provider"
+	"metrio.net/fougere-lite/internal/state"
+)
+
+// Prune modes of `clients create --prune`.
+const (
+	pruneOff    = "off"
+	pruneReport = "report"
+	pruneDelete = "delete"
+)
+
+func validatePruneMode(mode string) error {
+	switch mode {
+	case pruneOff, pruneReport, pruneDelete:
+		return nil
+	}
+	return fmt.Errorf("invalid prune mode %s, expected one of %s, %s or %s", mode, pruneOff, pruneReport, pruneDelete)
+}
+
+// pruneClients finds the resources of the projects and locations referenced by
+// the config that are no longer declared, and deletes them in delete mode.
+func (c *ClientsCommand) pruneClients(mode string) error {
+	if mode == pruneOff {
+		return nil
+	}
+	var orphans []common.ResourceChange
+	var pruners []provider.Provider
+	for _, p := range provider.All() {
+		pruner, ok := p.(provider.Pruner)
+		if !ok {
+			continue
+		}
+		var clients []string
+		var configs []provider.Config
+		for _, clientConfig := range c.clientConfigs {
+			clients = append(clients, clientConfig.Client)
+			if config, ok := clientConfig.Products[p.Key()]; ok {
+				configs = append(configs, config)
+			}
+		}
+		productOrphans, err := pruner.FindOrphans(clients, configs)
+		if err != nil {
+			return err
+		}
+		orphans = append(orphans, productOrphans...)
+		pruners = append(pruners, p)
+	}
+	sortChanges(orphans)
+	printPrune(os.Stdout, orphans)
+
+	if mode == pruneDelete {
+		byProduct := map[string][]common.ResourceChange{}
+		for _, orphan := range orphans {
+			if orphan.Action == common.ActionDelete {
+				byProduct[orphan.Product] = append(byProduct[orphan.Product], orphan)
+			}
+		}
+		for _, p := range pruners {
+			productOrphans := byProduct[p.Key()]
+			if len(productOrphans) == 0 {
+				continue
+			}
+			if err := p.(provider.Pruner).Prune(productOrphans); err != nil {
+				return err
+			}
+			for _, orphan := range productOrphans {
+				c.state.Remove(orphan.Client, state.ResourceID(orphan.Product, orphan.Key))
+			}
+		}
+	}
+	return nil
+}
+
+// printPrune lists the orphans. Undeclared resources whose ownership cannot be
+// proven are only reported.
+func printPrune(w io.Writer, orphans []common.ResourceChange) {
+	pruned := 0
+	for _, orphan := range orphans {
+		if orphan.Action == common.ActionUnmanaged {
+			fmt.Fprintf(w, "? undeclared %s %s is not pruned, it cannot carry an ownership label\n", orphan.Product, orphan.Name)
+			continue
+		}
+		fmt.Fprintf(w, "- prune %s/%s.%s (%s)\n", orphan.Client, orphan.Product, orphan.Key, orphan.Name)
+		pruned++
+	}
+	fmt.Fprintf(w, "\nPrune: %d managed resources no longer declared.\n", pruned)
+}
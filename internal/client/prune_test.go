@@ -0,0 +1,32 @@
+package client
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"metrio.net/fougere-lite/internal/common"
+)
+
+var _ = Describe("prune", func() {
+	Describe("validatePruneMode", func() {
+		It("accepts off, report and delete only", func() {
+			Expect(validatePruneMode(pruneOff)).To(Succeed())
+			Expect(validatePruneMode(pruneReport)).To(Succeed())
+			Expect(validatePruneMode(pruneDelete)).To(Succeed())
+			Expect(validatePruneMode("all")).To(MatchError("invalid prune mode all, expected one of off, report or delete"))
+		})
+	})
+	Describe("printPrune", func() {
+		It("lists the managed orphans and reports the undeclared resources it cannot prune", func() {
+			var out bytes.Buffer
+			printPrune(&out, []common.ResourceChange{
+				{Client: "banane", Product: "storageBucket", Key: "carotte", Name: "banane-carotte-projet-123", Action: common.ActionDelete},
+				{Product: "cloudTasks", Name: "projects/projet-123/locations/us-central1/queues/old", Action: common.ActionUnmanaged},
+			})
+			Expect(out.String()).To(Equal("- prune banane/storageBucket.carotte (banane-carotte-projet-123)\n" +
+				"? undeclared cloudTasks projects/projet-123/locations/us-central1/queues/old is not pruned, it cannot carry an ownership label\n" +
+				"\nPrune: 1 managed resources no longer declared.\n"))
+		})
+	})
+})
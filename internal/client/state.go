@@ -0,0 +1,184 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/state"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+const defaultStateFile = "fougere-lite.state.json"
+
+var stateLocation string
+
+func addStateFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&stateLocation, "state", defaultStateFile, "where fougere-lite records the resources it manages, a local file or gs://BUCKET/OBJECT")
+}
+
+func newStateBackend() (state.Backend, error) {
+	var options []option.ClientOption
+	return state.NewBackend(context.Background(), stateLocation, options...)
+}
+
+func loadState() (*state.State, error) {
+	backend, err := newStateBackend()
+	if err != nil {
+		return nil, err
+	}
+	return backend.Load()
+}
+
+// withState locks and loads the state, runs fn and saves the state even when fn
+// fails, so that the resources applied before the failure are recorded.
+func (c *ClientsCommand) withState(fn func() error) error {
+	backend, err := newStateBackend()
+	if err != nil {
+		return err
+	}
+	if err := backend.Lock(); err != nil {
+		return err
+	}
+	defer func() {
+		if err := backend.Unlock(); err != nil {
+			utils.Logger.Errorf("[%s] error unlocking state: %s", stateLocation, err)
+		}
+	}()
+	c.state, err = backend.Load()
+	if err != nil {
+		return err
+	}
+	fnErr := fn()
+	if err := backend.Save(c.state); err != nil {
+		if fnErr != nil {
+			utils.Logger.Errorf("[%s] error saving state: %s", stateLocation, err)
+			return fnErr
+		}
+		return err
+	}
+	return fnErr
+}
+
+// recordApplied saves the last applied spec of the resources in the state.
+func (c *ClientsCommand) recordApplied(applied []common.AppliedResource) error {
+	now := time.Now().UTC()
+	for _, resource := range applied {
+		spec, err := json.Marshal(resource.Spec)
+		if err != nil {
+			return err
+		}
+		c.state.Set(resource.Client, state.Resource{
+			Product:   resource.Product,
+			Key:       resource.Key,
+			Name:      resource.Name,
+			Spec:      spec,
+			Etag:      resource.Etag,
+			UpdatedAt: now,
+		})
+	}
+	return nil
+}
+
+func NewStateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "state",
+		Short: "inspects the resources recorded as managed by fougere-lite",
+	}
+	addStateFlag(cmd)
+
+	listCmd := &cobra.Command{
+		Use:   "list [CLIENT...]",
+		Short: "list the resources of the state",
+		Run: func(cmd *cobra.Command, args []string) {
+			s, err := loadState()
+			utils.CheckErr(err)
+			utils.CheckErr(printStateList(os.Stdout, s, args))
+		},
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show CLIENT [PRODUCT.KEY]",
+		Short: "show the last applied state of the resources of a client",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			s, err := loadState()
+			utils.CheckErr(err)
+			utils.CheckErr(printStateShow(os.Stdout, s, args[0], args[1:]))
+		},
+	}
+
+	var force bool
+	unlockCmd := &cobra.Command{
+		Use:   "unlock",
+		Short: "remove the lock left on the state by a run that did not finish",
+		Run: func(cmd *cobra.Command, args []string) {
+			if !force {
+				utils.CheckErr(fmt.Errorf("unlock removes the lock of any run, use --force once you are sure no other run is going on"))
+			}
+			backend, err := newStateBackend()
+			utils.CheckErr(err)
+			utils.CheckErr(backend.ForceUnlock())
+			fmt.Printf("Unlocked the state %s\n", stateLocation)
+		},
+	}
+	unlockCmd.Flags().BoolVar(&force, "force", false, "remove the lock whoever holds it")
+
+	cmd.AddCommand(listCmd)
+	cmd.AddCommand(showCmd)
+	cmd.AddCommand(unlockCmd)
+	return cmd
+}
+
+func printStateList(w io.Writer, s *state.State, clientNames []string) error {
+	if len(clientNames) == 0 {
+		clientNames = s.ClientNames()
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLIENT\tRESOURCE\tNAME\tUPDATED")
+	for _, clientName := range clientNames {
+		if _, ok := s.Clients[clientName]; !ok {
+			return fmt.Errorf("client %s is not in the state", clientName)
+		}
+		for _, resource := range s.Resources(clientName) {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", clientName, resource.ID(), resource.Name, resource.UpdatedAt.Format(time.RFC3339))
+		}
+	}
+	return tw.Flush()
+}
+
+func printStateShow(w io.Writer, s *state.State, clientName string, ids []string) error {
+	resources := s.Resources(clientName)
+	if len(resources) == 0 {
+		return fmt.Errorf("client %s is not in the state", clientName)
+	}
+	if len(ids) > 0 {
+		resource, ok := s.Get(clientName, ids[0])
+		if !ok {
+			return fmt.Errorf("resource %s of client %s is not in the state, expected one of %s", ids[0], clientName, resourceIDs(resources))
+		}
+		resources = []state.Resource{resource}
+	}
+	data, err := json.MarshalIndent(resources, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, string(data))
+	return nil
+}
+
+func resourceIDs(resources []state.Resource) string {
+	ids := make([]string, 0, len(resources))
+	for _, resource := range resources {
+		ids = append(ids, resource.ID())
+	}
+	return strings.Join(ids, ", ")
+}
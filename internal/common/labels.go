@@ -0,0 +1,120 @@
+package common
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Labels written on every resource created by fougere-lite so that it can tell
+// the resources it owns from the ones created by other tools.
+const (
+	ManagedByLabel = "managed-by"
+	ManagedByValue = "fougere-lite"
+	ClientLabel    = "fougere-lite-client"
+	// ConfigLabel and VersionLabel record the config file and the version of
+	// fougere-lite that last wrote the resource, see SetRunLabels.
+	ConfigLabel  = "fougere-lite-config"
+	VersionLabel = "fougere-lite-version"
+)
+
+// MaxLabels is the maximum number of labels of a GCP resource.
+const MaxLabels = 64
+
+// maxUserLabels leaves room for the four ownership labels.
+const maxUserLabels = MaxLabels - 4
+
+var (
+	labelKeyFormat   = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+	labelValueFormat = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+	invalidLabelChar = regexp.MustCompile(`[^a-z0-9_-]`)
+)
+
+// runLabels are the labels of the current run added to the ownership labels.
+var runLabels = map[string]string{}
+
+// SetRunLabels records the name of the config file and the version of the
+// current run, which OwnershipLabels then adds to every resource. Empty values
+// are left out. They are only written along with another change, a new version
+// or config path alone does not change a resource.
+func SetRunLabels(configFile string, version string) {
+	runLabels = map[string]string{}
+	if configFile != "" {
+		runLabels[ConfigLabel] = LabelValue(filepath.Base(configFile))
+	}
+	if value := LabelValue(version); value != "" {
+		runLabels[VersionLabel] = value
+	}
+}
+
+// OwnershipLabels returns the labels marking a resource as owned by a client.
+func OwnershipLabels(clientName string) map[string]string {
+	labels := map[string]string{
+		ManagedByLabel: ManagedByValue,
+		ClientLabel:    clientName,
+	}
+	for key, value := range runLabels {
+		labels[key] = value
+	}
+	return labels
+}
+
+// IsManaged returns true if the labels mark the resource as owned by fougere-lite.
+func IsManaged(labels map[string]string) bool {
+	return labels[ManagedByLabel] == ManagedByValue
+}
+
+func isRunLabel(key string) bool {
+	return key == ConfigLabel || key == VersionLabel
+}
+
+// MergeLabels returns the live labels overridden by the desired ones, so that
+// updating a resource keeps the labels set by other teams or tools.
+func MergeLabels(live map[string]string, desired map[string]string) map[string]string {
+	merged := map[string]string{}
+	for key, value := range live {
+		merged[key] = value
+	}
+	for key, value := range desired {
+		merged[key] = value
+	}
+	return merged
+}
+
+// IsOwnershipLabel returns true if the key is reserved for the labels written by
+// fougere-lite.
+func IsOwnershipLabel(key string) bool {
+	return key == ManagedByLabel || strings.HasPrefix(key, ManagedByValue+"-")
+}
+
+// LabelValue turns a string into a valid label value: lowercase, every other
+// character than letters, digits, _ and - replaced by _, at most 63 characters.
+func LabelValue(value string) string {
+	value = invalidLabelChar.ReplaceAllString(strings.ToLower(value), "_")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+	return value
+}
+
+// ValidateLabels returns an error if the user labels of a resource do not
+// follow the GCP rules, use a key reserved for the ownership labels or leave no
+// room for them.
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > maxUserLabels {
+		return fmt.Errorf("at most %d labels can be set", maxUserLabels)
+	}
+	for key, value := range labels {
+		if IsOwnershipLabel(key) {
+			return fmt.Errorf("label %s is reserved for fougere-lite", key)
+		}
+		if !labelKeyFormat.MatchString(key) {
+			return fmt.Errorf("label key %s must start with a lowercase letter and only contain lowercase letters, digits, _ and -, at most 63 characters", key)
+		}
+		if !labelValueFormat.MatchString(value) {
+			return fmt.Errorf("label %s: value %s must only contain lowercase letters, digits, _ and -, at most 63 characters", key, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+package common
+
+// LocationName returns the resource name of a GCP location,
+// projects/<project>/locations/<region>, the parent of the regional resources.
+func LocationName(projectId string, region string) string {
+	return "projects/" + projectId + "/locations/" + region
+}
@@ -1,7 +1,125 @@
 package common
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
 type Response struct {
-	StatusCode int    `json:"StatusCode"`
-	Err        error  `json:"err"`
-	SelfLink   string `json:"selfLink"`
+	StatusCode int              `json:"StatusCode"`
+	Err        error            `json:"err"`
+	SelfLink   string           `json:"selfLink"`
+	Change     ResourceChange   `json:"change"`
+	Applied    *AppliedResource `json:"applied"`
+}
+
+// AppliedResource is a resource that was successfully created or updated, with
+// the spec that was sent to GCP.
+type AppliedResource struct {
+	Client  string      `json:"client"`
+	Product string      `json:"product"`
+	Key     string      `json:"key"`
+	Name    string      `json:"name"`
+	Etag    string      `json:"etag"`
+	Spec    interface{} `json:"spec"`
+}
+
+// Action is the operation a plan would run against a resource.
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionNoop   Action = "no-op"
+	ActionDelete Action = "delete"
+	// ActionUnmanaged is an undeclared resource that fougere-lite cannot prove
+	// it owns. It is reported and never deleted.
+	ActionUnmanaged Action = "unmanaged"
+)
+
+// FieldDiff is a single property whose live value differs from the desired one.
+type FieldDiff struct {
+	Field   string      `json:"field"`
+	Current interface{} `json:"current"`
+	Desired interface{} `json:"desired"`
+}
+
+// ResourceChange describes what applying the config would do to one resource.
+// Desired holds the spec sent to GCP and LiveHash the hash of the live resource
+// at plan time, empty when it does not exist, so that a saved plan can be
+// applied exactly as it was reviewed.
+type ResourceChange struct {
+	Client   string          `json:"client"`
+	Product  string          `json:"product"`
+	Key      string          `json:"key"`
+	Name     string          `json:"name"`
+	Project  string          `json:"project"`
+	Action   Action          `json:"action"`
+	Diffs    []FieldDiff     `json:"diffs,omitempty"`
+	Desired  json.RawMessage `json:"desired,omitempty"`
+	LiveHash string          `json:"liveHash,omitempty"`
+}
+
+// AppendDiff adds a FieldDiff to diffs when current and desired are not equal.
+func AppendDiff(diffs []FieldDiff, field string, current, desired interface{}) []FieldDiff {
+	if reflect.DeepEqual(current, desired) {
+		return diffs
+	}
+	return append(diffs, FieldDiff{Field: field, Current: current, Desired: desired})
+}
+
+// AppendLabelDiffs adds a FieldDiff for every desired label whose live value
+// differs, in the order of the keys so that plans are stable. The live labels
+// that are not desired are left alone, and so are the run labels, which are
+// written with the resource but do not make it change, see SetRunLabels.
+func AppendLabelDiffs(diffs []FieldDiff, field string, live, desired map[string]string) []FieldDiff {
+	keys := make([]string, 0, len(desired))
+	for key := range desired {
+		if isRunLabel(key) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		diffs = AppendDiff(diffs, field+"."+key, live[key], desired[key])
+	}
+	return diffs
+}
+
+// PlanChange completes a planned change with the desired spec, its diffs and,
+// when the resource exists, its live hash. live is nil when the resource does
+// not exist, the change is then a create. Otherwise it is an update when there
+// are diffs and a no-op when there are none.
+func PlanChange(change ResourceChange, spec interface{}, live interface{}, diffs []FieldDiff) Response {
+	var err error
+	if change.Desired, err = json.Marshal(spec); err != nil {
+		return Response{Err: err}
+	}
+	change.Diffs = diffs
+	if live == nil {
+		change.Action = ActionCreate
+		return Response{Change: change}
+	}
+	if change.LiveHash, err = HashResource(live); err != nil {
+		return Response{Err: err}
+	}
+	change.Action = ActionNoop
+	if len(change.Diffs) > 0 {
+		change.Action = ActionUpdate
+	}
+	return Response{Change: change}
+}
+
+// HashResource returns the sha256 of the JSON representation of a resource.
+func HashResource(resource interface{}) (string, error) {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
 }
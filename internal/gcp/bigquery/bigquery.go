@@ -0,0 +1,619 @@
+package bigquery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Product is the key of the datasets in a client's config.
+const Product = "bigqueryDatasets"
+
+// tablesKey separates the key of a dataset from the key of its tables in the
+// plan and state, e.g. analytics.tables.events.
+const tablesKey = ".tables."
+
+// sinkWriterDomain is the domain of the unique writer identities of log sinks.
+const sinkWriterDomain = "@gcp-sa-logging.iam.gserviceaccount.com"
+
+type Client struct {
+	bigqueryService *bigquery.Service
+}
+
+func NewClient(ctx context.Context, opts ...option.ClientOption) (*Client, error) {
+	bigqueryService, err := bigquery.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		bigqueryService: bigqueryService,
+	}, nil
+}
+
+// Create creates the datasets of the config and then their tables, or updates
+// the ones that exist. A table update only adds columns or relaxes REQUIRED
+// columns, any other schema change is refused. It returns the resources that
+// were applied, even when some failed.
+func (c *Client) Create(config *Config) ([]common.AppliedResource, error) {
+	applied, err := c.createDatasets(config)
+	if err != nil {
+		return applied, err
+	}
+	tablesApplied, err := c.createTables(config)
+	return append(applied, tablesApplied...), err
+}
+
+func (c *Client) createDatasets(config *Config) ([]common.AppliedResource, error) {
+	createChannel := make(chan common.Response, len(config.Datasets))
+	for key, dataset := range config.Datasets {
+		go func(resp chan common.Response, key string, dataset Dataset) {
+			spec := c.createDatasetSpec(dataset)
+			name := DatasetName(dataset)
+			live, err := c.getDataset(dataset.ProjectId, dataset.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+					utils.Logger.Debugf("[%s] dataset not found", name)
+
+					if err := c.insertDataset(spec); err != nil {
+						resp <- common.Response{Err: err}
+						return
+					}
+				} else {
+					utils.Logger.Errorf("[%s] error getting dataset: %s", name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+			} else {
+				if !strings.EqualFold(live.Location, spec.Location) {
+					resp <- common.Response{Err: fmt.Errorf("[%s] the location of a dataset cannot be changed from %s to %s", name, live.Location, spec.Location)}
+					return
+				}
+				keepSinkWriters(live, spec)
+				if err := c.patchDataset(spec); err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+			}
+			resp <- common.Response{Applied: &common.AppliedResource{
+				Client:  dataset.ClientName,
+				Product: Product,
+				Key:     key,
+				Name:    name,
+				Spec:    spec,
+			}}
+		}(createChannel, key, dataset)
+	}
+	var applied []common.AppliedResource
+	var createErr error
+	for range config.Datasets {
+		resp := <-createChannel
+		if resp.Err != nil {
+			createErr = resp.Err
+			continue
+		}
+		applied = append(applied, *resp.Applied)
+	}
+	return applied, createErr
+}
+
+func (c *Client) createTables(config *Config) ([]common.AppliedResource, error) {
+	createChannel := make(chan common.Response)
+	tables := 0
+	for key, dataset := range config.Datasets {
+		for tableKey, table := range dataset.Tables {
+			tables++
+			go func(resp chan common.Response, key string, dataset Dataset, table Table) {
+				spec := c.createTableSpec(dataset, table)
+				name := TableName(dataset, table)
+				live, err := c.getTable(dataset.ProjectId, dataset.Name, table.Name)
+				if err != nil {
+					if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+						utils.Logger.Debugf("[%s] table not found", name)
+
+						if err := c.insertTable(spec); err != nil {
+							resp <- common.Response{Err: err}
+							return
+						}
+					} else {
+						utils.Logger.Errorf("[%s] error getting table: %s", name, err)
+						resp <- common.Response{Err: err}
+						return
+					}
+				} else {
+					if _, err := diffTable(live, spec); err != nil {
+						resp <- common.Response{Err: fmt.Errorf("[%s] %s", name, err)}
+						return
+					}
+					if err := c.patchTable(spec); err != nil {
+						resp <- common.Response{Err: err}
+						return
+					}
+				}
+				resp <- common.Response{Applied: &common.AppliedResource{
+					Client:  dataset.ClientName,
+					Product: Product,
+					Key:     key,
+					Name:    name,
+					Spec:    spec,
+				}}
+			}(createChannel, key+tablesKey+tableKey, dataset, table)
+		}
+	}
+	var applied []common.AppliedResource
+	var createErr error
+	for i := 0; i < tables; i++ {
+		resp := <-createChannel
+		if resp.Err != nil {
+			createErr = resp.Err
+			continue
+		}
+		applied = append(applied, *resp.Applied)
+	}
+	return applied, createErr
+}
+
+// Plan compares every dataset and table of the config with its live state and
+// returns the change Create would make to each of them. It returns an error if
+// the schema of a table cannot be patched.
+func (c *Client) Plan(config *Config) ([]common.ResourceChange, error) {
+	planChannel := make(chan common.Response)
+	total := 0
+	for key, dataset := range config.Datasets {
+		total++
+		go func(resp chan common.Response, key string, dataset Dataset) {
+			spec := c.createDatasetSpec(dataset)
+			change := common.ResourceChange{
+				Client:  dataset.ClientName,
+				Product: Product,
+				Key:     key,
+				Name:    DatasetName(dataset),
+				Project: dataset.ProjectId,
+			}
+			live, err := c.getDataset(dataset.ProjectId, dataset.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+					utils.Logger.Errorf("[%s] error getting dataset: %s", change.Name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+				resp <- common.PlanChange(change, spec, nil, nil)
+				return
+			}
+			keepSinkWriters(live, spec)
+			resp <- common.PlanChange(change, spec, live, diffDataset(live, spec))
+		}(planChannel, key, dataset)
+
+		for tableKey, table := range dataset.Tables {
+			total++
+			go func(resp chan common.Response, key string, dataset Dataset, table Table) {
+				spec := c.createTableSpec(dataset, table)
+				change := common.ResourceChange{
+					Client:  dataset.ClientName,
+					Product: Product,
+					Key:     key,
+					Name:    TableName(dataset, table),
+					Project: dataset.ProjectId,
+				}
+				live, err := c.getTable(dataset.ProjectId, dataset.Name, table.Name)
+				if err != nil {
+					if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+						utils.Logger.Errorf("[%s] error getting table: %s", change.Name, err)
+						resp <- common.Response{Err: err}
+						return
+					}
+					resp <- common.PlanChange(change, spec, nil, nil)
+					return
+				}
+				diffs, err := diffTable(live, spec)
+				if err != nil {
+					resp <- common.Response{Err: fmt.Errorf("[%s] %s", change.Name, err)}
+					return
+				}
+				resp <- common.PlanChange(change, spec, live, diffs)
+			}(planChannel, key+tablesKey+tableKey, dataset, table)
+		}
+	}
+	var changes []common.ResourceChange
+	var planErr error
+	for i := 0; i < total; i++ {
+		resp := <-planChannel
+		if resp.Err != nil {
+			planErr = resp.Err
+			continue
+		}
+		changes = append(changes, resp.Change)
+	}
+	return changes, planErr
+}
+
+// Verify returns an error if the live dataset or table is not in the state it
+// was in when the change was planned.
+func (c *Client) Verify(change common.ResourceChange) error {
+	var live interface{}
+	var err error
+	var desired struct {
+		DatasetReference *bigquery.DatasetReference `json:"datasetReference"`
+		TableReference   *bigquery.TableReference   `json:"tableReference"`
+	}
+	if err := json.Unmarshal(change.Desired, &desired); err != nil {
+		return fmt.Errorf("[%s] invalid planned spec: %s", change.Name, err)
+	}
+	if isTable(change) {
+		if desired.TableReference == nil {
+			return fmt.Errorf("[%s] invalid planned spec: missing table reference", change.Name)
+		}
+		live, err = c.getTable(desired.TableReference.ProjectId, desired.TableReference.DatasetId, desired.TableReference.TableId)
+	} else {
+		if desired.DatasetReference == nil {
+			return fmt.Errorf("[%s] invalid planned spec: missing dataset reference", change.Name)
+		}
+		live, err = c.getDataset(desired.DatasetReference.ProjectId, desired.DatasetReference.DatasetId)
+	}
+	liveHash := ""
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+			return err
+		}
+	} else {
+		if liveHash, err = common.HashResource(live); err != nil {
+			return err
+		}
+	}
+	if liveHash != change.LiveHash {
+		return fmt.Errorf("[%s] %s changed since the plan was made", change.Name, kind(change))
+	}
+	return nil
+}
+
+// Apply runs a planned change with the spec saved in the plan. It returns the
+// applied dataset or table, or nil when there was nothing to do.
+func (c *Client) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	if change.Action == common.ActionNoop {
+		return nil, nil
+	}
+	var spec interface{}
+	var err error
+	if isTable(change) {
+		table := &bigquery.Table{}
+		if err := json.Unmarshal(change.Desired, table); err != nil {
+			return nil, fmt.Errorf("[%s] invalid planned spec: %s", change.Name, err)
+		}
+		switch change.Action {
+		case common.ActionCreate:
+			err = c.insertTable(table)
+		case common.ActionUpdate:
+			err = c.patchTable(table)
+		default:
+			return nil, fmt.Errorf("[%s] unknown action %s", change.Name, change.Action)
+		}
+		spec = table
+	} else {
+		dataset := &bigquery.Dataset{}
+		if err := json.Unmarshal(change.Desired, dataset); err != nil {
+			return nil, fmt.Errorf("[%s] invalid planned spec: %s", change.Name, err)
+		}
+		switch change.Action {
+		case common.ActionCreate:
+			err = c.insertDataset(dataset)
+		case common.ActionUpdate:
+			err = c.patchDataset(dataset)
+		default:
+			return nil, fmt.Errorf("[%s] unknown action %s", change.Name, change.Action)
+		}
+		spec = dataset
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &common.AppliedResource{
+		Client:  change.Client,
+		Product: Product,
+		Key:     change.Key,
+		Name:    change.Name,
+		Spec:    spec,
+	}, nil
+}
+
+// Delete deletes the declared tables and then the datasets of the config.
+// A dataset that still contains other tables is only deleted with force, which
+// deletes its tables too. Resources that do not exist are ignored.
+func (c *Client) Delete(config *Config, force bool) error {
+	deleteChannel := make(chan common.Response)
+	tables := 0
+	for _, dataset := range config.Datasets {
+		for _, table := range dataset.Tables {
+			tables++
+			go func(resp chan common.Response, dataset Dataset, table Table) {
+				resp <- common.Response{Err: c.deleteTable(dataset.ProjectId, dataset.Name, table.Name)}
+			}(deleteChannel, dataset, table)
+		}
+	}
+	var deleteErr error
+	for i := 0; i < tables; i++ {
+		resp := <-deleteChannel
+		if resp.Err != nil {
+			deleteErr = resp.Err
+		}
+	}
+	if deleteErr != nil {
+		return deleteErr
+	}
+
+	for _, dataset := range config.Datasets {
+		go func(resp chan common.Response, dataset Dataset) {
+			resp <- common.Response{Err: c.deleteDataset(dataset.ProjectId, dataset.Name, force)}
+		}(deleteChannel, dataset)
+	}
+	for range config.Datasets {
+		resp := <-deleteChannel
+		if resp.Err != nil {
+			deleteErr = resp.Err
+		}
+	}
+	return deleteErr
+}
+
+func (c *Client) getDataset(projectId string, datasetId string) (*bigquery.Dataset, error) {
+	utils.Logger.Debugf("[%s:%s] getting dataset", projectId, datasetId)
+	dataset, err := c.bigqueryService.Datasets.Get(projectId, datasetId).Do()
+	if err != nil {
+		return nil, err
+	}
+	return dataset, nil
+}
+
+func (c *Client) insertDataset(spec *bigquery.Dataset) error {
+	name := spec.DatasetReference.ProjectId + ":" + spec.DatasetReference.DatasetId
+	utils.Logger.Infof("[%s] creating dataset", name)
+	_, err := c.bigqueryService.Datasets.Insert(spec.DatasetReference.ProjectId, spec).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error creating dataset: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) patchDataset(spec *bigquery.Dataset) error {
+	name := spec.DatasetReference.ProjectId + ":" + spec.DatasetReference.DatasetId
+	utils.Logger.Infof("[%s] updating dataset", name)
+	_, err := c.bigqueryService.Datasets.Patch(spec.DatasetReference.ProjectId, spec.DatasetReference.DatasetId, spec).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error updating dataset: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) deleteDataset(projectId string, datasetId string, force bool) error {
+	name := projectId + ":" + datasetId
+	utils.Logger.Infof("[%s] deleting dataset", name)
+	err := c.bigqueryService.Datasets.Delete(projectId, datasetId).DeleteContents(force).Do()
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			utils.Logger.Infof("[%s] dataset already deleted", name)
+			return nil
+		}
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusBadRequest && !force {
+			return fmt.Errorf("[%s] dataset is not empty, use --force to delete its tables: %s", name, err)
+		}
+		utils.Logger.Errorf("[%s] error deleting dataset: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) getTable(projectId string, datasetId string, tableId string) (*bigquery.Table, error) {
+	utils.Logger.Debugf("[%s:%s.%s] getting table", projectId, datasetId, tableId)
+	table, err := c.bigqueryService.Tables.Get(projectId, datasetId, tableId).Do()
+	if err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+func (c *Client) insertTable(spec *bigquery.Table) error {
+	ref := spec.TableReference
+	name := ref.ProjectId + ":" + ref.DatasetId + "." + ref.TableId
+	utils.Logger.Infof("[%s] creating table", name)
+	_, err := c.bigqueryService.Tables.Insert(ref.ProjectId, ref.DatasetId, spec).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error creating table: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) patchTable(spec *bigquery.Table) error {
+	ref := spec.TableReference
+	name := ref.ProjectId + ":" + ref.DatasetId + "." + ref.TableId
+	utils.Logger.Infof("[%s] updating table", name)
+	_, err := c.bigqueryService.Tables.Patch(ref.ProjectId, ref.DatasetId, ref.TableId, spec).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error updating table: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) deleteTable(projectId string, datasetId string, tableId string) error {
+	name := projectId + ":" + datasetId + "." + tableId
+	utils.Logger.Infof("[%s] deleting table", name)
+	err := c.bigqueryService.Tables.Delete(projectId, datasetId, tableId).Do()
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			utils.Logger.Infof("[%s] table already deleted", name)
+			return nil
+		}
+		utils.Logger.Errorf("[%s] error deleting table: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) createDatasetSpec(dataset Dataset) *bigquery.Dataset {
+	labels := map[string]string{}
+	for key, value := range dataset.Labels {
+		labels[key] = value
+	}
+	for key, value := range common.OwnershipLabels(dataset.ClientName) {
+		labels[key] = value
+	}
+	spec := &bigquery.Dataset{
+		DatasetReference: &bigquery.DatasetReference{
+			ProjectId: dataset.ProjectId,
+			DatasetId: dataset.Name,
+		},
+		Location:    dataset.Location,
+		Description: dataset.Description,
+		Labels:      labels,
+	}
+	if dataset.DefaultTableExpiration != "" {
+		if expiration, err := time.ParseDuration(dataset.DefaultTableExpiration); err == nil {
+			spec.DefaultTableExpirationMs = expiration.Milliseconds()
+		}
+	}
+	for _, entry := range dataset.Access {
+		spec.Access = append(spec.Access, &bigquery.DatasetAccess{
+			Role:         entry.Role,
+			UserByEmail:  entry.UserByEmail,
+			GroupByEmail: entry.GroupByEmail,
+			Domain:       entry.Domain,
+			SpecialGroup: entry.SpecialGroup,
+		})
+	}
+	return spec
+}
+
+func (c *Client) createTableSpec(dataset Dataset, table Table) *bigquery.Table {
+	return &bigquery.Table{
+		TableReference: &bigquery.TableReference{
+			ProjectId: dataset.ProjectId,
+			DatasetId: dataset.Name,
+			TableId:   table.Name,
+		},
+		Description: table.Description,
+		Schema:      &bigquery.TableSchema{Fields: table.Schema},
+		Labels:      common.OwnershipLabels(dataset.ClientName),
+	}
+}
+
+// diffDataset lists the properties of the desired spec that differ from the
+// live dataset. The access entries are only compared when they are set in the
+// config.
+func diffDataset(live *bigquery.Dataset, desired *bigquery.Dataset) []common.FieldDiff {
+	var diffs []common.FieldDiff
+	if !strings.EqualFold(live.Location, desired.Location) {
+		diffs = append(diffs, common.FieldDiff{Field: "location", Current: live.Location, Desired: desired.Location})
+	}
+	diffs = common.AppendDiff(diffs, "description", live.Description, desired.Description)
+	diffs = common.AppendDiff(diffs, "defaultTableExpirationMs", live.DefaultTableExpirationMs, desired.DefaultTableExpirationMs)
+	diffs = common.AppendLabelDiffs(diffs, "labels", live.Labels, desired.Labels)
+	if len(desired.Access) > 0 {
+		diffs = common.AppendDiff(diffs, "access", accessEntries(live.Access), accessEntries(desired.Access))
+	}
+	return diffs
+}
+
+// keepSinkWriters adds to the declared access entries the live entries of the
+// writer identities of log sinks, which the logging product grants. Without
+// them every update would revoke the access of the sinks.
+func keepSinkWriters(live *bigquery.Dataset, spec *bigquery.Dataset) {
+	if len(spec.Access) == 0 {
+		return
+	}
+	declared := map[string]bool{}
+	for _, entry := range spec.Access {
+		declared[accessEntry(entry)] = true
+	}
+	for _, entry := range live.Access {
+		if !isSinkWriter(entry) || declared[accessEntry(entry)] {
+			continue
+		}
+		spec.Access = append(spec.Access, entry)
+	}
+}
+
+// isSinkWriter returns true for an entry of the unique writer identity of a
+// log sink.
+func isSinkWriter(entry *bigquery.DatasetAccess) bool {
+	return strings.HasSuffix(strings.ToLower(entry.UserByEmail), sinkWriterDomain)
+}
+
+// accessEntries returns the access entries as sorted role:member strings, GCP
+// does not keep their order.
+func accessEntries(access []*bigquery.DatasetAccess) []string {
+	entries := []string{}
+	for _, entry := range access {
+		if member := accessEntry(entry); member != "" {
+			entries = append(entries, member)
+		}
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// accessEntry returns an access entry as a role:member string, or "" when it
+// grants no member, e.g. a view.
+func accessEntry(entry *bigquery.DatasetAccess) string {
+	member := ""
+	switch {
+	case entry.UserByEmail != "":
+		member = "user:" + entry.UserByEmail
+	case entry.GroupByEmail != "":
+		member = "group:" + entry.GroupByEmail
+	case entry.Domain != "":
+		member = "domain:" + entry.Domain
+	case entry.SpecialGroup != "":
+		member = "specialGroup:" + entry.SpecialGroup
+	default:
+		return ""
+	}
+	return entry.Role + ":" + member
+}
+
+// diffTable lists the properties of the desired spec that differ from the live
+// table. It returns an error if the schema change cannot be patched.
+func diffTable(live *bigquery.Table, desired *bigquery.Table) ([]common.FieldDiff, error) {
+	var liveFields []*bigquery.TableFieldSchema
+	if live.Schema != nil {
+		liveFields = live.Schema.Fields
+	}
+	diffs, err := diffSchema(liveFields, desired.Schema.Fields, "")
+	if err != nil {
+		return nil, fmt.Errorf("destructive schema change: %s", err)
+	}
+	diffs = common.AppendDiff(diffs, "description", live.Description, desired.Description)
+	diffs = common.AppendLabelDiffs(diffs, "labels", live.Labels, desired.Labels)
+	return diffs, nil
+}
+
+func isTable(change common.ResourceChange) bool {
+	return strings.Contains(change.Key, tablesKey)
+}
+
+func kind(change common.ResourceChange) string {
+	if isTable(change) {
+		return "table"
+	}
+	return "dataset"
+}
+
+// DatasetName returns the name of a dataset, <project>:<dataset>.
+func DatasetName(dataset Dataset) string {
+	return dataset.ProjectId + ":" + dataset.Name
+}
+
+// TableName returns the name of a table, <project>:<dataset>.<table>.
+func TableName(dataset Dataset, table Table) string {
+	return DatasetName(dataset) + "." + table.Name
+}
@@ -0,0 +1,13 @@
+package bigquery_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestBigquery(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Bigquery Suite")
+}
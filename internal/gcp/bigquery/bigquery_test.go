@@ -0,0 +1,230 @@
+package bigquery
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Helper method to create client
+func getMockedClient(url string) *Client {
+	client, err := NewClient(context.Background(), option.WithoutAuthentication(), option.WithEndpoint(url))
+	if err != nil {
+		Fail(err.Error())
+	}
+	return client
+}
+
+var _ = Describe("BigQuery client", func() {
+	var dataset Dataset
+	var config *Config
+	var datasetPath string
+	var tablePath string
+
+	BeforeEach(func() {
+		dataset = Dataset{
+			Name:       "banane_analytics",
+			ProjectId:  "projet-123",
+			Location:   "northamerica-northeast1",
+			Labels:     map[string]string{"team": "data"},
+			ClientName: "banane",
+			Tables: map[string]Table{
+				"events": {
+					Name: "events",
+					Schema: []*bigquery.TableFieldSchema{
+						{Name: "id", Type: "STRING", Mode: "REQUIRED"},
+						{Name: "at", Type: "TIMESTAMP"},
+					},
+				},
+			},
+		}
+		config = &Config{Datasets: map[string]Dataset{"analytics": dataset}}
+		datasetPath = "/projects/projet-123/datasets/banane_analytics"
+		tablePath = datasetPath + "/tables/events"
+	})
+
+	Describe("create dataset spec", func() {
+		It("merges the labels with the ownership labels", func() {
+			dataset.DefaultTableExpiration = "24h"
+			spec := (&Client{}).createDatasetSpec(dataset)
+			Expect(spec.DatasetReference.DatasetId).To(Equal("banane_analytics"))
+			Expect(spec.DefaultTableExpirationMs).To(Equal(int64(86400000)))
+			Expect(spec.Labels).To(Equal(map[string]string{
+				"team":                "data",
+				common.ManagedByLabel: common.ManagedByValue,
+				common.ClientLabel:    "banane",
+			}))
+		})
+	})
+	Describe("create", func() {
+		It("creates the dataset then its table", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 4)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasSuffix(url, datasetPath+"?alt=json&prettyPrint=false")
+				},
+				ResponseCode: 404,
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.Contains(url, "/projects/projet-123/datasets?")
+				},
+				Method: "post",
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.Contains(url, tablePath)
+				},
+				ResponseCode: 404,
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.Contains(url, datasetPath+"/tables?")
+				},
+				Method: "post",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			applied, err := client.Create(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(HaveLen(2))
+			Expect(applied[0].Name).To(Equal("projet-123:banane_analytics"))
+			Expect(applied[1].Key).To(Equal("analytics.tables.events"))
+		})
+		It("refuses to remove a column of an existing table", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 3)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: bigquery.Dataset{Location: "northamerica-northeast1"},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				Method: "patch",
+			}
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: bigquery.Table{Schema: &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{
+					{Name: "id", Type: "STRING", Mode: "REQUIRED"},
+					{Name: "at", Type: "TIMESTAMP"},
+					{Name: "payload", Type: "STRING"},
+				}}},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			_, err := client.Create(config)
+			Expect(err).To(MatchError("[projet-123:banane_analytics.events] destructive schema change: column payload cannot be removed"))
+		})
+	})
+	Describe("plan", func() {
+		It("plans an update with the fields that differ", func() {
+			config.Datasets["analytics"] = Dataset{
+				Name:       dataset.Name,
+				ProjectId:  dataset.ProjectId,
+				Location:   dataset.Location,
+				ClientName: dataset.ClientName,
+			}
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: bigquery.Dataset{
+					Location:                 "northamerica-northeast1",
+					DefaultTableExpirationMs: 3600000,
+					Labels:                   common.OwnershipLabels("banane"),
+				},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(HaveLen(1))
+			Expect(changes[0].Action).To(Equal(common.ActionUpdate))
+			Expect(changes[0].Diffs).To(ConsistOf(common.FieldDiff{Field: "defaultTableExpirationMs", Current: int64(3600000), Desired: int64(0)}))
+		})
+	})
+	Describe("diff schema", func() {
+		var live []*bigquery.TableFieldSchema
+
+		BeforeEach(func() {
+			live = []*bigquery.TableFieldSchema{
+				{Name: "id", Type: "STRING", Mode: "REQUIRED"},
+				{Name: "count", Type: "INTEGER"},
+				{Name: "user", Type: "RECORD", Fields: []*bigquery.TableFieldSchema{
+					{Name: "email", Type: "STRING"},
+				}},
+			}
+		})
+
+		It("allows adding nullable columns and relaxing required ones", func() {
+			desired := []*bigquery.TableFieldSchema{
+				{Name: "id", Type: "STRING", Mode: "NULLABLE"},
+				{Name: "count", Type: "INT64"},
+				{Name: "user", Type: "STRUCT", Fields: []*bigquery.TableFieldSchema{
+					{Name: "email", Type: "STRING"},
+					{Name: "name", Type: "STRING"},
+				}},
+				{Name: "tags", Type: "STRING", Mode: "REPEATED"},
+			}
+			diffs, err := diffSchema(live, desired, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(diffs).To(ConsistOf(
+				common.FieldDiff{Field: "schema.id.mode", Current: "REQUIRED", Desired: "NULLABLE"},
+				common.FieldDiff{Field: "schema.user.name", Current: nil, Desired: "STRING NULLABLE"},
+				common.FieldDiff{Field: "schema.tags", Current: nil, Desired: "STRING REPEATED"},
+			))
+		})
+		It("rejects a type change", func() {
+			desired := []*bigquery.TableFieldSchema{live[0], {Name: "count", Type: "STRING"}, live[2]}
+			_, err := diffSchema(live, desired, "")
+			Expect(err).To(MatchError("the type of column count cannot be changed from INTEGER to STRING"))
+		})
+		It("rejects a nested column removal", func() {
+			desired := []*bigquery.TableFieldSchema{live[0], live[1], {Name: "user", Type: "RECORD"}}
+			_, err := diffSchema(live, desired, "")
+			Expect(err).To(MatchError("column user.email cannot be removed"))
+		})
+		It("rejects a new required column", func() {
+			desired := append(live, &bigquery.TableFieldSchema{Name: "at", Type: "TIMESTAMP", Mode: "REQUIRED"})
+			_, err := diffSchema(live, desired, "")
+			Expect(err).To(MatchError("column at cannot be added as REQUIRED"))
+		})
+		It("rejects making a column required", func() {
+			desired := []*bigquery.TableFieldSchema{live[0], {Name: "count", Type: "INTEGER", Mode: "REQUIRED"}, live[2]}
+			_, err := diffSchema(live, desired, "")
+			Expect(err).To(MatchError("the mode of column count cannot be changed from NULLABLE to REQUIRED"))
+		})
+	})
+	Describe("delete", func() {
+		It("deletes the tables then the dataset", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 2)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.Contains(url, tablePath)
+				},
+				Method: "delete",
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.Contains(url, datasetPath+"?") && strings.Contains(url, "deleteContents=true")
+				},
+				Method: "delete",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			Expect(client.Delete(config, true)).To(Succeed())
+		})
+	})
+})
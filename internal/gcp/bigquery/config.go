@@ -0,0 +1,155 @@
+// ©Copyright 2022 Metrio
+package bigquery
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/viper"
+	"google.golang.org/api/bigquery/v2"
+	"metrio.net/fougere-lite/internal/common"
+)
+
+type Config struct {
+	Datasets map[string]Dataset `mapstructure:"bigqueryDatasets" yaml:"bigqueryDatasets" validate:"dive"`
+}
+
+type Dataset struct {
+	Name        string `json:"name" yaml:"-" validate:"required"`
+	ProjectId   string `json:"projectId" yaml:"projectId" validate:"required"`
+	Location    string `json:"location" yaml:"location" validate:"required"`
+	Description string `json:"description" yaml:"description,omitempty"`
+	// DefaultTableExpiration is the lifetime of the new tables of the dataset,
+	// a duration of at least 1h.
+	DefaultTableExpiration string            `json:"defaultTableExpiration" yaml:"defaultTableExpiration,omitempty"`
+	Labels                 map[string]string `json:"labels" yaml:"labels,omitempty"`
+	// Access replaces the access entries of the dataset when it is set, except
+	// the entries of the writer identities of log sinks. GCP grants its
+	// default entries when it is not.
+	Access     []AccessEntry    `json:"access" yaml:"access,omitempty" validate:"dive"`
+	Tables     map[string]Table `json:"tables" yaml:"tables,omitempty" validate:"dive"`
+	ClientName string           `yaml:"-"`
+}
+
+// AccessEntry grants a role to exactly one of its members.
+type AccessEntry struct {
+	Role         string `json:"role" yaml:"role" validate:"required,oneof=READER WRITER OWNER"`
+	UserByEmail  string `json:"userByEmail" yaml:"userByEmail,omitempty" validate:"omitempty,email"`
+	GroupByEmail string `json:"groupByEmail" yaml:"groupByEmail,omitempty" validate:"omitempty,email"`
+	Domain       string `json:"domain" yaml:"domain,omitempty" validate:"omitempty,fqdn"`
+	SpecialGroup string `json:"specialGroup" yaml:"specialGroup,omitempty" validate:"omitempty,oneof=projectOwners projectReaders projectWriters allAuthenticatedUsers"`
+}
+
+type Table struct {
+	Name string `json:"name" yaml:"-" validate:"required"`
+	// SchemaFile is the path of a JSON schema, in the format of `bq show
+	// --schema`. A relative path is relative to the config file.
+	SchemaFile  string                       `json:"schemaFile" yaml:"schemaFile" validate:"required"`
+	Description string                       `json:"description" yaml:"description,omitempty"`
+	Schema      []*bigquery.TableFieldSchema `json:"-" yaml:"-"`
+}
+
+const minTableExpiration = time.Hour
+
+// GetBigQueryConfig parses the datasets of a client and loads the schemas of
+// their tables.
+func GetBigQueryConfig(viperConfig *viper.Viper, clientName string) (*Config, error) {
+	if viperConfig == nil {
+		return nil, nil
+	}
+
+	var bigqueryConfig Config
+	err := viperConfig.Unmarshal(&bigqueryConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, dataset := range bigqueryConfig.Datasets {
+		dataset.Name = DatasetId(clientName, name)
+		dataset.ClientName = clientName
+		for tableName, table := range dataset.Tables {
+			table.Name = TableId(tableName)
+			if table.Schema, err = loadSchema(table.SchemaFile); err != nil {
+				return nil, fmt.Errorf("table %s.%s: %s", name, tableName, err)
+			}
+			dataset.Tables[tableName] = table
+		}
+
+		bigqueryConfig.Datasets[name] = dataset
+	}
+	return &bigqueryConfig, nil
+}
+
+// DatasetId returns the GCP id of a dataset, <client>_<key>. Dataset ids only
+// allow letters, digits and underscores, so dashes are replaced.
+func DatasetId(clientName string, key string) string {
+	return strings.ReplaceAll(clientName+"_"+key, "-", "_")
+}
+
+// TableId returns the GCP id of a table, its key with the dashes replaced.
+func TableId(key string) string {
+	return strings.ReplaceAll(key, "-", "_")
+}
+
+func loadSchema(schemaFile string) ([]*bigquery.TableFieldSchema, error) {
+	if schemaFile == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(schemaFile) && viper.ConfigFileUsed() != "" {
+		schemaFile = filepath.Join(filepath.Dir(viper.ConfigFileUsed()), schemaFile)
+	}
+	data, err := os.ReadFile(schemaFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read schema file: %s", err)
+	}
+	var schema []*bigquery.TableFieldSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("invalid schema file %s: %s", schemaFile, err)
+	}
+	return schema, nil
+}
+
+func ValidateConfig(config *Config) error {
+	v := validator.New()
+	if err := v.Struct(config); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			return fmt.Errorf("%s validate failed on the %s rule", err.Namespace(), err.Tag())
+		}
+	}
+	for key, dataset := range config.Datasets {
+		if err := common.ValidateLabels(dataset.Labels); err != nil {
+			return fmt.Errorf("dataset %s: %s", key, err)
+		}
+		if dataset.DefaultTableExpiration != "" {
+			expiration, err := time.ParseDuration(dataset.DefaultTableExpiration)
+			if err != nil {
+				return fmt.Errorf("dataset %s: invalid default table expiration %s", key, dataset.DefaultTableExpiration)
+			}
+			if expiration < minTableExpiration {
+				return fmt.Errorf("dataset %s: default table expiration must be at least %s", key, minTableExpiration)
+			}
+		}
+		for i, entry := range dataset.Access {
+			members := 0
+			for _, member := range []string{entry.UserByEmail, entry.GroupByEmail, entry.Domain, entry.SpecialGroup} {
+				if member != "" {
+					members++
+				}
+			}
+			if members != 1 {
+				return fmt.Errorf("dataset %s: access entry %d must have exactly one of userByEmail, groupByEmail, domain and specialGroup", key, i)
+			}
+		}
+		for tableKey, table := range dataset.Tables {
+			if len(table.Schema) == 0 {
+				return fmt.Errorf("dataset %s: table %s has an empty schema", key, tableKey)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package bigquery
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+	"google.golang.org/api/bigquery/v2"
+)
+
+var validBigQueryConfig = []byte(`
+bigqueryDatasets:
+  analytics:
+    projectId: some-project
+    location: northamerica-northeast1
+    defaultTableExpiration: 720h
+    labels:
+      team: data
+    access:
+      - role: OWNER
+        groupByEmail: data@example.com
+      - role: READER
+        specialGroup: projectReaders
+    tables:
+      page-views:
+        schemaFile: page-views.json`)
+
+var invalidConfig = []byte(`
+bigqueryDatasets:
+  some-dataset:
+    location:
+      - should_not_be_an_array`)
+
+var _ = Describe("config", func() {
+	var dir string
+
+	BeforeEach(func() {
+		viper.Reset()
+		viper.SetConfigType("yaml")
+		dir = GinkgoT().TempDir()
+	})
+	Describe("GetBigQueryConfig", func() {
+		It("should successfully parse a dataset config and load the table schemas", func() {
+			schema := `[{"name": "url", "type": "STRING", "mode": "REQUIRED"}, {"name": "at", "type": "TIMESTAMP"}]`
+			Expect(os.WriteFile(filepath.Join(dir, "page-views.json"), []byte(schema), 0o644)).To(Succeed())
+			configFile := filepath.Join(dir, "fougere-lite.yaml")
+			Expect(os.WriteFile(configFile, validBigQueryConfig, 0o644)).To(Succeed())
+			viper.SetConfigFile(configFile)
+			Expect(viper.ReadInConfig()).To(Succeed())
+
+			bigqueryConfig, err := GetBigQueryConfig(viper.GetViper(), "some-client")
+			Expect(err).To(BeNil())
+			dataset := bigqueryConfig.Datasets["analytics"]
+			Expect(dataset.Name).To(Equal("some_client_analytics"))
+			Expect(dataset.Location).To(Equal("northamerica-northeast1"))
+			Expect(dataset.DefaultTableExpiration).To(Equal("720h"))
+			Expect(dataset.Labels).To(Equal(map[string]string{"team": "data"}))
+			Expect(dataset.Access).To(Equal([]AccessEntry{
+				{Role: "OWNER", GroupByEmail: "data@example.com"},
+				{Role: "READER", SpecialGroup: "projectReaders"},
+			}))
+			table := dataset.Tables["page-views"]
+			Expect(table.Name).To(Equal("page_views"))
+			Expect(table.Schema).To(Equal([]*bigquery.TableFieldSchema{
+				{Name: "url", Type: "STRING", Mode: "REQUIRED"},
+				{Name: "at", Type: "TIMESTAMP"},
+			}))
+			Expect(ValidateConfig(bigqueryConfig)).To(Succeed())
+		})
+		It("returns an error if the schema file cannot be read", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(validBigQueryConfig))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = GetBigQueryConfig(viper.GetViper(), "some-client")
+			Expect(err).To(MatchError(ContainSubstring("table analytics.page-views: cannot read schema file")))
+		})
+		It("returns an error if cannot parse the config", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(invalidConfig))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = GetBigQueryConfig(viper.GetViper(), "some-client")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("validates datasets", func() {
+		var dataset Dataset
+
+		BeforeEach(func() {
+			dataset = Dataset{
+				Name:      "banane_analytics",
+				ProjectId: "mock-project",
+				Location:  "US",
+			}
+		})
+
+		It("should not detect error", func() {
+			Expect(ValidateConfig(&Config{Datasets: map[string]Dataset{"analytics": dataset}})).To(Succeed())
+		})
+		It("should detect an empty location", func() {
+			dataset.Location = ""
+			err := ValidateConfig(&Config{Datasets: map[string]Dataset{"analytics": dataset}})
+			Expect(err).To(MatchError("Config.Datasets[analytics].Location validate failed on the required rule"))
+		})
+		It("should detect a default table expiration under an hour", func() {
+			dataset.DefaultTableExpiration = "30m"
+			err := ValidateConfig(&Config{Datasets: map[string]Dataset{"analytics": dataset}})
+			Expect(err).To(MatchError("dataset analytics: default table expiration must be at least 1h0m0s"))
+		})
+		It("should detect an access entry with several members", func() {
+			dataset.Access = []AccessEntry{{Role: "READER", UserByEmail: "a@example.com", GroupByEmail: "b@example.com"}}
+			err := ValidateConfig(&Config{Datasets: map[string]Dataset{"analytics": dataset}})
+			Expect(err).To(MatchError("dataset analytics: access entry 0 must have exactly one of userByEmail, groupByEmail, domain and specialGroup"))
+		})
+		It("should detect an invalid role", func() {
+			dataset.Access = []AccessEntry{{Role: "ADMIN", UserByEmail: "a@example.com"}}
+			err := ValidateConfig(&Config{Datasets: map[string]Dataset{"analytics": dataset}})
+			Expect(err).To(MatchError("Config.Datasets[analytics].Access[0].Role validate failed on the oneof rule"))
+		})
+	})
+})
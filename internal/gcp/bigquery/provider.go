@@ -0,0 +1,93 @@
+package bigquery
+
+import (
+	"context"
+
+	"github.com/spf13/viper"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/provider"
+)
+
+func init() {
+	provider.Register(&bigqueryProvider{})
+}
+
+// bigqueryProvider plugs the BigQuery datasets and tables into the provider
+// registry.
+type bigqueryProvider struct {
+	client *Client
+}
+
+func (p *bigqueryProvider) Key() string {
+	return Product
+}
+
+func (p *bigqueryProvider) Init(ctx context.Context, opts ...option.ClientOption) error {
+	client, err := NewClient(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	p.client = client
+	return nil
+}
+
+func (p *bigqueryProvider) GetConfig(viperConfig *viper.Viper, clientName string) (provider.Config, error) {
+	config, err := GetBigQueryConfig(viperConfig, clientName)
+	if err != nil || config == nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (p *bigqueryProvider) ValidateConfig(config provider.Config) error {
+	return ValidateConfig(config.(*Config))
+}
+
+func (p *bigqueryProvider) Resources(config provider.Config) []common.ResourceChange {
+	var resources []common.ResourceChange
+	for key, dataset := range config.(*Config).Datasets {
+		resources = append(resources, common.ResourceChange{
+			Client:  dataset.ClientName,
+			Product: Product,
+			Key:     key,
+			Name:    DatasetName(dataset),
+			Project: dataset.ProjectId,
+		})
+		for tableKey, table := range dataset.Tables {
+			resources = append(resources, common.ResourceChange{
+				Client:  dataset.ClientName,
+				Product: Product,
+				Key:     key + tablesKey + tableKey,
+				Name:    TableName(dataset, table),
+				Project: dataset.ProjectId,
+			})
+		}
+	}
+	return resources
+}
+
+func (p *bigqueryProvider) Create(config provider.Config) ([]common.AppliedResource, error) {
+	return p.client.Create(config.(*Config))
+}
+
+func (p *bigqueryProvider) Plan(config provider.Config) ([]common.ResourceChange, error) {
+	return p.client.Plan(config.(*Config))
+}
+
+func (p *bigqueryProvider) Verify(change common.ResourceChange) error {
+	return p.client.Verify(change)
+}
+
+func (p *bigqueryProvider) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	return p.client.Apply(change)
+}
+
+func (p *bigqueryProvider) Delete(config provider.Config, opts provider.DeleteOptions) error {
+	return p.client.Delete(config.(*Config), opts.Force)
+}
+
+// Before orders the datasets before their tables.
+func (p *bigqueryProvider) Before(a, b common.ResourceChange) bool {
+	return !isTable(a) && isTable(b)
+}
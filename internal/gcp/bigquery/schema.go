@@ -0,0 +1,88 @@
+package bigquery
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/bigquery/v2"
+	"metrio.net/fougere-lite/internal/common"
+)
+
+// Modes of a column. An empty mode is NULLABLE.
+const (
+	modeNullable = "NULLABLE"
+	modeRequired = "REQUIRED"
+	modeRepeated = "REPEATED"
+)
+
+// legacyTypes maps the standard SQL type names to the names returned by the
+// API.
+var legacyTypes = map[string]string{
+	"INT64":   "INTEGER",
+	"FLOAT64": "FLOAT",
+	"BOOL":    "BOOLEAN",
+	"STRUCT":  "RECORD",
+}
+
+// diffSchema lists the columns the desired schema adds to the live one and the
+// columns it relaxes from REQUIRED to NULLABLE, the only changes a table patch
+// can make. It returns an error for any other change, which would require the
+// table to be recreated.
+func diffSchema(live []*bigquery.TableFieldSchema, desired []*bigquery.TableFieldSchema, prefix string) ([]common.FieldDiff, error) {
+	var diffs []common.FieldDiff
+	desiredByName := map[string]*bigquery.TableFieldSchema{}
+	for _, field := range desired {
+		desiredByName[field.Name] = field
+	}
+	for _, liveField := range live {
+		path := prefix + liveField.Name
+		desiredField, ok := desiredByName[liveField.Name]
+		if !ok {
+			return nil, fmt.Errorf("column %s cannot be removed", path)
+		}
+		if fieldType(liveField) != fieldType(desiredField) {
+			return nil, fmt.Errorf("the type of column %s cannot be changed from %s to %s", path, fieldType(liveField), fieldType(desiredField))
+		}
+		liveMode, desiredMode := fieldMode(liveField), fieldMode(desiredField)
+		if liveMode != desiredMode {
+			if liveMode != modeRequired || desiredMode != modeNullable {
+				return nil, fmt.Errorf("the mode of column %s cannot be changed from %s to %s", path, liveMode, desiredMode)
+			}
+			diffs = append(diffs, common.FieldDiff{Field: "schema." + path + ".mode", Current: liveMode, Desired: desiredMode})
+		}
+		if fieldType(liveField) == "RECORD" {
+			nested, err := diffSchema(liveField.Fields, desiredField.Fields, path+".")
+			if err != nil {
+				return nil, err
+			}
+			diffs = append(diffs, nested...)
+		}
+		delete(desiredByName, liveField.Name)
+	}
+	for _, field := range desired {
+		if _, added := desiredByName[field.Name]; !added {
+			continue
+		}
+		path := prefix + field.Name
+		if fieldMode(field) == modeRequired {
+			return nil, fmt.Errorf("column %s cannot be added as REQUIRED", path)
+		}
+		diffs = append(diffs, common.FieldDiff{Field: "schema." + path, Current: nil, Desired: fieldType(field) + " " + fieldMode(field)})
+	}
+	return diffs, nil
+}
+
+func fieldType(field *bigquery.TableFieldSchema) string {
+	fieldType := strings.ToUpper(field.Type)
+	if legacy, ok := legacyTypes[fieldType]; ok {
+		return legacy
+	}
+	return fieldType
+}
+
+func fieldMode(field *bigquery.TableFieldSchema) string {
+	if field.Mode == "" {
+		return modeNullable
+	}
+	return strings.ToUpper(field.Mode)
+}
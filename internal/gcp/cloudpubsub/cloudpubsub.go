@@ -0,0 +1,562 @@
+package cloudpubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/pubsub/v1"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Product is the key of the topics and subscriptions in a client's config.
+const Product = "pubsub"
+
+// Prefixes of the keys of the topics and subscriptions in the plan and state,
+// e.g. topics.events.
+const (
+	topicsKey        = "topics"
+	subscriptionsKey = "subscriptions"
+)
+
+// subscriptionUpdateMask lists the properties of a subscription that can be
+// patched. The topic and the filter of a subscription cannot be changed.
+const subscriptionUpdateMask = "ackDeadlineSeconds,messageRetentionDuration,deadLetterPolicy,pushConfig,labels"
+
+type Client struct {
+	pubsubService *pubsub.Service
+}
+
+func NewClient(ctx context.Context, opts ...option.ClientOption) (*Client, error) {
+	pubsubService, err := pubsub.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		pubsubService: pubsubService,
+	}, nil
+}
+
+// Create creates the topics and subscriptions of the config that do not exist
+// and updates the others. The topics are created first so that the
+// subscriptions can reference them. It returns the resources that were
+// applied, even when some failed.
+func (c *Client) Create(config *Config) ([]common.AppliedResource, error) {
+	applied, err := c.createTopics(config)
+	if err != nil {
+		return applied, err
+	}
+	subscriptionsApplied, err := c.createSubscriptions(config)
+	return append(applied, subscriptionsApplied...), err
+}
+
+func (c *Client) createTopics(config *Config) ([]common.AppliedResource, error) {
+	createChannel := make(chan common.Response, len(config.PubSub.Topics))
+	for key, topic := range config.PubSub.Topics {
+		go func(resp chan common.Response, key string, topic Topic) {
+			spec := c.createTopicSpec(topic)
+			live, err := c.getTopic(spec.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+					utils.Logger.Debugf("[%s] topic not found", spec.Name)
+
+					if err := c.insertTopic(spec); err != nil {
+						resp <- common.Response{Err: err}
+						return
+					}
+				} else {
+					utils.Logger.Errorf("[%s] error getting topic: %s", spec.Name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+			} else {
+				spec.Labels = common.MergeLabels(live.Labels, spec.Labels)
+				if err := c.patchTopic(spec); err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+			}
+			resp <- common.Response{Applied: &common.AppliedResource{
+				Client:  topic.ClientName,
+				Product: Product,
+				Key:     topicsKey + "." + key,
+				Name:    spec.Name,
+				Spec:    spec,
+			}}
+		}(createChannel, key, topic)
+	}
+	var applied []common.AppliedResource
+	var createErr error
+	for range config.PubSub.Topics {
+		resp := <-createChannel
+		if resp.Err != nil {
+			createErr = resp.Err
+			continue
+		}
+		applied = append(applied, *resp.Applied)
+	}
+	return applied, createErr
+}
+
+func (c *Client) createSubscriptions(config *Config) ([]common.AppliedResource, error) {
+	createChannel := make(chan common.Response, len(config.PubSub.Subscriptions))
+	for key, subscription := range config.PubSub.Subscriptions {
+		go func(resp chan common.Response, key string, subscription Subscription) {
+			spec := c.createSubscriptionSpec(config, subscription)
+			live, err := c.getSubscription(spec.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+					utils.Logger.Debugf("[%s] subscription not found", spec.Name)
+
+					if err := c.insertSubscription(spec); err != nil {
+						resp <- common.Response{Err: err}
+						return
+					}
+				} else {
+					utils.Logger.Errorf("[%s] error getting subscription: %s", spec.Name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+			} else {
+				spec.Labels = common.MergeLabels(live.Labels, spec.Labels)
+				if err := checkImmutable(spec.Name, diffSubscription(live, spec)); err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+				if err := c.patchSubscription(spec); err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+			}
+			resp <- common.Response{Applied: &common.AppliedResource{
+				Client:  subscription.ClientName,
+				Product: Product,
+				Key:     subscriptionsKey + "." + key,
+				Name:    spec.Name,
+				Spec:    spec,
+			}}
+		}(createChannel, key, subscription)
+	}
+	var applied []common.AppliedResource
+	var createErr error
+	for range config.PubSub.Subscriptions {
+		resp := <-createChannel
+		if resp.Err != nil {
+			createErr = resp.Err
+			continue
+		}
+		applied = append(applied, *resp.Applied)
+	}
+	return applied, createErr
+}
+
+// Plan compares every topic and subscription of the config with its live state
+// and returns the change Create would make to each of them.
+func (c *Client) Plan(config *Config) ([]common.ResourceChange, error) {
+	total := len(config.PubSub.Topics) + len(config.PubSub.Subscriptions)
+	planChannel := make(chan common.Response, total)
+	for key, topic := range config.PubSub.Topics {
+		go func(resp chan common.Response, key string, topic Topic) {
+			spec := c.createTopicSpec(topic)
+			change := common.ResourceChange{
+				Client:  topic.ClientName,
+				Product: Product,
+				Key:     topicsKey + "." + key,
+				Name:    spec.Name,
+				Project: topic.ProjectId,
+			}
+			live, err := c.getTopic(spec.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+					utils.Logger.Errorf("[%s] error getting topic: %s", spec.Name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+				resp <- common.PlanChange(change, spec, nil, nil)
+				return
+			}
+			spec.Labels = common.MergeLabels(live.Labels, spec.Labels)
+			resp <- common.PlanChange(change, spec, live, diffTopic(live, spec))
+		}(planChannel, key, topic)
+	}
+	for key, subscription := range config.PubSub.Subscriptions {
+		go func(resp chan common.Response, key string, subscription Subscription) {
+			spec := c.createSubscriptionSpec(config, subscription)
+			change := common.ResourceChange{
+				Client:  subscription.ClientName,
+				Product: Product,
+				Key:     subscriptionsKey + "." + key,
+				Name:    spec.Name,
+				Project: subscription.ProjectId,
+			}
+			live, err := c.getSubscription(spec.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+					utils.Logger.Errorf("[%s] error getting subscription: %s", spec.Name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+				resp <- common.PlanChange(change, spec, nil, nil)
+				return
+			}
+			spec.Labels = common.MergeLabels(live.Labels, spec.Labels)
+			resp <- common.PlanChange(change, spec, live, diffSubscription(live, spec))
+		}(planChannel, key, subscription)
+	}
+	var changes []common.ResourceChange
+	var planErr error
+	for i := 0; i < total; i++ {
+		resp := <-planChannel
+		if resp.Err != nil {
+			planErr = resp.Err
+			continue
+		}
+		changes = append(changes, resp.Change)
+	}
+	return changes, planErr
+}
+
+// Verify returns an error if the live topic or subscription is not in the state
+// it was in when the change was planned.
+func (c *Client) Verify(change common.ResourceChange) error {
+	var live interface{}
+	var err error
+	if isTopic(change) {
+		live, err = c.getTopic(change.Name)
+	} else {
+		live, err = c.getSubscription(change.Name)
+	}
+	liveHash := ""
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+			return err
+		}
+	} else {
+		if liveHash, err = common.HashResource(live); err != nil {
+			return err
+		}
+	}
+	if liveHash != change.LiveHash {
+		return fmt.Errorf("[%s] %s changed since the plan was made", change.Name, kind(change))
+	}
+	return nil
+}
+
+// Apply runs a planned change with the spec saved in the plan. It returns the
+// applied topic or subscription, or nil when there was nothing to do.
+func (c *Client) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	if change.Action == common.ActionNoop {
+		return nil, nil
+	}
+	var spec interface{}
+	var err error
+	if isTopic(change) {
+		topic := &pubsub.Topic{}
+		if err := json.Unmarshal(change.Desired, topic); err != nil {
+			return nil, fmt.Errorf("[%s] invalid planned spec: %s", change.Name, err)
+		}
+		switch change.Action {
+		case common.ActionCreate:
+			err = c.insertTopic(topic)
+		case common.ActionUpdate:
+			err = c.patchTopic(topic)
+		default:
+			return nil, fmt.Errorf("[%s] unknown action %s", change.Name, change.Action)
+		}
+		spec = topic
+	} else {
+		subscription := &pubsub.Subscription{}
+		if err := json.Unmarshal(change.Desired, subscription); err != nil {
+			return nil, fmt.Errorf("[%s] invalid planned spec: %s", change.Name, err)
+		}
+		switch change.Action {
+		case common.ActionCreate:
+			err = c.insertSubscription(subscription)
+		case common.ActionUpdate:
+			if err := checkImmutable(change.Name, change.Diffs); err != nil {
+				return nil, err
+			}
+			err = c.patchSubscription(subscription)
+		default:
+			return nil, fmt.Errorf("[%s] unknown action %s", change.Name, change.Action)
+		}
+		spec = subscription
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &common.AppliedResource{
+		Client:  change.Client,
+		Product: Product,
+		Key:     change.Key,
+		Name:    change.Name,
+		Spec:    spec,
+	}, nil
+}
+
+// Delete deletes every subscription then every topic of the config. Resources
+// that do not exist are ignored.
+func (c *Client) Delete(config *Config) error {
+	deleteChannel := make(chan common.Response, len(config.PubSub.Subscriptions))
+	for _, subscription := range config.PubSub.Subscriptions {
+		go func(resp chan common.Response, subscription Subscription) {
+			resp <- common.Response{Err: c.deleteSubscription(SubscriptionName(subscription))}
+		}(deleteChannel, subscription)
+	}
+	var deleteErr error
+	for range config.PubSub.Subscriptions {
+		resp := <-deleteChannel
+		if resp.Err != nil {
+			deleteErr = resp.Err
+		}
+	}
+	if deleteErr != nil {
+		return deleteErr
+	}
+
+	deleteChannel = make(chan common.Response, len(config.PubSub.Topics))
+	for _, topic := range config.PubSub.Topics {
+		go func(resp chan common.Response, topic Topic) {
+			resp <- common.Response{Err: c.deleteTopic(TopicName(topic))}
+		}(deleteChannel, topic)
+	}
+	for range config.PubSub.Topics {
+		resp := <-deleteChannel
+		if resp.Err != nil {
+			deleteErr = resp.Err
+		}
+	}
+	return deleteErr
+}
+
+func (c *Client) getTopic(name string) (*pubsub.Topic, error) {
+	utils.Logger.Debugf("[%s] getting topic", name)
+	topic, err := c.pubsubService.Projects.Topics.Get(name).Do()
+	if err != nil {
+		return nil, err
+	}
+	return topic, nil
+}
+
+func (c *Client) insertTopic(spec *pubsub.Topic) error {
+	utils.Logger.Infof("[%s] creating topic", spec.Name)
+	_, err := c.pubsubService.Projects.Topics.Create(spec.Name, spec).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error creating topic: %s", spec.Name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) patchTopic(spec *pubsub.Topic) error {
+	utils.Logger.Infof("[%s] updating topic", spec.Name)
+	_, err := c.pubsubService.Projects.Topics.Patch(spec.Name, &pubsub.UpdateTopicRequest{
+		Topic:      spec,
+		UpdateMask: "labels",
+	}).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error updating topic: %s", spec.Name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) deleteTopic(name string) error {
+	utils.Logger.Infof("[%s] deleting topic", name)
+	_, err := c.pubsubService.Projects.Topics.Delete(name).Do()
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			utils.Logger.Infof("[%s] topic already deleted", name)
+			return nil
+		}
+		utils.Logger.Errorf("[%s] error deleting topic: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) getSubscription(name string) (*pubsub.Subscription, error) {
+	utils.Logger.Debugf("[%s] getting subscription", name)
+	subscription, err := c.pubsubService.Projects.Subscriptions.Get(name).Do()
+	if err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+func (c *Client) insertSubscription(spec *pubsub.Subscription) error {
+	utils.Logger.Infof("[%s] creating subscription", spec.Name)
+	_, err := c.pubsubService.Projects.Subscriptions.Create(spec.Name, spec).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error creating subscription: %s", spec.Name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) patchSubscription(spec *pubsub.Subscription) error {
+	utils.Logger.Infof("[%s] updating subscription", spec.Name)
+	_, err := c.pubsubService.Projects.Subscriptions.Patch(spec.Name, &pubsub.UpdateSubscriptionRequest{
+		Subscription: spec,
+		UpdateMask:   subscriptionUpdateMask,
+	}).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error updating subscription: %s", spec.Name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) deleteSubscription(name string) error {
+	utils.Logger.Infof("[%s] deleting subscription", name)
+	_, err := c.pubsubService.Projects.Subscriptions.Delete(name).Do()
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			utils.Logger.Infof("[%s] subscription already deleted", name)
+			return nil
+		}
+		utils.Logger.Errorf("[%s] error deleting subscription: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) createTopicSpec(topic Topic) *pubsub.Topic {
+	return &pubsub.Topic{
+		Name:   TopicName(topic),
+		Labels: common.OwnershipLabels(topic.ClientName),
+	}
+}
+
+func (c *Client) createSubscriptionSpec(config *Config, subscription Subscription) *pubsub.Subscription {
+	spec := &pubsub.Subscription{
+		Name:               SubscriptionName(subscription),
+		Topic:              resolveTopic(config, subscription.Topic),
+		AckDeadlineSeconds: subscription.AckDeadlineSeconds,
+		Filter:             subscription.Filter,
+		PushConfig:         &pubsub.PushConfig{PushEndpoint: subscription.PushEndpoint},
+		Labels:             common.OwnershipLabels(subscription.ClientName),
+	}
+	if subscription.MessageRetention != "" {
+		if retention, err := time.ParseDuration(subscription.MessageRetention); err == nil {
+			spec.MessageRetentionDuration = fmt.Sprintf("%ds", int64(retention.Seconds()))
+		}
+	}
+	if subscription.DeadLetterTopic != "" {
+		spec.DeadLetterPolicy = &pubsub.DeadLetterPolicy{
+			DeadLetterTopic:     resolveTopic(config, subscription.DeadLetterTopic),
+			MaxDeliveryAttempts: subscription.MaxDeliveryAttempts,
+		}
+	}
+	return spec
+}
+
+// resolveTopic returns the full resource name of a topic referenced by its key
+// in the config or by its full resource name.
+func resolveTopic(config *Config, reference string) string {
+	if strings.Contains(reference, "/") {
+		return reference
+	}
+	if topic, ok := config.PubSub.Topics[reference]; ok {
+		return TopicName(topic)
+	}
+	return reference
+}
+
+func diffTopic(live *pubsub.Topic, desired *pubsub.Topic) []common.FieldDiff {
+	var diffs []common.FieldDiff
+	diffs = common.AppendLabelDiffs(diffs, "labels", live.Labels, desired.Labels)
+	return diffs
+}
+
+// diffSubscription lists the properties of the desired spec that differ from
+// the live subscription. The ack deadline and the retention are filled by GCP
+// defaults when they are left empty in the config and are then not compared.
+func diffSubscription(live *pubsub.Subscription, desired *pubsub.Subscription) []common.FieldDiff {
+	var diffs []common.FieldDiff
+	diffs = common.AppendDiff(diffs, "topic", live.Topic, desired.Topic)
+	diffs = common.AppendDiff(diffs, "filter", live.Filter, desired.Filter)
+	if desired.AckDeadlineSeconds != 0 {
+		diffs = common.AppendDiff(diffs, "ackDeadlineSeconds", live.AckDeadlineSeconds, desired.AckDeadlineSeconds)
+	}
+	if desired.MessageRetentionDuration != "" && !sameDuration(live.MessageRetentionDuration, desired.MessageRetentionDuration) {
+		diffs = append(diffs, common.FieldDiff{Field: "messageRetentionDuration", Current: live.MessageRetentionDuration, Desired: desired.MessageRetentionDuration})
+	}
+	diffs = common.AppendDiff(diffs, "deadLetterPolicy.deadLetterTopic", deadLetterTopic(live), deadLetterTopic(desired))
+	if desired.DeadLetterPolicy != nil && desired.DeadLetterPolicy.MaxDeliveryAttempts != 0 {
+		diffs = common.AppendDiff(diffs, "deadLetterPolicy.maxDeliveryAttempts", maxDeliveryAttempts(live), desired.DeadLetterPolicy.MaxDeliveryAttempts)
+	}
+	diffs = common.AppendDiff(diffs, "pushConfig.pushEndpoint", pushEndpoint(live), pushEndpoint(desired))
+	diffs = common.AppendLabelDiffs(diffs, "labels", live.Labels, desired.Labels)
+	return diffs
+}
+
+// checkImmutable returns an error if the diffs change a property of a
+// subscription that cannot be patched.
+func checkImmutable(name string, diffs []common.FieldDiff) error {
+	for _, diff := range diffs {
+		if diff.Field == "topic" || diff.Field == "filter" {
+			return fmt.Errorf("[%s] the %s of a subscription cannot be changed, delete the subscription first", name, diff.Field)
+		}
+	}
+	return nil
+}
+
+func deadLetterTopic(subscription *pubsub.Subscription) string {
+	if subscription.DeadLetterPolicy == nil {
+		return ""
+	}
+	return subscription.DeadLetterPolicy.DeadLetterTopic
+}
+
+func maxDeliveryAttempts(subscription *pubsub.Subscription) int64 {
+	if subscription.DeadLetterPolicy == nil {
+		return 0
+	}
+	return subscription.DeadLetterPolicy.MaxDeliveryAttempts
+}
+
+func pushEndpoint(subscription *pubsub.Subscription) string {
+	if subscription.PushConfig == nil {
+		return ""
+	}
+	return subscription.PushConfig.PushEndpoint
+}
+
+// sameDuration compares two durations, GCP returns them in seconds (e.g.
+// "604800s") so they are parsed before being compared.
+func sameDuration(a, b string) bool {
+	durationA, errA := time.ParseDuration(a)
+	durationB, errB := time.ParseDuration(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return durationA == durationB
+}
+
+func isTopic(change common.ResourceChange) bool {
+	return strings.HasPrefix(change.Key, topicsKey+".")
+}
+
+func kind(change common.ResourceChange) string {
+	if isTopic(change) {
+		return "topic"
+	}
+	return "subscription"
+}
+
+// TopicName returns the full resource name of a topic.
+func TopicName(topic Topic) string {
+	return "projects/" + topic.ProjectId + "/topics/" + topic.Name
+}
+
+// SubscriptionName returns the full resource name of a subscription.
+func SubscriptionName(subscription Subscription) string {
+	return "projects/" + subscription.ProjectId + "/subscriptions/" + subscription.Name
+}
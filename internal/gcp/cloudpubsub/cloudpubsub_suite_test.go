@@ -0,0 +1,13 @@
+package cloudpubsub_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestCloudpubsub(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cloudpubsub Suite")
+}
@@ -0,0 +1,272 @@
+package cloudpubsub
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/option"
+	"google.golang.org/api/pubsub/v1"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Helper method to create client
+func getMockedClient(url string) *Client {
+	client, err := NewClient(context.Background(), option.WithoutAuthentication(), option.WithEndpoint(url))
+	if err != nil {
+		Fail(err.Error())
+	}
+	return client
+}
+
+var _ = Describe("Pub/Sub client", func() {
+	var topic Topic
+	var subscription Subscription
+	var config *Config
+	var topicName string
+	var subscriptionName string
+
+	BeforeEach(func() {
+		topic = Topic{
+			Name:       "banane-events",
+			ProjectId:  "projet-123",
+			ClientName: "banane",
+		}
+		subscription = Subscription{
+			Name:               "banane-worker",
+			ProjectId:          "projet-123",
+			Topic:              "events",
+			AckDeadlineSeconds: 30,
+			MessageRetention:   "24h",
+			ClientName:         "banane",
+		}
+		config = &Config{PubSub: PubSub{
+			Topics:        map[string]Topic{"events": topic},
+			Subscriptions: map[string]Subscription{"worker": subscription},
+		}}
+		topicName = "projects/projet-123/topics/banane-events"
+		subscriptionName = "projects/projet-123/subscriptions/banane-worker"
+	})
+
+	Describe("create subscription spec", func() {
+		It("resolves the topics and converts the retention", func() {
+			subscription.DeadLetterTopic = "events"
+			subscription.MaxDeliveryAttempts = 10
+			subscription.PushEndpoint = "https://worker.example.com/push"
+
+			spec := (&Client{}).createSubscriptionSpec(config, subscription)
+			Expect(spec.Name).To(Equal(subscriptionName))
+			Expect(spec.Topic).To(Equal(topicName))
+			Expect(spec.MessageRetentionDuration).To(Equal("86400s"))
+			Expect(spec.DeadLetterPolicy).To(Equal(&pubsub.DeadLetterPolicy{DeadLetterTopic: topicName, MaxDeliveryAttempts: 10}))
+			Expect(spec.PushConfig.PushEndpoint).To(Equal("https://worker.example.com/push"))
+			Expect(spec.Labels).To(Equal(common.OwnershipLabels("banane")))
+		})
+	})
+	Describe("create", func() {
+		It("creates the topic then the subscription when they do not exist", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 4)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+topicName)
+				},
+				ResponseCode: 404,
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+topicName)
+				},
+				Method: "put",
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+subscriptionName)
+				},
+				ResponseCode: 404,
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+subscriptionName)
+				},
+				Method: "put",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			applied, err := client.Create(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(HaveLen(2))
+			Expect(applied[0].Key).To(Equal("topics.events"))
+			Expect(applied[1].Key).To(Equal("subscriptions.worker"))
+		})
+		It("patches the existing subscription", func() {
+			config.PubSub.Topics = nil
+			subscription.Topic = topicName
+			config.PubSub.Subscriptions["worker"] = subscription
+			mockServerCalls := make(chan utils.MockServerCall, 2)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: pubsub.Subscription{Name: subscriptionName, Topic: topicName},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+subscriptionName)
+				},
+				Method: "patch",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			_, err := client.Create(config)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("refuses to change the topic of a subscription", func() {
+			config.PubSub.Topics = nil
+			subscription.Topic = topicName
+			config.PubSub.Subscriptions["worker"] = subscription
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: pubsub.Subscription{Name: subscriptionName, Topic: "projects/projet-123/topics/other"},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			_, err := client.Create(config)
+			Expect(err).To(MatchError(ContainSubstring("the topic of a subscription cannot be changed")))
+		})
+		It("returns the error when getting the topic fails", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseCode: 500,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			applied, err := client.Create(config)
+			Expect(err).To(HaveOccurred())
+			Expect(applied).To(BeEmpty())
+		})
+	})
+	Describe("plan", func() {
+		BeforeEach(func() {
+			config.PubSub.Topics = nil
+			subscription.Topic = topicName
+			config.PubSub.Subscriptions["worker"] = subscription
+		})
+
+		It("plans a create when the subscription does not exist", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseCode: 404,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(HaveLen(1))
+			Expect(changes[0].Action).To(Equal(common.ActionCreate))
+			Expect(changes[0].Key).To(Equal("subscriptions.worker"))
+			Expect(changes[0].Name).To(Equal(subscriptionName))
+		})
+		It("plans an update with the fields that differ", func() {
+			liveLabels := common.OwnershipLabels("banane")
+			liveLabels["owner"] = "platform"
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: pubsub.Subscription{
+					Name:                     subscriptionName,
+					Topic:                    topicName,
+					AckDeadlineSeconds:       10,
+					MessageRetentionDuration: "86400s",
+					PushConfig:               &pubsub.PushConfig{},
+					Labels:                   liveLabels,
+				},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Action).To(Equal(common.ActionUpdate))
+			Expect(changes[0].Diffs).To(ConsistOf(common.FieldDiff{Field: "ackDeadlineSeconds", Current: int64(10), Desired: int64(30)}))
+			Expect(string(changes[0].Desired)).To(ContainSubstring(`"owner":"platform"`))
+		})
+	})
+	Describe("apply planned change", func() {
+		It("creates the planned topic", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+topicName)
+				},
+				Method: "put",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			applied, err := client.Apply(common.ResourceChange{
+				Client:  "banane",
+				Product: Product,
+				Key:     "topics.events",
+				Name:    topicName,
+				Action:  common.ActionCreate,
+				Desired: []byte(`{"name":"` + topicName + `"}`),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied.Spec).To(Equal(&pubsub.Topic{Name: topicName}))
+		})
+		It("refuses a planned change of the filter", func() {
+			client := getMockedClient("http://localhost")
+
+			_, err := client.Apply(common.ResourceChange{
+				Product: Product,
+				Key:     "subscriptions.worker",
+				Name:    subscriptionName,
+				Action:  common.ActionUpdate,
+				Diffs:   []common.FieldDiff{{Field: "filter", Current: "", Desired: `attributes.type = "order"`}},
+				Desired: []byte(`{"name":"` + subscriptionName + `"}`),
+			})
+			Expect(err).To(MatchError(ContainSubstring("the filter of a subscription cannot be changed")))
+		})
+	})
+	Describe("delete", func() {
+		It("deletes the subscription then the topic", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 2)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+subscriptionName)
+				},
+				Method: "delete",
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+topicName)
+				},
+				Method:       "delete",
+				ResponseCode: 404,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			Expect(client.Delete(config)).To(Succeed())
+		})
+	})
+})
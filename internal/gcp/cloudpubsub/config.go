@@ -0,0 +1,125 @@
+// ©Copyright 2022 Metrio
+package cloudpubsub
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	PubSub PubSub `mapstructure:"pubsub" yaml:"pubsub"`
+}
+
+type PubSub struct {
+	Topics        map[string]Topic        `mapstructure:"topics" yaml:"topics,omitempty" validate:"dive"`
+	Subscriptions map[string]Subscription `mapstructure:"subscriptions" yaml:"subscriptions,omitempty" validate:"dive"`
+}
+
+type Topic struct {
+	Name       string `json:"name" yaml:"-" validate:"required"`
+	ProjectId  string `json:"projectId" yaml:"projectId" validate:"required"`
+	ClientName string `yaml:"-"`
+}
+
+type Subscription struct {
+	Name      string `json:"name" yaml:"-" validate:"required"`
+	ProjectId string `json:"projectId" yaml:"projectId" validate:"required"`
+	// Topic is the key of a topic of the client, or the full resource name of
+	// a topic, projects/<project>/topics/<topic>.
+	Topic              string `json:"topic" yaml:"topic" validate:"required"`
+	AckDeadlineSeconds int64  `json:"ackDeadlineSeconds" yaml:"ackDeadlineSeconds,omitempty" validate:"omitempty,min=10,max=600"`
+	// MessageRetention is a duration between 10m and 168h (7 days).
+	MessageRetention string `json:"messageRetention" yaml:"messageRetention,omitempty"`
+	// DeadLetterTopic is the key or the full resource name of the topic the
+	// messages are forwarded to after MaxDeliveryAttempts.
+	DeadLetterTopic     string `json:"deadLetterTopic" yaml:"deadLetterTopic,omitempty"`
+	MaxDeliveryAttempts int64  `json:"maxDeliveryAttempts" yaml:"maxDeliveryAttempts,omitempty" validate:"omitempty,min=5,max=100"`
+	PushEndpoint        string `json:"pushEndpoint" yaml:"pushEndpoint,omitempty" validate:"omitempty,url"`
+	Filter              string `json:"filter" yaml:"filter,omitempty"`
+	ClientName          string `yaml:"-"`
+}
+
+const (
+	minMessageRetention = 10 * time.Minute
+	maxMessageRetention = 7 * 24 * time.Hour
+)
+
+func GetPubSubConfig(viperConfig *viper.Viper, clientName string) (*Config, error) {
+	if viperConfig == nil {
+		return nil, nil
+	}
+
+	var pubsubConfig Config
+	err := viperConfig.Unmarshal(&pubsubConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, topic := range pubsubConfig.PubSub.Topics {
+		topic.Name = ResourceName(clientName, name)
+		topic.ClientName = clientName
+
+		pubsubConfig.PubSub.Topics[name] = topic
+	}
+	for name, subscription := range pubsubConfig.PubSub.Subscriptions {
+		subscription.Name = ResourceName(clientName, name)
+		subscription.ClientName = clientName
+
+		pubsubConfig.PubSub.Subscriptions[name] = subscription
+	}
+	return &pubsubConfig, nil
+}
+
+// ResourceName returns the GCP name of a topic or subscription, <client>-<key>.
+// Topics and subscriptions are unique per project, the client prefix keeps the
+// clients sharing a project apart.
+func ResourceName(clientName string, key string) string {
+	return fmt.Sprintf("%s-%s", clientName, key)
+}
+
+func ValidateConfig(config *Config) error {
+	v := validator.New()
+	if err := v.Struct(config); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			return fmt.Errorf("%s validate failed on the %s rule", err.Namespace(), err.Tag())
+		}
+	}
+	for key, subscription := range config.PubSub.Subscriptions {
+		if err := validateTopicReference(config, subscription.Topic); err != nil {
+			return fmt.Errorf("subscription %s: %s", key, err)
+		}
+		if subscription.DeadLetterTopic != "" {
+			if err := validateTopicReference(config, subscription.DeadLetterTopic); err != nil {
+				return fmt.Errorf("subscription %s: dead letter %s", key, err)
+			}
+		}
+		if subscription.MessageRetention != "" {
+			retention, err := time.ParseDuration(subscription.MessageRetention)
+			if err != nil {
+				return fmt.Errorf("subscription %s: invalid message retention %s", key, subscription.MessageRetention)
+			}
+			if retention < minMessageRetention || retention > maxMessageRetention {
+				return fmt.Errorf("subscription %s: message retention must be between %s and %s", key, minMessageRetention, maxMessageRetention)
+			}
+		}
+	}
+	return nil
+}
+
+func validateTopicReference(config *Config, topic string) error {
+	if strings.Contains(topic, "/") {
+		parts := strings.Split(topic, "/")
+		if len(parts) != 4 || parts[0] != "projects" || parts[2] != "topics" {
+			return fmt.Errorf("invalid topic name %s", topic)
+		}
+		return nil
+	}
+	if _, ok := config.PubSub.Topics[topic]; !ok {
+		return fmt.Errorf("topic %s is not declared", topic)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package cloudpubsub
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+var validPubSubConfig = []byte(`
+pubsub:
+  topics:
+    events:
+      projectId: some-project
+    events-dead-letter:
+      projectId: some-project
+  subscriptions:
+    events-worker:
+      projectId: some-project
+      topic: events
+      ackDeadlineSeconds: 30
+      messageRetention: 24h
+      deadLetterTopic: events-dead-letter
+      maxDeliveryAttempts: 10
+      pushEndpoint: https://worker.example.com/push
+      filter: attributes.type = "order"`)
+
+var invalidConfig = []byte(`
+pubsub:
+  topics:
+    some-topic:
+      projectId:
+        - should_not_be_an_array`)
+
+var _ = Describe("config", func() {
+	BeforeEach(func() {
+		viper.Reset()
+		viper.SetConfigType("yaml")
+	})
+	Describe("GetPubSubConfig", func() {
+		It("should successfully parse a pubsub config", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(validPubSubConfig))
+			Expect(err).ToNot(HaveOccurred())
+			pubsubConfig, err := GetPubSubConfig(viper.GetViper(), "some-client")
+			Expect(err).To(BeNil())
+			Expect(pubsubConfig.PubSub.Topics).To(HaveLen(2))
+			Expect(pubsubConfig.PubSub.Topics["events"].Name).To(Equal("some-client-events"))
+			subscription := pubsubConfig.PubSub.Subscriptions["events-worker"]
+			Expect(subscription.Name).To(Equal("some-client-events-worker"))
+			Expect(subscription.ProjectId).To(Equal("some-project"))
+			Expect(subscription.Topic).To(Equal("events"))
+			Expect(subscription.AckDeadlineSeconds).To(Equal(int64(30)))
+			Expect(subscription.MessageRetention).To(Equal("24h"))
+			Expect(subscription.DeadLetterTopic).To(Equal("events-dead-letter"))
+			Expect(subscription.MaxDeliveryAttempts).To(Equal(int64(10)))
+			Expect(subscription.PushEndpoint).To(Equal("https://worker.example.com/push"))
+			Expect(subscription.Filter).To(Equal(`attributes.type = "order"`))
+			Expect(ValidateConfig(pubsubConfig)).To(Succeed())
+		})
+		It("returns an error if cannot parse the config", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(invalidConfig))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = GetPubSubConfig(viper.GetViper(), "some-client")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("validates topics and subscriptions", func() {
+		var config *Config
+
+		BeforeEach(func() {
+			config = &Config{PubSub: PubSub{
+				Topics: map[string]Topic{
+					"events": {Name: "banane-events", ProjectId: "mock-project"},
+				},
+				Subscriptions: map[string]Subscription{
+					"worker": {Name: "banane-worker", ProjectId: "mock-project", Topic: "events"},
+				},
+			}}
+		})
+
+		It("should not detect error", func() {
+			Expect(ValidateConfig(config)).To(Succeed())
+		})
+		It("should accept the full name of a topic of another project", func() {
+			subscription := config.PubSub.Subscriptions["worker"]
+			subscription.Topic = "projects/other-project/topics/events"
+			config.PubSub.Subscriptions["worker"] = subscription
+			Expect(ValidateConfig(config)).To(Succeed())
+		})
+		It("should detect a subscription to an undeclared topic", func() {
+			subscription := config.PubSub.Subscriptions["worker"]
+			subscription.Topic = "unknown"
+			config.PubSub.Subscriptions["worker"] = subscription
+			Expect(ValidateConfig(config)).To(MatchError("subscription worker: topic unknown is not declared"))
+		})
+		It("should detect an undeclared dead letter topic", func() {
+			subscription := config.PubSub.Subscriptions["worker"]
+			subscription.DeadLetterTopic = "unknown"
+			config.PubSub.Subscriptions["worker"] = subscription
+			Expect(ValidateConfig(config)).To(MatchError("subscription worker: dead letter topic unknown is not declared"))
+		})
+		It("should detect an ack deadline out of range", func() {
+			subscription := config.PubSub.Subscriptions["worker"]
+			subscription.AckDeadlineSeconds = 601
+			config.PubSub.Subscriptions["worker"] = subscription
+			Expect(ValidateConfig(config)).To(MatchError("Config.PubSub.Subscriptions[worker].AckDeadlineSeconds validate failed on the max rule"))
+		})
+		It("should detect a message retention out of range", func() {
+			subscription := config.PubSub.Subscriptions["worker"]
+			subscription.MessageRetention = "240h"
+			config.PubSub.Subscriptions["worker"] = subscription
+			Expect(ValidateConfig(config)).To(MatchError("subscription worker: message retention must be between 10m0s and 168h0m0s"))
+		})
+		It("should detect an invalid message retention", func() {
+			subscription := config.PubSub.Subscriptions["worker"]
+			subscription.MessageRetention = "7 days"
+			config.PubSub.Subscriptions["worker"] = subscription
+			Expect(ValidateConfig(config)).To(MatchError("subscription worker: invalid message retention 7 days"))
+		})
+	})
+})
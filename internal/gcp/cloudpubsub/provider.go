@@ -0,0 +1,93 @@
+package cloudpubsub
+
+import (
+	"context"
+
+	"github.com/spf13/viper"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/provider"
+)
+
+func init() {
+	provider.Register(&pubsubProvider{})
+}
+
+// pubsubProvider plugs the Pub/Sub topics and subscriptions into the provider
+// registry.
+type pubsubProvider struct {
+	client *Client
+}
+
+func (p *pubsubProvider) Key() string {
+	return Product
+}
+
+func (p *pubsubProvider) Init(ctx context.Context, opts ...option.ClientOption) error {
+	client, err := NewClient(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	p.client = client
+	return nil
+}
+
+func (p *pubsubProvider) GetConfig(viperConfig *viper.Viper, clientName string) (provider.Config, error) {
+	config, err := GetPubSubConfig(viperConfig, clientName)
+	if err != nil || config == nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (p *pubsubProvider) ValidateConfig(config provider.Config) error {
+	return ValidateConfig(config.(*Config))
+}
+
+func (p *pubsubProvider) Resources(config provider.Config) []common.ResourceChange {
+	var resources []common.ResourceChange
+	for key, topic := range config.(*Config).PubSub.Topics {
+		resources = append(resources, common.ResourceChange{
+			Client:  topic.ClientName,
+			Product: Product,
+			Key:     topicsKey + "." + key,
+			Name:    TopicName(topic),
+			Project: topic.ProjectId,
+		})
+	}
+	for key, subscription := range config.(*Config).PubSub.Subscriptions {
+		resources = append(resources, common.ResourceChange{
+			Client:  subscription.ClientName,
+			Product: Product,
+			Key:     subscriptionsKey + "." + key,
+			Name:    SubscriptionName(subscription),
+			Project: subscription.ProjectId,
+		})
+	}
+	return resources
+}
+
+func (p *pubsubProvider) Create(config provider.Config) ([]common.AppliedResource, error) {
+	return p.client.Create(config.(*Config))
+}
+
+func (p *pubsubProvider) Plan(config provider.Config) ([]common.ResourceChange, error) {
+	return p.client.Plan(config.(*Config))
+}
+
+func (p *pubsubProvider) Verify(change common.ResourceChange) error {
+	return p.client.Verify(change)
+}
+
+func (p *pubsubProvider) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	return p.client.Apply(change)
+}
+
+func (p *pubsubProvider) Delete(config provider.Config, opts provider.DeleteOptions) error {
+	return p.client.Delete(config.(*Config))
+}
+
+// Before orders the topics before the subscriptions that reference them.
+func (p *pubsubProvider) Before(a, b common.ResourceChange) bool {
+	return isTopic(a) && !isTopic(b)
+}
@@ -0,0 +1,589 @@
+package cloudrun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/run/v1"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Product is the key of the Cloud Run services in a client's config.
+const Product = "cloudRun"
+
+// Annotations of the Knative resources of Cloud Run.
+const (
+	ingressAnnotation      = "run.googleapis.com/ingress"
+	minInstancesAnnotation = "autoscaling.knative.dev/minScale"
+	maxInstancesAnnotation = "autoscaling.knative.dev/maxScale"
+)
+
+const conditionReady = "Ready"
+
+// readyPollInterval and readyTimeout bound the wait for a new revision to
+// become ready.
+var (
+	readyPollInterval = 2 * time.Second
+	readyTimeout      = 5 * time.Minute
+)
+
+// RevisionError is returned when the revision created by a change of a service
+// fails to become ready.
+type RevisionError struct {
+	Service  string `json:"service"`
+	Revision string `json:"revision"`
+	Reason   string `json:"reason"`
+	Message  string `json:"message"`
+}
+
+func (e *RevisionError) Error() string {
+	return fmt.Sprintf("[%s] revision %s failed: %s: %s", e.Service, e.Revision, e.Reason, e.Message)
+}
+
+// Client calls the regional endpoint of Cloud Run of each region, which the
+// Knative API requires.
+type Client struct {
+	ctx         context.Context
+	opts        []option.ClientOption
+	mutex       sync.Mutex
+	runServices map[string]*run.APIService
+}
+
+func NewClient(ctx context.Context, opts ...option.ClientOption) (*Client, error) {
+	return &Client{
+		ctx:         ctx,
+		opts:        opts,
+		runServices: map[string]*run.APIService{},
+	}, nil
+}
+
+// service returns the client of the regional endpoint of a region. The
+// endpoint of the options given to NewClient takes precedence.
+func (c *Client) service(region string) (*run.APIService, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if runService, ok := c.runServices[region]; ok {
+		return runService, nil
+	}
+	opts := append([]option.ClientOption{option.WithEndpoint("https://" + region + "-run.googleapis.com/")}, c.opts...)
+	runService, err := run.NewService(c.ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.runServices[region] = runService
+	return runService, nil
+}
+
+// Create creates the services of the config that do not exist and updates the
+// others, then waits for their new revision to be ready. It returns the
+// services that were applied, even when some failed.
+func (c *Client) Create(config *Config) ([]common.AppliedResource, error) {
+	createChannel := make(chan common.Response, len(config.Services))
+	for key, service := range config.Services {
+		go func(resp chan common.Response, key string, service Service) {
+			spec := c.createServiceSpec(service)
+			name := ServiceName(service)
+			live, err := c.get(service.Region, name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+					utils.Logger.Debugf("[%s] service not found", name)
+
+					live, err = c.insert(service.Region, name, spec)
+				} else {
+					utils.Logger.Errorf("[%s] error getting service: %s", name, err)
+				}
+			} else {
+				live, err = c.replace(service.Region, name, mergeService(live, spec))
+			}
+			if err == nil {
+				err = c.waitReady(service.Region, name, live.Metadata.Generation)
+			}
+			if err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			resp <- common.Response{Applied: &common.AppliedResource{
+				Client:  service.ClientName,
+				Product: Product,
+				Key:     key,
+				Name:    name,
+				Spec:    spec,
+			}}
+		}(createChannel, key, service)
+	}
+	var applied []common.AppliedResource
+	var createErr error
+	for range config.Services {
+		resp := <-createChannel
+		if resp.Err != nil {
+			createErr = resp.Err
+			continue
+		}
+		applied = append(applied, *resp.Applied)
+	}
+	return applied, createErr
+}
+
+// Plan compares every service of the config with its live state and returns
+// the change Create would make to each of them.
+func (c *Client) Plan(config *Config) ([]common.ResourceChange, error) {
+	planChannel := make(chan common.Response, len(config.Services))
+	for key, service := range config.Services {
+		go func(resp chan common.Response, key string, service Service) {
+			spec := c.createServiceSpec(service)
+			change := common.ResourceChange{
+				Client:  service.ClientName,
+				Product: Product,
+				Key:     key,
+				Name:    ServiceName(service),
+				Project: service.ProjectId,
+			}
+			var err error
+			if change.Desired, err = json.Marshal(spec); err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			live, err := c.get(service.Region, change.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+					utils.Logger.Errorf("[%s] error getting service: %s", change.Name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+				change.Action = common.ActionCreate
+				resp <- common.Response{Change: change}
+				return
+			}
+			if change.LiveHash, err = common.HashResource(live); err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			change.Diffs = diffService(live, spec)
+			change.Action = common.ActionNoop
+			if len(change.Diffs) > 0 {
+				change.Action = common.ActionUpdate
+			}
+			resp <- common.Response{Change: change}
+		}(planChannel, key, service)
+	}
+	var changes []common.ResourceChange
+	var planErr error
+	for range config.Services {
+		resp := <-planChannel
+		if resp.Err != nil {
+			planErr = resp.Err
+			continue
+		}
+		changes = append(changes, resp.Change)
+	}
+	return changes, planErr
+}
+
+// Verify returns an error if the live service is not in the state it was in
+// when the change was planned.
+func (c *Client) Verify(change common.ResourceChange) error {
+	liveHash := ""
+	live, err := c.get(regionOf(change.Name), change.Name)
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+			return err
+		}
+	} else {
+		if liveHash, err = common.HashResource(live); err != nil {
+			return err
+		}
+	}
+	if liveHash != change.LiveHash {
+		return fmt.Errorf("[%s] service changed since the plan was made", change.Name)
+	}
+	return nil
+}
+
+// Apply runs a planned change with the spec saved in the plan and waits for the
+// new revision to be ready. It returns the applied service, or nil when there
+// was nothing to do.
+func (c *Client) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	if change.Action == common.ActionNoop {
+		return nil, nil
+	}
+	spec := &run.Service{}
+	if err := json.Unmarshal(change.Desired, spec); err != nil {
+		return nil, fmt.Errorf("[%s] invalid planned spec: %s", change.Name, err)
+	}
+	region := regionOf(change.Name)
+	var live *run.Service
+	var err error
+	switch change.Action {
+	case common.ActionCreate:
+		live, err = c.insert(region, change.Name, spec)
+	case common.ActionUpdate:
+		if live, err = c.get(region, change.Name); err == nil {
+			live, err = c.replace(region, change.Name, mergeService(live, spec))
+		}
+	default:
+		return nil, fmt.Errorf("[%s] unknown action %s", change.Name, change.Action)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := c.waitReady(region, change.Name, live.Metadata.Generation); err != nil {
+		return nil, err
+	}
+	return &common.AppliedResource{
+		Client:  change.Client,
+		Product: Product,
+		Key:     change.Key,
+		Name:    change.Name,
+		Spec:    spec,
+	}, nil
+}
+
+// Delete deletes every service of the config. Services that do not exist are
+// ignored.
+func (c *Client) Delete(config *Config) error {
+	deleteChannel := make(chan common.Response, len(config.Services))
+	for _, service := range config.Services {
+		go func(resp chan common.Response, service Service) {
+			resp <- common.Response{Err: c.delete(service.Region, ServiceName(service))}
+		}(deleteChannel, service)
+	}
+	var deleteErr error
+	for range config.Services {
+		resp := <-deleteChannel
+		if resp.Err != nil {
+			deleteErr = resp.Err
+		}
+	}
+	return deleteErr
+}
+
+func (c *Client) get(region string, name string) (*run.Service, error) {
+	utils.Logger.Debugf("[%s] getting service", name)
+	runService, err := c.service(region)
+	if err != nil {
+		return nil, err
+	}
+	service, err := runService.Projects.Locations.Services.Get(name).Do()
+	if err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+func (c *Client) insert(region string, name string, spec *run.Service) (*run.Service, error) {
+	utils.Logger.Infof("[%s] creating service", name)
+	runService, err := c.service(region)
+	if err != nil {
+		return nil, err
+	}
+	service, err := runService.Projects.Locations.Services.Create(parentOf(name), spec).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error creating service: %s", name, err)
+		return nil, err
+	}
+	return service, nil
+}
+
+func (c *Client) replace(region string, name string, spec *run.Service) (*run.Service, error) {
+	utils.Logger.Infof("[%s] updating service", name)
+	runService, err := c.service(region)
+	if err != nil {
+		return nil, err
+	}
+	service, err := runService.Projects.Locations.Services.ReplaceService(name, spec).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error updating service: %s", name, err)
+		return nil, err
+	}
+	return service, nil
+}
+
+func (c *Client) delete(region string, name string) error {
+	utils.Logger.Infof("[%s] deleting service", name)
+	runService, err := c.service(region)
+	if err != nil {
+		return err
+	}
+	_, err = runService.Projects.Locations.Services.Delete(name).Do()
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			utils.Logger.Infof("[%s] service already deleted", name)
+			return nil
+		}
+		utils.Logger.Errorf("[%s] error deleting service: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+// waitReady polls a service until it has rolled out the given generation. It
+// returns a RevisionError when the revision of that generation fails.
+func (c *Client) waitReady(region string, name string, generation int64) error {
+	deadline := time.Now().Add(readyTimeout)
+	for {
+		service, err := c.get(region, name)
+		if err != nil {
+			return err
+		}
+		if service.Status != nil && service.Status.ObservedGeneration >= generation {
+			ready := readyCondition(service)
+			if ready != nil && ready.Status == "True" {
+				utils.Logger.Infof("[%s] revision %s is ready", name, service.Status.LatestReadyRevisionName)
+				return nil
+			}
+			if ready != nil && ready.Status == "False" {
+				return &RevisionError{
+					Service:  name,
+					Revision: service.Status.LatestCreatedRevisionName,
+					Reason:   ready.Reason,
+					Message:  ready.Message,
+				}
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("[%s] the revision of generation %d was not ready after %s", name, generation, readyTimeout)
+		}
+		utils.Logger.Debugf("[%s] waiting for the revision of generation %d", name, generation)
+		time.Sleep(readyPollInterval)
+	}
+}
+
+func readyCondition(service *run.Service) *run.GoogleCloudRunV1Condition {
+	for _, condition := range service.Status.Conditions {
+		if condition.Type == conditionReady {
+			return condition
+		}
+	}
+	return nil
+}
+
+func (c *Client) createServiceSpec(service Service) *run.Service {
+	container := &run.Container{Image: service.Image}
+	for _, env := range service.Env {
+		container.Env = append(container.Env, &run.EnvVar{Name: env.Name, Value: env.Value})
+	}
+	for _, ref := range service.Secrets {
+		container.Env = append(container.Env, &run.EnvVar{
+			Name: ref.Name,
+			ValueFrom: &run.EnvVarSource{SecretKeyRef: &run.SecretKeySelector{
+				Name: ref.SecretId,
+				Key:  ref.Version,
+			}},
+		})
+	}
+	if service.Cpu != "" || service.Memory != "" {
+		container.Resources = &run.ResourceRequirements{Limits: map[string]string{}}
+		if service.Cpu != "" {
+			container.Resources.Limits["cpu"] = service.Cpu
+		}
+		if service.Memory != "" {
+			container.Resources.Limits["memory"] = service.Memory
+		}
+	}
+	templateAnnotations := map[string]string{}
+	if service.MinInstances > 0 {
+		templateAnnotations[minInstancesAnnotation] = strconv.FormatInt(service.MinInstances, 10)
+	}
+	if service.MaxInstances > 0 {
+		templateAnnotations[maxInstancesAnnotation] = strconv.FormatInt(service.MaxInstances, 10)
+	}
+	annotations := map[string]string{}
+	if service.Ingress != "" {
+		annotations[ingressAnnotation] = service.Ingress
+	}
+	return &run.Service{
+		ApiVersion: "serving.knative.dev/v1",
+		Kind:       "Service",
+		Metadata: &run.ObjectMeta{
+			Name:        service.Name,
+			Namespace:   service.ProjectId,
+			Labels:      common.OwnershipLabels(service.ClientName),
+			Annotations: annotations,
+		},
+		Spec: &run.ServiceSpec{
+			Template: &run.RevisionTemplate{
+				Metadata: &run.ObjectMeta{Annotations: templateAnnotations},
+				Spec: &run.RevisionSpec{
+					Containers:         []*run.Container{container},
+					ServiceAccountName: service.ServiceAccountEmail,
+				},
+			},
+		},
+	}
+}
+
+// mergeService returns a copy of the live service with the properties of the
+// desired spec, so that the properties the config does not manage keep the
+// values set by Cloud Run.
+func mergeService(live *run.Service, desired *run.Service) *run.Service {
+	merged := &run.Service{}
+	data, _ := json.Marshal(live)
+	_ = json.Unmarshal(data, merged)
+	merged.Status = nil
+	if merged.Metadata.Labels == nil {
+		merged.Metadata.Labels = map[string]string{}
+	}
+	for key, value := range desired.Metadata.Labels {
+		merged.Metadata.Labels[key] = value
+	}
+	if merged.Metadata.Annotations == nil {
+		merged.Metadata.Annotations = map[string]string{}
+	}
+	for key, value := range desired.Metadata.Annotations {
+		merged.Metadata.Annotations[key] = value
+	}
+	if merged.Spec == nil {
+		merged.Spec = &run.ServiceSpec{}
+	}
+	if merged.Spec.Template == nil {
+		merged.Spec.Template = &run.RevisionTemplate{}
+	}
+	template := merged.Spec.Template
+	// The revision name of the live template cannot be reused by a new
+	// revision.
+	if template.Metadata == nil {
+		template.Metadata = &run.ObjectMeta{}
+	}
+	template.Metadata.Name = ""
+	if template.Metadata.Annotations == nil {
+		template.Metadata.Annotations = map[string]string{}
+	}
+	desiredTemplate := desired.Spec.Template
+	delete(template.Metadata.Annotations, minInstancesAnnotation)
+	for key, value := range desiredTemplate.Metadata.Annotations {
+		template.Metadata.Annotations[key] = value
+	}
+	if template.Spec == nil || len(template.Spec.Containers) == 0 {
+		template.Spec = desiredTemplate.Spec
+		return merged
+	}
+	if desiredTemplate.Spec.ServiceAccountName != "" {
+		template.Spec.ServiceAccountName = desiredTemplate.Spec.ServiceAccountName
+	}
+	container := template.Spec.Containers[0]
+	desiredContainer := desiredTemplate.Spec.Containers[0]
+	container.Image = desiredContainer.Image
+	container.Env = desiredContainer.Env
+	if desiredContainer.Resources != nil {
+		if container.Resources == nil {
+			container.Resources = &run.ResourceRequirements{}
+		}
+		if container.Resources.Limits == nil {
+			container.Resources.Limits = map[string]string{}
+		}
+		for key, value := range desiredContainer.Resources.Limits {
+			container.Resources.Limits[key] = value
+		}
+	}
+	return merged
+}
+
+// diffService lists the properties of the desired spec that differ from the
+// live service. The properties left empty in the config keep the values set
+// by Cloud Run and are not compared.
+func diffService(live *run.Service, desired *run.Service) []common.FieldDiff {
+	var diffs []common.FieldDiff
+	liveTemplate := &run.RevisionTemplate{Metadata: &run.ObjectMeta{}, Spec: &run.RevisionSpec{}}
+	if live.Spec != nil && live.Spec.Template != nil {
+		liveTemplate = live.Spec.Template
+	}
+	liveContainer := &run.Container{}
+	if liveTemplate.Spec != nil && len(liveTemplate.Spec.Containers) > 0 {
+		liveContainer = liveTemplate.Spec.Containers[0]
+	}
+	desiredTemplate := desired.Spec.Template
+	desiredContainer := desiredTemplate.Spec.Containers[0]
+
+	diffs = common.AppendDiff(diffs, "image", liveContainer.Image, desiredContainer.Image)
+	liveEnv, desiredEnv := envValues(liveContainer), envValues(desiredContainer)
+	for _, name := range envNames(liveEnv, desiredEnv) {
+		diffs = common.AppendDiff(diffs, "env."+name, liveEnv[name], desiredEnv[name])
+	}
+	if desiredContainer.Resources != nil {
+		for _, key := range []string{"cpu", "memory"} {
+			if value, ok := desiredContainer.Resources.Limits[key]; ok {
+				current := ""
+				if liveContainer.Resources != nil {
+					current = liveContainer.Resources.Limits[key]
+				}
+				diffs = common.AppendDiff(diffs, key, current, value)
+			}
+		}
+	}
+	liveAnnotations := map[string]string{}
+	if liveTemplate.Metadata != nil && liveTemplate.Metadata.Annotations != nil {
+		liveAnnotations = liveTemplate.Metadata.Annotations
+	}
+	diffs = common.AppendDiff(diffs, "minInstances", liveAnnotations[minInstancesAnnotation], desiredTemplate.Metadata.Annotations[minInstancesAnnotation])
+	if value, ok := desiredTemplate.Metadata.Annotations[maxInstancesAnnotation]; ok {
+		diffs = common.AppendDiff(diffs, "maxInstances", liveAnnotations[maxInstancesAnnotation], value)
+	}
+	if value, ok := desired.Metadata.Annotations[ingressAnnotation]; ok {
+		diffs = common.AppendDiff(diffs, "ingress", live.Metadata.Annotations[ingressAnnotation], value)
+	}
+	if desiredTemplate.Spec.ServiceAccountName != "" {
+		serviceAccount := ""
+		if liveTemplate.Spec != nil {
+			serviceAccount = liveTemplate.Spec.ServiceAccountName
+		}
+		diffs = common.AppendDiff(diffs, "serviceAccount", serviceAccount, desiredTemplate.Spec.ServiceAccountName)
+	}
+	diffs = common.AppendLabelDiffs(diffs, "labels", live.Metadata.Labels, desired.Metadata.Labels)
+	return diffs
+}
+
+// envValues returns the value of each environment variable of a container.
+// Secrets are shown as secret:<secret>:<version>, never with their value.
+func envValues(container *run.Container) map[string]string {
+	values := map[string]string{}
+	for _, env := range container.Env {
+		if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+			values[env.Name] = "secret:" + env.ValueFrom.SecretKeyRef.Name + ":" + env.ValueFrom.SecretKeyRef.Key
+		} else {
+			values[env.Name] = env.Value
+		}
+	}
+	return values
+}
+
+func envNames(a map[string]string, b map[string]string) []string {
+	var names []string
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ServiceName returns the full resource name of a service.
+func ServiceName(service Service) string {
+	return common.LocationName(service.ProjectId, service.Region) + "/services/" + service.Name
+}
+
+// parentOf returns the location of a service from its full name.
+func parentOf(name string) string {
+	parent, _, _ := strings.Cut(name, "/services/")
+	return parent
+}
+
+// regionOf returns the region of a service from its full name,
+// projects/<project>/locations/<region>/services/<name>.
+func regionOf(name string) string {
+	_, region, _ := strings.Cut(parentOf(name), "/locations/")
+	return region
+}
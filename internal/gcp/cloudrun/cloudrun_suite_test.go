@@ -0,0 +1,13 @@
+package cloudrun_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestCloudrun(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cloudrun Suite")
+}
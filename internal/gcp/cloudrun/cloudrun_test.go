@@ -0,0 +1,204 @@
+package cloudrun
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/option"
+	"google.golang.org/api/run/v1"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Helper method to create client
+func getMockedClient(url string) *Client {
+	client, err := NewClient(context.Background(), option.WithoutAuthentication(), option.WithEndpoint(url))
+	if err != nil {
+		Fail(err.Error())
+	}
+	return client
+}
+
+var _ = Describe("Cloud Run client", func() {
+	var service Service
+	var serviceName string
+	var config *Config
+
+	BeforeEach(func() {
+		readyPollInterval = time.Millisecond
+		service = Service{
+			Name:         "banane-worker",
+			Region:       "us-central1",
+			ProjectId:    "projet-123",
+			Image:        "gcr.io/projet-123/worker:2",
+			Env:          []EnvVar{{Name: "QUEUE_NAME", Value: "queue1"}},
+			Secrets:      []SecretRef{{Name: "API_KEY", Secret: "api-key", Version: "latest", SecretId: "banane-api-key"}},
+			Memory:       "512Mi",
+			MinInstances: 1,
+			Ingress:      "internal",
+			ClientName:   "banane",
+		}
+		serviceName = "projects/projet-123/locations/us-central1/services/banane-worker"
+		config = &Config{Services: map[string]Service{"worker": service}}
+	})
+
+	liveService := func(generation int64, status string) run.Service {
+		return run.Service{
+			Metadata: &run.ObjectMeta{
+				Name:        "banane-worker",
+				Generation:  generation,
+				Labels:      map[string]string{"managed-by": "fougere-lite", "fougere-lite-client": "banane"},
+				Annotations: map[string]string{ingressAnnotation: "internal"},
+			},
+			Spec: &run.ServiceSpec{Template: &run.RevisionTemplate{
+				Metadata: &run.ObjectMeta{
+					Name:        "banane-worker-00001",
+					Annotations: map[string]string{minInstancesAnnotation: "1"},
+				},
+				Spec: &run.RevisionSpec{
+					TimeoutSeconds: 300,
+					Containers: []*run.Container{{
+						Image:     "gcr.io/projet-123/worker:1",
+						Resources: &run.ResourceRequirements{Limits: map[string]string{"cpu": "1000m", "memory": "512Mi"}},
+						Env: []*run.EnvVar{
+							{Name: "QUEUE_NAME", Value: "queue1"},
+							{Name: "API_KEY", ValueFrom: &run.EnvVarSource{SecretKeyRef: &run.SecretKeySelector{Name: "banane-api-key", Key: "latest"}}},
+						},
+					}},
+				},
+			}},
+			Status: &run.ServiceStatus{
+				ObservedGeneration:        generation,
+				LatestCreatedRevisionName: "banane-worker-00002",
+				LatestReadyRevisionName:   "banane-worker-00001",
+				Conditions: []*run.GoogleCloudRunV1Condition{{
+					Type:    "Ready",
+					Status:  status,
+					Reason:  "ContainerMissing",
+					Message: "Image 'gcr.io/projet-123/worker:2' not found.",
+				}},
+			},
+		}
+	}
+
+	Describe("create service spec", func() {
+		It("builds the Knative service of the config", func() {
+			client := &Client{}
+			spec := client.createServiceSpec(service)
+			Expect(spec.Metadata.Name).To(Equal("banane-worker"))
+			Expect(spec.Metadata.Namespace).To(Equal("projet-123"))
+			Expect(spec.Metadata.Annotations).To(HaveKeyWithValue(ingressAnnotation, "internal"))
+			template := spec.Spec.Template
+			Expect(template.Metadata.Annotations).To(Equal(map[string]string{minInstancesAnnotation: "1"}))
+			Expect(template.Spec.Containers[0].Env[1].ValueFrom.SecretKeyRef.Name).To(Equal("banane-api-key"))
+			Expect(template.Spec.Containers[0].Resources.Limits).To(Equal(map[string]string{"memory": "512Mi"}))
+		})
+	})
+	Describe("merge service", func() {
+		It("keeps the properties the config does not manage", func() {
+			live := liveService(1, "True")
+			client := &Client{}
+			merged := mergeService(&live, client.createServiceSpec(service))
+			Expect(merged.Status).To(BeNil())
+			Expect(merged.Spec.Template.Metadata.Name).To(BeEmpty())
+			Expect(merged.Spec.Template.Spec.TimeoutSeconds).To(Equal(int64(300)))
+			container := merged.Spec.Template.Spec.Containers[0]
+			Expect(container.Image).To(Equal("gcr.io/projet-123/worker:2"))
+			Expect(container.Resources.Limits).To(Equal(map[string]string{"cpu": "1000m", "memory": "512Mi"}))
+			Expect(live.Spec.Template.Spec.Containers[0].Image).To(Equal("gcr.io/projet-123/worker:1"))
+		})
+	})
+	Describe("plan service", func() {
+		It("plans an update with the fields that differ", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+serviceName)
+				},
+				ResponseBody: liveService(1, "True"),
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Action).To(Equal(common.ActionUpdate))
+			Expect(changes[0].Diffs).To(ConsistOf(common.FieldDiff{Field: "image", Current: "gcr.io/projet-123/worker:1", Desired: "gcr.io/projet-123/worker:2"}))
+		})
+		It("plans a create when the service does not exist", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{ResponseCode: 404}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Action).To(Equal(common.ActionCreate))
+			Expect(changes[0].Name).To(Equal(serviceName))
+		})
+	})
+	Describe("create service", func() {
+		It("replaces the service and waits for the new revision", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 4)
+			mockServerCalls <- utils.MockServerCall{ResponseBody: liveService(1, "True")}
+			mockServerCalls <- utils.MockServerCall{Method: "put", ResponseBody: liveService(2, "Unknown")}
+			mockServerCalls <- utils.MockServerCall{ResponseBody: liveService(1, "True")}
+			mockServerCalls <- utils.MockServerCall{ResponseBody: liveService(2, "True")}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			applied, err := client.Create(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(HaveLen(1))
+			Expect(mockServerCalls).To(BeEmpty())
+		})
+		It("returns a revision error when the new revision fails", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 3)
+			mockServerCalls <- utils.MockServerCall{ResponseCode: 404}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.Contains(url, "projects/projet-123/locations/us-central1/services?")
+				},
+				Method:       "post",
+				ResponseBody: liveService(1, "Unknown"),
+			}
+			mockServerCalls <- utils.MockServerCall{ResponseBody: liveService(1, "False")}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			_, err := client.Create(config)
+			var revisionErr *RevisionError
+			Expect(errors.As(err, &revisionErr)).To(BeTrue())
+			Expect(revisionErr).To(Equal(&RevisionError{
+				Service:  serviceName,
+				Revision: "banane-worker-00002",
+				Reason:   "ContainerMissing",
+				Message:  "Image 'gcr.io/projet-123/worker:2' not found.",
+			}))
+		})
+	})
+	Describe("delete service", func() {
+		It("ignores a service that does not exist", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{Method: "delete", ResponseCode: 404}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			Expect(client.Delete(config)).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,148 @@
+// ©Copyright 2022 Metrio
+package cloudrun
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/viper"
+	"metrio.net/fougere-lite/internal/gcp/iam"
+	"metrio.net/fougere-lite/internal/gcp/secretmanager"
+)
+
+type Config struct {
+	Services map[string]Service `mapstructure:"cloudRun" yaml:"cloudRun" validate:"dive"`
+}
+
+// Service is a Cloud Run service running one container image.
+type Service struct {
+	Name      string `json:"name" yaml:"-" validate:"required"`
+	Region    string `json:"region" yaml:"region" validate:"required"`
+	ProjectId string `json:"projectId" yaml:"projectId" validate:"required"`
+	Image     string `json:"image" yaml:"image" validate:"required"`
+	// Env are the plain environment variables of the container. They are a
+	// list since the keys of maps are lowercased when the config is read.
+	Env []EnvVar `json:"env" yaml:"env,omitempty" validate:"dive"`
+	// Secrets are the environment variables read from Secret Manager.
+	Secrets      []SecretRef `json:"secrets" yaml:"secrets,omitempty" validate:"dive"`
+	Cpu          string      `json:"cpu" yaml:"cpu,omitempty"`
+	Memory       string      `json:"memory" yaml:"memory,omitempty"`
+	MinInstances int64       `json:"minInstances" yaml:"minInstances,omitempty" validate:"gte=0"`
+	MaxInstances int64       `json:"maxInstances" yaml:"maxInstances,omitempty" validate:"gte=0"`
+	Ingress      string      `json:"ingress" yaml:"ingress,omitempty" validate:"omitempty,oneof=all internal internal-and-cloud-load-balancing"`
+	// ServiceAccount is the key of a service account of the client's iam
+	// config, or an email.
+	ServiceAccount string `json:"serviceAccount" yaml:"serviceAccount,omitempty"`
+	// ServiceAccountEmail is the resolved service account.
+	ServiceAccountEmail string `json:"-" yaml:"-"`
+	ClientName          string `yaml:"-"`
+}
+
+type EnvVar struct {
+	Name  string `json:"name" yaml:"name" validate:"required"`
+	Value string `json:"value" yaml:"value"`
+}
+
+// SecretRef exposes a version of a secret as an environment variable.
+type SecretRef struct {
+	Name string `json:"name" yaml:"name" validate:"required"`
+	// Secret is the key of a secret of the client's secrets config, or its
+	// full name projects/<project>/secrets/<secret>.
+	Secret string `json:"secret" yaml:"secret" validate:"required"`
+	// Version defaults to latest.
+	Version string `json:"version" yaml:"version,omitempty"`
+	// SecretId is the resolved id of the secret.
+	SecretId string `json:"-" yaml:"-"`
+}
+
+// serviceNamePattern is the format Cloud Run requires for the name of a
+// service.
+var serviceNamePattern = regexp.MustCompile(`^[a-z]([a-z0-9-]{0,47}[a-z0-9])?$`)
+
+// GetRunConfig parses the Cloud Run services of a client. The secrets and the
+// service account referenced by key are resolved with the config of the same
+// client.
+func GetRunConfig(viperConfig *viper.Viper, clientName string) (*Config, error) {
+	if viperConfig == nil {
+		return nil, nil
+	}
+
+	var runConfig Config
+	err := viperConfig.Unmarshal(&runConfig)
+	if err != nil {
+		return nil, err
+	}
+	secretConfig, err := secretmanager.GetSecretConfig(viperConfig, clientName)
+	if err != nil {
+		return nil, err
+	}
+	iamConfig, err := iam.GetIamConfig(viperConfig, clientName)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, service := range runConfig.Services {
+		service.Name = clientName + "-" + name
+		service.ClientName = clientName
+		service.ServiceAccountEmail = ""
+		if strings.Contains(service.ServiceAccount, "@") {
+			service.ServiceAccountEmail = service.ServiceAccount
+		} else if serviceAccount, ok := iamConfig.IAM.ServiceAccounts[service.ServiceAccount]; ok {
+			service.ServiceAccountEmail = iam.ServiceAccountEmail(serviceAccount)
+		}
+		for i, ref := range service.Secrets {
+			if ref.Version == "" {
+				ref.Version = "latest"
+			}
+			ref.SecretId = ""
+			if strings.Contains(ref.Secret, "/secrets/") {
+				ref.SecretId = ref.Secret[strings.LastIndex(ref.Secret, "/")+1:]
+			} else if secret, ok := secretConfig.Secrets[ref.Secret]; ok {
+				ref.SecretId = secret.Name
+			}
+			service.Secrets[i] = ref
+		}
+
+		runConfig.Services[name] = service
+	}
+	return &runConfig, nil
+}
+
+func ValidateConfig(config *Config) error {
+	v := validator.New()
+	if err := v.Struct(config); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			return fmt.Errorf("%s validate failed on the %s rule", err.Namespace(), err.Tag())
+		}
+	}
+	for key, service := range config.Services {
+		if !serviceNamePattern.MatchString(service.Name) {
+			return fmt.Errorf("service %s: name %s must be at most 49 lowercase letters, digits or dashes", key, service.Name)
+		}
+		if service.MaxInstances != 0 && service.MinInstances > service.MaxInstances {
+			return fmt.Errorf("service %s: minInstances must not be greater than maxInstances", key)
+		}
+		if service.ServiceAccount != "" && service.ServiceAccountEmail == "" {
+			return fmt.Errorf("service %s: service account %s is not declared in iam", key, service.ServiceAccount)
+		}
+		variables := map[string]bool{}
+		for _, env := range service.Env {
+			if variables[env.Name] {
+				return fmt.Errorf("service %s: env var %s is declared twice", key, env.Name)
+			}
+			variables[env.Name] = true
+		}
+		for _, ref := range service.Secrets {
+			if ref.SecretId == "" {
+				return fmt.Errorf("service %s: secret %s of %s is not declared in secrets", key, ref.Secret, ref.Name)
+			}
+			if variables[ref.Name] {
+				return fmt.Errorf("service %s: env var %s is declared twice", key, ref.Name)
+			}
+			variables[ref.Name] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package cloudrun
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+var validRunConfig = []byte(`
+secrets:
+  api-key:
+    projectId: some-project
+iam:
+  serviceAccounts:
+    worker:
+      projectId: some-project
+cloudRun:
+  worker:
+    region: us-central1
+    projectId: some-project
+    image: gcr.io/some-project/worker:1.2.3
+    env:
+      - name: QUEUE_NAME
+        value: queue1
+    secrets:
+      - name: API_KEY
+        secret: api-key
+      - name: SHARED_TOKEN
+        secret: projects/shared-project/secrets/shared-token
+        version: "3"
+    cpu: "1"
+    memory: 512Mi
+    minInstances: 1
+    maxInstances: 10
+    ingress: internal
+    serviceAccount: worker`)
+
+var invalidConfig = []byte(`
+cloudRun:
+  worker:
+    image:
+      - should_not_be_an_array`)
+
+var _ = Describe("config", func() {
+	BeforeEach(func() {
+		viper.Reset()
+		viper.SetConfigType("yaml")
+	})
+	Describe("GetRunConfig", func() {
+		It("should successfully parse a Cloud Run config and resolve its references", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(validRunConfig))
+			Expect(err).ToNot(HaveOccurred())
+			runConfig, err := GetRunConfig(viper.GetViper(), "some-client")
+			Expect(err).To(BeNil())
+			service := runConfig.Services["worker"]
+			Expect(service.Name).To(Equal("some-client-worker"))
+			Expect(service.Env).To(Equal([]EnvVar{{Name: "QUEUE_NAME", Value: "queue1"}}))
+			Expect(service.Secrets[0]).To(Equal(SecretRef{Name: "API_KEY", Secret: "api-key", Version: "latest", SecretId: "some-client-api-key"}))
+			Expect(service.Secrets[1].SecretId).To(Equal("shared-token"))
+			Expect(service.Secrets[1].Version).To(Equal("3"))
+			Expect(service.MinInstances).To(Equal(int64(1)))
+			Expect(service.ServiceAccountEmail).To(Equal("some-client-worker@some-project.iam.gserviceaccount.com"))
+			Expect(ValidateConfig(runConfig)).To(Succeed())
+		})
+		It("returns an error if cannot parse the config", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(invalidConfig))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = GetRunConfig(viper.GetViper(), "some-client")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("validates services", func() {
+		var service Service
+
+		BeforeEach(func() {
+			service = Service{
+				Name:      "banane-worker",
+				Region:    "us-central1",
+				ProjectId: "mock-project",
+				Image:     "gcr.io/mock-project/worker",
+				Env:       []EnvVar{{Name: "QUEUE_NAME", Value: "queue1"}},
+				Secrets:   []SecretRef{{Name: "API_KEY", Secret: "api-key", Version: "latest", SecretId: "banane-api-key"}},
+			}
+		})
+
+		It("should not detect error", func() {
+			Expect(ValidateConfig(&Config{Services: map[string]Service{"worker": service}})).To(Succeed())
+		})
+		It("should detect a service without image", func() {
+			service.Image = ""
+			err := ValidateConfig(&Config{Services: map[string]Service{"worker": service}})
+			Expect(err).To(MatchError("Config.Services[worker].Image validate failed on the required rule"))
+		})
+		It("should detect an invalid ingress", func() {
+			service.Ingress = "public"
+			err := ValidateConfig(&Config{Services: map[string]Service{"worker": service}})
+			Expect(err).To(MatchError("Config.Services[worker].Ingress validate failed on the oneof rule"))
+		})
+		It("should detect more min than max instances", func() {
+			service.MinInstances = 5
+			service.MaxInstances = 2
+			err := ValidateConfig(&Config{Services: map[string]Service{"worker": service}})
+			Expect(err).To(MatchError("service worker: minInstances must not be greater than maxInstances"))
+		})
+		It("should detect a secret that is not declared", func() {
+			service.Secrets[0].SecretId = ""
+			err := ValidateConfig(&Config{Services: map[string]Service{"worker": service}})
+			Expect(err).To(MatchError("service worker: secret api-key of API_KEY is not declared in secrets"))
+		})
+		It("should detect a variable declared twice", func() {
+			service.Secrets[0].Name = "QUEUE_NAME"
+			err := ValidateConfig(&Config{Services: map[string]Service{"worker": service}})
+			Expect(err).To(MatchError("service worker: env var QUEUE_NAME is declared twice"))
+		})
+		It("should detect a service account that is not declared", func() {
+			service.ServiceAccount = "unknown"
+			err := ValidateConfig(&Config{Services: map[string]Service{"worker": service}})
+			Expect(err).To(MatchError("service worker: service account unknown is not declared in iam"))
+		})
+	})
+})
@@ -0,0 +1,86 @@
+package cloudrun
+
+import (
+	"context"
+
+	"github.com/spf13/viper"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/gcp/iam"
+	"metrio.net/fougere-lite/internal/gcp/secretmanager"
+	"metrio.net/fougere-lite/internal/provider"
+)
+
+func init() {
+	provider.Register(&runProvider{})
+}
+
+// runProvider plugs the Cloud Run services into the provider registry.
+type runProvider struct {
+	client *Client
+}
+
+func (p *runProvider) Key() string {
+	return Product
+}
+
+// DependsOn creates the secrets and service accounts before the services
+// using them.
+func (p *runProvider) DependsOn() []string {
+	return []string{secretmanager.Product, iam.Product}
+}
+
+func (p *runProvider) Init(ctx context.Context, opts ...option.ClientOption) error {
+	client, err := NewClient(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	p.client = client
+	return nil
+}
+
+func (p *runProvider) GetConfig(viperConfig *viper.Viper, clientName string) (provider.Config, error) {
+	config, err := GetRunConfig(viperConfig, clientName)
+	if err != nil || config == nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (p *runProvider) ValidateConfig(config provider.Config) error {
+	return ValidateConfig(config.(*Config))
+}
+
+func (p *runProvider) Resources(config provider.Config) []common.ResourceChange {
+	var resources []common.ResourceChange
+	for key, service := range config.(*Config).Services {
+		resources = append(resources, common.ResourceChange{
+			Client:  service.ClientName,
+			Product: Product,
+			Key:     key,
+			Name:    ServiceName(service),
+			Project: service.ProjectId,
+		})
+	}
+	return resources
+}
+
+func (p *runProvider) Create(config provider.Config) ([]common.AppliedResource, error) {
+	return p.client.Create(config.(*Config))
+}
+
+func (p *runProvider) Plan(config provider.Config) ([]common.ResourceChange, error) {
+	return p.client.Plan(config.(*Config))
+}
+
+func (p *runProvider) Verify(change common.ResourceChange) error {
+	return p.client.Verify(change)
+}
+
+func (p *runProvider) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	return p.client.Apply(change)
+}
+
+func (p *runProvider) Delete(config provider.Config, opts provider.DeleteOptions) error {
+	return p.client.Delete(config.(*Config))
+}
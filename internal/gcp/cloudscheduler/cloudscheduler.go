@@ -0,0 +1,480 @@
+package cloudscheduler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"google.golang.org/api/cloudscheduler/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Product is the key of the scheduler jobs in a client's config.
+const Product = "schedulerJobs"
+
+// States of a job.
+const (
+	stateEnabled = "ENABLED"
+	statePaused  = "PAUSED"
+)
+
+// cloudTasksEndpoint is the endpoint a job targeting a queue calls to add a
+// task to it.
+const cloudTasksEndpoint = "https://cloudtasks.googleapis.com/v2/"
+
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+type Client struct {
+	cloudschedulerService *cloudscheduler.Service
+}
+
+func NewClient(ctx context.Context, opts ...option.ClientOption) (*Client, error) {
+	cloudschedulerService, err := cloudscheduler.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		cloudschedulerService: cloudschedulerService,
+	}, nil
+}
+
+// Create creates the jobs of the config that do not exist and updates the
+// others. It returns the jobs that were applied, even when some failed.
+func (c *Client) Create(config *Config) ([]common.AppliedResource, error) {
+	createChannel := make(chan common.Response, len(config.SchedulerJobs))
+	for key, job := range config.SchedulerJobs {
+		go func(resp chan common.Response, key string, job SchedulerJob) {
+			spec := c.createJobSpec(job)
+			live, err := c.get(spec.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+					utils.Logger.Debugf("[%s] job not found", spec.Name)
+
+					if err := c.insert(spec); err != nil {
+						resp <- common.Response{Err: err}
+						return
+					}
+				} else {
+					utils.Logger.Errorf("[%s] error getting job: %s", spec.Name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+			} else {
+				if err := c.patch(live, spec); err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+			}
+			resp <- common.Response{Applied: &common.AppliedResource{
+				Client:  job.ClientName,
+				Product: Product,
+				Key:     key,
+				Name:    spec.Name,
+				Spec:    spec,
+			}}
+		}(createChannel, key, job)
+	}
+	var applied []common.AppliedResource
+	var createErr error
+	for range config.SchedulerJobs {
+		resp := <-createChannel
+		if resp.Err != nil {
+			createErr = resp.Err
+			continue
+		}
+		applied = append(applied, *resp.Applied)
+	}
+	return applied, createErr
+}
+
+// Plan compares every job of the config with its live state and returns the
+// change Create would make to each of them.
+func (c *Client) Plan(config *Config) ([]common.ResourceChange, error) {
+	planChannel := make(chan common.Response, len(config.SchedulerJobs))
+	for key, job := range config.SchedulerJobs {
+		go func(resp chan common.Response, key string, job SchedulerJob) {
+			spec := c.createJobSpec(job)
+			desired, err := json.Marshal(spec)
+			if err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			change := common.ResourceChange{
+				Client:  job.ClientName,
+				Product: Product,
+				Key:     key,
+				Name:    spec.Name,
+				Project: job.ProjectId,
+				Desired: desired,
+			}
+			live, err := c.get(spec.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+					change.Action = common.ActionCreate
+					resp <- common.Response{Change: change}
+					return
+				}
+				utils.Logger.Errorf("[%s] error getting job: %s", spec.Name, err)
+				resp <- common.Response{Err: err}
+				return
+			}
+			if change.LiveHash, err = common.HashResource(live); err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			change.Diffs = diffJob(live, spec)
+			change.Action = common.ActionNoop
+			if len(change.Diffs) > 0 {
+				change.Action = common.ActionUpdate
+			}
+			resp <- common.Response{Change: change}
+		}(planChannel, key, job)
+	}
+	var changes []common.ResourceChange
+	var planErr error
+	for range config.SchedulerJobs {
+		resp := <-planChannel
+		if resp.Err != nil {
+			planErr = resp.Err
+			continue
+		}
+		changes = append(changes, resp.Change)
+	}
+	return changes, planErr
+}
+
+// Verify returns an error if the live job is not in the state it was in when
+// the change was planned.
+func (c *Client) Verify(change common.ResourceChange) error {
+	liveHash := ""
+	live, err := c.get(change.Name)
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+			return err
+		}
+	} else {
+		if liveHash, err = common.HashResource(live); err != nil {
+			return err
+		}
+	}
+	if liveHash != change.LiveHash {
+		return fmt.Errorf("[%s] job changed since the plan was made", change.Name)
+	}
+	return nil
+}
+
+// Apply runs a planned change with the spec saved in the plan. It returns the
+// applied job, or nil when there was nothing to do.
+func (c *Client) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	if change.Action == common.ActionNoop {
+		return nil, nil
+	}
+	var spec cloudscheduler.Job
+	if err := json.Unmarshal(change.Desired, &spec); err != nil {
+		return nil, fmt.Errorf("[%s] invalid planned spec: %s", change.Name, err)
+	}
+	var err error
+	switch change.Action {
+	case common.ActionCreate:
+		err = c.insert(&spec)
+	case common.ActionUpdate:
+		var live *cloudscheduler.Job
+		if live, err = c.get(change.Name); err == nil {
+			err = c.patch(live, &spec)
+		}
+	default:
+		return nil, fmt.Errorf("[%s] unknown action %s", change.Name, change.Action)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &common.AppliedResource{
+		Client:  change.Client,
+		Product: Product,
+		Key:     change.Key,
+		Name:    change.Name,
+		Spec:    &spec,
+	}, nil
+}
+
+// Delete deletes every job of the config. Jobs that do not exist are ignored.
+func (c *Client) Delete(config *Config) error {
+	deleteChannel := make(chan common.Response, len(config.SchedulerJobs))
+	for _, job := range config.SchedulerJobs {
+		go func(resp chan common.Response, job SchedulerJob) {
+			resp <- common.Response{Err: c.delete(JobName(job))}
+		}(deleteChannel, job)
+	}
+	var deleteErr error
+	for range config.SchedulerJobs {
+		resp := <-deleteChannel
+		if resp.Err != nil {
+			deleteErr = resp.Err
+		}
+	}
+	return deleteErr
+}
+
+func (c *Client) get(name string) (*cloudscheduler.Job, error) {
+	utils.Logger.Debugf("[%s] getting job", name)
+	job, err := c.cloudschedulerService.Projects.Locations.Jobs.Get(name).Do()
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// insert creates the job, then pauses it if the spec is paused. The state of
+// a job is output only and can only be changed with pause and resume.
+func (c *Client) insert(spec *cloudscheduler.Job) error {
+	utils.Logger.Infof("[%s] creating job", spec.Name)
+	parent := path.Dir(path.Dir(spec.Name))
+	job := *spec
+	job.State = ""
+	_, err := c.cloudschedulerService.Projects.Locations.Jobs.Create(parent, &job).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error creating job: %s", spec.Name, err)
+		return err
+	}
+	if spec.State == statePaused {
+		return c.setState(spec.Name, statePaused)
+	}
+	return nil
+}
+
+// patch updates the job, then pauses or resumes it if its state differs from
+// the spec.
+func (c *Client) patch(live *cloudscheduler.Job, spec *cloudscheduler.Job) error {
+	utils.Logger.Infof("[%s] updating job", spec.Name)
+	job := *spec
+	job.State = ""
+	_, err := c.cloudschedulerService.Projects.Locations.Jobs.Patch(spec.Name, &job).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error updating job: %s", spec.Name, err)
+		return err
+	}
+	if live.State != spec.State {
+		return c.setState(spec.Name, spec.State)
+	}
+	return nil
+}
+
+func (c *Client) setState(name string, state string) error {
+	var err error
+	if state == statePaused {
+		utils.Logger.Infof("[%s] pausing job", name)
+		_, err = c.cloudschedulerService.Projects.Locations.Jobs.Pause(name, &cloudscheduler.PauseJobRequest{}).Do()
+	} else {
+		utils.Logger.Infof("[%s] resuming job", name)
+		_, err = c.cloudschedulerService.Projects.Locations.Jobs.Resume(name, &cloudscheduler.ResumeJobRequest{}).Do()
+	}
+	if err != nil {
+		utils.Logger.Errorf("[%s] error changing the job state to %s: %s", name, state, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) delete(name string) error {
+	utils.Logger.Infof("[%s] deleting job", name)
+	_, err := c.cloudschedulerService.Projects.Locations.Jobs.Delete(name).Do()
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			utils.Logger.Infof("[%s] job already deleted", name)
+			return nil
+		}
+		utils.Logger.Errorf("[%s] error deleting job: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) createJobSpec(job SchedulerJob) *cloudscheduler.Job {
+	spec := &cloudscheduler.Job{
+		Name:        JobName(job),
+		Schedule:    job.Schedule,
+		TimeZone:    job.TimeZone,
+		Description: job.Description,
+		State:       stateEnabled,
+	}
+	if job.Paused {
+		spec.State = statePaused
+	}
+	switch {
+	case job.Http != nil:
+		spec.HttpTarget = &cloudscheduler.HttpTarget{
+			Uri:        job.Http.Uri,
+			HttpMethod: httpMethod(job.Http.HttpMethod),
+			Body:       base64.StdEncoding.EncodeToString([]byte(job.Http.Body)),
+			Headers:    job.Http.Headers,
+		}
+		if job.Http.ServiceAccountEmail != "" {
+			spec.HttpTarget.OidcToken = &cloudscheduler.OidcToken{ServiceAccountEmail: job.Http.ServiceAccountEmail}
+		}
+	case job.PubSub != nil:
+		spec.PubsubTarget = &cloudscheduler.PubsubTarget{
+			TopicName:  job.PubSub.TopicName,
+			Data:       base64.StdEncoding.EncodeToString([]byte(job.PubSub.Data)),
+			Attributes: job.PubSub.Attributes,
+		}
+	case job.Queue != nil:
+		spec.HttpTarget = queueHttpTarget(job.Queue)
+	}
+	if job.Retry != nil {
+		spec.RetryConfig = &cloudscheduler.RetryConfig{
+			RetryCount:         job.Retry.RetryCount,
+			MaxRetryDuration:   apiDuration(job.Retry.MaxRetryDuration),
+			MinBackoffDuration: apiDuration(job.Retry.MinBackoff),
+			MaxBackoffDuration: apiDuration(job.Retry.MaxBackoff),
+			MaxDoublings:       job.Retry.MaxDoublings,
+		}
+	}
+	return spec
+}
+
+// createTaskRequest is the body of a Cloud Tasks tasks.create call.
+type createTaskRequest struct {
+	Task struct {
+		HttpRequest taskHttpRequest `json:"httpRequest"`
+	} `json:"task"`
+}
+
+type taskHttpRequest struct {
+	Url        string `json:"url"`
+	HttpMethod string `json:"httpMethod"`
+	Body       string `json:"body,omitempty"`
+	OidcToken  struct {
+		ServiceAccountEmail string `json:"serviceAccountEmail"`
+	} `json:"oidcToken"`
+}
+
+// queueHttpTarget returns the target of a job adding a task to a queue: a
+// call to the tasks.create method of the Cloud Tasks API.
+func queueHttpTarget(queue *QueueTarget) *cloudscheduler.HttpTarget {
+	var request createTaskRequest
+	request.Task.HttpRequest = taskHttpRequest{
+		Url:        queue.Uri,
+		HttpMethod: httpMethod(queue.HttpMethod),
+	}
+	if queue.Body != "" {
+		request.Task.HttpRequest.Body = base64.StdEncoding.EncodeToString([]byte(queue.Body))
+	}
+	request.Task.HttpRequest.OidcToken.ServiceAccountEmail = queue.ServiceAccountEmail
+	body, _ := json.Marshal(request)
+	return &cloudscheduler.HttpTarget{
+		Uri:        cloudTasksEndpoint + queue.QueueName + "/tasks",
+		HttpMethod: http.MethodPost,
+		Body:       base64.StdEncoding.EncodeToString(body),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		OauthToken: &cloudscheduler.OAuthToken{
+			ServiceAccountEmail: queue.ServiceAccountEmail,
+			Scope:               cloudPlatformScope,
+		},
+	}
+}
+
+// apiDuration converts a duration of the config, e.g. 1m, to the format of the
+// API, a number of seconds, e.g. 60s.
+func apiDuration(duration string) string {
+	parsed, err := time.ParseDuration(duration)
+	if duration == "" || err != nil {
+		return duration
+	}
+	return fmt.Sprintf("%ds", int64(parsed.Seconds()))
+}
+
+func httpMethod(method string) string {
+	if method == "" {
+		return http.MethodPost
+	}
+	return method
+}
+
+// diffJob lists the properties of the desired spec that differ from the live
+// job. The time zone and the retry config are filled by GCP defaults when they
+// are left empty in the config and are then not compared. GCP adds its own
+// headers to the HTTP targets, so headers are not compared either.
+func diffJob(live *cloudscheduler.Job, desired *cloudscheduler.Job) []common.FieldDiff {
+	var diffs []common.FieldDiff
+	diffs = common.AppendDiff(diffs, "schedule", live.Schedule, desired.Schedule)
+	if desired.TimeZone != "" {
+		diffs = common.AppendDiff(diffs, "timeZone", live.TimeZone, desired.TimeZone)
+	}
+	diffs = common.AppendDiff(diffs, "description", live.Description, desired.Description)
+	diffs = common.AppendDiff(diffs, "state", live.State, desired.State)
+
+	liveHttp := live.HttpTarget
+	if liveHttp == nil {
+		liveHttp = &cloudscheduler.HttpTarget{}
+	}
+	desiredHttp := desired.HttpTarget
+	if desiredHttp == nil {
+		desiredHttp = &cloudscheduler.HttpTarget{}
+	}
+	diffs = common.AppendDiff(diffs, "httpTarget.uri", liveHttp.Uri, desiredHttp.Uri)
+	if desired.HttpTarget != nil {
+		diffs = common.AppendDiff(diffs, "httpTarget.httpMethod", liveHttp.HttpMethod, desiredHttp.HttpMethod)
+		diffs = common.AppendDiff(diffs, "httpTarget.body", liveHttp.Body, desiredHttp.Body)
+	}
+
+	livePubsub := live.PubsubTarget
+	if livePubsub == nil {
+		livePubsub = &cloudscheduler.PubsubTarget{}
+	}
+	desiredPubsub := desired.PubsubTarget
+	if desiredPubsub == nil {
+		desiredPubsub = &cloudscheduler.PubsubTarget{}
+	}
+	diffs = common.AppendDiff(diffs, "pubsubTarget.topicName", livePubsub.TopicName, desiredPubsub.TopicName)
+	diffs = common.AppendDiff(diffs, "pubsubTarget.data", livePubsub.Data, desiredPubsub.Data)
+
+	if desired.RetryConfig != nil {
+		liveRetry := live.RetryConfig
+		if liveRetry == nil {
+			liveRetry = &cloudscheduler.RetryConfig{}
+		}
+		diffs = common.AppendDiff(diffs, "retryConfig.retryCount", liveRetry.RetryCount, desired.RetryConfig.RetryCount)
+		if desired.RetryConfig.MaxDoublings != 0 {
+			diffs = common.AppendDiff(diffs, "retryConfig.maxDoublings", liveRetry.MaxDoublings, desired.RetryConfig.MaxDoublings)
+		}
+		durations := []struct{ field, live, desired string }{
+			{"retryConfig.maxRetryDuration", liveRetry.MaxRetryDuration, desired.RetryConfig.MaxRetryDuration},
+			{"retryConfig.minBackoffDuration", liveRetry.MinBackoffDuration, desired.RetryConfig.MinBackoffDuration},
+			{"retryConfig.maxBackoffDuration", liveRetry.MaxBackoffDuration, desired.RetryConfig.MaxBackoffDuration},
+		}
+		for _, duration := range durations {
+			if duration.desired != "" && !sameDuration(duration.live, duration.desired) {
+				diffs = append(diffs, common.FieldDiff{Field: duration.field, Current: duration.live, Desired: duration.desired})
+			}
+		}
+	}
+	return diffs
+}
+
+// sameDuration compares two durations, GCP returns them normalized (e.g. "5s")
+// so they are parsed before being compared.
+func sameDuration(a, b string) bool {
+	durationA, errA := time.ParseDuration(a)
+	durationB, errB := time.ParseDuration(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return durationA == durationB
+}
+
+// LocationName returns the resource name of the location of a job.
+func LocationName(job SchedulerJob) string {
+	return common.LocationName(job.ProjectId, job.Region)
+}
+
+// JobName returns the full resource name of a job.
+func JobName(job SchedulerJob) string {
+	return LocationName(job) + "/jobs/" + job.Name
+}
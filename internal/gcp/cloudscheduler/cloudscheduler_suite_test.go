@@ -0,0 +1,13 @@
+package cloudscheduler_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestCloudscheduler(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cloudscheduler Suite")
+}
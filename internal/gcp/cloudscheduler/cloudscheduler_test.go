@@ -0,0 +1,193 @@
+package cloudscheduler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/cloudscheduler/v1"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Helper method to create client
+func getMockedClient(url string) *Client {
+	client, err := NewClient(context.Background(), option.WithoutAuthentication(), option.WithEndpoint(url))
+	if err != nil {
+		Fail(err.Error())
+	}
+	return client
+}
+
+var _ = Describe("Scheduler client", func() {
+	var job SchedulerJob
+	var parent string
+	var jobName string
+
+	BeforeEach(func() {
+		job = SchedulerJob{
+			Name:       "nightly-export",
+			Region:     "northamerica-northeast1",
+			ProjectId:  "projet-123",
+			Schedule:   "0 3 * * *",
+			Http:       &HttpTarget{Uri: "https://worker.example.com/export"},
+			ClientName: "banane",
+		}
+		parent = "projects/" + job.ProjectId + "/locations/" + job.Region
+		jobName = parent + "/jobs/" + job.Name
+	})
+
+	Describe("create job spec", func() {
+		It("targets the tasks of a queue", func() {
+			job.Http = nil
+			job.Queue = &QueueTarget{
+				Key:                 "queue1",
+				Uri:                 "https://worker.example.com/export",
+				Body:                "{}",
+				ServiceAccountEmail: "scheduler@projet-123.iam.gserviceaccount.com",
+				QueueName:           parent + "/queues/queue1",
+			}
+
+			spec := (&Client{}).createJobSpec(job)
+			Expect(spec.Name).To(Equal(jobName))
+			Expect(spec.State).To(Equal(stateEnabled))
+			Expect(spec.HttpTarget.Uri).To(Equal("https://cloudtasks.googleapis.com/v2/" + parent + "/queues/queue1/tasks"))
+			Expect(spec.HttpTarget.HttpMethod).To(Equal("POST"))
+			Expect(spec.HttpTarget.OauthToken.ServiceAccountEmail).To(Equal("scheduler@projet-123.iam.gserviceaccount.com"))
+
+			body, err := base64.StdEncoding.DecodeString(spec.HttpTarget.Body)
+			Expect(err).ToNot(HaveOccurred())
+			var request createTaskRequest
+			Expect(json.Unmarshal(body, &request)).To(Succeed())
+			Expect(request.Task.HttpRequest.Url).To(Equal("https://worker.example.com/export"))
+			Expect(request.Task.HttpRequest.Body).To(Equal(base64.StdEncoding.EncodeToString([]byte("{}"))))
+		})
+		It("converts the retry durations", func() {
+			job.Paused = true
+			job.Retry = &Retry{RetryCount: 3, MaxBackoff: "1m"}
+
+			spec := (&Client{}).createJobSpec(job)
+			Expect(spec.State).To(Equal(statePaused))
+			Expect(spec.RetryConfig.MaxBackoffDuration).To(Equal("60s"))
+		})
+	})
+	Describe("create job", func() {
+		It("creates the job and pauses it", func() {
+			job.Paused = true
+			mockServerCalls := make(chan utils.MockServerCall, 3)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseCode: 404,
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+parent+"/jobs")
+				},
+				Method: "post",
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+jobName+":pause")
+				},
+				Method: "post",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			applied, err := client.Create(&Config{SchedulerJobs: map[string]SchedulerJob{"nightly-export": job}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(HaveLen(1))
+			Expect(applied[0].Name).To(Equal(jobName))
+		})
+		It("updates the job and resumes it", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 3)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: cloudscheduler.Job{Name: jobName, State: statePaused},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+jobName)
+				},
+				Method: "patch",
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+jobName+":resume")
+				},
+				Method: "post",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			_, err := client.Create(&Config{SchedulerJobs: map[string]SchedulerJob{"nightly-export": job}})
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Describe("plan job", func() {
+		It("plans a create when the job does not exist", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseCode: 404,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(&Config{SchedulerJobs: map[string]SchedulerJob{"nightly-export": job}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(HaveLen(1))
+			Expect(changes[0].Action).To(Equal(common.ActionCreate))
+		})
+		It("plans an update with the fields that differ", func() {
+			spec := (&Client{}).createJobSpec(job)
+			live := *spec
+			live.Schedule = "0 4 * * *"
+			live.TimeZone = "Etc/UTC"
+			live.HttpTarget = &cloudscheduler.HttpTarget{
+				Uri:        spec.HttpTarget.Uri,
+				HttpMethod: spec.HttpTarget.HttpMethod,
+				Body:       spec.HttpTarget.Body,
+				Headers:    map[string]string{"User-Agent": "Google-Cloud-Scheduler"},
+			}
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: live,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(&Config{SchedulerJobs: map[string]SchedulerJob{"nightly-export": job}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Action).To(Equal(common.ActionUpdate))
+			Expect(changes[0].Diffs).To(ConsistOf(common.FieldDiff{Field: "schedule", Current: "0 4 * * *", Desired: "0 3 * * *"}))
+		})
+	})
+	Describe("delete job", func() {
+		It("ignores the jobs that do not exist", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+jobName)
+				},
+				Method:       "delete",
+				ResponseCode: 404,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			Expect(client.Delete(&Config{SchedulerJobs: map[string]SchedulerJob{"nightly-export": job}})).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,154 @@
+// ©Copyright 2022 Metrio
+package cloudscheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/viper"
+	"metrio.net/fougere-lite/internal/gcp/cloudpubsub"
+	"metrio.net/fougere-lite/internal/gcp/cloudtasks"
+)
+
+type Config struct {
+	SchedulerJobs map[string]SchedulerJob `mapstructure:"schedulerJobs" yaml:"schedulerJobs" validate:"dive"`
+}
+
+// SchedulerJob is a cron job with exactly one of the Http, PubSub and Queue
+// targets.
+type SchedulerJob struct {
+	Name        string `json:"name" yaml:"-" validate:"required"`
+	Region      string `json:"region" yaml:"region" validate:"required"`
+	ProjectId   string `json:"projectId" yaml:"projectId" validate:"required"`
+	Schedule    string `json:"schedule" yaml:"schedule" validate:"required"`
+	TimeZone    string `json:"timeZone" yaml:"timeZone,omitempty"`
+	Description string `json:"description" yaml:"description,omitempty"`
+	Paused      bool   `json:"paused" yaml:"paused,omitempty"`
+
+	Http   *HttpTarget   `json:"http" yaml:"http,omitempty" validate:"omitempty"`
+	PubSub *PubSubTarget `json:"pubsub" yaml:"pubsub,omitempty" validate:"omitempty"`
+	Queue  *QueueTarget  `json:"queue" yaml:"queue,omitempty" validate:"omitempty"`
+	Retry  *Retry        `json:"retry" yaml:"retry,omitempty" validate:"omitempty"`
+
+	ClientName string `yaml:"-"`
+}
+
+type HttpTarget struct {
+	Uri        string            `json:"uri" yaml:"uri" validate:"required,url"`
+	HttpMethod string            `json:"httpMethod" yaml:"httpMethod,omitempty" validate:"omitempty,oneof=POST GET HEAD PUT DELETE PATCH OPTIONS"`
+	Body       string            `json:"body" yaml:"body,omitempty"`
+	Headers    map[string]string `json:"headers" yaml:"headers,omitempty"`
+	// ServiceAccountEmail is the service account of the OIDC token sent with
+	// the request.
+	ServiceAccountEmail string `json:"serviceAccountEmail" yaml:"serviceAccountEmail,omitempty" validate:"omitempty,email"`
+}
+
+type PubSubTarget struct {
+	// Topic is the key of a topic of the client's pubsub config, or the full
+	// resource name of a topic, projects/<project>/topics/<topic>.
+	Topic      string            `json:"topic" yaml:"topic" validate:"required"`
+	Data       string            `json:"data" yaml:"data,omitempty"`
+	Attributes map[string]string `json:"attributes" yaml:"attributes,omitempty"`
+	TopicName  string            `json:"-" yaml:"-"`
+}
+
+// QueueTarget adds a task to a queue of the client's cloudTasks config. The
+// task sends an HTTP request to Uri.
+type QueueTarget struct {
+	// Key is the key of the queue in the client's cloudTasks config.
+	Key        string `json:"key" yaml:"key" validate:"required"`
+	Uri        string `json:"uri" yaml:"uri" validate:"required,url"`
+	HttpMethod string `json:"httpMethod" yaml:"httpMethod,omitempty" validate:"omitempty,oneof=POST GET HEAD PUT DELETE PATCH OPTIONS"`
+	Body       string `json:"body" yaml:"body,omitempty"`
+	// ServiceAccountEmail is the service account used to add the task to the
+	// queue and of the OIDC token sent with the task's request.
+	ServiceAccountEmail string `json:"serviceAccountEmail" yaml:"serviceAccountEmail" validate:"required,email"`
+	QueueName           string `json:"-" yaml:"-"`
+}
+
+type Retry struct {
+	RetryCount       int64  `json:"retryCount" yaml:"retryCount,omitempty" validate:"omitempty,min=0,max=5"`
+	MaxRetryDuration string `json:"maxRetryDuration" yaml:"maxRetryDuration,omitempty"`
+	MinBackoff       string `json:"minBackoff" yaml:"minBackoff,omitempty"`
+	MaxBackoff       string `json:"maxBackoff" yaml:"maxBackoff,omitempty"`
+	MaxDoublings     int64  `json:"maxDoublings" yaml:"maxDoublings,omitempty"`
+}
+
+// GetSchedulerConfig parses the scheduler jobs of a client. The queue and
+// topic targets referenced by key are resolved with the cloudTasks and pubsub
+// configs of the same client.
+func GetSchedulerConfig(viperConfig *viper.Viper, clientName string) (*Config, error) {
+	if viperConfig == nil {
+		return nil, nil
+	}
+
+	var schedulerConfig Config
+	err := viperConfig.Unmarshal(&schedulerConfig)
+	if err != nil {
+		return nil, err
+	}
+	taskConfig, err := cloudtasks.GetTaskConfig(viperConfig, clientName)
+	if err != nil {
+		return nil, err
+	}
+	pubsubConfig, err := cloudpubsub.GetPubSubConfig(viperConfig, clientName)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, job := range schedulerConfig.SchedulerJobs {
+		job.Name = name
+		job.ClientName = clientName
+		if job.Queue != nil {
+			if queue, ok := taskConfig.TaskQueues[job.Queue.Key]; ok {
+				job.Queue.QueueName = cloudtasks.QueueName(queue)
+			}
+		}
+		if job.PubSub != nil {
+			if strings.Contains(job.PubSub.Topic, "/") {
+				job.PubSub.TopicName = job.PubSub.Topic
+			} else if topic, ok := pubsubConfig.PubSub.Topics[job.PubSub.Topic]; ok {
+				job.PubSub.TopicName = cloudpubsub.TopicName(topic)
+			}
+		}
+
+		schedulerConfig.SchedulerJobs[name] = job
+	}
+	return &schedulerConfig, nil
+}
+
+func ValidateConfig(config *Config) error {
+	v := validator.New()
+	if err := v.Struct(config); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			return fmt.Errorf("%s validate failed on the %s rule", err.Namespace(), err.Tag())
+		}
+	}
+	for key, job := range config.SchedulerJobs {
+		targets := 0
+		for _, set := range []bool{job.Http != nil, job.PubSub != nil, job.Queue != nil} {
+			if set {
+				targets++
+			}
+		}
+		if targets != 1 {
+			return fmt.Errorf("job %s: exactly one of http, pubsub and queue must be set", key)
+		}
+		if job.Queue != nil && job.Queue.QueueName == "" {
+			return fmt.Errorf("job %s: queue %s is not declared in cloudTasks", key, job.Queue.Key)
+		}
+		if job.PubSub != nil && job.PubSub.TopicName == "" {
+			return fmt.Errorf("job %s: topic %s is not declared in pubsub", key, job.PubSub.Topic)
+		}
+		if job.Retry != nil {
+			for _, duration := range []string{job.Retry.MaxRetryDuration, job.Retry.MinBackoff, job.Retry.MaxBackoff} {
+				if _, err := time.ParseDuration(duration); duration != "" && err != nil {
+					return fmt.Errorf("job %s: invalid retry duration %s", key, duration)
+				}
+			}
+		}
+	}
+	return nil
+}
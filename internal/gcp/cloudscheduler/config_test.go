@@ -0,0 +1,122 @@
+package cloudscheduler
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+var validSchedulerConfig = []byte(`
+cloudTasks:
+  queue1:
+    region: us-central1
+    projectId: some-project
+pubsub:
+  topics:
+    events:
+      projectId: some-project
+schedulerJobs:
+  nightly-export:
+    region: us-central1
+    projectId: some-project
+    schedule: 0 3 * * *
+    timeZone: America/Montreal
+    paused: true
+    queue:
+      key: queue1
+      uri: https://worker.example.com/export
+      serviceAccountEmail: scheduler@some-project.iam.gserviceaccount.com
+    retry:
+      retryCount: 3
+      minBackoff: 5s
+  hourly-ping:
+    region: us-central1
+    projectId: some-project
+    schedule: 0 * * * *
+    pubsub:
+      topic: events
+      data: ping`)
+
+var invalidConfig = []byte(`
+schedulerJobs:
+  some-job:
+    schedule:
+      - should_not_be_an_array`)
+
+var _ = Describe("config", func() {
+	BeforeEach(func() {
+		viper.Reset()
+		viper.SetConfigType("yaml")
+	})
+	Describe("GetSchedulerConfig", func() {
+		It("should successfully parse a scheduler config and resolve its targets", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(validSchedulerConfig))
+			Expect(err).ToNot(HaveOccurred())
+			schedulerConfig, err := GetSchedulerConfig(viper.GetViper(), "some-client")
+			Expect(err).To(BeNil())
+			Expect(schedulerConfig.SchedulerJobs).To(HaveLen(2))
+			job := schedulerConfig.SchedulerJobs["nightly-export"]
+			Expect(job.Name).To(Equal("nightly-export"))
+			Expect(job.Schedule).To(Equal("0 3 * * *"))
+			Expect(job.TimeZone).To(Equal("America/Montreal"))
+			Expect(job.Paused).To(BeTrue())
+			Expect(job.Queue.QueueName).To(Equal("projects/some-project/locations/us-central1/queues/queue1"))
+			Expect(job.Retry.RetryCount).To(Equal(int64(3)))
+			Expect(job.Retry.MinBackoff).To(Equal("5s"))
+			ping := schedulerConfig.SchedulerJobs["hourly-ping"]
+			Expect(ping.PubSub.TopicName).To(Equal("projects/some-project/topics/some-client-events"))
+			Expect(ValidateConfig(schedulerConfig)).To(Succeed())
+		})
+		It("returns an error if cannot parse the config", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(invalidConfig))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = GetSchedulerConfig(viper.GetViper(), "some-client")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("validates scheduler jobs", func() {
+		var job SchedulerJob
+
+		BeforeEach(func() {
+			job = SchedulerJob{
+				Name:      "foooo",
+				Region:    "us-central1",
+				ProjectId: "mock-project",
+				Schedule:  "* * * * *",
+				Http:      &HttpTarget{Uri: "https://example.com"},
+			}
+		})
+
+		It("should not detect error", func() {
+			Expect(ValidateConfig(&Config{SchedulerJobs: map[string]SchedulerJob{"foooo": job}})).To(Succeed())
+		})
+		It("should detect a job without target", func() {
+			job.Http = nil
+			err := ValidateConfig(&Config{SchedulerJobs: map[string]SchedulerJob{"foooo": job}})
+			Expect(err).To(MatchError("job foooo: exactly one of http, pubsub and queue must be set"))
+		})
+		It("should detect a job with several targets", func() {
+			job.PubSub = &PubSubTarget{Topic: "events", TopicName: "projects/mock-project/topics/events"}
+			err := ValidateConfig(&Config{SchedulerJobs: map[string]SchedulerJob{"foooo": job}})
+			Expect(err).To(MatchError("job foooo: exactly one of http, pubsub and queue must be set"))
+		})
+		It("should detect a queue that is not declared", func() {
+			job.Http = nil
+			job.Queue = &QueueTarget{Key: "unknown", Uri: "https://example.com", ServiceAccountEmail: "sa@mock-project.iam.gserviceaccount.com"}
+			err := ValidateConfig(&Config{SchedulerJobs: map[string]SchedulerJob{"foooo": job}})
+			Expect(err).To(MatchError("job foooo: queue unknown is not declared in cloudTasks"))
+		})
+		It("should detect an invalid HTTP method", func() {
+			job.Http.HttpMethod = "FETCH"
+			err := ValidateConfig(&Config{SchedulerJobs: map[string]SchedulerJob{"foooo": job}})
+			Expect(err).To(MatchError("Config.SchedulerJobs[foooo].Http.HttpMethod validate failed on the oneof rule"))
+		})
+		It("should detect an empty schedule", func() {
+			job.Schedule = ""
+			err := ValidateConfig(&Config{SchedulerJobs: map[string]SchedulerJob{"foooo": job}})
+			Expect(err).To(MatchError("Config.SchedulerJobs[foooo].Schedule validate failed on the required rule"))
+		})
+	})
+})
@@ -0,0 +1,85 @@
+package cloudscheduler
+
+import (
+	"context"
+
+	"github.com/spf13/viper"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/gcp/cloudpubsub"
+	"metrio.net/fougere-lite/internal/gcp/cloudtasks"
+	"metrio.net/fougere-lite/internal/provider"
+)
+
+func init() {
+	provider.Register(&schedulerProvider{})
+}
+
+// schedulerProvider plugs the Cloud Scheduler jobs into the provider registry.
+type schedulerProvider struct {
+	client *Client
+}
+
+func (p *schedulerProvider) Key() string {
+	return Product
+}
+
+// DependsOn creates the queues and topics before the jobs targeting them.
+func (p *schedulerProvider) DependsOn() []string {
+	return []string{cloudtasks.Product, cloudpubsub.Product}
+}
+
+func (p *schedulerProvider) Init(ctx context.Context, opts ...option.ClientOption) error {
+	client, err := NewClient(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	p.client = client
+	return nil
+}
+
+func (p *schedulerProvider) GetConfig(viperConfig *viper.Viper, clientName string) (provider.Config, error) {
+	config, err := GetSchedulerConfig(viperConfig, clientName)
+	if err != nil || config == nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (p *schedulerProvider) ValidateConfig(config provider.Config) error {
+	return ValidateConfig(config.(*Config))
+}
+
+func (p *schedulerProvider) Resources(config provider.Config) []common.ResourceChange {
+	var resources []common.ResourceChange
+	for key, job := range config.(*Config).SchedulerJobs {
+		resources = append(resources, common.ResourceChange{
+			Client:  job.ClientName,
+			Product: Product,
+			Key:     key,
+			Name:    JobName(job),
+			Project: job.ProjectId,
+		})
+	}
+	return resources
+}
+
+func (p *schedulerProvider) Create(config provider.Config) ([]common.AppliedResource, error) {
+	return p.client.Create(config.(*Config))
+}
+
+func (p *schedulerProvider) Plan(config provider.Config) ([]common.ResourceChange, error) {
+	return p.client.Plan(config.(*Config))
+}
+
+func (p *schedulerProvider) Verify(change common.ResourceChange) error {
+	return p.client.Verify(change)
+}
+
+func (p *schedulerProvider) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	return p.client.Apply(change)
+}
+
+func (p *schedulerProvider) Delete(config provider.Config, opts provider.DeleteOptions) error {
+	return p.client.Delete(config.(*Config))
+}
@@ -2,17 +2,36 @@ package cloudstorage
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 
+	"google.golang.org/api/cloudkms/v1"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 	"google.golang.org/api/storage/v1"
 	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/gcp/iam/iampolicy"
 	"metrio.net/fougere-lite/internal/utils"
 )
 
+// Product is the key of the storage buckets in a client's config.
+const Product = "storageBucket"
+
+// kmsKeyRole is granted on the default KMS key of a bucket to the Cloud Storage
+// service agent of its project, so that it can encrypt and decrypt objects.
+const kmsKeyRole = "roles/cloudkms.cryptoKeyEncrypterDecrypter"
+
 type Client struct {
 	storageService *storage.Service
+	kmsService     *cloudkms.Service
+	// confirmRetentionLock allows locking the retention policies, which cannot
+	// be undone.
+	confirmRetentionLock bool
 }
 
 func NewClient(ctx context.Context, opts ...option.ClientOption) (*Client, error) {
@@ -20,21 +39,37 @@ func NewClient(ctx context.Context, opts ...option.ClientOption) (*Client, error
 	if err != nil {
 		return nil, err
 	}
+	kmsService, err := cloudkms.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
 	return &Client{
 		storageService: storageService,
+		kmsService:     kmsService,
 	}, nil
 }
 
-func (c *Client) Create(config *Config) error {
+// Create creates the buckets of the config that do not exist and updates the
+// others. A retention policy declared as locked is locked once the bucket is
+// written, only when confirmed. It returns the buckets that were applied, even
+// when some failed.
+func (c *Client) Create(config *Config) ([]common.AppliedResource, error) {
 	createChannel := make(chan common.Response, len(config.StorageBuckets))
-	for _, bucket := range config.StorageBuckets {
-		go func(resp chan common.Response, bucket StorageBucket) {
-			_, err := c.get(bucket.Name)
+	for key, bucket := range config.StorageBuckets {
+		go func(resp chan common.Response, key string, bucket StorageBucket) {
+			var applied *storage.Bucket
+			spec := c.createStorageSpec(bucket)
+			live, err := c.get(bucket.Name)
 			if err != nil {
 				if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
 					utils.Logger.Debug("[%s] bucket not found", bucket.Name)
 
-					if err := c.create(bucket); err != nil {
+					live = nil
+					if err = c.checkLockConfirmed(spec, true); err != nil {
+						resp <- common.Response{Err: err}
+						return
+					}
+					if applied, err = c.create(bucket); err != nil {
 						resp <- common.Response{Err: err}
 						return
 					}
@@ -44,23 +79,279 @@ func (c *Client) Create(config *Config) error {
 					return
 				}
 			} else {
-				if err := c.update(bucket); err != nil {
+				if bucket.KeepLiveLocation {
+					keepLiveLocation(live, spec)
+				}
+				if err = checkImmutable(live, spec); err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+				if err = c.checkLockConfirmed(spec, !retentionLocked(live)); err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+				if applied, err = c.replace(bucket.ProjectId, mergeLive(live, spec)); err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+			}
+			if retentionLocked(spec) && !retentionLocked(applied) {
+				if err = c.lockRetention(applied); err != nil {
 					resp <- common.Response{Err: err}
 					return
 				}
 			}
-			resp <- common.Response{}
-		}(createChannel, bucket)
+			resp <- common.Response{Applied: &common.AppliedResource{
+				Client:  bucket.ClientName,
+				Product: Product,
+				Key:     key,
+				Name:    bucket.Name,
+				Etag:    applied.Etag,
+				Spec:    c.createStorageSpec(bucket),
+			}}
+		}(createChannel, key, bucket)
 	}
+	var applied []common.AppliedResource
+	var createErr error
 	for range config.StorageBuckets {
 		resp := <-createChannel
 		if resp.Err != nil {
-			return resp.Err
+			createErr = resp.Err
+			continue
 		}
+		applied = append(applied, *resp.Applied)
+	}
+	return applied, createErr
+}
+
+// Plan compares every bucket of the config with its live state and returns the
+// change Create would make to each of them.
+func (c *Client) Plan(config *Config) ([]common.ResourceChange, error) {
+	planChannel := make(chan common.Response, len(config.StorageBuckets))
+	for key, bucket := range config.StorageBuckets {
+		go func(resp chan common.Response, key string, bucket StorageBucket) {
+			spec := c.createStorageSpec(bucket)
+			desired, err := json.Marshal(spec)
+			if err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			change := common.ResourceChange{
+				Client:  bucket.ClientName,
+				Product: Product,
+				Key:     key,
+				Name:    bucket.Name,
+				Project: bucket.ProjectId,
+				Desired: desired,
+			}
+			live, err := c.get(bucket.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+					change.Action = common.ActionCreate
+					resp <- common.Response{Change: change}
+					return
+				}
+				utils.Logger.Errorf("[%s] error getting bucket: %s", bucket.Name, err)
+				resp <- common.Response{Err: err}
+				return
+			}
+			if bucket.KeepLiveLocation {
+				keepLiveLocation(live, spec)
+			}
+			if err := checkImmutable(live, spec); err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			if change.Desired, err = json.Marshal(mergeLive(live, spec)); err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			if change.LiveHash, err = common.HashResource(live); err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			change.Diffs = diffBucket(live, spec)
+			change.Action = common.ActionNoop
+			if len(change.Diffs) > 0 {
+				change.Action = common.ActionUpdate
+			}
+			resp <- common.Response{Change: change}
+		}(planChannel, key, bucket)
+	}
+	var changes []common.ResourceChange
+	var planErr error
+	for range config.StorageBuckets {
+		resp := <-planChannel
+		if resp.Err != nil {
+			planErr = resp.Err
+			continue
+		}
+		changes = append(changes, resp.Change)
+	}
+	return changes, planErr
+}
+
+// Verify returns an error if the live bucket is not in the state it was in
+// when the change was planned.
+func (c *Client) Verify(change common.ResourceChange) error {
+	liveHash := ""
+	live, err := c.get(change.Name)
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+			return err
+		}
+	} else {
+		if liveHash, err = common.HashResource(live); err != nil {
+			return err
+		}
+	}
+	if liveHash != change.LiveHash {
+		return fmt.Errorf("[%s] bucket changed since the plan was made", change.Name)
 	}
 	return nil
 }
 
+// Apply runs a planned change with the spec saved in the plan, locking the
+// retention policy when the plan locks it and it is confirmed. It returns the
+// applied bucket, or nil when there was nothing to do.
+func (c *Client) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	if change.Action == common.ActionNoop {
+		return nil, nil
+	}
+	var spec storage.Bucket
+	if err := json.Unmarshal(change.Desired, &spec); err != nil {
+		return nil, fmt.Errorf("[%s] invalid planned spec: %s", change.Name, err)
+	}
+	lock := change.Action == common.ActionCreate
+	for _, diff := range change.Diffs {
+		if diff.Field == "retentionPolicy.isLocked" {
+			lock = true
+		}
+	}
+	if err := c.checkLockConfirmed(&spec, lock); err != nil {
+		return nil, err
+	}
+	var applied *storage.Bucket
+	var err error
+	switch change.Action {
+	case common.ActionCreate:
+		applied, err = c.insert(change.Project, &spec)
+	case common.ActionUpdate:
+		applied, err = c.replace(change.Project, &spec)
+	default:
+		return nil, fmt.Errorf("[%s] unknown action %s", change.Name, change.Action)
+	}
+	if err == nil && retentionLocked(&spec) && !retentionLocked(applied) {
+		err = c.lockRetention(applied)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &common.AppliedResource{
+		Client:  change.Client,
+		Product: Product,
+		Key:     change.Key,
+		Name:    change.Name,
+		Etag:    applied.Etag,
+		Spec:    &spec,
+	}, nil
+}
+
+// Delete deletes every bucket of the config. GCP refuses to delete a bucket
+// that still has objects, force deletes all the objects and their versions
+// first. Buckets that do not exist are ignored.
+func (c *Client) Delete(config *Config, force bool) error {
+	deleteChannel := make(chan common.Response, len(config.StorageBuckets))
+	for _, bucket := range config.StorageBuckets {
+		go func(resp chan common.Response, bucket StorageBucket) {
+			resp <- common.Response{Err: c.delete(bucket.Name, force)}
+		}(deleteChannel, bucket)
+	}
+	var deleteErr error
+	for range config.StorageBuckets {
+		resp := <-deleteChannel
+		if resp.Err != nil {
+			deleteErr = resp.Err
+		}
+	}
+	return deleteErr
+}
+
+// FindOrphans lists the buckets of the projects that are labeled as managed by
+// fougere-lite for one of the clients but are not in declared, a set of bucket
+// names. The buckets of other clients, e.g. of another config file sharing the
+// project, are left out.
+func (c *Client) FindOrphans(projects []string, declared map[string]bool, clients map[string]bool) ([]common.ResourceChange, error) {
+	var orphans []common.ResourceChange
+	for _, project := range projects {
+		buckets, err := c.List(project)
+		if err != nil {
+			return nil, err
+		}
+		for _, bucket := range buckets {
+			clientName := bucket.Labels[common.ClientLabel]
+			if !common.IsManaged(bucket.Labels) || declared[bucket.Name] || !clients[clientName] {
+				continue
+			}
+			orphans = append(orphans, common.ResourceChange{
+				Client:  clientName,
+				Product: Product,
+				Key:     strings.TrimSuffix(strings.TrimPrefix(bucket.Name, clientName+"-"), "-"+project),
+				Name:    bucket.Name,
+				Project: project,
+				Action:  common.ActionDelete,
+			})
+		}
+	}
+	return orphans, nil
+}
+
+// Export returns the config of the live buckets of the config, with the values
+// as deployed. Buckets that do not exist are left out.
+func (c *Client) Export(config *Config) (map[string]StorageBucket, error) {
+	exported := map[string]StorageBucket{}
+	for key, bucket := range config.StorageBuckets {
+		live, err := c.get(bucket.Name)
+		if err != nil {
+			if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+				utils.Logger.Warnf("[%s] bucket not found, not exported", bucket.Name)
+				continue
+			}
+			utils.Logger.Errorf("[%s] error getting bucket: %s", bucket.Name, err)
+			return nil, err
+		}
+		exported[key] = FromBucket(live, bucket.ProjectId, bucket.ClientName)
+	}
+	return exported, nil
+}
+
+// List returns every bucket of a project.
+func (c *Client) List(projectId string) ([]*storage.Bucket, error) {
+	utils.Logger.Debugf("[%s] listing buckets", projectId)
+	var buckets []*storage.Bucket
+	err := c.storageService.Buckets.List(projectId).Pages(context.Background(), func(page *storage.Buckets) error {
+		buckets = append(buckets, page.Items...)
+		return nil
+	})
+	if err != nil {
+		utils.Logger.Errorf("[%s] error listing buckets: %s", projectId, err)
+		return nil, err
+	}
+	return buckets, nil
+}
+
+// Prune deletes the orphan buckets returned by FindOrphans. Buckets that still
+// have objects are not emptied and make Prune return an error.
+func (c *Client) Prune(orphans []common.ResourceChange) error {
+	var pruneErr error
+	for _, orphan := range orphans {
+		if err := c.delete(orphan.Name, false); err != nil {
+			pruneErr = err
+		}
+	}
+	return pruneErr
+}
+
 func (c *Client) get(name string) (*storage.Bucket, error) {
 	utils.Logger.Debug("[%s] getting bucket", name)
 	bucket, err := c.storageService.Buckets.Get(name).Do()
@@ -70,35 +361,540 @@ func (c *Client) get(name string) (*storage.Bucket, error) {
 	return bucket, nil
 }
 
-func (c *Client) create(bucket StorageBucket) error {
-	utils.Logger.Infof("[%s] creating bucket", bucket.Name)
-	spec := c.createStorageSpec(bucket)
-	_, err := c.storageService.Buckets.Insert(bucket.ProjectId, spec).Do()
+func (c *Client) create(bucket StorageBucket) (*storage.Bucket, error) {
+	return c.insert(bucket.ProjectId, c.createStorageSpec(bucket))
+}
+
+func (c *Client) insert(projectId string, spec *storage.Bucket) (*storage.Bucket, error) {
+	if err := c.grantKeyAccess(projectId, spec); err != nil {
+		return nil, err
+	}
+	utils.Logger.Infof("[%s] creating bucket", spec.Name)
+	bucket, err := c.storageService.Buckets.Insert(projectId, requestSpec(spec)).Do()
 	if err != nil {
 		utils.Logger.Errorf("[%s] error creating bucket: %s", spec.Name, err)
-		return err
+		return nil, err
 	}
 
-	return nil
+	return bucket, nil
+}
+
+// keepLiveLocation gives the spec the location of the live bucket, for the
+// buckets whose config does not set location.
+func keepLiveLocation(live *storage.Bucket, spec *storage.Bucket) {
+	spec.Location = live.Location
+	spec.CustomPlacementConfig = live.CustomPlacementConfig
+}
+
+// mergeLive returns the spec with the labels of the live bucket it does not
+// set, so that the labels added by other teams are kept on update, and with the
+// live storage class when the config does not set one.
+func mergeLive(live *storage.Bucket, spec *storage.Bucket) *storage.Bucket {
+	merged := *spec
+	merged.Labels = common.MergeLabels(live.Labels, spec.Labels)
+	if merged.StorageClass == "" {
+		merged.StorageClass = live.StorageClass
+	}
+	return &merged
 }
 
-func (c *Client) update(bucket StorageBucket) error {
-	spec := c.createStorageSpec(bucket)
+func (c *Client) replace(projectId string, spec *storage.Bucket) (*storage.Bucket, error) {
+	if err := c.grantKeyAccess(projectId, spec); err != nil {
+		return nil, err
+	}
 	utils.Logger.Infof("[%s] updating bucket", spec.Name)
-	_, err := c.storageService.Buckets.Update(spec.Name, spec).Do()
+	bucket, err := c.storageService.Buckets.Update(spec.Name, requestSpec(spec)).Do()
 	if err != nil {
 		utils.Logger.Errorf("[%s] error updating bucket: %s", spec.Name, err)
+		return nil, err
+	}
+	return bucket, nil
+}
+
+// requestSpec returns the spec to send to GCP. The lock of the retention
+// policy is left out, it is only set by lockRetention, and a soft delete
+// duration of zero is sent to disable soft delete.
+func requestSpec(spec *storage.Bucket) *storage.Bucket {
+	request := *spec
+	if spec.RetentionPolicy != nil {
+		retentionPolicy := *spec.RetentionPolicy
+		retentionPolicy.IsLocked = false
+		request.RetentionPolicy = &retentionPolicy
+	}
+	if spec.SoftDeletePolicy != nil && spec.SoftDeletePolicy.RetentionDurationSeconds == 0 {
+		request.SoftDeletePolicy = &storage.BucketSoftDeletePolicy{ForceSendFields: []string{"RetentionDurationSeconds"}}
+	}
+	return &request
+}
+
+// checkLockConfirmed returns an error if the spec locks the retention policy
+// of a bucket that is not locked yet, lock, without the confirmation.
+func (c *Client) checkLockConfirmed(spec *storage.Bucket, lock bool) error {
+	if lock && retentionLocked(spec) && !c.confirmRetentionLock {
+		return fmt.Errorf("[%s] locking the retention policy cannot be undone, rerun with --confirm-retention-lock to lock it", spec.Name)
+	}
+	return nil
+}
+
+// lockRetention locks the retention policy of a bucket as of the metageneration
+// it was written with.
+func (c *Client) lockRetention(bucket *storage.Bucket) error {
+	utils.Logger.Warnf("[%s] locking the retention policy, this cannot be undone", bucket.Name)
+	_, err := c.storageService.Buckets.LockRetentionPolicy(bucket.Name, bucket.Metageneration).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error locking the retention policy: %s", bucket.Name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) delete(name string, force bool) error {
+	if force {
+		if err := c.empty(name); err != nil {
+			if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+				utils.Logger.Infof("[%s] bucket already deleted", name)
+				return nil
+			}
+			utils.Logger.Errorf("[%s] error emptying bucket: %s", name, err)
+			return err
+		}
+	}
+	utils.Logger.Infof("[%s] deleting bucket", name)
+	err := c.storageService.Buckets.Delete(name).Do()
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			utils.Logger.Infof("[%s] bucket already deleted", name)
+			return nil
+		}
+		utils.Logger.Errorf("[%s] error deleting bucket: %s", name, err)
 		return err
 	}
 	return nil
 }
 
+// grantKeyAccess grants the Cloud Storage service agent of the project the role
+// to use the default KMS key of the bucket, without which GCP refuses to create
+// it. The policy of the key is updated with a read-modify-write on its etag and
+// its other bindings are kept.
+func (c *Client) grantKeyAccess(projectId string, spec *storage.Bucket) error {
+	if spec.Encryption == nil || spec.Encryption.DefaultKmsKeyName == "" {
+		return nil
+	}
+	keyName := spec.Encryption.DefaultKmsKeyName
+	agent, err := c.storageService.Projects.ServiceAccount.Get(projectId).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error getting the Cloud Storage service agent: %s", projectId, err)
+		return err
+	}
+	member := "serviceAccount:" + agent.EmailAddress
+	cryptoKeys := c.kmsService.Projects.Locations.KeyRings.CryptoKeys
+	return iampolicy.Update(keyName, func() error {
+		utils.Logger.Debugf("[%s] getting IAM policy", keyName)
+		policy, err := cryptoKeys.GetIamPolicy(keyName).OptionsRequestedPolicyVersion(3).Do()
+		if err != nil {
+			return err
+		}
+		if !grantMember(policy, kmsKeyRole, member) {
+			utils.Logger.Debugf("[%s] %s already granted to %s", keyName, kmsKeyRole, member)
+			return nil
+		}
+		utils.Logger.Infof("[%s] granting %s to %s", keyName, kmsKeyRole, member)
+		_, err = cryptoKeys.SetIamPolicy(keyName, &cloudkms.SetIamPolicyRequest{Policy: policy}).Do()
+		return err
+	})
+}
+
+// grantMember adds a member to the unconditional binding of a role. It returns
+// false when the member already had the role.
+func grantMember(policy *cloudkms.Policy, role string, member string) bool {
+	for _, binding := range policy.Bindings {
+		if binding.Role != role || binding.Condition != nil {
+			continue
+		}
+		for _, existing := range binding.Members {
+			if existing == member {
+				return false
+			}
+		}
+		binding.Members = append(binding.Members, member)
+		return true
+	}
+	policy.Bindings = append(policy.Bindings, &cloudkms.Binding{Role: role, Members: []string{member}})
+	return true
+}
+
+// empty deletes every object of a bucket, including noncurrent versions.
+func (c *Client) empty(name string) error {
+	utils.Logger.Infof("[%s] emptying bucket", name)
+	return c.storageService.Objects.List(name).Versions(true).Pages(context.Background(), func(objects *storage.Objects) error {
+		for _, object := range objects.Items {
+			err := c.storageService.Objects.Delete(name, object.Name).Generation(object.Generation).Do()
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+					continue
+				}
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (c *Client) createStorageSpec(storageBucket StorageBucket) *storage.Bucket {
-	return &storage.Bucket{
+	labels := map[string]string{}
+	for key, value := range storageBucket.Labels {
+		labels[key] = value
+	}
+	for key, value := range common.OwnershipLabels(storageBucket.ClientName) {
+		labels[key] = value
+	}
+	spec := &storage.Bucket{
 		Name:         storageBucket.Name,
-		StorageClass: "MULTI_REGIONAL",
+		Location:     strings.ToUpper(storageBucket.Location),
+		Labels:       labels,
+		StorageClass: storageBucket.StorageClass,
 		Versioning: &storage.BucketVersioning{
-			Enabled: false,
+			Enabled: storageBucket.Versioning,
 		},
 	}
+	if len(storageBucket.DataLocations) > 0 {
+		spec.CustomPlacementConfig = &storage.BucketCustomPlacementConfig{}
+		for _, region := range storageBucket.DataLocations {
+			spec.CustomPlacementConfig.DataLocations = append(spec.CustomPlacementConfig.DataLocations, strings.ToUpper(region))
+		}
+	}
+	if storageBucket.Autoclass != nil && storageBucket.Autoclass.Enabled {
+		terminalStorageClass := storageBucket.Autoclass.TerminalStorageClass
+		if terminalStorageClass == "" {
+			terminalStorageClass = DefaultTerminalStorageClass
+		}
+		spec.Autoclass = &storage.BucketAutoclass{Enabled: true, TerminalStorageClass: terminalStorageClass}
+	}
+	if len(storageBucket.LifecycleRules) > 0 {
+		spec.Lifecycle = &storage.BucketLifecycle{}
+		for _, rule := range storageBucket.LifecycleRules {
+			spec.Lifecycle.Rule = append(spec.Lifecycle.Rule, &storage.BucketLifecycleRule{
+				Action: &storage.BucketLifecycleRuleAction{Type: rule.Action, StorageClass: rule.StorageClass},
+				Condition: &storage.BucketLifecycleRuleCondition{
+					Age:              rule.Condition.Age,
+					CreatedBefore:    rule.Condition.CreatedBefore,
+					NumNewerVersions: rule.Condition.NumNewerVersions,
+					MatchesPrefix:    rule.Condition.MatchesPrefix,
+					MatchesSuffix:    rule.Condition.MatchesSuffix,
+					IsLive:           rule.Condition.IsLive,
+				},
+			})
+		}
+	}
+	if storageBucket.Retention != nil {
+		period, err := time.ParseDuration(storageBucket.Retention.Period)
+		if err == nil {
+			spec.RetentionPolicy = &storage.BucketRetentionPolicy{RetentionPeriod: int64(period.Seconds()), IsLocked: storageBucket.Retention.Locked}
+		}
+	}
+	spec.DefaultEventBasedHold = storageBucket.DefaultEventBasedHold
+	if storageBucket.SoftDeleteRetention != "" {
+		duration, err := time.ParseDuration(storageBucket.SoftDeleteRetention)
+		if err == nil {
+			spec.SoftDeletePolicy = &storage.BucketSoftDeletePolicy{RetentionDurationSeconds: int64(duration.Seconds())}
+		}
+	}
+	spec.IamConfiguration = &storage.BucketIamConfiguration{
+		UniformBucketLevelAccess: &storage.BucketIamConfigurationUniformBucketLevelAccess{Enabled: storageBucket.UniformAccess},
+		PublicAccessPrevention:   storageBucket.PublicAccessPrevention,
+	}
+	for _, rule := range storageBucket.Cors {
+		cors := &storage.BucketCors{Origin: rule.Origins, Method: rule.Methods, ResponseHeader: rule.ResponseHeaders}
+		if maxAge, err := time.ParseDuration(rule.MaxAge); err == nil {
+			cors.MaxAgeSeconds = int64(maxAge.Seconds())
+		}
+		spec.Cors = append(spec.Cors, cors)
+	}
+	if storageBucket.Website != nil {
+		spec.Website = &storage.BucketWebsite{MainPageSuffix: storageBucket.Website.MainPageSuffix, NotFoundPage: storageBucket.Website.NotFoundPage}
+	}
+	if storageBucket.KmsKeyName != "" {
+		spec.Encryption = &storage.BucketEncryption{DefaultKmsKeyName: storageBucket.KmsKeyName}
+	}
+	return spec
+}
+
+// checkImmutable returns an error if the desired spec changes what GCP does not
+// allow to change: the location of the live bucket, which requires replacing
+// it, and its locked retention policy, which can only be lengthened.
+func checkImmutable(live *storage.Bucket, desired *storage.Bucket) error {
+	if !strings.EqualFold(live.Location, desired.Location) || !reflect.DeepEqual(dataLocations(live), dataLocations(desired)) {
+		return fmt.Errorf("[%s] the location cannot be changed from %s to %s, the bucket must be replaced", desired.Name, describeLocation(live), describeLocation(desired))
+	}
+	if !retentionLocked(live) {
+		return nil
+	}
+	switch {
+	case desired.RetentionPolicy == nil:
+		return fmt.Errorf("[%s] the retention policy is locked and cannot be removed", desired.Name)
+	case desired.RetentionPolicy.RetentionPeriod < live.RetentionPolicy.RetentionPeriod:
+		return fmt.Errorf("[%s] the retention policy is locked, its period cannot be shortened from %s to %s", desired.Name,
+			time.Duration(live.RetentionPolicy.RetentionPeriod)*time.Second, time.Duration(desired.RetentionPolicy.RetentionPeriod)*time.Second)
+	case !desired.RetentionPolicy.IsLocked:
+		return fmt.Errorf("[%s] the retention policy is locked and cannot be unlocked, set retention.locked", desired.Name)
+	}
+	return nil
+}
+
+// diffBucket lists the properties managed by fougere-lite that differ between
+// the live bucket and the desired spec.
+func diffBucket(live *storage.Bucket, desired *storage.Bucket) []common.FieldDiff {
+	var diffs []common.FieldDiff
+	if desired.StorageClass != "" {
+		diffs = common.AppendDiff(diffs, "storageClass", live.StorageClass, desired.StorageClass)
+	}
+	diffs = common.AppendDiff(diffs, "versioning.enabled", versioningEnabled(live), versioningEnabled(desired))
+	diffs = common.AppendDiff(diffs, "autoclass.enabled", autoclassEnabled(live), autoclassEnabled(desired))
+	if autoclassEnabled(live) && autoclassEnabled(desired) {
+		diffs = common.AppendDiff(diffs, "autoclass.terminalStorageClass", live.Autoclass.TerminalStorageClass, desired.Autoclass.TerminalStorageClass)
+	}
+	diffs = common.AppendDiff(diffs, "encryption.defaultKmsKeyName", defaultKmsKeyName(live), defaultKmsKeyName(desired))
+	diffs = append(diffs, diffLifecycle(live, desired)...)
+	diffs = common.AppendDiff(diffs, "retentionPolicy.retentionPeriod", retentionPeriod(live), retentionPeriod(desired))
+	diffs = common.AppendDiff(diffs, "retentionPolicy.isLocked", retentionLocked(live), retentionLocked(desired))
+	diffs = common.AppendDiff(diffs, "defaultEventBasedHold", live.DefaultEventBasedHold, desired.DefaultEventBasedHold)
+	if desired.SoftDeletePolicy != nil {
+		diffs = common.AppendDiff(diffs, "softDeletePolicy.retentionDurationSeconds", softDeleteRetention(live), desired.SoftDeletePolicy.RetentionDurationSeconds)
+	}
+	diffs = common.AppendDiff(diffs, "iamConfiguration.uniformBucketLevelAccess.enabled", uniformAccess(live), uniformAccess(desired))
+	if desired.IamConfiguration != nil && desired.IamConfiguration.PublicAccessPrevention != "" {
+		diffs = common.AppendDiff(diffs, "iamConfiguration.publicAccessPrevention", publicAccessPrevention(live), desired.IamConfiguration.PublicAccessPrevention)
+	}
+	diffs = common.AppendDiff(diffs, "cors", describeCors(live), describeCors(desired))
+	diffs = common.AppendDiff(diffs, "website.mainPageSuffix", website(live).MainPageSuffix, website(desired).MainPageSuffix)
+	diffs = common.AppendDiff(diffs, "website.notFoundPage", website(live).NotFoundPage, website(desired).NotFoundPage)
+	diffs = common.AppendLabelDiffs(diffs, "labels", live.Labels, desired.Labels)
+	return diffs
+}
+
+func versioningEnabled(bucket *storage.Bucket) bool {
+	return bucket.Versioning != nil && bucket.Versioning.Enabled
+}
+
+// diffLifecycle compares the lifecycle rules of the live bucket and the desired
+// spec as sets, with a diff for every rule that is removed or added.
+func diffLifecycle(live *storage.Bucket, desired *storage.Bucket) []common.FieldDiff {
+	liveRules := describeRules(live)
+	desiredRules := describeRules(desired)
+	var diffs []common.FieldDiff
+	for _, rule := range sortedKeys(liveRules) {
+		if !desiredRules[rule] {
+			diffs = append(diffs, common.FieldDiff{Field: "lifecycle.rule", Current: rule, Desired: ""})
+		}
+	}
+	for _, rule := range sortedKeys(desiredRules) {
+		if !liveRules[rule] {
+			diffs = append(diffs, common.FieldDiff{Field: "lifecycle.rule", Current: "", Desired: rule})
+		}
+	}
+	return diffs
+}
+
+// describeRules returns the set of the lifecycle rules of a bucket, each in a
+// readable and comparable form such as "Delete if age=30 matchesPrefix=[tmp/]".
+func describeRules(bucket *storage.Bucket) map[string]bool {
+	rules := map[string]bool{}
+	if bucket.Lifecycle == nil {
+		return rules
+	}
+	for _, rule := range bucket.Lifecycle.Rule {
+		description := ""
+		if rule.Action != nil {
+			description = rule.Action.Type
+			if rule.Action.StorageClass != "" {
+				description += " to " + rule.Action.StorageClass
+			}
+		}
+		description += " if"
+		if condition := rule.Condition; condition != nil {
+			if condition.Age != nil {
+				description += fmt.Sprintf(" age=%d", *condition.Age)
+			}
+			if condition.CreatedBefore != "" {
+				description += " createdBefore=" + condition.CreatedBefore
+			}
+			if condition.NumNewerVersions != 0 {
+				description += fmt.Sprintf(" numNewerVersions=%d", condition.NumNewerVersions)
+			}
+			if len(condition.MatchesPrefix) > 0 {
+				description += fmt.Sprintf(" matchesPrefix=%v", condition.MatchesPrefix)
+			}
+			if len(condition.MatchesSuffix) > 0 {
+				description += fmt.Sprintf(" matchesSuffix=%v", condition.MatchesSuffix)
+			}
+			if condition.IsLive != nil {
+				description += fmt.Sprintf(" isLive=%t", *condition.IsLive)
+			}
+			if condition.DaysSinceNoncurrentTime != 0 {
+				description += fmt.Sprintf(" daysSinceNoncurrentTime=%d", condition.DaysSinceNoncurrentTime)
+			}
+			if condition.DaysSinceCustomTime != 0 {
+				description += fmt.Sprintf(" daysSinceCustomTime=%d", condition.DaysSinceCustomTime)
+			}
+			if len(condition.MatchesStorageClass) > 0 {
+				description += fmt.Sprintf(" matchesStorageClass=%v", condition.MatchesStorageClass)
+			}
+		}
+		rules[description] = true
+	}
+	return rules
+}
+
+func uniformAccess(bucket *storage.Bucket) bool {
+	return bucket.IamConfiguration != nil && bucket.IamConfiguration.UniformBucketLevelAccess != nil &&
+		bucket.IamConfiguration.UniformBucketLevelAccess.Enabled
+}
+
+func publicAccessPrevention(bucket *storage.Bucket) string {
+	if bucket.IamConfiguration == nil {
+		return ""
+	}
+	return bucket.IamConfiguration.PublicAccessPrevention
+}
+
+// describeCors returns a description of each CORS rule of a bucket, nil when it
+// has none.
+func describeCors(bucket *storage.Bucket) []string {
+	var rules []string
+	for _, rule := range bucket.Cors {
+		rules = append(rules, fmt.Sprintf("origins=%v methods=%v responseHeaders=%v maxAge=%s",
+			rule.Origin, rule.Method, rule.ResponseHeader, time.Duration(rule.MaxAgeSeconds)*time.Second))
+	}
+	return rules
+}
+
+// website returns the website config of a bucket, empty when it has none.
+func website(bucket *storage.Bucket) storage.BucketWebsite {
+	if bucket.Website == nil {
+		return storage.BucketWebsite{}
+	}
+	return *bucket.Website
+}
+
+// retentionPeriod returns the retention period of a bucket in seconds, 0 when
+// it has no retention policy.
+func retentionPeriod(bucket *storage.Bucket) int64 {
+	if bucket.RetentionPolicy == nil {
+		return 0
+	}
+	return bucket.RetentionPolicy.RetentionPeriod
+}
+
+func retentionLocked(bucket *storage.Bucket) bool {
+	return bucket.RetentionPolicy != nil && bucket.RetentionPolicy.IsLocked
+}
+
+func softDeleteRetention(bucket *storage.Bucket) int64 {
+	if bucket.SoftDeletePolicy == nil {
+		return 0
+	}
+	return bucket.SoftDeletePolicy.RetentionDurationSeconds
+}
+
+func autoclassEnabled(bucket *storage.Bucket) bool {
+	return bucket.Autoclass != nil && bucket.Autoclass.Enabled
+}
+
+// dataLocations returns the regions of a configurable dual-region in lower
+// case, or nil.
+func dataLocations(bucket *storage.Bucket) []string {
+	if bucket.CustomPlacementConfig == nil {
+		return nil
+	}
+	var regions []string
+	for _, region := range bucket.CustomPlacementConfig.DataLocations {
+		regions = append(regions, strings.ToLower(region))
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+func describeLocation(bucket *storage.Bucket) string {
+	location := strings.ToLower(bucket.Location)
+	if regions := dataLocations(bucket); len(regions) > 0 {
+		location += " (" + strings.Join(regions, "+") + ")"
+	}
+	return location
+}
+
+func defaultKmsKeyName(bucket *storage.Bucket) string {
+	if bucket.Encryption == nil {
+		return ""
+	}
+	return bucket.Encryption.DefaultKmsKeyName
+}
+
+// FromBucket returns the config of a live bucket.
+func FromBucket(bucket *storage.Bucket, projectId string, clientName string) StorageBucket {
+	var labels map[string]string
+	for key, value := range bucket.Labels {
+		if common.IsOwnershipLabel(key) {
+			continue
+		}
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[key] = value
+	}
+	exported := StorageBucket{
+		Name:          bucket.Name,
+		Labels:        labels,
+		Location:      strings.ToLower(bucket.Location),
+		DataLocations: dataLocations(bucket),
+		ProjectId:     projectId,
+		StorageClass:  bucket.StorageClass,
+		Versioning:    versioningEnabled(bucket),
+		KmsKey:        defaultKmsKeyName(bucket),
+		ClientName:    clientName,
+	}
+	if bucket.Lifecycle != nil {
+		for _, rule := range bucket.Lifecycle.Rule {
+			if rule.Action == nil || rule.Condition == nil {
+				continue
+			}
+			exported.LifecycleRules = append(exported.LifecycleRules, LifecycleRule{
+				Action:       rule.Action.Type,
+				StorageClass: rule.Action.StorageClass,
+				Condition: LifecycleCondition{
+					Age:              rule.Condition.Age,
+					CreatedBefore:    rule.Condition.CreatedBefore,
+					NumNewerVersions: rule.Condition.NumNewerVersions,
+					MatchesPrefix:    rule.Condition.MatchesPrefix,
+					MatchesSuffix:    rule.Condition.MatchesSuffix,
+					IsLive:           rule.Condition.IsLive,
+				},
+			})
+		}
+	}
+	if bucket.RetentionPolicy != nil {
+		exported.Retention = &Retention{
+			Period: (time.Duration(bucket.RetentionPolicy.RetentionPeriod) * time.Second).String(),
+			Locked: bucket.RetentionPolicy.IsLocked,
+		}
+	}
+	exported.DefaultEventBasedHold = bucket.DefaultEventBasedHold
+	if bucket.SoftDeletePolicy != nil {
+		exported.SoftDeleteRetention = (time.Duration(bucket.SoftDeletePolicy.RetentionDurationSeconds) * time.Second).String()
+	}
+	exported.UniformAccess = uniformAccess(bucket)
+	exported.PublicAccessPrevention = publicAccessPrevention(bucket)
+	for _, rule := range bucket.Cors {
+		cors := CorsRule{Origins: rule.Origin, Methods: rule.Method, ResponseHeaders: rule.ResponseHeader}
+		if rule.MaxAgeSeconds > 0 {
+			cors.MaxAge = (time.Duration(rule.MaxAgeSeconds) * time.Second).String()
+		}
+		exported.Cors = append(exported.Cors, cors)
+	}
+	if bucket.Website != nil {
+		exported.Website = &Website{MainPageSuffix: bucket.Website.MainPageSuffix, NotFoundPage: bucket.Website.NotFoundPage}
+	}
+	if autoclassEnabled(bucket) {
+		exported.Autoclass = &Autoclass{Enabled: true, TerminalStorageClass: bucket.Autoclass.TerminalStorageClass}
+	}
+	return exported
 }
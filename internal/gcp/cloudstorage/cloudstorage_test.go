@@ -3,11 +3,15 @@ package cloudstorage
 
 import (
 	"context"
+	"encoding/json"
 	"strings"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"google.golang.org/api/cloudkms/v1"
 	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+	"metrio.net/fougere-lite/internal/common"
 	"metrio.net/fougere-lite/internal/utils"
 )
 
@@ -26,7 +30,7 @@ var _ = Describe("Storage client", func() {
 	BeforeEach(func() {
 		bucketConfig = StorageBucket{
 			Name:       "patate-23423k",
-			Region:     "northamerica-northeast1",
+			Location:   "northamerica-northeast1",
 			ProjectId:  "projet-123",
 			ClientName: "banane",
 		}
@@ -42,7 +46,67 @@ var _ = Describe("Storage client", func() {
 
 			bucket := client.createStorageSpec(bucketConfig)
 			Expect(bucket.Name).To(Equal(bucketConfig.Name))
-			Expect(bucket.StorageClass).To(Equal("MULTI_REGIONAL"))
+			Expect(bucket.Location).To(Equal("NORTHAMERICA-NORTHEAST1"))
+			Expect(bucket.StorageClass).To(BeEmpty())
+			Expect(bucket.Autoclass).To(BeNil())
+			Expect(bucket.Labels).To(HaveKeyWithValue(common.ManagedByLabel, common.ManagedByValue))
+			Expect(bucket.Labels).To(HaveKeyWithValue(common.ClientLabel, "banane"))
+		})
+	})
+	Describe("create storage spec with a storage class", func() {
+		It("uses the storage class of the config", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 0)
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			bucketConfig.StorageClass = "COLDLINE"
+			bucket := client.createStorageSpec(bucketConfig)
+			Expect(bucket.StorageClass).To(Equal("COLDLINE"))
+		})
+	})
+	Describe("create storage spec with labels", func() {
+		AfterEach(func() {
+			common.SetRunLabels("", "")
+		})
+
+		It("adds the user labels and the labels of the run to the ownership labels", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 0)
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			common.SetRunLabels("/configs/Prod.fougere-lite.yaml", "v1.4.0")
+			bucketConfig.Labels = map[string]string{"cost-center": "data"}
+			bucket := client.createStorageSpec(bucketConfig)
+			Expect(bucket.Labels).To(Equal(map[string]string{
+				"cost-center":         "data",
+				common.ManagedByLabel: common.ManagedByValue,
+				common.ClientLabel:    "banane",
+				common.ConfigLabel:    "prod_fougere-lite_yaml",
+				common.VersionLabel:   "v1_4_0",
+			}))
+		})
+	})
+	Describe("create storage spec with access settings", func() {
+		It("sets the IAM configuration, the CORS rules and the website", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 0)
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			bucketConfig.UniformAccess = true
+			bucketConfig.PublicAccessPrevention = PublicAccessPreventionEnforced
+			bucketConfig.Cors = []CorsRule{{Origins: []string{"https://example.com"}, Methods: []string{"GET", "HEAD"}, MaxAge: "1h"}}
+			bucketConfig.Website = &Website{MainPageSuffix: "index.html", NotFoundPage: "404.html"}
+			bucket := client.createStorageSpec(bucketConfig)
+			Expect(bucket.IamConfiguration.UniformBucketLevelAccess.Enabled).To(BeTrue())
+			Expect(bucket.IamConfiguration.PublicAccessPrevention).To(Equal("enforced"))
+			Expect(bucket.Cors).To(Equal([]*storage.BucketCors{{Origin: []string{"https://example.com"}, Method: []string{"GET", "HEAD"}, MaxAgeSeconds: 3600}}))
+			Expect(bucket.Website).To(Equal(&storage.BucketWebsite{MainPageSuffix: "index.html", NotFoundPage: "404.html"}))
 		})
 	})
 	Describe("create bucket", func() {
@@ -59,10 +123,140 @@ var _ = Describe("Storage client", func() {
 
 			client := getMockedClient(mockServer.URL)
 
-			err := client.create(bucketConfig)
+			_, err := client.create(bucketConfig)
 			Expect(err).ToNot(HaveOccurred())
 		})
 	})
+	Describe("create bucket with a KMS key", func() {
+		var keyName string
+
+		BeforeEach(func() {
+			keyName = "projects/projet-123/locations/northamerica-northeast1/keyRings/banane-main/cryptoKeys/banane-buckets"
+			bucketConfig.KmsKeyName = keyName
+		})
+
+		It("grants the key to the service agent before creating the bucket", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 4)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/projects/projet-123/serviceAccount?")
+				},
+				ResponseBody: storage.ServiceAccount{EmailAddress: "service-42@gs-project-accounts.iam.gserviceaccount.com"},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+keyName+":getIamPolicy?")
+				},
+				ResponseBody: cloudkms.Policy{Etag: "BwX=", Bindings: []*cloudkms.Binding{
+					{Role: "roles/cloudkms.admin", Members: []string{"group:security@example.com"}},
+				}},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+keyName+":setIamPolicy?")
+				},
+				Method: "post",
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b?")
+				},
+				Method: "post",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			bucket := client.createStorageSpec(bucketConfig)
+			Expect(bucket.Encryption.DefaultKmsKeyName).To(Equal(keyName))
+			_, err := client.create(bucketConfig)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mockServerCalls).To(BeEmpty())
+		})
+		It("does not set the policy when the service agent already has the role", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 3)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: storage.ServiceAccount{EmailAddress: "service-42@gs-project-accounts.iam.gserviceaccount.com"},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: cloudkms.Policy{Etag: "BwX=", Bindings: []*cloudkms.Binding{
+					{Role: "roles/cloudkms.cryptoKeyEncrypterDecrypter", Members: []string{"serviceAccount:service-42@gs-project-accounts.iam.gserviceaccount.com"}},
+				}},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/patate-23423k?")
+				},
+				Method: "put",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			desired, err := json.Marshal(client.createStorageSpec(bucketConfig))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = client.Apply(common.ResourceChange{Product: Product, Name: bucketConfig.Name, Project: bucketConfig.ProjectId, Action: common.ActionUpdate, Desired: desired})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mockServerCalls).To(BeEmpty())
+		})
+	})
+	Describe("grant member", func() {
+		It("adds the member to the existing binding of the role", func() {
+			policy := &cloudkms.Policy{Bindings: []*cloudkms.Binding{
+				{Role: "roles/cloudkms.cryptoKeyEncrypterDecrypter", Members: []string{"user:a@example.com"}},
+			}}
+			Expect(grantMember(policy, "roles/cloudkms.cryptoKeyEncrypterDecrypter", "serviceAccount:agent")).To(BeTrue())
+			Expect(policy.Bindings).To(HaveLen(1))
+			Expect(policy.Bindings[0].Members).To(Equal([]string{"user:a@example.com", "serviceAccount:agent"}))
+		})
+	})
+	Describe("create or update buckets", func() {
+		It("returns the applied buckets", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 2)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseCode: 404,
+			}
+			mockServerCalls <- utils.MockServerCall{
+				Method:       "post",
+				ResponseBody: storage.Bucket{Name: "patate-23423k", Etag: "CAE="},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			applied, err := client.Create(&Config{StorageBuckets: map[string]StorageBucket{"patate": bucketConfig}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(HaveLen(1))
+			Expect(applied[0].Key).To(Equal("patate"))
+			Expect(applied[0].Client).To(Equal("banane"))
+			Expect(applied[0].Etag).To(Equal("CAE="))
+		})
+		It("updates an existing bucket in its live location when only the region is set", func() {
+			bucketConfig.Region = "europe-west1"
+			bucketConfig.Location = "europe-west1"
+			bucketConfig.KeepLiveLocation = true
+			mockServerCalls := make(chan utils.MockServerCall, 2)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: storage.Bucket{Name: "patate-23423k", Location: "US", StorageClass: "MULTI_REGIONAL"},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				Method:       "put",
+				ResponseBody: storage.Bucket{Name: "patate-23423k", Location: "US", StorageClass: "MULTI_REGIONAL"},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			applied, err := client.Create(&Config{StorageBuckets: map[string]StorageBucket{"patate": bucketConfig}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(HaveLen(1))
+			Expect(mockServerCalls).To(BeEmpty())
+		})
+	})
 	Describe("update bucket", func() {
 		It("successfully updates the bucket", func() {
 			mockServerCalls := make(chan utils.MockServerCall, 1)
@@ -77,8 +271,577 @@ var _ = Describe("Storage client", func() {
 
 			client := getMockedClient(mockServer.URL)
 
-			err := client.update(bucketConfig)
+			desired, err := json.Marshal(client.createStorageSpec(bucketConfig))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = client.Apply(common.ResourceChange{Product: Product, Name: bucketConfig.Name, Project: bucketConfig.ProjectId, Action: common.ActionUpdate, Desired: desired})
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Describe("plan bucket", func() {
+		var config *Config
+
+		BeforeEach(func() {
+			config = &Config{StorageBuckets: map[string]StorageBucket{"patate": bucketConfig}}
+		})
+
+		It("plans a create when the bucket does not exist", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/patate-23423k?")
+				},
+				ResponseCode: 404,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(HaveLen(1))
+			Expect(changes[0].Action).To(Equal(common.ActionCreate))
+			Expect(changes[0].Key).To(Equal("patate"))
+			Expect(changes[0].Client).To(Equal("banane"))
+		})
+		It("plans an update with the fields that differ", func() {
+			bucketConfig.StorageClass = "STANDARD"
+			config.StorageBuckets["patate"] = bucketConfig
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/patate-23423k?")
+				},
+				ResponseBody: storage.Bucket{Name: "patate-23423k", Location: "NORTHAMERICA-NORTHEAST1", StorageClass: "MULTI_REGIONAL", Labels: common.OwnershipLabels("banane")},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(HaveLen(1))
+			Expect(changes[0].Action).To(Equal(common.ActionUpdate))
+			Expect(changes[0].Diffs).To(ConsistOf(common.FieldDiff{Field: "storageClass", Current: "MULTI_REGIONAL", Desired: "STANDARD"}))
+		})
+		It("plans a no-op when the bucket is up to date", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: storage.Bucket{Name: "patate-23423k", Location: "NORTHAMERICA-NORTHEAST1", StorageClass: "STANDARD", Labels: common.OwnershipLabels("banane")},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Action).To(Equal(common.ActionNoop))
+		})
+		It("plans a new bucket without location in its region", func() {
+			bucketConfig.Region = "europe-west1"
+			bucketConfig.Location = "europe-west1"
+			bucketConfig.KeepLiveLocation = true
+			config.StorageBuckets["patate"] = bucketConfig
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseCode: 404,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Action).To(Equal(common.ActionCreate))
+			desired := &storage.Bucket{}
+			Expect(json.Unmarshal(changes[0].Desired, desired)).To(Succeed())
+			Expect(desired.Location).To(Equal("EUROPE-WEST1"))
+		})
+		It("keeps the location and storage class of a bucket created before they were set", func() {
+			bucketConfig.Region = "europe-west1"
+			bucketConfig.Location = "europe-west1"
+			bucketConfig.KeepLiveLocation = true
+			config.StorageBuckets["patate"] = bucketConfig
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: storage.Bucket{Name: "patate-23423k", Location: "US", StorageClass: "MULTI_REGIONAL", Labels: common.OwnershipLabels("banane")},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Action).To(Equal(common.ActionNoop))
+			desired := &storage.Bucket{}
+			Expect(json.Unmarshal(changes[0].Desired, desired)).To(Succeed())
+			Expect(desired.StorageClass).To(Equal("MULTI_REGIONAL"))
+			Expect(desired.Location).To(Equal("US"))
+		})
+		It("plans the enabling of versioning and autoclass", func() {
+			bucketConfig.Versioning = true
+			bucketConfig.Autoclass = &Autoclass{Enabled: true}
+			config.StorageBuckets["patate"] = bucketConfig
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: storage.Bucket{Name: "patate-23423k", Location: "NORTHAMERICA-NORTHEAST1", StorageClass: "STANDARD", Labels: common.OwnershipLabels("banane")},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Diffs).To(ConsistOf(
+				common.FieldDiff{Field: "versioning.enabled", Current: false, Desired: true},
+				common.FieldDiff{Field: "autoclass.enabled", Current: false, Desired: true},
+			))
+		})
+		It("plans the lifecycle rules that are added and removed", func() {
+			age := int64(7)
+			bucketConfig.LifecycleRules = []LifecycleRule{
+				{Action: LifecycleDelete, Condition: LifecycleCondition{Age: &age, MatchesPrefix: []string{"tmp/"}}},
+				{Action: LifecycleSetStorageClass, StorageClass: "COLDLINE", Condition: LifecycleCondition{Age: &age}},
+			}
+			config.StorageBuckets["patate"] = bucketConfig
+			liveAge := int64(30)
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: storage.Bucket{
+					Name:         "patate-23423k",
+					Location:     "NORTHAMERICA-NORTHEAST1",
+					StorageClass: "STANDARD",
+					Labels:       common.OwnershipLabels("banane"),
+					Lifecycle: &storage.BucketLifecycle{Rule: []*storage.BucketLifecycleRule{
+						{Action: &storage.BucketLifecycleRuleAction{Type: "SetStorageClass", StorageClass: "COLDLINE"}, Condition: &storage.BucketLifecycleRuleCondition{Age: &age}},
+						{Action: &storage.BucketLifecycleRuleAction{Type: "Delete"}, Condition: &storage.BucketLifecycleRuleCondition{Age: &liveAge}},
+					}},
+				},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Action).To(Equal(common.ActionUpdate))
+			Expect(changes[0].Diffs).To(Equal([]common.FieldDiff{
+				{Field: "lifecycle.rule", Current: "Delete if age=30", Desired: ""},
+				{Field: "lifecycle.rule", Current: "", Desired: "Delete if age=7 matchesPrefix=[tmp/]"},
+			}))
+		})
+		It("returns an error when the location changes instead of planning an update", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: storage.Bucket{Name: "patate-23423k", Location: "US", StorageClass: "STANDARD", Labels: common.OwnershipLabels("banane")},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			_, err := client.Plan(config)
+			Expect(err).To(MatchError("[patate-23423k] the location cannot be changed from us to northamerica-northeast1, the bucket must be replaced"))
+		})
+		It("plans the retention policy, event-based hold and soft delete", func() {
+			bucketConfig.Retention = &Retention{Period: "48h", Locked: true}
+			bucketConfig.DefaultEventBasedHold = true
+			bucketConfig.SoftDeleteRetention = "0s"
+			config.StorageBuckets["patate"] = bucketConfig
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: storage.Bucket{
+					Name:             "patate-23423k",
+					Location:         "NORTHAMERICA-NORTHEAST1",
+					StorageClass:     "STANDARD",
+					Labels:           common.OwnershipLabels("banane"),
+					RetentionPolicy:  &storage.BucketRetentionPolicy{RetentionPeriod: 86400},
+					SoftDeletePolicy: &storage.BucketSoftDeletePolicy{RetentionDurationSeconds: 604800},
+				},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Diffs).To(ConsistOf(
+				common.FieldDiff{Field: "retentionPolicy.retentionPeriod", Current: int64(86400), Desired: int64(172800)},
+				common.FieldDiff{Field: "retentionPolicy.isLocked", Current: false, Desired: true},
+				common.FieldDiff{Field: "defaultEventBasedHold", Current: false, Desired: true},
+				common.FieldDiff{Field: "softDeletePolicy.retentionDurationSeconds", Current: int64(604800), Desired: int64(0)},
+			))
+		})
+		It("plans the access settings, the CORS rules and the website", func() {
+			bucketConfig.UniformAccess = true
+			bucketConfig.PublicAccessPrevention = PublicAccessPreventionEnforced
+			bucketConfig.Cors = []CorsRule{{Origins: []string{"*"}, Methods: []string{"GET"}}}
+			bucketConfig.Website = &Website{MainPageSuffix: "index.html"}
+			config.StorageBuckets["patate"] = bucketConfig
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: storage.Bucket{
+					Name:             "patate-23423k",
+					Location:         "NORTHAMERICA-NORTHEAST1",
+					StorageClass:     "STANDARD",
+					Labels:           common.OwnershipLabels("banane"),
+					IamConfiguration: &storage.BucketIamConfiguration{PublicAccessPrevention: "inherited"},
+				},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Diffs).To(ConsistOf(
+				common.FieldDiff{Field: "iamConfiguration.uniformBucketLevelAccess.enabled", Current: false, Desired: true},
+				common.FieldDiff{Field: "iamConfiguration.publicAccessPrevention", Current: "inherited", Desired: "enforced"},
+				common.FieldDiff{Field: "cors", Current: []string(nil), Desired: []string{"origins=[*] methods=[GET] responseHeaders=[] maxAge=0s"}},
+				common.FieldDiff{Field: "website.mainPageSuffix", Current: "", Desired: "index.html"},
+			))
+		})
+		It("does not plan a change for the labels of the run alone", func() {
+			common.SetRunLabels("/configs/fougere-lite.yaml", "v2.0.0")
+			defer common.SetRunLabels("", "")
+			liveLabels := common.OwnershipLabels("banane")
+			liveLabels[common.VersionLabel] = "v1_0_0"
+			delete(liveLabels, common.ConfigLabel)
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: storage.Bucket{Name: "patate-23423k", Location: "NORTHAMERICA-NORTHEAST1", StorageClass: "STANDARD", Labels: liveLabels},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Action).To(Equal(common.ActionNoop))
+		})
+		It("keeps the labels added by other tools in the planned spec", func() {
+			bucketConfig.Labels = map[string]string{"cost-center": "data", "app": "exports", "env": "prod"}
+			config.StorageBuckets["patate"] = bucketConfig
+			liveLabels := common.OwnershipLabels("banane")
+			liveLabels["team"] = "analytics"
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: storage.Bucket{Name: "patate-23423k", Location: "NORTHAMERICA-NORTHEAST1", StorageClass: "STANDARD", Labels: liveLabels},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Diffs).To(Equal([]common.FieldDiff{
+				{Field: "labels.app", Current: "", Desired: "exports"},
+				{Field: "labels.cost-center", Current: "", Desired: "data"},
+				{Field: "labels.env", Current: "", Desired: "prod"},
+			}))
+			var desired storage.Bucket
+			Expect(json.Unmarshal(changes[0].Desired, &desired)).To(Succeed())
+			Expect(desired.Labels).To(HaveKeyWithValue("team", "analytics"))
+			Expect(desired.Labels).To(HaveKeyWithValue("cost-center", "data"))
+		})
+		It("returns an error when a locked retention policy is shortened", func() {
+			bucketConfig.Retention = &Retention{Period: "24h", Locked: true}
+			config.StorageBuckets["patate"] = bucketConfig
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: storage.Bucket{
+					Name:            "patate-23423k",
+					Location:        "NORTHAMERICA-NORTHEAST1",
+					StorageClass:    "STANDARD",
+					Labels:          common.OwnershipLabels("banane"),
+					RetentionPolicy: &storage.BucketRetentionPolicy{RetentionPeriod: 172800, IsLocked: true},
+				},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			_, err := client.Plan(config)
+			Expect(err).To(MatchError("[patate-23423k] the retention policy is locked, its period cannot be shortened from 48h0m0s to 24h0m0s"))
+		})
+		It("returns an error if the bucket cannot be read", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseCode: 500,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			_, err := client.Plan(config)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Describe("apply planned change", func() {
+		var change common.ResourceChange
+
+		BeforeEach(func() {
+			change = common.ResourceChange{
+				Product: Product,
+				Name:    "patate-23423k",
+				Project: "projet-123",
+				Action:  common.ActionCreate,
+				Desired: []byte(`{"name":"patate-23423k","storageClass":"MULTI_REGIONAL"}`),
+			}
+		})
+
+		It("creates the bucket with the planned spec", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b?") && strings.Contains(url, "project=projet-123")
+				},
+				Method: "post",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			applied, err := client.Apply(change)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied.Name).To(Equal("patate-23423k"))
+			Expect(applied.Product).To(Equal(Product))
+		})
+		It("refuses to lock a retention policy without confirmation", func() {
+			change.Desired = []byte(`{"name":"patate-23423k","retentionPolicy":{"retentionPeriod":"86400","isLocked":true}}`)
+			mockServerCalls := make(chan utils.MockServerCall, 0)
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			_, err := client.Apply(change)
+			Expect(err).To(MatchError("[patate-23423k] locking the retention policy cannot be undone, rerun with --confirm-retention-lock to lock it"))
+		})
+		It("locks the retention policy once the bucket is created when confirmed", func() {
+			change.Desired = []byte(`{"name":"patate-23423k","retentionPolicy":{"retentionPeriod":"86400","isLocked":true}}`)
+			mockServerCalls := make(chan utils.MockServerCall, 2)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b?")
+				},
+				Method:       "post",
+				ResponseBody: storage.Bucket{Name: "patate-23423k", Metageneration: 1, RetentionPolicy: &storage.BucketRetentionPolicy{RetentionPeriod: 86400}},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/patate-23423k/lockRetentionPolicy?") && strings.Contains(url, "ifMetagenerationMatch=1")
+				},
+				Method: "post",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+			client.confirmRetentionLock = true
+
+			_, err := client.Apply(change)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("verifies a bucket that is still missing", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseCode: 404,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			Expect(client.Verify(change)).To(Succeed())
+		})
+		It("refuses a bucket that changed since the plan was made", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: storage.Bucket{Name: "patate-23423k"},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			Expect(client.Verify(change)).To(MatchError(ContainSubstring("changed since the plan was made")))
+		})
+	})
+	Describe("delete bucket", func() {
+		It("successfully deletes the bucket", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/patate-23423k?")
+				},
+				Method:       "delete",
+				ResponseCode: 204,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			err := client.delete(bucketConfig.Name, false)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("empties the bucket first when forced", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 3)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/patate-23423k/o?") && strings.Contains(url, "versions=true")
+				},
+				ResponseBody: storage.Objects{Items: []*storage.Object{{Name: "export.csv", Generation: 42}}},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/patate-23423k/o/export.csv?") && strings.Contains(url, "generation=42")
+				},
+				Method:       "delete",
+				ResponseCode: 204,
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/patate-23423k?")
+				},
+				Method:       "delete",
+				ResponseCode: 204,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			err := client.delete(bucketConfig.Name, true)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("ignores a bucket that does not exist", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				Method:       "delete",
+				ResponseCode: 404,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			err := client.Delete(&Config{StorageBuckets: map[string]StorageBucket{"patate": bucketConfig}}, false)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("returns an error if the bucket is not empty", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				Method:       "delete",
+				ResponseCode: 409,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			err := client.Delete(&Config{StorageBuckets: map[string]StorageBucket{"patate": bucketConfig}}, false)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Describe("find orphan buckets", func() {
+		It("returns the managed buckets that are not declared", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b?") && strings.Contains(url, "project=projet-123")
+				},
+				ResponseBody: storage.Buckets{Items: []*storage.Bucket{
+					{Name: "banane-patate-projet-123", Labels: common.OwnershipLabels("banane")},
+					{Name: "banane-carotte-projet-123", Labels: common.OwnershipLabels("banane")},
+					{Name: "not-managed-by-us"},
+				}},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			orphans, err := client.FindOrphans([]string{"projet-123"}, map[string]bool{"banane-patate-projet-123": true}, map[string]bool{"banane": true})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(orphans).To(HaveLen(1))
+			Expect(orphans[0].Name).To(Equal("banane-carotte-projet-123"))
+			Expect(orphans[0].Client).To(Equal("banane"))
+			Expect(orphans[0].Key).To(Equal("carotte"))
+			Expect(orphans[0].Action).To(Equal(common.ActionDelete))
+		})
+		It("leaves out the buckets of the clients of another config", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b?") && strings.Contains(url, "project=projet-123")
+				},
+				ResponseBody: storage.Buckets{Items: []*storage.Bucket{
+					{Name: "banane-patate-projet-123", Labels: common.OwnershipLabels("banane")},
+					{Name: "pomme-tarte-projet-123", Labels: common.OwnershipLabels("pomme")},
+				}},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			orphans, err := client.FindOrphans([]string{"projet-123"}, map[string]bool{"banane-patate-projet-123": true}, map[string]bool{"banane": true})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(orphans).To(BeEmpty())
+		})
+	})
+	Describe("export buckets", func() {
+		It("returns the config of the live buckets", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/patate-23423k?")
+				},
+				ResponseBody: storage.Bucket{Name: "patate-23423k", Location: "NORTHAMERICA-NORTHEAST1", StorageClass: "NEARLINE"},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			exported, err := client.Export(&Config{StorageBuckets: map[string]StorageBucket{"patate": bucketConfig}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exported).To(HaveKeyWithValue("patate", StorageBucket{
+				Name:         "patate-23423k",
+				Location:     "northamerica-northeast1",
+				ProjectId:    "projet-123",
+				StorageClass: "NEARLINE",
+				ClientName:   "banane",
+			}))
+		})
+		It("leaves out the buckets that do not exist", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseCode: 404,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			exported, err := client.Export(&Config{StorageBuckets: map[string]StorageBucket{"patate": bucketConfig}})
 			Expect(err).ToNot(HaveOccurred())
+			Expect(exported).To(BeEmpty())
 		})
 	})
 	// Describe("get bucket", func() {
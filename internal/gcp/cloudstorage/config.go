@@ -3,24 +3,179 @@ package cloudstorage
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/spf13/viper"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/gcp/kms"
 )
 
 type Config struct {
-	StorageBuckets map[string]StorageBucket `mapstructure:"storageBucket" validate:"dive"`
+	StorageBuckets map[string]StorageBucket `mapstructure:"storageBucket" yaml:"storageBucket" validate:"dive"`
 }
 
 // StorageBucket contains the information required to create a Cloud Storage in gcp.
 // A storage bucket is used to store all kinds of objects.
 type StorageBucket struct {
-	Name       string `json:"name" validate:"required"`
-	Region     string `json:"region" validate:"required"`
-	ProjectId  string `json:"projectId" validate:"required"`
-	ClientName string
+	Name string `json:"name" yaml:"-" validate:"required"`
+	// Location is a region such as us-central1, a dual-region such as nam4 or
+	// a multi-region such as us. It cannot be changed once the bucket exists.
+	Location string `json:"location" yaml:"location,omitempty"`
+	// DataLocations are the two regions of a configurable dual-region, whose
+	// Location is the multi-region holding them.
+	DataLocations []string `json:"dataLocations" yaml:"dataLocations,omitempty"`
+	// Region is the location of a new bucket when Location is not set. It was
+	// not applied before Location existed, so an existing bucket keeps its
+	// live location.
+	Region       string `json:"region" yaml:"region,omitempty"`
+	ProjectId    string `json:"projectId" yaml:"projectId" validate:"required"`
+	StorageClass string `json:"storageClass" yaml:"storageClass,omitempty" validate:"omitempty,oneof=STANDARD NEARLINE COLDLINE ARCHIVE MULTI_REGIONAL REGIONAL DURABLE_REDUCED_AVAILABILITY"`
+	Versioning   bool   `json:"versioning" yaml:"versioning,omitempty"`
+	// Autoclass moves the objects between storage classes according to their
+	// access, the storage class of the bucket must then be STANDARD.
+	Autoclass *Autoclass `json:"autoclass" yaml:"autoclass,omitempty"`
+	// LifecycleRules delete the objects or change their storage class when
+	// they match the condition of a rule.
+	LifecycleRules []LifecycleRule `json:"lifecycleRules" yaml:"lifecycleRules,omitempty" validate:"dive"`
+	// Retention keeps the objects from being deleted or replaced for its
+	// period.
+	Retention *Retention `json:"retention" yaml:"retention,omitempty"`
+	// DefaultEventBasedHold places an event-based hold on the new objects.
+	DefaultEventBasedHold bool `json:"defaultEventBasedHold" yaml:"defaultEventBasedHold,omitempty"`
+	// SoftDeleteRetention is how long deleted objects can be restored, e.g.
+	// 168h. 0s disables soft delete, GCP keeps them 7 days when it is not set.
+	SoftDeleteRetention string `json:"softDeleteRetention" yaml:"softDeleteRetention,omitempty"`
+	// UniformAccess disables the object ACLs, access to the objects is then
+	// only granted by IAM.
+	UniformAccess bool `json:"uniformAccess" yaml:"uniformAccess,omitempty"`
+	// PublicAccessPrevention is enforced to refuse any public access, or
+	// inherited from the organization policy. GCP inherits it when it is not
+	// set.
+	PublicAccessPrevention string `json:"publicAccessPrevention" yaml:"publicAccessPrevention,omitempty" validate:"omitempty,oneof=enforced inherited"`
+	// AllowPublic exempts the bucket from --enforce-secure-defaults.
+	AllowPublic bool `json:"allowPublic" yaml:"allowPublic,omitempty"`
+	// Cors are the cross-origin requests allowed on the objects.
+	Cors []CorsRule `json:"cors" yaml:"cors,omitempty" validate:"dive"`
+	// Website serves the bucket as a static website.
+	Website *Website `json:"website" yaml:"website,omitempty"`
+	// Labels are added to the ownership labels of the bucket. The labels
+	// added by other tools are kept on update.
+	Labels map[string]string `json:"labels" yaml:"labels,omitempty"`
+	// KmsKey is the key of a crypto key of the client's kms config, or a full
+	// crypto key name, used as the default encryption key of the bucket.
+	KmsKey string `json:"kmsKey" yaml:"kmsKey,omitempty"`
+	// KmsKeyName is the full name of the crypto key, resolved by
+	// GetStorageConfig.
+	KmsKeyName string `json:"-" yaml:"-"`
+	// KeepLiveLocation is set by GetStorageConfig when Location is not set:
+	// Location then only applies to a new bucket.
+	KeepLiveLocation bool   `json:"-" yaml:"-"`
+	ClientName       string `yaml:"-"`
 }
 
+type Autoclass struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// TerminalStorageClass is the coldest class objects are moved to, NEARLINE
+	// by default.
+	TerminalStorageClass string `json:"terminalStorageClass" yaml:"terminalStorageClass,omitempty" validate:"omitempty,oneof=NEARLINE ARCHIVE"`
+}
+
+type Retention struct {
+	// Period is the minimum time the objects are kept, e.g. 8760h.
+	Period string `json:"period" yaml:"period" validate:"required"`
+	// Locked locks the retention policy, which then cannot be removed or
+	// shortened. Locking requires the --confirm-retention-lock flag.
+	Locked bool `json:"locked" yaml:"locked,omitempty"`
+}
+
+type CorsRule struct {
+	Origins []string `json:"origins" yaml:"origins" validate:"required"`
+	Methods []string `json:"methods" yaml:"methods" validate:"required,dive,oneof=GET HEAD PUT POST DELETE PATCH OPTIONS"`
+	// ResponseHeaders are the headers the browsers may read from the
+	// responses.
+	ResponseHeaders []string `json:"responseHeaders" yaml:"responseHeaders,omitempty"`
+	// MaxAge is how long the browsers may cache the preflight responses, e.g.
+	// 1h.
+	MaxAge string `json:"maxAge" yaml:"maxAge,omitempty"`
+}
+
+type Website struct {
+	// MainPageSuffix is the object served for the directories, e.g.
+	// index.html.
+	MainPageSuffix string `json:"mainPageSuffix" yaml:"mainPageSuffix,omitempty"`
+	// NotFoundPage is the object served when an object does not exist, e.g.
+	// 404.html.
+	NotFoundPage string `json:"notFoundPage" yaml:"notFoundPage,omitempty"`
+}
+
+// PublicAccessPreventionEnforced refuses any public access to a bucket.
+const PublicAccessPreventionEnforced = "enforced"
+
+// Limits of the retention period and of the soft delete retention duration.
+const (
+	maxRetentionPeriod     = 100 * 365 * 24 * time.Hour
+	minSoftDeleteRetention = 7 * 24 * time.Hour
+	maxSoftDeleteRetention = 90 * 24 * time.Hour
+)
+
+// LifecycleRule runs its action on the objects matching every property set in
+// its condition.
+type LifecycleRule struct {
+	Action string `json:"action" yaml:"action" validate:"required,oneof=Delete SetStorageClass AbortIncompleteMultipartUpload"`
+	// StorageClass is the class the objects are moved to by SetStorageClass.
+	StorageClass string             `json:"storageClass" yaml:"storageClass,omitempty" validate:"omitempty,oneof=NEARLINE COLDLINE ARCHIVE MULTI_REGIONAL REGIONAL"`
+	Condition    LifecycleCondition `json:"condition" yaml:"condition"`
+}
+
+type LifecycleCondition struct {
+	// Age is the age of the objects in days.
+	Age *int64 `json:"age" yaml:"age,omitempty" validate:"omitempty,min=0"`
+	// CreatedBefore is a date, e.g. 2023-01-31.
+	CreatedBefore string `json:"createdBefore" yaml:"createdBefore,omitempty" validate:"omitempty,datetime=2006-01-02"`
+	// NumNewerVersions matches the noncurrent versions that have at least
+	// this number of newer versions.
+	NumNewerVersions int64    `json:"numNewerVersions" yaml:"numNewerVersions,omitempty" validate:"min=0"`
+	MatchesPrefix    []string `json:"matchesPrefix" yaml:"matchesPrefix,omitempty"`
+	MatchesSuffix    []string `json:"matchesSuffix" yaml:"matchesSuffix,omitempty"`
+	// IsLive matches the live versions when true and the noncurrent ones when
+	// false.
+	IsLive *bool `json:"isLive" yaml:"isLive,omitempty"`
+}
+
+// Actions of the lifecycle rules.
+const (
+	LifecycleDelete          = "Delete"
+	LifecycleSetStorageClass = "SetStorageClass"
+	LifecycleAbortUpload     = "AbortIncompleteMultipartUpload"
+)
+
+// DefaultLocation is the location of the new buckets that set neither location
+// nor region, the one GCP gave the buckets created before Location existed.
+const DefaultLocation = "us"
+
+// DefaultTerminalStorageClass is the terminal storage class of autoclass when
+// it is not set.
+const DefaultTerminalStorageClass = "NEARLINE"
+
+// Kinds of bucket locations, see LocationType.
+const (
+	LocationRegion      = "region"
+	LocationDualRegion  = "dual-region"
+	LocationMultiRegion = "multi-region"
+)
+
+var (
+	multiRegions = map[string]bool{"us": true, "eu": true, "asia": true}
+	dualRegions  = map[string]bool{"asia1": true, "eur4": true, "eur5": true, "eur7": true, "eur8": true, "nam4": true}
+	regionFormat = regexp.MustCompile(`^[a-z]+-[a-z]+[0-9]+$`)
+)
+
+// GetStorageConfig parses the buckets of a client. The crypto keys referenced
+// by key are resolved with the kms config of the same client.
 func GetStorageConfig(viperConfig *viper.Viper, clientName string) (*Config, error) {
 	if viperConfig == nil {
 		return nil, nil
@@ -31,16 +186,67 @@ func GetStorageConfig(viperConfig *viper.Viper, clientName string) (*Config, err
 	if err != nil {
 		return nil, err
 	}
+	kmsConfig, err := kms.GetKmsConfig(viperConfig, clientName)
+	if err != nil {
+		return nil, err
+	}
 
 	for name, bucket := range storageConfig.StorageBuckets {
-		bucket.Name = fmt.Sprintf("%s-%s-%s", clientName, name, bucket.ProjectId)
+		bucket.Name = BucketName(clientName, name, bucket.ProjectId)
 		bucket.ClientName = clientName
+		if bucket.Location == "" {
+			bucket.Location = bucket.Region
+			if bucket.Location == "" {
+				bucket.Location = DefaultLocation
+			}
+			bucket.KeepLiveLocation = true
+		}
+		bucket.Location = strings.ToLower(bucket.Location)
+		bucket.KmsKeyName = ""
+		if strings.Contains(bucket.KmsKey, "/") {
+			bucket.KmsKeyName = bucket.KmsKey
+		} else if cryptoKey, ok := kmsConfig.Kms.CryptoKeys[bucket.KmsKey]; ok && bucket.KmsKey != "" {
+			bucket.KmsKeyName = kms.CryptoKeyName(cryptoKey)
+		}
 
 		storageConfig.StorageBuckets[name] = bucket
 	}
 	return &storageConfig, nil
 }
 
+// BucketName returns the GCP name of a bucket, <client>-<key>-<project>.
+func BucketName(clientName string, key string, projectId string) string {
+	return fmt.Sprintf("%s-%s-%s", clientName, key, projectId)
+}
+
+// ParseBucketName maps the GCP name of a bucket back to its client and key. The
+// client is the longest of clientNames the name starts with or, when none
+// matches, the part of the name before the first dash.
+func ParseBucketName(name string, projectId string, clientNames []string) (string, string, error) {
+	rest := strings.TrimSuffix(name, "-"+projectId)
+	if rest == name {
+		return "", "", fmt.Errorf("bucket %s does not end with the project id %s", name, projectId)
+	}
+	clientName := ""
+	for _, candidate := range clientNames {
+		if strings.HasPrefix(rest, candidate+"-") && len(candidate) > len(clientName) {
+			clientName = candidate
+		}
+	}
+	if clientName == "" {
+		dash := strings.Index(rest, "-")
+		if dash <= 0 {
+			return "", "", fmt.Errorf("bucket %s does not follow the <client>-<name>-<project> convention", name)
+		}
+		clientName = rest[:dash]
+	}
+	key := strings.TrimPrefix(rest, clientName+"-")
+	if key == "" {
+		return "", "", fmt.Errorf("bucket %s does not follow the <client>-<name>-<project> convention", name)
+	}
+	return clientName, key, nil
+}
+
 func ValidateConfig(config *Config) error {
 	v := validator.New()
 	if err := v.Struct(config); err != nil {
@@ -48,5 +254,176 @@ func ValidateConfig(config *Config) error {
 			return fmt.Errorf("%s validate failed on the %s rule", err.Namespace(), err.Tag())
 		}
 	}
+	for key, bucket := range config.StorageBuckets {
+		if err := common.ValidateLabels(bucket.Labels); err != nil {
+			return fmt.Errorf("bucket %s: %s", key, err)
+		}
+		if err := validateLocation(key, bucket); err != nil {
+			return err
+		}
+		if err := validateLifecycle(key, bucket); err != nil {
+			return err
+		}
+		if err := validateRetention(key, bucket); err != nil {
+			return err
+		}
+		if err := validateAccess(key, bucket); err != nil {
+			return err
+		}
+		if bucket.KmsKey == "" {
+			continue
+		}
+		if bucket.KmsKeyName == "" {
+			return fmt.Errorf("bucket %s: crypto key %s is not declared in kms", key, bucket.KmsKey)
+		}
+		if location := kms.KeyLocation(bucket.KmsKeyName); !strings.EqualFold(location, bucket.Location) {
+			return fmt.Errorf("bucket %s: crypto key %s must be in the location of the bucket %s", key, bucket.KmsKey, bucket.Location)
+		}
+	}
+	return nil
+}
+
+// validateLocation checks that the location of a bucket exists and allows its
+// storage class and autoclass.
+func validateLocation(key string, bucket StorageBucket) error {
+	locationType := LocationType(bucket)
+	if locationType == "" {
+		return fmt.Errorf("bucket %s: unknown location %s", key, bucket.Location)
+	}
+	if len(bucket.DataLocations) > 0 {
+		if len(bucket.DataLocations) != 2 || !multiRegions[strings.ToLower(bucket.Location)] {
+			return fmt.Errorf("bucket %s: dataLocations must be two regions of the multi-region location", key)
+		}
+		for _, region := range bucket.DataLocations {
+			if !regionFormat.MatchString(strings.ToLower(region)) {
+				return fmt.Errorf("bucket %s: data location %s is not a region", key, region)
+			}
+		}
+	}
+	switch {
+	case bucket.StorageClass == "REGIONAL" && locationType != LocationRegion:
+		return fmt.Errorf("bucket %s: storage class REGIONAL requires a region, %s is a %s", key, bucket.Location, locationType)
+	case bucket.StorageClass == "MULTI_REGIONAL" && locationType == LocationRegion:
+		return fmt.Errorf("bucket %s: storage class MULTI_REGIONAL requires a dual-region or a multi-region, %s is a region", key, bucket.Location)
+	}
+	if bucket.Autoclass != nil && bucket.Autoclass.Enabled && bucket.StorageClass != "" && bucket.StorageClass != "STANDARD" {
+		return fmt.Errorf("bucket %s: autoclass requires the STANDARD storage class", key)
+	}
 	return nil
 }
+
+// validateLifecycle checks that every lifecycle rule of a bucket has a
+// condition and only uses the conditions and storage classes its action and the
+// bucket allow.
+func validateLifecycle(key string, bucket StorageBucket) error {
+	for i, rule := range bucket.LifecycleRules {
+		condition := rule.Condition
+		if condition.Age == nil && condition.CreatedBefore == "" && condition.NumNewerVersions == 0 &&
+			len(condition.MatchesPrefix) == 0 && len(condition.MatchesSuffix) == 0 && condition.IsLive == nil {
+			return fmt.Errorf("bucket %s: lifecycle rule %d has no condition", key, i)
+		}
+		switch rule.Action {
+		case LifecycleSetStorageClass:
+			if rule.StorageClass == "" {
+				return fmt.Errorf("bucket %s: lifecycle rule %d: SetStorageClass requires a storageClass", key, i)
+			}
+			if bucket.Autoclass != nil && bucket.Autoclass.Enabled {
+				return fmt.Errorf("bucket %s: lifecycle rule %d: SetStorageClass cannot be used with autoclass", key, i)
+			}
+			locationType := LocationType(bucket)
+			if rule.StorageClass == "REGIONAL" && locationType != LocationRegion || rule.StorageClass == "MULTI_REGIONAL" && locationType == LocationRegion {
+				return fmt.Errorf("bucket %s: lifecycle rule %d: storage class %s is not available in the %s %s", key, i, rule.StorageClass, locationType, bucket.Location)
+			}
+		default:
+			if rule.StorageClass != "" {
+				return fmt.Errorf("bucket %s: lifecycle rule %d: storageClass only applies to SetStorageClass", key, i)
+			}
+		}
+		if rule.Action == LifecycleAbortUpload && (condition.CreatedBefore != "" || condition.NumNewerVersions != 0 || condition.IsLive != nil) {
+			return fmt.Errorf("bucket %s: lifecycle rule %d: AbortIncompleteMultipartUpload only supports the age, matchesPrefix and matchesSuffix conditions", key, i)
+		}
+		if !bucket.Versioning && (condition.NumNewerVersions != 0 || condition.IsLive != nil && !*condition.IsLive) {
+			return fmt.Errorf("bucket %s: lifecycle rule %d: numNewerVersions and isLive false require versioning", key, i)
+		}
+	}
+	return nil
+}
+
+// validateRetention checks the retention period and the soft delete retention
+// duration of a bucket.
+func validateRetention(key string, bucket StorageBucket) error {
+	if bucket.Retention != nil {
+		period, err := time.ParseDuration(bucket.Retention.Period)
+		if err != nil || period < time.Second || period > maxRetentionPeriod {
+			return fmt.Errorf("bucket %s: retention period %s must be a duration between 1s and 100 years", key, bucket.Retention.Period)
+		}
+		if bucket.Versioning {
+			return fmt.Errorf("bucket %s: retention cannot be used with versioning", key)
+		}
+	}
+	if bucket.SoftDeleteRetention != "" {
+		duration, err := time.ParseDuration(bucket.SoftDeleteRetention)
+		if err != nil || duration != 0 && (duration < minSoftDeleteRetention || duration > maxSoftDeleteRetention) {
+			return fmt.Errorf("bucket %s: softDeleteRetention %s must be 0s or a duration between 7 and 90 days", key, bucket.SoftDeleteRetention)
+		}
+	}
+	return nil
+}
+
+// validateAccess checks the CORS rules and that a website can be served.
+func validateAccess(key string, bucket StorageBucket) error {
+	for i, rule := range bucket.Cors {
+		if rule.MaxAge == "" {
+			continue
+		}
+		if maxAge, err := time.ParseDuration(rule.MaxAge); err != nil || maxAge < 0 {
+			return fmt.Errorf("bucket %s: cors rule %d: invalid maxAge %s", key, i, rule.MaxAge)
+		}
+	}
+	if bucket.Website != nil && bucket.PublicAccessPrevention == PublicAccessPreventionEnforced {
+		return fmt.Errorf("bucket %s: a website cannot be served with publicAccessPrevention enforced", key)
+	}
+	return nil
+}
+
+// ValidateSecureDefaults returns an error if a bucket that does not set
+// allowPublic could be made public, i.e. it does not use uniform access with
+// public access prevention enforced.
+func ValidateSecureDefaults(config *Config) error {
+	for _, key := range sortedBucketKeys(config) {
+		bucket := config.StorageBuckets[key]
+		if bucket.AllowPublic {
+			continue
+		}
+		if !bucket.UniformAccess || bucket.PublicAccessPrevention != PublicAccessPreventionEnforced {
+			return fmt.Errorf("bucket %s: could be public, set uniformAccess and publicAccessPrevention to enforced, or allowPublic to keep it public", key)
+		}
+	}
+	return nil
+}
+
+func sortedBucketKeys(config *Config) []string {
+	keys := make([]string, 0, len(config.StorageBuckets))
+	for key := range config.StorageBuckets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// LocationType returns whether the location of a bucket is a region, a
+// dual-region or a multi-region, or an empty string when it is none of them.
+func LocationType(bucket StorageBucket) string {
+	location := strings.ToLower(bucket.Location)
+	switch {
+	case multiRegions[location] && len(bucket.DataLocations) > 0:
+		return LocationDualRegion
+	case multiRegions[location]:
+		return LocationMultiRegion
+	case dualRegions[location]:
+		return LocationDualRegion
+	case regionFormat.MatchString(location):
+		return LocationRegion
+	}
+	return ""
+}
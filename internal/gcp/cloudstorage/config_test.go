@@ -7,13 +7,57 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/spf13/viper"
+	"metrio.net/fougere-lite/internal/common"
 )
 
 var validBucketConfig = []byte(`
 storageBucket:
   metrio-test:
     region: us-central1
-    projectId: some-project`)
+    projectId: some-project
+  archive:
+    location: US
+    dataLocations: [us-east1, us-central1]
+    projectId: some-project
+    versioning: true
+    autoclass:
+      enabled: true
+      terminalStorageClass: ARCHIVE`)
+
+var encryptedBucketConfig = []byte(`
+kms:
+  keyRings:
+    main:
+      projectId: some-project
+      location: us-central1
+  cryptoKeys:
+    buckets:
+      keyRing: main
+storageBucket:
+  metrio-test:
+    location: us-central1
+    projectId: some-project
+    kmsKey: buckets`)
+
+var lifecycleBucketConfig = []byte(`
+storageBucket:
+  exports:
+    location: us-central1
+    projectId: some-project
+    versioning: true
+    lifecycleRules:
+      - action: Delete
+        condition:
+          age: 7
+          matchesPrefix: [tmp/]
+      - action: SetStorageClass
+        storageClass: COLDLINE
+        condition:
+          createdBefore: 2023-01-31
+      - action: Delete
+        condition:
+          numNewerVersions: 3
+          isLive: false`)
 
 var invalidConfig = []byte(`
 storageBucket:
@@ -32,10 +76,54 @@ var _ = Describe("config", func() {
 			Expect(err).ToNot(HaveOccurred())
 			storageConfig, err := GetStorageConfig(viper.GetViper(), "metrio-client")
 			Expect(err).To(BeNil())
-			Expect(len(storageConfig.StorageBuckets)).To(Equal(1))
+			Expect(len(storageConfig.StorageBuckets)).To(Equal(2))
 			bucket := storageConfig.StorageBuckets["metrio-test"]
-			Expect(bucket.Region).To(Equal("us-central1"))
+			Expect(bucket.Location).To(Equal("us-central1"))
+			Expect(bucket.KeepLiveLocation).To(BeTrue())
 			Expect(bucket.ProjectId).To(Equal("some-project"))
+			Expect(LocationType(bucket)).To(Equal(LocationRegion))
+			archive := storageConfig.StorageBuckets["archive"]
+			Expect(archive.Location).To(Equal("us"))
+			Expect(archive.KeepLiveLocation).To(BeFalse())
+			Expect(LocationType(archive)).To(Equal(LocationDualRegion))
+			Expect(archive.Versioning).To(BeTrue())
+			Expect(archive.Autoclass).To(Equal(&Autoclass{Enabled: true, TerminalStorageClass: "ARCHIVE"}))
+			Expect(ValidateConfig(storageConfig)).To(Succeed())
+		})
+		It("uses the default location when neither location nor region is set", func() {
+			err := viper.ReadConfig(bytes.NewBuffer([]byte(`
+storageBucket:
+  metrio-test:
+    projectId: some-project`)))
+			Expect(err).ToNot(HaveOccurred())
+			storageConfig, err := GetStorageConfig(viper.GetViper(), "metrio-client")
+			Expect(err).To(BeNil())
+			bucket := storageConfig.StorageBuckets["metrio-test"]
+			Expect(bucket.Location).To(Equal(DefaultLocation))
+			Expect(bucket.KeepLiveLocation).To(BeTrue())
+		})
+		It("resolves the crypto key of the kms config", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(encryptedBucketConfig))
+			Expect(err).ToNot(HaveOccurred())
+			storageConfig, err := GetStorageConfig(viper.GetViper(), "metrio-client")
+			Expect(err).To(BeNil())
+			bucket := storageConfig.StorageBuckets["metrio-test"]
+			Expect(bucket.KmsKeyName).To(Equal("projects/some-project/locations/us-central1/keyRings/metrio-client-main/cryptoKeys/metrio-client-buckets"))
+			Expect(ValidateConfig(storageConfig)).To(Succeed())
+		})
+		It("parses the lifecycle rules", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(lifecycleBucketConfig))
+			Expect(err).ToNot(HaveOccurred())
+			storageConfig, err := GetStorageConfig(viper.GetViper(), "metrio-client")
+			Expect(err).To(BeNil())
+			rules := storageConfig.StorageBuckets["exports"].LifecycleRules
+			Expect(rules).To(HaveLen(3))
+			Expect(*rules[0].Condition.Age).To(Equal(int64(7)))
+			Expect(rules[0].Condition.MatchesPrefix).To(Equal([]string{"tmp/"}))
+			Expect(rules[1].StorageClass).To(Equal("COLDLINE"))
+			Expect(rules[1].Condition.CreatedBefore).To(Equal("2023-01-31"))
+			Expect(*rules[2].Condition.IsLive).To(BeFalse())
+			Expect(ValidateConfig(storageConfig)).To(Succeed())
 		})
 		It("returns an error if cannot parse the config", func() {
 			err := viper.ReadConfig(bytes.NewBuffer(invalidConfig))
@@ -49,7 +137,7 @@ var _ = Describe("config", func() {
 			config := &Config{
 				StorageBuckets: map[string]StorageBucket{
 					"foooo": {
-						Region:    "us-central1",
+						Location:  "us-central1",
 						ProjectId: "mock-project",
 						Name:      "foooo",
 					},
@@ -62,7 +150,7 @@ var _ = Describe("config", func() {
 			config := &Config{
 				StorageBuckets: map[string]StorageBucket{
 					"foooo": {
-						Region:    "us-central1",
+						Location:  "us-central1",
 						ProjectId: "mock-project",
 					},
 				},
@@ -70,7 +158,7 @@ var _ = Describe("config", func() {
 			err := ValidateConfig(config)
 			Expect(err).Should(MatchError(ContainSubstring("validate failed on the required rule")))
 		})
-		It("should detect an empty region", func() {
+		It("should detect an empty location", func() {
 			config := &Config{
 				StorageBuckets: map[string]StorageBucket{
 					"foooo": {
@@ -80,7 +168,239 @@ var _ = Describe("config", func() {
 				},
 			}
 			err := ValidateConfig(config)
-			Expect(err).Should(MatchError(ContainSubstring("validate failed on the required rule")))
+			Expect(err).Should(MatchError("bucket foooo: unknown location "))
+		})
+		It("should detect an invalid storage class", func() {
+			config := &Config{
+				StorageBuckets: map[string]StorageBucket{
+					"foooo": {
+						Location:     "us-central1",
+						ProjectId:    "mock-project",
+						Name:         "foooo",
+						StorageClass: "FROZEN",
+					},
+				},
+			}
+			err := ValidateConfig(config)
+			Expect(err).Should(MatchError(ContainSubstring("validate failed on the oneof rule")))
+		})
+		It("should detect an unknown location", func() {
+			config := &Config{
+				StorageBuckets: map[string]StorageBucket{
+					"foooo": {Location: "moon", ProjectId: "mock-project", Name: "foooo"},
+				},
+			}
+			err := ValidateConfig(config)
+			Expect(err).Should(MatchError("bucket foooo: unknown location moon"))
+		})
+		It("should detect a regional storage class in a multi-region", func() {
+			config := &Config{
+				StorageBuckets: map[string]StorageBucket{
+					"foooo": {Location: "eu", ProjectId: "mock-project", Name: "foooo", StorageClass: "REGIONAL"},
+				},
+			}
+			err := ValidateConfig(config)
+			Expect(err).Should(MatchError("bucket foooo: storage class REGIONAL requires a region, eu is a multi-region"))
+		})
+		It("should detect a multi-regional storage class in a region", func() {
+			config := &Config{
+				StorageBuckets: map[string]StorageBucket{
+					"foooo": {Location: "us-central1", ProjectId: "mock-project", Name: "foooo", StorageClass: "MULTI_REGIONAL"},
+				},
+			}
+			err := ValidateConfig(config)
+			Expect(err).Should(MatchError("bucket foooo: storage class MULTI_REGIONAL requires a dual-region or a multi-region, us-central1 is a region"))
+		})
+		It("should detect data locations outside of a multi-region", func() {
+			config := &Config{
+				StorageBuckets: map[string]StorageBucket{
+					"foooo": {Location: "nam4", DataLocations: []string{"us-east1", "us-central1"}, ProjectId: "mock-project", Name: "foooo"},
+				},
+			}
+			err := ValidateConfig(config)
+			Expect(err).Should(MatchError("bucket foooo: dataLocations must be two regions of the multi-region location"))
+		})
+		It("should detect autoclass with another storage class", func() {
+			config := &Config{
+				StorageBuckets: map[string]StorageBucket{
+					"foooo": {Location: "us-central1", ProjectId: "mock-project", Name: "foooo", StorageClass: "COLDLINE", Autoclass: &Autoclass{Enabled: true}},
+				},
+			}
+			err := ValidateConfig(config)
+			Expect(err).Should(MatchError("bucket foooo: autoclass requires the STANDARD storage class"))
+		})
+		Context("lifecycle rules", func() {
+			var bucket StorageBucket
+			var age int64 = 30
+
+			BeforeEach(func() {
+				bucket = StorageBucket{Location: "us-central1", ProjectId: "mock-project", Name: "foooo"}
+			})
+			validate := func(rule LifecycleRule) error {
+				bucket.LifecycleRules = []LifecycleRule{rule}
+				return ValidateConfig(&Config{StorageBuckets: map[string]StorageBucket{"foooo": bucket}})
+			}
+
+			It("should detect a rule without condition", func() {
+				Expect(validate(LifecycleRule{Action: LifecycleDelete})).To(MatchError("bucket foooo: lifecycle rule 0 has no condition"))
+			})
+			It("should detect an invalid action", func() {
+				err := validate(LifecycleRule{Action: "Archive", Condition: LifecycleCondition{Age: &age}})
+				Expect(err).To(MatchError("Config.StorageBuckets[foooo].LifecycleRules[0].Action validate failed on the oneof rule"))
+			})
+			It("should detect an invalid date", func() {
+				err := validate(LifecycleRule{Action: LifecycleDelete, Condition: LifecycleCondition{CreatedBefore: "31/01/2023"}})
+				Expect(err).To(MatchError("Config.StorageBuckets[foooo].LifecycleRules[0].Condition.CreatedBefore validate failed on the datetime rule"))
+			})
+			It("should detect SetStorageClass without storage class", func() {
+				err := validate(LifecycleRule{Action: LifecycleSetStorageClass, Condition: LifecycleCondition{Age: &age}})
+				Expect(err).To(MatchError("bucket foooo: lifecycle rule 0: SetStorageClass requires a storageClass"))
+			})
+			It("should detect a storage class on Delete", func() {
+				err := validate(LifecycleRule{Action: LifecycleDelete, StorageClass: "ARCHIVE", Condition: LifecycleCondition{Age: &age}})
+				Expect(err).To(MatchError("bucket foooo: lifecycle rule 0: storageClass only applies to SetStorageClass"))
+			})
+			It("should detect SetStorageClass with autoclass", func() {
+				bucket.Autoclass = &Autoclass{Enabled: true}
+				err := validate(LifecycleRule{Action: LifecycleSetStorageClass, StorageClass: "ARCHIVE", Condition: LifecycleCondition{Age: &age}})
+				Expect(err).To(MatchError("bucket foooo: lifecycle rule 0: SetStorageClass cannot be used with autoclass"))
+			})
+			It("should detect a storage class unavailable in the location", func() {
+				err := validate(LifecycleRule{Action: LifecycleSetStorageClass, StorageClass: "MULTI_REGIONAL", Condition: LifecycleCondition{Age: &age}})
+				Expect(err).To(MatchError("bucket foooo: lifecycle rule 0: storage class MULTI_REGIONAL is not available in the region us-central1"))
+			})
+			It("should detect an unsupported condition on AbortIncompleteMultipartUpload", func() {
+				err := validate(LifecycleRule{Action: LifecycleAbortUpload, Condition: LifecycleCondition{CreatedBefore: "2023-01-31"}})
+				Expect(err).To(MatchError("bucket foooo: lifecycle rule 0: AbortIncompleteMultipartUpload only supports the age, matchesPrefix and matchesSuffix conditions"))
+			})
+			It("should detect a noncurrent version condition without versioning", func() {
+				err := validate(LifecycleRule{Action: LifecycleDelete, Condition: LifecycleCondition{NumNewerVersions: 2}})
+				Expect(err).To(MatchError("bucket foooo: lifecycle rule 0: numNewerVersions and isLive false require versioning"))
+			})
+		})
+		Context("retention", func() {
+			var bucket StorageBucket
+
+			BeforeEach(func() {
+				bucket = StorageBucket{Location: "us-central1", ProjectId: "mock-project", Name: "foooo"}
+			})
+			validate := func() error {
+				return ValidateConfig(&Config{StorageBuckets: map[string]StorageBucket{"foooo": bucket}})
+			}
+
+			It("should accept a locked retention policy with soft delete disabled", func() {
+				bucket.Retention = &Retention{Period: "720h", Locked: true}
+				bucket.SoftDeleteRetention = "0s"
+				Expect(validate()).To(Succeed())
+			})
+			It("should detect an invalid retention period", func() {
+				bucket.Retention = &Retention{Period: "forever"}
+				Expect(validate()).To(MatchError("bucket foooo: retention period forever must be a duration between 1s and 100 years"))
+			})
+			It("should detect a retention policy with versioning", func() {
+				bucket.Retention = &Retention{Period: "24h"}
+				bucket.Versioning = true
+				Expect(validate()).To(MatchError("bucket foooo: retention cannot be used with versioning"))
+			})
+			It("should detect a soft delete duration out of range", func() {
+				bucket.SoftDeleteRetention = "24h"
+				Expect(validate()).To(MatchError("bucket foooo: softDeleteRetention 24h must be 0s or a duration between 7 and 90 days"))
+			})
+		})
+		Context("access", func() {
+			var bucket StorageBucket
+
+			BeforeEach(func() {
+				bucket = StorageBucket{Location: "us-central1", ProjectId: "mock-project", Name: "foooo"}
+			})
+			config := func() *Config {
+				return &Config{StorageBuckets: map[string]StorageBucket{"foooo": bucket}}
+			}
+
+			It("should detect an invalid public access prevention", func() {
+				bucket.PublicAccessPrevention = "unspecified"
+				Expect(ValidateConfig(config())).To(MatchError("Config.StorageBuckets[foooo].PublicAccessPrevention validate failed on the oneof rule"))
+			})
+			It("should detect an invalid CORS method", func() {
+				bucket.Cors = []CorsRule{{Origins: []string{"https://example.com"}, Methods: []string{"FETCH"}}}
+				Expect(ValidateConfig(config())).To(MatchError("Config.StorageBuckets[foooo].Cors[0].Methods[0] validate failed on the oneof rule"))
+			})
+			It("should detect an invalid CORS max age", func() {
+				bucket.Cors = []CorsRule{{Origins: []string{"*"}, Methods: []string{"GET"}, MaxAge: "an hour"}}
+				Expect(ValidateConfig(config())).To(MatchError("bucket foooo: cors rule 0: invalid maxAge an hour"))
+			})
+			It("should detect a website with public access prevention enforced", func() {
+				bucket.Website = &Website{MainPageSuffix: "index.html"}
+				bucket.PublicAccessPrevention = PublicAccessPreventionEnforced
+				Expect(ValidateConfig(config())).To(MatchError("bucket foooo: a website cannot be served with publicAccessPrevention enforced"))
+			})
+			It("should detect a bucket that could be public when enforcing secure defaults", func() {
+				bucket.UniformAccess = true
+				Expect(ValidateSecureDefaults(config())).To(MatchError("bucket foooo: could be public, set uniformAccess and publicAccessPrevention to enforced, or allowPublic to keep it public"))
+			})
+			It("should accept a secure bucket or an explicit override when enforcing secure defaults", func() {
+				bucket.UniformAccess = true
+				bucket.PublicAccessPrevention = PublicAccessPreventionEnforced
+				Expect(ValidateSecureDefaults(config())).To(Succeed())
+				bucket = StorageBucket{Location: "us-central1", ProjectId: "mock-project", Name: "foooo", AllowPublic: true}
+				Expect(ValidateSecureDefaults(config())).To(Succeed())
+			})
+		})
+		It("should detect an invalid label key", func() {
+			config := &Config{
+				StorageBuckets: map[string]StorageBucket{
+					"foooo": {Location: "us-central1", ProjectId: "mock-project", Name: "foooo", Labels: map[string]string{"Cost-Center": "data"}},
+				},
+			}
+			err := ValidateConfig(config)
+			Expect(err).Should(MatchError("bucket foooo: label key Cost-Center must start with a lowercase letter and only contain lowercase letters, digits, _ and -, at most 63 characters"))
+		})
+		It("should detect an invalid label value", func() {
+			config := &Config{
+				StorageBuckets: map[string]StorageBucket{
+					"foooo": {Location: "us-central1", ProjectId: "mock-project", Name: "foooo", Labels: map[string]string{"team": "Data Eng"}},
+				},
+			}
+			err := ValidateConfig(config)
+			Expect(err).Should(MatchError("bucket foooo: label team: value Data Eng must only contain lowercase letters, digits, _ and -, at most 63 characters"))
+		})
+		It("should detect a label reserved for fougere-lite", func() {
+			config := &Config{
+				StorageBuckets: map[string]StorageBucket{
+					"foooo": {Location: "us-central1", ProjectId: "mock-project", Name: "foooo", Labels: map[string]string{common.ClientLabel: "other"}},
+				},
+			}
+			err := ValidateConfig(config)
+			Expect(err).Should(MatchError("bucket foooo: label fougere-lite-client is reserved for fougere-lite"))
+		})
+		It("should detect an undeclared crypto key", func() {
+			config := &Config{
+				StorageBuckets: map[string]StorageBucket{
+					"foooo": {
+						Location:  "us-central1",
+						ProjectId: "mock-project",
+						Name:      "foooo",
+						KmsKey:    "missing",
+					},
+				},
+			}
+			err := ValidateConfig(config)
+			Expect(err).Should(MatchError("bucket foooo: crypto key missing is not declared in kms"))
+		})
+		It("should detect a crypto key in another location", func() {
+			config := &Config{
+				StorageBuckets: map[string]StorageBucket{
+					"foooo": {
+						Location:   "us-central1",
+						ProjectId:  "mock-project",
+						Name:       "foooo",
+						KmsKey:     "keys",
+						KmsKeyName: "projects/mock-project/locations/us-east1/keyRings/ring/cryptoKeys/keys",
+					},
+				},
+			}
+			err := ValidateConfig(config)
+			Expect(err).Should(MatchError("bucket foooo: crypto key keys must be in the location of the bucket us-central1"))
 		})
 		It("should detect a missing project id", func() {
 			config := &Config{
@@ -94,4 +414,29 @@ var _ = Describe("config", func() {
 			Expect(err).Should(MatchError(ContainSubstring("validate failed on the required rule")))
 		})
 	})
+	Context("bucket names", func() {
+		It("builds the name from the client, the key and the project", func() {
+			Expect(BucketName("client1", "bucket1", "some-project")).To(Equal("client1-bucket1-some-project"))
+		})
+		It("maps a name back to its client and key", func() {
+			clientName, key, err := ParseBucketName("client1-bucket1-some-project", "some-project", nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clientName).To(Equal("client1"))
+			Expect(key).To(Equal("bucket1"))
+		})
+		It("uses the longest known client a name starts with", func() {
+			clientName, key, err := ParseBucketName("metrio-client-raw-exports-some-project", "some-project", []string{"metrio", "metrio-client"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clientName).To(Equal("metrio-client"))
+			Expect(key).To(Equal("raw-exports"))
+		})
+		It("returns an error for a name of another project", func() {
+			_, _, err := ParseBucketName("client1-bucket1-other-project", "some-project", nil)
+			Expect(err).To(MatchError(ContainSubstring("does not end with the project id")))
+		})
+		It("returns an error for a name without a key", func() {
+			_, _, err := ParseBucketName("client1-some-project", "some-project", []string{"client1"})
+			Expect(err).To(MatchError(ContainSubstring("convention")))
+		})
+	})
 })
@@ -0,0 +1,176 @@
+package cloudstorage
+
+import (
+	"context"
+	"sort"
+
+	"github.com/spf13/viper"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/gcp/kms"
+	"metrio.net/fougere-lite/internal/provider"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+func init() {
+	provider.Register(&storageProvider{})
+}
+
+// storageProvider plugs the storage buckets into the provider registry.
+type storageProvider struct {
+	client                *Client
+	enforceSecureDefaults bool
+}
+
+func (p *storageProvider) Key() string {
+	return Product
+}
+
+// DependsOn creates the crypto keys before the buckets encrypted with them.
+func (p *storageProvider) DependsOn() []string {
+	return []string{kms.Product}
+}
+
+// SetApplyOptions lets the client lock the retention policies when confirmed
+// and enables the validation of the secure defaults.
+func (p *storageProvider) SetApplyOptions(opts provider.ApplyOptions) {
+	p.client.confirmRetentionLock = opts.ConfirmRetentionLock
+	p.enforceSecureDefaults = opts.EnforceSecureDefaults
+}
+
+func (p *storageProvider) Init(ctx context.Context, opts ...option.ClientOption) error {
+	client, err := NewClient(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	p.client = client
+	return nil
+}
+
+func (p *storageProvider) GetConfig(viperConfig *viper.Viper, clientName string) (provider.Config, error) {
+	config, err := GetStorageConfig(viperConfig, clientName)
+	if err != nil || config == nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (p *storageProvider) ValidateConfig(config provider.Config) error {
+	if err := ValidateConfig(config.(*Config)); err != nil {
+		return err
+	}
+	if p.enforceSecureDefaults {
+		return ValidateSecureDefaults(config.(*Config))
+	}
+	return nil
+}
+
+func (p *storageProvider) Resources(config provider.Config) []common.ResourceChange {
+	var resources []common.ResourceChange
+	for key, bucket := range config.(*Config).StorageBuckets {
+		resources = append(resources, common.ResourceChange{
+			Client:  bucket.ClientName,
+			Product: Product,
+			Key:     key,
+			Name:    bucket.Name,
+			Project: bucket.ProjectId,
+		})
+	}
+	return resources
+}
+
+func (p *storageProvider) Create(config provider.Config) ([]common.AppliedResource, error) {
+	return p.client.Create(config.(*Config))
+}
+
+func (p *storageProvider) Plan(config provider.Config) ([]common.ResourceChange, error) {
+	return p.client.Plan(config.(*Config))
+}
+
+func (p *storageProvider) Verify(change common.ResourceChange) error {
+	return p.client.Verify(change)
+}
+
+func (p *storageProvider) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	return p.client.Apply(change)
+}
+
+func (p *storageProvider) Delete(config provider.Config, opts provider.DeleteOptions) error {
+	return p.client.Delete(config.(*Config), opts.Force)
+}
+
+// FindOrphans looks for the buckets labeled as managed by the clients in every
+// project of the configs.
+func (p *storageProvider) FindOrphans(clients []string, configs []provider.Config) ([]common.ResourceChange, error) {
+	projects := map[string]bool{}
+	declared := map[string]bool{}
+	for _, config := range configs {
+		for _, bucket := range config.(*Config).StorageBuckets {
+			projects[bucket.ProjectId] = true
+			declared[bucket.Name] = true
+		}
+	}
+	owners := map[string]bool{}
+	for _, client := range clients {
+		owners[client] = true
+	}
+	return p.client.FindOrphans(sortedKeys(projects), declared, owners)
+}
+
+func (p *storageProvider) Prune(orphans []common.ResourceChange) error {
+	return p.client.Prune(orphans)
+}
+
+// Import maps the buckets of the project back to their client and key. The
+// client label of the managed buckets takes precedence over clientNames.
+func (p *storageProvider) Import(projectId string, region string, clientNames []string, onlyClient string) ([]provider.Imported, error) {
+	if onlyClient != "" {
+		clientNames = []string{onlyClient}
+	}
+	buckets, err := p.client.List(projectId)
+	if err != nil {
+		return nil, err
+	}
+	var imported []provider.Imported
+	for _, bucket := range buckets {
+		candidates := clientNames
+		if label := bucket.Labels[common.ClientLabel]; label != "" && onlyClient == "" {
+			candidates = []string{label}
+		}
+		clientName, key, err := ParseBucketName(bucket.Name, projectId, candidates)
+		if err != nil {
+			utils.Logger.Warnf("[%s] bucket skipped: %s", bucket.Name, err)
+			continue
+		}
+		if onlyClient != "" && clientName != onlyClient {
+			continue
+		}
+		imported = append(imported, provider.Imported{
+			Client: clientName,
+			Key:    key,
+			Config: FromBucket(bucket, projectId, clientName),
+		})
+	}
+	return imported, nil
+}
+
+func (p *storageProvider) Export(config provider.Config) (map[string]interface{}, error) {
+	buckets, err := p.client.Export(config.(*Config))
+	if err != nil {
+		return nil, err
+	}
+	exported := map[string]interface{}{}
+	for key, bucket := range buckets {
+		exported[key] = bucket
+	}
+	return exported, nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
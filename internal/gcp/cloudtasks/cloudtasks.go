@@ -2,7 +2,12 @@ package cloudtasks
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"path"
+	"strings"
+	"time"
 
 	"google.golang.org/api/cloudtasks/v2"
 	"google.golang.org/api/googleapi"
@@ -11,6 +16,9 @@ import (
 	"metrio.net/fougere-lite/internal/utils"
 )
 
+// Product is the key of the task queues in a client's config.
+const Product = "cloudTasks"
+
 type Client struct {
 	cloudtasksService *cloudtasks.Service
 }
@@ -25,11 +33,13 @@ func NewClient(ctx context.Context, opts ...option.ClientOption) (*Client, error
 	}, nil
 }
 
-func (c *Client) Create(config *Config) error {
+// Create creates the queues of the config that do not exist and updates the
+// others. It returns the queues that were applied, even when some failed.
+func (c *Client) Create(config *Config) ([]common.AppliedResource, error) {
 	createChannel := make(chan common.Response, len(config.TaskQueues))
-	for _, queue := range config.TaskQueues {
-		go func(resp chan common.Response, queue TaskQueue) {
-			name := "projects/" + queue.ProjectId + "/locations/" + queue.Region + "/queues/" + queue.Name
+	for key, queue := range config.TaskQueues {
+		go func(resp chan common.Response, key string, queue TaskQueue) {
+			name := QueueName(queue)
 			_, err := c.get(name)
 			if err != nil {
 				if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
@@ -50,18 +60,211 @@ func (c *Client) Create(config *Config) error {
 					return
 				}
 			}
-			resp <- common.Response{}
-		}(createChannel, queue)
+			resp <- common.Response{Applied: &common.AppliedResource{
+				Client:  queue.ClientName,
+				Product: Product,
+				Key:     key,
+				Name:    name,
+				Spec:    c.createStorageSpec(queue),
+			}}
+		}(createChannel, key, queue)
 	}
+	var applied []common.AppliedResource
+	var createErr error
 	for range config.TaskQueues {
 		resp := <-createChannel
 		if resp.Err != nil {
-			return resp.Err
+			createErr = resp.Err
+			continue
+		}
+		applied = append(applied, *resp.Applied)
+	}
+	return applied, createErr
+}
+
+// Plan compares every queue of the config with its live state and returns the
+// change Create would make to each of them.
+func (c *Client) Plan(config *Config) ([]common.ResourceChange, error) {
+	planChannel := make(chan common.Response, len(config.TaskQueues))
+	for key, queue := range config.TaskQueues {
+		go func(resp chan common.Response, key string, queue TaskQueue) {
+			name := QueueName(queue)
+			spec := c.createStorageSpec(queue)
+			desired, err := json.Marshal(spec)
+			if err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			change := common.ResourceChange{
+				Client:  queue.ClientName,
+				Product: Product,
+				Key:     key,
+				Name:    name,
+				Project: queue.ProjectId,
+				Desired: desired,
+			}
+			live, err := c.get(name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+					change.Action = common.ActionCreate
+					resp <- common.Response{Change: change}
+					return
+				}
+				utils.Logger.Errorf("[%s] error getting queue: %s", name, err)
+				resp <- common.Response{Err: err}
+				return
+			}
+			if change.LiveHash, err = common.HashResource(live); err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			change.Diffs = diffQueue(live, spec)
+			change.Action = common.ActionNoop
+			if len(change.Diffs) > 0 {
+				change.Action = common.ActionUpdate
+			}
+			resp <- common.Response{Change: change}
+		}(planChannel, key, queue)
+	}
+	var changes []common.ResourceChange
+	var planErr error
+	for range config.TaskQueues {
+		resp := <-planChannel
+		if resp.Err != nil {
+			planErr = resp.Err
+			continue
 		}
+		changes = append(changes, resp.Change)
+	}
+	return changes, planErr
+}
+
+// Verify returns an error if the live queue is not in the state it was in
+// when the change was planned.
+func (c *Client) Verify(change common.ResourceChange) error {
+	liveHash := ""
+	live, err := c.get(change.Name)
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+			return err
+		}
+	} else {
+		if liveHash, err = common.HashResource(live); err != nil {
+			return err
+		}
+	}
+	if liveHash != change.LiveHash {
+		return fmt.Errorf("[%s] queue changed since the plan was made", change.Name)
 	}
 	return nil
 }
 
+// Apply runs a planned change with the spec saved in the plan. It returns the
+// applied queue, or nil when there was nothing to do.
+func (c *Client) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	if change.Action == common.ActionNoop {
+		return nil, nil
+	}
+	var spec cloudtasks.Queue
+	if err := json.Unmarshal(change.Desired, &spec); err != nil {
+		return nil, fmt.Errorf("[%s] invalid planned spec: %s", change.Name, err)
+	}
+	var err error
+	switch change.Action {
+	case common.ActionCreate:
+		err = c.insert(&spec)
+	case common.ActionUpdate:
+		err = c.patch(&spec)
+	default:
+		return nil, fmt.Errorf("[%s] unknown action %s", change.Name, change.Action)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &common.AppliedResource{
+		Client:  change.Client,
+		Product: Product,
+		Key:     change.Key,
+		Name:    change.Name,
+		Spec:    &spec,
+	}, nil
+}
+
+// Delete deletes every queue of the config. Queues that do not exist are
+// ignored.
+func (c *Client) Delete(config *Config) error {
+	deleteChannel := make(chan common.Response, len(config.TaskQueues))
+	for _, queue := range config.TaskQueues {
+		go func(resp chan common.Response, queue TaskQueue) {
+			resp <- common.Response{Err: c.delete(QueueName(queue))}
+		}(deleteChannel, queue)
+	}
+	var deleteErr error
+	for range config.TaskQueues {
+		resp := <-deleteChannel
+		if resp.Err != nil {
+			deleteErr = resp.Err
+		}
+	}
+	return deleteErr
+}
+
+// FindUndeclared lists the queues of the locations that are not in declared, a
+// set of queue names. Cloud Tasks queues cannot carry labels, so there is no
+// way to tell whether they were created by fougere-lite.
+func (c *Client) FindUndeclared(locations []string, declared map[string]bool) ([]string, error) {
+	var undeclared []string
+	for _, location := range locations {
+		queues, err := c.List(location)
+		if err != nil {
+			return nil, err
+		}
+		for _, queue := range queues {
+			if !declared[queue.Name] {
+				undeclared = append(undeclared, queue.Name)
+			}
+		}
+	}
+	return undeclared, nil
+}
+
+// Export returns the config of the live queues of the config, with the values
+// as deployed. Queues that do not exist are left out.
+func (c *Client) Export(config *Config) (map[string]TaskQueue, error) {
+	exported := map[string]TaskQueue{}
+	for key, queue := range config.TaskQueues {
+		name := QueueName(queue)
+		live, err := c.get(name)
+		if err != nil {
+			if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+				utils.Logger.Warnf("[%s] queue not found, not exported", name)
+				continue
+			}
+			utils.Logger.Errorf("[%s] error getting queue: %s", name, err)
+			return nil, err
+		}
+		if exported[key], err = FromQueue(live, queue.ClientName); err != nil {
+			return nil, err
+		}
+	}
+	return exported, nil
+}
+
+// List returns every queue of a location, projects/<project>/locations/<region>.
+func (c *Client) List(location string) ([]*cloudtasks.Queue, error) {
+	utils.Logger.Debugf("[%s] listing queues", location)
+	var queues []*cloudtasks.Queue
+	err := c.cloudtasksService.Projects.Locations.Queues.List(location).Pages(context.Background(), func(page *cloudtasks.ListQueuesResponse) error {
+		queues = append(queues, page.Queues...)
+		return nil
+	})
+	if err != nil {
+		utils.Logger.Errorf("[%s] error listing queues: %s", location, err)
+		return nil, err
+	}
+	return queues, nil
+}
+
 func (c *Client) get(name string) (*cloudtasks.Queue, error) {
 	utils.Logger.Debug("[%s] getting queue", name)
 	queue, err := c.cloudtasksService.Projects.Locations.Queues.Get(name).Do()
@@ -72,12 +275,15 @@ func (c *Client) get(name string) (*cloudtasks.Queue, error) {
 }
 
 func (c *Client) create(queue TaskQueue) error {
-	utils.Logger.Infof("[%s] creating queue", queue.Name)
-	spec := c.createStorageSpec(queue)
-	parent := "projects/" + queue.ProjectId + "/locations/" + queue.Region
+	return c.insert(c.createStorageSpec(queue))
+}
+
+func (c *Client) insert(spec *cloudtasks.Queue) error {
+	utils.Logger.Infof("[%s] creating queue", spec.Name)
+	parent := path.Dir(path.Dir(spec.Name))
 	_, err := c.cloudtasksService.Projects.Locations.Queues.Create(parent, spec).Do()
 	if err != nil {
-		utils.Logger.Errorf("[%s] error creating queue: %s", queue.Name, err)
+		utils.Logger.Errorf("[%s] error creating queue: %s", spec.Name, err)
 		return err
 	}
 
@@ -85,7 +291,10 @@ func (c *Client) create(queue TaskQueue) error {
 }
 
 func (c *Client) update(queue TaskQueue) error {
-	spec := c.createStorageSpec(queue)
+	return c.patch(c.createStorageSpec(queue))
+}
+
+func (c *Client) patch(spec *cloudtasks.Queue) error {
 	utils.Logger.Infof("[%s] updating queue", spec.Name)
 	_, err := c.cloudtasksService.Projects.Locations.Queues.Patch(spec.Name, spec).Do()
 	if err != nil {
@@ -95,9 +304,23 @@ func (c *Client) update(queue TaskQueue) error {
 	return nil
 }
 
+func (c *Client) delete(name string) error {
+	utils.Logger.Infof("[%s] deleting queue", name)
+	_, err := c.cloudtasksService.Projects.Locations.Queues.Delete(name).Do()
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			utils.Logger.Infof("[%s] queue already deleted", name)
+			return nil
+		}
+		utils.Logger.Errorf("[%s] error deleting queue: %s", name, err)
+		return err
+	}
+	return nil
+}
+
 func (c *Client) createStorageSpec(queue TaskQueue) *cloudtasks.Queue {
 	return &cloudtasks.Queue{
-		Name: "projects/" + queue.ProjectId + "/locations/" + queue.Region + "/queues/" + queue.Name,
+		Name: QueueName(queue),
 		RateLimits: &cloudtasks.RateLimits{
 			MaxDispatchesPerSecond:  queue.MaxDispatchesPerSecond,
 			MaxConcurrentDispatches: int64(queue.MaxConcurrentDispatches),
@@ -108,3 +331,75 @@ func (c *Client) createStorageSpec(queue TaskQueue) *cloudtasks.Queue {
 		},
 	}
 }
+
+// diffQueue lists the properties set in the desired spec that differ from the
+// live queue. Properties left empty in the config are filled by GCP defaults
+// and are not compared.
+func diffQueue(live *cloudtasks.Queue, desired *cloudtasks.Queue) []common.FieldDiff {
+	var diffs []common.FieldDiff
+	liveRateLimits := live.RateLimits
+	if liveRateLimits == nil {
+		liveRateLimits = &cloudtasks.RateLimits{}
+	}
+	liveRetryConfig := live.RetryConfig
+	if liveRetryConfig == nil {
+		liveRetryConfig = &cloudtasks.RetryConfig{}
+	}
+	if desired.RateLimits.MaxDispatchesPerSecond != 0 {
+		diffs = common.AppendDiff(diffs, "rateLimits.maxDispatchesPerSecond", liveRateLimits.MaxDispatchesPerSecond, desired.RateLimits.MaxDispatchesPerSecond)
+	}
+	if desired.RateLimits.MaxConcurrentDispatches != 0 {
+		diffs = common.AppendDiff(diffs, "rateLimits.maxConcurrentDispatches", liveRateLimits.MaxConcurrentDispatches, desired.RateLimits.MaxConcurrentDispatches)
+	}
+	if desired.RetryConfig.MinBackoff != "" && !sameDuration(liveRetryConfig.MinBackoff, desired.RetryConfig.MinBackoff) {
+		diffs = append(diffs, common.FieldDiff{Field: "retryConfig.minBackoff", Current: liveRetryConfig.MinBackoff, Desired: desired.RetryConfig.MinBackoff})
+	}
+	if desired.RetryConfig.MaxBackoff != "" && !sameDuration(liveRetryConfig.MaxBackoff, desired.RetryConfig.MaxBackoff) {
+		diffs = append(diffs, common.FieldDiff{Field: "retryConfig.maxBackoff", Current: liveRetryConfig.MaxBackoff, Desired: desired.RetryConfig.MaxBackoff})
+	}
+	return diffs
+}
+
+// sameDuration compares two durations, GCP returns them normalized (e.g. "0.100s")
+// so they are parsed before being compared.
+func sameDuration(a, b string) bool {
+	durationA, errA := time.ParseDuration(a)
+	durationB, errB := time.ParseDuration(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return durationA == durationB
+}
+
+// LocationName returns the resource name of the location of a queue.
+func LocationName(queue TaskQueue) string {
+	return common.LocationName(queue.ProjectId, queue.Region)
+}
+
+// QueueName returns the full resource name of a queue.
+func QueueName(queue TaskQueue) string {
+	return LocationName(queue) + "/queues/" + queue.Name
+}
+
+// FromQueue returns the config of a live queue.
+func FromQueue(queue *cloudtasks.Queue, clientName string) (TaskQueue, error) {
+	parts := strings.Split(queue.Name, "/")
+	if len(parts) != 6 || parts[0] != "projects" || parts[2] != "locations" || parts[4] != "queues" {
+		return TaskQueue{}, fmt.Errorf("invalid queue name %s", queue.Name)
+	}
+	taskQueue := TaskQueue{
+		Name:       parts[5],
+		Region:     parts[3],
+		ProjectId:  parts[1],
+		ClientName: clientName,
+	}
+	if queue.RateLimits != nil {
+		taskQueue.MaxDispatchesPerSecond = queue.RateLimits.MaxDispatchesPerSecond
+		taskQueue.MaxConcurrentDispatches = queue.RateLimits.MaxConcurrentDispatches
+	}
+	if queue.RetryConfig != nil {
+		taskQueue.MinBackoff = queue.RetryConfig.MinBackoff
+		taskQueue.MaxBackoff = queue.RetryConfig.MaxBackoff
+	}
+	return taskQueue, nil
+}
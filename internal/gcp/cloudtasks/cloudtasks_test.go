@@ -7,7 +7,9 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"google.golang.org/api/cloudtasks/v2"
 	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
 	"metrio.net/fougere-lite/internal/utils"
 )
 
@@ -84,4 +86,228 @@ var _ = Describe("Storage client", func() {
 			Expect(err).ToNot(HaveOccurred())
 		})
 	})
+	Describe("plan queue", func() {
+		var config *Config
+
+		BeforeEach(func() {
+			taskConfig.MinBackoff = "1s"
+			taskConfig.MaxDispatchesPerSecond = 500
+			config = &Config{TaskQueues: map[string]TaskQueue{"queue1": taskConfig}}
+		})
+
+		It("plans a create when the queue does not exist", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v2/"+queueName)
+				},
+				ResponseCode: 404,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(HaveLen(1))
+			Expect(changes[0].Action).To(Equal(common.ActionCreate))
+			Expect(changes[0].Name).To(Equal(queueName))
+		})
+		It("plans an update with the fields that differ", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: cloudtasks.Queue{
+					Name:        queueName,
+					RateLimits:  &cloudtasks.RateLimits{MaxDispatchesPerSecond: 100},
+					RetryConfig: &cloudtasks.RetryConfig{MinBackoff: "1.000s"},
+				},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Action).To(Equal(common.ActionUpdate))
+			Expect(changes[0].Diffs).To(ConsistOf(common.FieldDiff{Field: "rateLimits.maxDispatchesPerSecond", Current: 100.0, Desired: 500.0}))
+		})
+		It("plans a no-op when the queue is up to date", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: cloudtasks.Queue{
+					Name:        queueName,
+					RateLimits:  &cloudtasks.RateLimits{MaxDispatchesPerSecond: 500, MaxConcurrentDispatches: 1000},
+					RetryConfig: &cloudtasks.RetryConfig{MinBackoff: "1s", MaxBackoff: "3600s"},
+				},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Action).To(Equal(common.ActionNoop))
+		})
+	})
+	Describe("apply planned change", func() {
+		It("patches the queue with the planned spec", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 2)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: cloudtasks.Queue{Name: queueName},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v2/"+queueName)
+				},
+				Method: "patch",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			liveHash, err := common.HashResource(&cloudtasks.Queue{Name: queueName})
+			Expect(err).ToNot(HaveOccurred())
+			change := common.ResourceChange{
+				Product:  Product,
+				Name:     queueName,
+				Action:   common.ActionUpdate,
+				Desired:  []byte(`{"name":"` + queueName + `"}`),
+				LiveHash: liveHash,
+			}
+			Expect(client.Verify(change)).To(Succeed())
+			_, err = client.Apply(change)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("creates the queue in the parent location", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v2/"+parent+"/queues?")
+				},
+				Method: "post",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			change := common.ResourceChange{
+				Product: Product,
+				Name:    queueName,
+				Action:  common.ActionCreate,
+				Desired: []byte(`{"name":"` + queueName + `"}`),
+			}
+			applied, err := client.Apply(change)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied.Name).To(Equal(queueName))
+		})
+	})
+	Describe("delete queue", func() {
+		It("successfully deletes the queue", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v2/"+queueName+"?")
+				},
+				Method: "delete",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			err := client.Delete(&Config{TaskQueues: map[string]TaskQueue{"queue1": taskConfig}})
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("ignores a queue that does not exist", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				Method:       "delete",
+				ResponseCode: 404,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			err := client.delete(queueName)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Describe("find undeclared queues", func() {
+		It("returns the queues of the location that are not declared", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v2/"+parent+"/queues?")
+				},
+				ResponseBody: cloudtasks.ListQueuesResponse{Queues: []*cloudtasks.Queue{
+					{Name: queueName},
+					{Name: parent + "/queues/old-queue"},
+				}},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			undeclared, err := client.FindUndeclared([]string{parent}, map[string]bool{queueName: true})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(undeclared).To(ConsistOf(parent + "/queues/old-queue"))
+		})
+	})
+	Describe("queue from live queue", func() {
+		It("returns the config of the queue", func() {
+			queue, err := FromQueue(&cloudtasks.Queue{
+				Name:        queueName,
+				RateLimits:  &cloudtasks.RateLimits{MaxDispatchesPerSecond: 500, MaxConcurrentDispatches: 1000},
+				RetryConfig: &cloudtasks.RetryConfig{MinBackoff: "0.100s", MaxBackoff: "3600s"},
+			}, "banane")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(queue).To(Equal(TaskQueue{
+				Name:                    "queue1",
+				Region:                  "northamerica-northeast1",
+				ProjectId:               "projet-123",
+				MinBackoff:              "0.100s",
+				MaxBackoff:              "3600s",
+				MaxConcurrentDispatches: 1000,
+				MaxDispatchesPerSecond:  500,
+				ClientName:              "banane",
+			}))
+		})
+		It("returns an error for an invalid name", func() {
+			_, err := FromQueue(&cloudtasks.Queue{Name: "queue1"}, "banane")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Describe("export queues", func() {
+		It("returns the config of the live queues", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v2/"+queueName+"?")
+				},
+				ResponseBody: cloudtasks.Queue{
+					Name:        queueName,
+					RateLimits:  &cloudtasks.RateLimits{MaxDispatchesPerSecond: 20, MaxConcurrentDispatches: 5},
+					RetryConfig: &cloudtasks.RetryConfig{MinBackoff: "2s", MaxBackoff: "60s"},
+				},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			exported, err := client.Export(&Config{TaskQueues: map[string]TaskQueue{"queue1": taskConfig}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exported["queue1"].MaxDispatchesPerSecond).To(Equal(20.0))
+			Expect(exported["queue1"].MaxConcurrentDispatches).To(Equal(int64(5)))
+			Expect(exported["queue1"].MinBackoff).To(Equal("2s"))
+			Expect(exported["queue1"].MaxBackoff).To(Equal("60s"))
+		})
+	})
 })
@@ -8,18 +8,18 @@ import (
 )
 
 type Config struct {
-	TaskQueues map[string]TaskQueue `mapstructure:"cloudTasks" validate:"dive"`
+	TaskQueues map[string]TaskQueue `mapstructure:"cloudTasks" yaml:"cloudTasks" validate:"dive"`
 }
 
 type TaskQueue struct {
-	Name                    string  `json:"name" validate:"required"`
-	Region                  string  `json:"region" validate:"required"`
-	ProjectId               string  `json:"projectId" validate:"required"`
-	MinBackoff              string  `json:"minBackoff"`
-	MaxBackoff              string  `json:"maxBackoff"`
-	MaxConcurrentDispatches int64  	`json:"maxConcurrentDispatches"`
-	MaxDispatchesPerSecond  float64 `json:"maxDispatchesPerSecond"`
-	ClientName              string
+	Name                    string  `json:"name" yaml:"-" validate:"required"`
+	Region                  string  `json:"region" yaml:"region" validate:"required"`
+	ProjectId               string  `json:"projectId" yaml:"projectId" validate:"required"`
+	MinBackoff              string  `json:"minBackoff" yaml:"minBackoff,omitempty"`
+	MaxBackoff              string  `json:"maxBackoff" yaml:"maxBackoff,omitempty"`
+	MaxConcurrentDispatches int64   `json:"maxConcurrentDispatches" yaml:"maxConcurrentDispatches,omitempty"`
+	MaxDispatchesPerSecond  float64 `json:"maxDispatchesPerSecond" yaml:"maxDispatchesPerSecond,omitempty"`
+	ClientName              string  `yaml:"-"`
 }
 
 func GetTaskConfig(viperConfig *viper.Viper, clientName string) (*Config, error) {
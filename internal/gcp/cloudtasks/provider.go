@@ -0,0 +1,158 @@
+package cloudtasks
+
+import (
+	"context"
+	"path"
+	"sort"
+
+	"github.com/spf13/viper"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/provider"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+func init() {
+	provider.Register(&tasksProvider{})
+}
+
+// tasksProvider plugs the Cloud Tasks queues into the provider registry.
+type tasksProvider struct {
+	client *Client
+}
+
+func (p *tasksProvider) Key() string {
+	return Product
+}
+
+func (p *tasksProvider) Init(ctx context.Context, opts ...option.ClientOption) error {
+	client, err := NewClient(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	p.client = client
+	return nil
+}
+
+func (p *tasksProvider) GetConfig(viperConfig *viper.Viper, clientName string) (provider.Config, error) {
+	config, err := GetTaskConfig(viperConfig, clientName)
+	if err != nil || config == nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (p *tasksProvider) ValidateConfig(config provider.Config) error {
+	return ValidateConfig(config.(*Config))
+}
+
+func (p *tasksProvider) Resources(config provider.Config) []common.ResourceChange {
+	var resources []common.ResourceChange
+	for key, queue := range config.(*Config).TaskQueues {
+		resources = append(resources, common.ResourceChange{
+			Client:  queue.ClientName,
+			Product: Product,
+			Key:     key,
+			Name:    QueueName(queue),
+			Project: queue.ProjectId,
+		})
+	}
+	return resources
+}
+
+func (p *tasksProvider) Create(config provider.Config) ([]common.AppliedResource, error) {
+	return p.client.Create(config.(*Config))
+}
+
+func (p *tasksProvider) Plan(config provider.Config) ([]common.ResourceChange, error) {
+	return p.client.Plan(config.(*Config))
+}
+
+func (p *tasksProvider) Verify(change common.ResourceChange) error {
+	return p.client.Verify(change)
+}
+
+func (p *tasksProvider) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	return p.client.Apply(change)
+}
+
+func (p *tasksProvider) Delete(config provider.Config, opts provider.DeleteOptions) error {
+	return p.client.Delete(config.(*Config))
+}
+
+// FindOrphans reports the undeclared queues of every location of the configs.
+// Cloud Tasks queues cannot carry an ownership label, so they are never pruned.
+func (p *tasksProvider) FindOrphans(clients []string, configs []provider.Config) ([]common.ResourceChange, error) {
+	locations := map[string]bool{}
+	declared := map[string]bool{}
+	for _, config := range configs {
+		for _, queue := range config.(*Config).TaskQueues {
+			locations[LocationName(queue)] = true
+			declared[QueueName(queue)] = true
+		}
+	}
+	sortedLocations := make([]string, 0, len(locations))
+	for location := range locations {
+		sortedLocations = append(sortedLocations, location)
+	}
+	sort.Strings(sortedLocations)
+
+	undeclared, err := p.client.FindUndeclared(sortedLocations, declared)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []common.ResourceChange
+	for _, name := range undeclared {
+		orphans = append(orphans, common.ResourceChange{
+			Product: Product,
+			Key:     path.Base(name),
+			Name:    name,
+			Action:  common.ActionUnmanaged,
+		})
+	}
+	return orphans, nil
+}
+
+func (p *tasksProvider) Prune(orphans []common.ResourceChange) error {
+	return nil
+}
+
+// Import lists the queues of the region. Queue names do not carry their
+// client, so they are only imported for onlyClient.
+func (p *tasksProvider) Import(projectId string, region string, clientNames []string, onlyClient string) ([]provider.Imported, error) {
+	location := LocationName(TaskQueue{ProjectId: projectId, Region: region})
+	queues, err := p.client.List(location)
+	if err != nil {
+		return nil, err
+	}
+	if onlyClient == "" && len(queues) > 0 {
+		utils.Logger.Warnf("[%s] %d queues skipped, queue names do not carry their client, use --client to import them", location, len(queues))
+		return nil, nil
+	}
+	var imported []provider.Imported
+	for _, queue := range queues {
+		taskQueue, err := FromQueue(queue, onlyClient)
+		if err != nil {
+			utils.Logger.Warnf("[%s] queue skipped: %s", queue.Name, err)
+			continue
+		}
+		imported = append(imported, provider.Imported{
+			Client: onlyClient,
+			Key:    taskQueue.Name,
+			Config: taskQueue,
+		})
+	}
+	return imported, nil
+}
+
+func (p *tasksProvider) Export(config provider.Config) (map[string]interface{}, error) {
+	queues, err := p.client.Export(config.(*Config))
+	if err != nil {
+		return nil, err
+	}
+	exported := map[string]interface{}{}
+	for key, queue := range queues {
+		exported[key] = queue
+	}
+	return exported, nil
+}
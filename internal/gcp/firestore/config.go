@@ -0,0 +1,123 @@
+// ©Copyright 2022 Metrio
+package firestore
+
+import (
+	"fmt"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	Firestore Firestore `mapstructure:"firestore" yaml:"firestore"`
+}
+
+// Firestore is the composite indexes and TTL policies a client needs in the
+// Firestore databases of its projects.
+type Firestore struct {
+	Indexes     map[string]Index     `json:"indexes" yaml:"indexes,omitempty" validate:"dive"`
+	TtlPolicies map[string]TtlPolicy `json:"ttlPolicies" yaml:"ttlPolicies,omitempty" validate:"dive"`
+}
+
+// Index is a composite index of a collection group. Indexes cannot be changed,
+// a changed definition is a new index.
+type Index struct {
+	ProjectId string `json:"projectId" yaml:"projectId" validate:"required"`
+	// Database defaults to (default).
+	Database        string `json:"database" yaml:"database,omitempty"`
+	CollectionGroup string `json:"collectionGroup" yaml:"collectionGroup" validate:"required"`
+	// QueryScope defaults to COLLECTION.
+	QueryScope string       `json:"queryScope" yaml:"queryScope,omitempty" validate:"omitempty,oneof=COLLECTION COLLECTION_GROUP"`
+	Fields     []IndexField `json:"fields" yaml:"fields" validate:"required,min=2,dive"`
+	ClientName string       `yaml:"-"`
+}
+
+// IndexField is a field of an index, with exactly one of order and
+// arrayConfig.
+type IndexField struct {
+	FieldPath   string `json:"fieldPath" yaml:"fieldPath" validate:"required"`
+	Order       string `json:"order" yaml:"order,omitempty" validate:"omitempty,oneof=ASCENDING DESCENDING"`
+	ArrayConfig string `json:"arrayConfig" yaml:"arrayConfig,omitempty" validate:"omitempty,oneof=CONTAINS"`
+}
+
+// TtlPolicy deletes the documents of a collection group once the time in
+// their field is past.
+type TtlPolicy struct {
+	ProjectId string `json:"projectId" yaml:"projectId" validate:"required"`
+	// Database defaults to (default).
+	Database        string `json:"database" yaml:"database,omitempty"`
+	CollectionGroup string `json:"collectionGroup" yaml:"collectionGroup" validate:"required"`
+	Field           string `json:"field" yaml:"field" validate:"required"`
+	ClientName      string `yaml:"-"`
+}
+
+const (
+	defaultDatabase   = "(default)"
+	defaultQueryScope = "COLLECTION"
+)
+
+func GetFirestoreConfig(viperConfig *viper.Viper, clientName string) (*Config, error) {
+	if viperConfig == nil {
+		return nil, nil
+	}
+
+	var firestoreConfig Config
+	err := viperConfig.Unmarshal(&firestoreConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, index := range firestoreConfig.Firestore.Indexes {
+		if index.Database == "" {
+			index.Database = defaultDatabase
+		}
+		if index.QueryScope == "" {
+			index.QueryScope = defaultQueryScope
+		}
+		index.ClientName = clientName
+
+		firestoreConfig.Firestore.Indexes[name] = index
+	}
+	for name, ttlPolicy := range firestoreConfig.Firestore.TtlPolicies {
+		if ttlPolicy.Database == "" {
+			ttlPolicy.Database = defaultDatabase
+		}
+		ttlPolicy.ClientName = clientName
+
+		firestoreConfig.Firestore.TtlPolicies[name] = ttlPolicy
+	}
+	return &firestoreConfig, nil
+}
+
+func ValidateConfig(config *Config) error {
+	v := validator.New()
+	if err := v.Struct(config); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			return fmt.Errorf("%s validate failed on the %s rule", err.Namespace(), err.Tag())
+		}
+	}
+	for key, index := range config.Firestore.Indexes {
+		for _, field := range index.Fields {
+			if (field.Order == "") == (field.ArrayConfig == "") {
+				return fmt.Errorf("index %s: field %s must have exactly one of order and arrayConfig", key, field.FieldPath)
+			}
+		}
+	}
+	return nil
+}
+
+// DatabaseName returns the full resource name of a database.
+func DatabaseName(projectId string, database string) string {
+	return "projects/" + projectId + "/databases/" + database
+}
+
+// CollectionGroupName returns the full resource name of the collection group
+// of an index.
+func CollectionGroupName(index Index) string {
+	return DatabaseName(index.ProjectId, index.Database) + "/collectionGroups/" + index.CollectionGroup
+}
+
+// FieldName returns the full resource name of the field of a TTL policy.
+func FieldName(ttlPolicy TtlPolicy) string {
+	return DatabaseName(ttlPolicy.ProjectId, ttlPolicy.Database) + "/collectionGroups/" + ttlPolicy.CollectionGroup + "/fields/" + ttlPolicy.Field
+}
@@ -0,0 +1,116 @@
+package firestore
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+var validFirestoreConfig = []byte(`
+firestore:
+  indexes:
+    orders-by-customer:
+      projectId: some-project
+      collectionGroup: orders
+      fields:
+        - fieldPath: customerId
+          order: ASCENDING
+        - fieldPath: createdAt
+          order: DESCENDING
+    tagged-orders:
+      projectId: some-project
+      database: orders-db
+      collectionGroup: orders
+      queryScope: COLLECTION_GROUP
+      fields:
+        - fieldPath: tags
+          arrayConfig: CONTAINS
+        - fieldPath: createdAt
+          order: ASCENDING
+  ttlPolicies:
+    sessions:
+      projectId: some-project
+      collectionGroup: sessions
+      field: expireAt`)
+
+var invalidConfig = []byte(`
+firestore:
+  indexes:
+    some-index:
+      fields: should_not_be_a_string`)
+
+var _ = Describe("config", func() {
+	BeforeEach(func() {
+		viper.Reset()
+		viper.SetConfigType("yaml")
+	})
+	Describe("GetFirestoreConfig", func() {
+		It("should successfully parse a Firestore config", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(validFirestoreConfig))
+			Expect(err).ToNot(HaveOccurred())
+			firestoreConfig, err := GetFirestoreConfig(viper.GetViper(), "some-client")
+			Expect(err).To(BeNil())
+			index := firestoreConfig.Firestore.Indexes["orders-by-customer"]
+			Expect(index.Database).To(Equal("(default)"))
+			Expect(index.QueryScope).To(Equal("COLLECTION"))
+			Expect(index.Fields).To(Equal([]IndexField{
+				{FieldPath: "customerId", Order: "ASCENDING"},
+				{FieldPath: "createdAt", Order: "DESCENDING"},
+			}))
+			Expect(CollectionGroupName(index)).To(Equal("projects/some-project/databases/(default)/collectionGroups/orders"))
+			tagged := firestoreConfig.Firestore.Indexes["tagged-orders"]
+			Expect(CollectionGroupName(tagged)).To(Equal("projects/some-project/databases/orders-db/collectionGroups/orders"))
+			ttlPolicy := firestoreConfig.Firestore.TtlPolicies["sessions"]
+			Expect(FieldName(ttlPolicy)).To(Equal("projects/some-project/databases/(default)/collectionGroups/sessions/fields/expireAt"))
+			Expect(ValidateConfig(firestoreConfig)).To(Succeed())
+		})
+		It("returns an error if cannot parse the config", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(invalidConfig))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = GetFirestoreConfig(viper.GetViper(), "some-client")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("validates indexes", func() {
+		var index Index
+
+		BeforeEach(func() {
+			index = Index{
+				ProjectId:       "mock-project",
+				Database:        "(default)",
+				CollectionGroup: "orders",
+				QueryScope:      "COLLECTION",
+				Fields: []IndexField{
+					{FieldPath: "customerId", Order: "ASCENDING"},
+					{FieldPath: "createdAt", Order: "DESCENDING"},
+				},
+			}
+		})
+
+		It("should not detect error", func() {
+			Expect(ValidateConfig(&Config{Firestore: Firestore{Indexes: map[string]Index{"foooo": index}}})).To(Succeed())
+		})
+		It("should detect an index with a single field", func() {
+			index.Fields = index.Fields[:1]
+			err := ValidateConfig(&Config{Firestore: Firestore{Indexes: map[string]Index{"foooo": index}}})
+			Expect(err).To(MatchError("Config.Firestore.Indexes[foooo].Fields validate failed on the min rule"))
+		})
+		It("should detect a field with both an order and an array config", func() {
+			index.Fields[0].ArrayConfig = "CONTAINS"
+			err := ValidateConfig(&Config{Firestore: Firestore{Indexes: map[string]Index{"foooo": index}}})
+			Expect(err).To(MatchError("index foooo: field customerId must have exactly one of order and arrayConfig"))
+		})
+		It("should detect an invalid order", func() {
+			index.Fields[0].Order = "UP"
+			err := ValidateConfig(&Config{Firestore: Firestore{Indexes: map[string]Index{"foooo": index}}})
+			Expect(err).To(MatchError("Config.Firestore.Indexes[foooo].Fields[0].Order validate failed on the oneof rule"))
+		})
+		It("should detect a TTL policy without field", func() {
+			ttlPolicy := TtlPolicy{ProjectId: "mock-project", CollectionGroup: "sessions"}
+			err := ValidateConfig(&Config{Firestore: Firestore{TtlPolicies: map[string]TtlPolicy{"foooo": ttlPolicy}}})
+			Expect(err).To(MatchError("Config.Firestore.TtlPolicies[foooo].Field validate failed on the required rule"))
+		})
+	})
+})
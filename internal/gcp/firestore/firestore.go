@@ -0,0 +1,494 @@
+package firestore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/api/firestore/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Product is the key of the Firestore config in a client's config.
+const Product = "firestore"
+
+// Prefixes of the keys of the indexes and TTL policies in the plan and state,
+// e.g. indexes.orders-by-customer.
+const (
+	indexesKey     = "indexes"
+	ttlPoliciesKey = "ttlPolicies"
+)
+
+// nameField is the field Firestore appends to every composite index.
+const nameField = "__name__"
+
+// operationPollInterval and operationTimeout bound the wait for an index build
+// or a TTL policy change, which are long-running operations.
+var (
+	operationPollInterval = 5 * time.Second
+	operationTimeout      = time.Hour
+)
+
+type Client struct {
+	firestoreService *firestore.Service
+}
+
+func NewClient(ctx context.Context, opts ...option.ClientOption) (*Client, error) {
+	firestoreService, err := firestore.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		firestoreService: firestoreService,
+	}, nil
+}
+
+// Create creates the indexes and TTL policies of the config that do not exist
+// and waits for them to be built. Existing indexes are never changed. It
+// returns the resources that were applied, even when some failed.
+func (c *Client) Create(config *Config) ([]common.AppliedResource, error) {
+	total := len(config.Firestore.Indexes) + len(config.Firestore.TtlPolicies)
+	createChannel := make(chan common.Response, total)
+	for key, index := range config.Firestore.Indexes {
+		go func(resp chan common.Response, key string, index Index) {
+			parent := CollectionGroupName(index)
+			spec := createIndexSpec(index)
+			name := parent
+			live, err := c.findIndex(parent, spec)
+			if err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			if live == nil {
+				err = c.insertIndex(parent, spec)
+			} else {
+				name = live.Name
+			}
+			if err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			resp <- common.Response{Applied: &common.AppliedResource{
+				Client:  index.ClientName,
+				Product: Product,
+				Key:     indexesKey + "." + key,
+				Name:    name,
+				Spec:    spec,
+			}}
+		}(createChannel, key, index)
+	}
+	for key, ttlPolicy := range config.Firestore.TtlPolicies {
+		go func(resp chan common.Response, key string, ttlPolicy TtlPolicy) {
+			name := FieldName(ttlPolicy)
+			live, err := c.getTtl(name)
+			if err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			if live == nil {
+				if err := c.enableTtl(name); err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+			}
+			resp <- common.Response{Applied: &common.AppliedResource{
+				Client:  ttlPolicy.ClientName,
+				Product: Product,
+				Key:     ttlPoliciesKey + "." + key,
+				Name:    name,
+				Spec:    createTtlSpec(ttlPolicy),
+			}}
+		}(createChannel, key, ttlPolicy)
+	}
+	var applied []common.AppliedResource
+	var createErr error
+	for i := 0; i < total; i++ {
+		resp := <-createChannel
+		if resp.Err != nil {
+			createErr = resp.Err
+			continue
+		}
+		applied = append(applied, *resp.Applied)
+	}
+	return applied, createErr
+}
+
+// Plan returns a create for every index and TTL policy of the config that does
+// not exist, and a no-op for the others. Indexes and TTL policies are never
+// updated, so the live hash is the one of their name.
+func (c *Client) Plan(config *Config) ([]common.ResourceChange, error) {
+	total := len(config.Firestore.Indexes) + len(config.Firestore.TtlPolicies)
+	planChannel := make(chan common.Response, total)
+	for key, index := range config.Firestore.Indexes {
+		go func(resp chan common.Response, key string, index Index) {
+			parent := CollectionGroupName(index)
+			spec := createIndexSpec(index)
+			change := common.ResourceChange{
+				Client:  index.ClientName,
+				Product: Product,
+				Key:     indexesKey + "." + key,
+				Name:    parent,
+				Project: index.ProjectId,
+			}
+			live, err := c.findIndex(parent, spec)
+			if err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			if live != nil {
+				change.Name = live.Name
+				resp <- common.PlanChange(change, spec, live.Name, nil)
+				return
+			}
+			resp <- common.PlanChange(change, spec, nil, nil)
+		}(planChannel, key, index)
+	}
+	for key, ttlPolicy := range config.Firestore.TtlPolicies {
+		go func(resp chan common.Response, key string, ttlPolicy TtlPolicy) {
+			change := common.ResourceChange{
+				Client:  ttlPolicy.ClientName,
+				Product: Product,
+				Key:     ttlPoliciesKey + "." + key,
+				Name:    FieldName(ttlPolicy),
+				Project: ttlPolicy.ProjectId,
+			}
+			live, err := c.getTtl(change.Name)
+			if err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			if live != nil {
+				resp <- common.PlanChange(change, createTtlSpec(ttlPolicy), change.Name, nil)
+				return
+			}
+			resp <- common.PlanChange(change, createTtlSpec(ttlPolicy), nil, nil)
+		}(planChannel, key, ttlPolicy)
+	}
+	var changes []common.ResourceChange
+	var planErr error
+	for i := 0; i < total; i++ {
+		resp := <-planChannel
+		if resp.Err != nil {
+			planErr = resp.Err
+			continue
+		}
+		changes = append(changes, resp.Change)
+	}
+	return changes, planErr
+}
+
+// Verify returns an error if an index or TTL policy was created or deleted
+// since the change was planned.
+func (c *Client) Verify(change common.ResourceChange) error {
+	var live interface{}
+	if isIndex(change) {
+		spec := &firestore.GoogleFirestoreAdminV1Index{}
+		if err := json.Unmarshal(change.Desired, spec); err != nil {
+			return fmt.Errorf("[%s] invalid planned spec: %s", change.Name, err)
+		}
+		index, err := c.findIndex(indexParent(change.Name), spec)
+		if err != nil {
+			return err
+		}
+		if index != nil {
+			live = index.Name
+		}
+	} else {
+		ttlConfig, err := c.getTtl(change.Name)
+		if err != nil {
+			return err
+		}
+		if ttlConfig != nil {
+			live = change.Name
+		}
+	}
+	liveHash := ""
+	if live != nil {
+		var err error
+		if liveHash, err = common.HashResource(live); err != nil {
+			return err
+		}
+	}
+	if liveHash != change.LiveHash {
+		return fmt.Errorf("[%s] %s changed since the plan was made", change.Name, kind(change))
+	}
+	return nil
+}
+
+// Apply runs a planned change with the spec saved in the plan and waits for the
+// operation to be done. It returns the applied resource, or nil when there was
+// nothing to do.
+func (c *Client) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	if change.Action == common.ActionNoop {
+		return nil, nil
+	}
+	if change.Action != common.ActionCreate {
+		return nil, fmt.Errorf("[%s] unknown action %s", change.Name, change.Action)
+	}
+	var spec interface{}
+	if isIndex(change) {
+		index := &firestore.GoogleFirestoreAdminV1Index{}
+		if err := json.Unmarshal(change.Desired, index); err != nil {
+			return nil, fmt.Errorf("[%s] invalid planned spec: %s", change.Name, err)
+		}
+		if err := c.insertIndex(indexParent(change.Name), index); err != nil {
+			return nil, err
+		}
+		spec = index
+	} else {
+		field := &firestore.GoogleFirestoreAdminV1Field{}
+		if err := json.Unmarshal(change.Desired, field); err != nil {
+			return nil, fmt.Errorf("[%s] invalid planned spec: %s", change.Name, err)
+		}
+		if err := c.enableTtl(change.Name); err != nil {
+			return nil, err
+		}
+		spec = field
+	}
+	return &common.AppliedResource{
+		Client:  change.Client,
+		Product: Product,
+		Key:     change.Key,
+		Name:    change.Name,
+		Spec:    spec,
+	}, nil
+}
+
+// Delete deletes the indexes and disables the TTL policies of the config.
+// Resources that do not exist are ignored.
+func (c *Client) Delete(config *Config) error {
+	total := len(config.Firestore.Indexes) + len(config.Firestore.TtlPolicies)
+	deleteChannel := make(chan common.Response, total)
+	for _, index := range config.Firestore.Indexes {
+		go func(resp chan common.Response, index Index) {
+			live, err := c.findIndex(CollectionGroupName(index), createIndexSpec(index))
+			if err != nil || live == nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			resp <- common.Response{Err: c.deleteIndex(live.Name)}
+		}(deleteChannel, index)
+	}
+	for _, ttlPolicy := range config.Firestore.TtlPolicies {
+		go func(resp chan common.Response, ttlPolicy TtlPolicy) {
+			resp <- common.Response{Err: c.disableTtl(FieldName(ttlPolicy))}
+		}(deleteChannel, ttlPolicy)
+	}
+	var deleteErr error
+	for i := 0; i < total; i++ {
+		resp := <-deleteChannel
+		if resp.Err != nil {
+			deleteErr = resp.Err
+		}
+	}
+	return deleteErr
+}
+
+// FindUndeclared lists the composite indexes of the databases that match none
+// of the declared indexes. Indexes cannot carry labels, so there is no way to
+// tell whether they were created by fougere-lite.
+func (c *Client) FindUndeclared(databases []string, declared []Index) ([]string, error) {
+	var undeclared []string
+	for _, database := range databases {
+		indexes, err := c.listIndexes(database + "/collectionGroups/-")
+		if err != nil {
+			return nil, err
+		}
+		for _, live := range indexes {
+			found := false
+			for _, index := range declared {
+				if indexParent(live.Name) == CollectionGroupName(index) && sameIndex(live, createIndexSpec(index)) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				undeclared = append(undeclared, live.Name)
+			}
+		}
+	}
+	return undeclared, nil
+}
+
+// findIndex returns the index of the collection group with the definition of
+// spec, or nil if there is none.
+func (c *Client) findIndex(parent string, spec *firestore.GoogleFirestoreAdminV1Index) (*firestore.GoogleFirestoreAdminV1Index, error) {
+	indexes, err := c.listIndexes(parent)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		if sameIndex(index, spec) {
+			return index, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *Client) listIndexes(parent string) ([]*firestore.GoogleFirestoreAdminV1Index, error) {
+	utils.Logger.Debugf("[%s] listing indexes", parent)
+	var indexes []*firestore.GoogleFirestoreAdminV1Index
+	err := c.firestoreService.Projects.Databases.CollectionGroups.Indexes.List(parent).Pages(context.Background(), func(page *firestore.GoogleFirestoreAdminV1ListIndexesResponse) error {
+		indexes = append(indexes, page.Indexes...)
+		return nil
+	})
+	if err != nil {
+		utils.Logger.Errorf("[%s] error listing indexes: %s", parent, err)
+		return nil, err
+	}
+	return indexes, nil
+}
+
+func (c *Client) insertIndex(parent string, spec *firestore.GoogleFirestoreAdminV1Index) error {
+	utils.Logger.Infof("[%s] creating index", parent)
+	operation, err := c.firestoreService.Projects.Databases.CollectionGroups.Indexes.Create(parent, spec).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error creating index: %s", parent, err)
+		return err
+	}
+	return c.waitOperation(parent, operation)
+}
+
+func (c *Client) deleteIndex(name string) error {
+	utils.Logger.Infof("[%s] deleting index", name)
+	_, err := c.firestoreService.Projects.Databases.CollectionGroups.Indexes.Delete(name).Do()
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			utils.Logger.Infof("[%s] index already deleted", name)
+			return nil
+		}
+		utils.Logger.Errorf("[%s] error deleting index: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+// getTtl returns the TTL config of a field, or nil when the field has none.
+func (c *Client) getTtl(name string) (*firestore.GoogleFirestoreAdminV1TtlConfig, error) {
+	utils.Logger.Debugf("[%s] getting field", name)
+	field, err := c.firestoreService.Projects.Databases.CollectionGroups.Fields.Get(name).Do()
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			return nil, nil
+		}
+		utils.Logger.Errorf("[%s] error getting field: %s", name, err)
+		return nil, err
+	}
+	return field.TtlConfig, nil
+}
+
+func (c *Client) enableTtl(name string) error {
+	utils.Logger.Infof("[%s] enabling TTL policy", name)
+	return c.patchTtl(name, &firestore.GoogleFirestoreAdminV1TtlConfig{})
+}
+
+func (c *Client) disableTtl(name string) error {
+	utils.Logger.Infof("[%s] disabling TTL policy", name)
+	err := c.patchTtl(name, nil)
+	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+		utils.Logger.Infof("[%s] field already deleted", name)
+		return nil
+	}
+	return err
+}
+
+// patchTtl sets the TTL config of a field, nil removes it.
+func (c *Client) patchTtl(name string, ttlConfig *firestore.GoogleFirestoreAdminV1TtlConfig) error {
+	field := &firestore.GoogleFirestoreAdminV1Field{Name: name, TtlConfig: ttlConfig}
+	operation, err := c.firestoreService.Projects.Databases.CollectionGroups.Fields.Patch(name, field).UpdateMask("ttlConfig").Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error updating TTL policy: %s", name, err)
+		return err
+	}
+	return c.waitOperation(name, operation)
+}
+
+// waitOperation polls a long-running operation until it is done and returns
+// its error, if any.
+func (c *Client) waitOperation(name string, operation *firestore.GoogleLongrunningOperation) error {
+	deadline := time.Now().Add(operationTimeout)
+	for !operation.Done {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("[%s] operation %s not done after %s", name, operation.Name, operationTimeout)
+		}
+		utils.Logger.Debugf("[%s] waiting for operation %s", name, operation.Name)
+		time.Sleep(operationPollInterval)
+		var err error
+		operation, err = c.firestoreService.Projects.Databases.Operations.Get(operation.Name).Do()
+		if err != nil {
+			utils.Logger.Errorf("[%s] error getting operation: %s", name, err)
+			return err
+		}
+	}
+	if operation.Error != nil {
+		return fmt.Errorf("[%s] operation %s failed: %s", name, operation.Name, operation.Error.Message)
+	}
+	utils.Logger.Infof("[%s] operation %s done", name, operation.Name)
+	return nil
+}
+
+func createIndexSpec(index Index) *firestore.GoogleFirestoreAdminV1Index {
+	spec := &firestore.GoogleFirestoreAdminV1Index{QueryScope: index.QueryScope}
+	for _, field := range index.Fields {
+		spec.Fields = append(spec.Fields, &firestore.GoogleFirestoreAdminV1IndexField{
+			FieldPath:   field.FieldPath,
+			Order:       field.Order,
+			ArrayConfig: field.ArrayConfig,
+		})
+	}
+	return spec
+}
+
+func createTtlSpec(ttlPolicy TtlPolicy) *firestore.GoogleFirestoreAdminV1Field {
+	return &firestore.GoogleFirestoreAdminV1Field{
+		Name:      FieldName(ttlPolicy),
+		TtlConfig: &firestore.GoogleFirestoreAdminV1TtlConfig{},
+	}
+}
+
+// sameIndex returns whether a live index has the definition of spec. The
+// __name__ field Firestore appends to the fields is ignored.
+func sameIndex(live *firestore.GoogleFirestoreAdminV1Index, spec *firestore.GoogleFirestoreAdminV1Index) bool {
+	if live.QueryScope != spec.QueryScope {
+		return false
+	}
+	fields := live.Fields
+	if len(fields) == len(spec.Fields)+1 && fields[len(fields)-1].FieldPath == nameField {
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) != len(spec.Fields) {
+		return false
+	}
+	for i, field := range fields {
+		desired := spec.Fields[i]
+		if field.FieldPath != desired.FieldPath || field.Order != desired.Order || field.ArrayConfig != desired.ArrayConfig {
+			return false
+		}
+	}
+	return true
+}
+
+func isIndex(change common.ResourceChange) bool {
+	return strings.HasPrefix(change.Key, indexesKey+".")
+}
+
+// kind returns the kind of the resource of a planned change.
+func kind(change common.ResourceChange) string {
+	if isIndex(change) {
+		return "index"
+	}
+	return "TTL policy"
+}
+
+// indexParent returns the collection group of an index name. The name of an
+// index that does not exist yet is its collection group.
+func indexParent(name string) string {
+	parent, _, _ := strings.Cut(name, "/indexes/")
+	return parent
+}
@@ -0,0 +1,13 @@
+package firestore_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestFirestore(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Firestore Suite")
+}
@@ -0,0 +1,241 @@
+package firestore
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/firestore/v1"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Helper method to create client
+func getMockedClient(url string) *Client {
+	client, err := NewClient(context.Background(), option.WithoutAuthentication(), option.WithEndpoint(url))
+	if err != nil {
+		Fail(err.Error())
+	}
+	return client
+}
+
+var _ = Describe("Firestore client", func() {
+	var index Index
+	var ttlPolicy TtlPolicy
+	var parent string
+	var fieldName string
+
+	BeforeEach(func() {
+		operationPollInterval = time.Millisecond
+		index = Index{
+			ProjectId:       "projet-123",
+			Database:        "(default)",
+			CollectionGroup: "orders",
+			QueryScope:      "COLLECTION",
+			Fields: []IndexField{
+				{FieldPath: "customerId", Order: "ASCENDING"},
+				{FieldPath: "createdAt", Order: "DESCENDING"},
+			},
+			ClientName: "banane",
+		}
+		ttlPolicy = TtlPolicy{
+			ProjectId:       "projet-123",
+			Database:        "(default)",
+			CollectionGroup: "sessions",
+			Field:           "expireAt",
+			ClientName:      "banane",
+		}
+		parent = "projects/projet-123/databases/(default)/collectionGroups/orders"
+		fieldName = "projects/projet-123/databases/(default)/collectionGroups/sessions/fields/expireAt"
+	})
+
+	liveIndex := func(id string, fields ...string) *firestore.GoogleFirestoreAdminV1Index {
+		live := &firestore.GoogleFirestoreAdminV1Index{Name: parent + "/indexes/" + id, QueryScope: "COLLECTION", State: "READY"}
+		for i := 0; i < len(fields); i += 2 {
+			live.Fields = append(live.Fields, &firestore.GoogleFirestoreAdminV1IndexField{FieldPath: fields[i], Order: fields[i+1]})
+		}
+		return live
+	}
+
+	Describe("match index", func() {
+		It("ignores the __name__ field appended by Firestore", func() {
+			live := liveIndex("CICAgOjXh4EK", "customerId", "ASCENDING", "createdAt", "DESCENDING", "__name__", "DESCENDING")
+			Expect(sameIndex(live, createIndexSpec(index))).To(BeTrue())
+		})
+		It("does not match an index with another order", func() {
+			live := liveIndex("CICAgOjXh4EK", "customerId", "ASCENDING", "createdAt", "ASCENDING")
+			Expect(sameIndex(live, createIndexSpec(index))).To(BeFalse())
+		})
+	})
+	Describe("create index", func() {
+		It("creates the missing index and polls the build until done", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 4)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+parent+"/indexes?")
+				},
+				ResponseBody: firestore.GoogleFirestoreAdminV1ListIndexesResponse{},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+parent+"/indexes?")
+				},
+				Method:       "post",
+				ResponseBody: firestore.GoogleLongrunningOperation{Name: "projects/projet-123/databases/(default)/operations/op1"},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/projects/projet-123/databases/(default)/operations/op1")
+				},
+				ResponseBody: firestore.GoogleLongrunningOperation{Name: "projects/projet-123/databases/(default)/operations/op1"},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: firestore.GoogleLongrunningOperation{Name: "projects/projet-123/databases/(default)/operations/op1", Done: true},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			applied, err := client.Create(&Config{Firestore: Firestore{Indexes: map[string]Index{"orders-by-customer": index}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(HaveLen(1))
+			Expect(applied[0].Key).To(Equal("indexes.orders-by-customer"))
+			Expect(mockServerCalls).To(BeEmpty())
+		})
+		It("returns the error of a failed build", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 2)
+			mockServerCalls <- utils.MockServerCall{ResponseBody: firestore.GoogleFirestoreAdminV1ListIndexesResponse{}}
+			mockServerCalls <- utils.MockServerCall{
+				Method: "post",
+				ResponseBody: firestore.GoogleLongrunningOperation{
+					Name:  "projects/projet-123/databases/(default)/operations/op1",
+					Done:  true,
+					Error: &firestore.Status{Code: 9, Message: "too many composite indexes"},
+				},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			_, err := client.Create(&Config{Firestore: Firestore{Indexes: map[string]Index{"orders-by-customer": index}}})
+			Expect(err).To(MatchError(ContainSubstring("too many composite indexes")))
+		})
+	})
+	Describe("plan", func() {
+		It("plans a no-op for an existing index and a create for a missing TTL policy", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+parent+"/indexes?")
+				},
+				ResponseBody: firestore.GoogleFirestoreAdminV1ListIndexesResponse{Indexes: []*firestore.GoogleFirestoreAdminV1Index{
+					liveIndex("CICAgOjXh4EK", "customerId", "ASCENDING", "createdAt", "DESCENDING", "__name__", "DESCENDING"),
+				}},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(&Config{Firestore: Firestore{Indexes: map[string]Index{"orders-by-customer": index}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Action).To(Equal(common.ActionNoop))
+			Expect(changes[0].Name).To(Equal(parent + "/indexes/CICAgOjXh4EK"))
+		})
+		It("plans a create for a field without TTL config", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+fieldName)
+				},
+				ResponseBody: firestore.GoogleFirestoreAdminV1Field{Name: fieldName},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(&Config{Firestore: Firestore{TtlPolicies: map[string]TtlPolicy{"sessions": ttlPolicy}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Action).To(Equal(common.ActionCreate))
+			Expect(changes[0].Key).To(Equal("ttlPolicies.sessions"))
+		})
+	})
+	Describe("apply planned change", func() {
+		It("enables the TTL policy and waits for the operation", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 2)
+			mockServerCalls <- utils.MockServerCall{ResponseCode: 404}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+fieldName) && strings.Contains(url, "updateMask=ttlConfig")
+				},
+				Method:       "patch",
+				ResponseBody: firestore.GoogleLongrunningOperation{Name: "op2", Done: true},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			change := common.ResourceChange{
+				Product: Product,
+				Key:     "ttlPolicies.sessions",
+				Name:    fieldName,
+				Action:  common.ActionCreate,
+				Desired: []byte(`{"name":"` + fieldName + `","ttlConfig":{}}`),
+			}
+			Expect(client.Verify(change)).To(Succeed())
+			_, err := client.Apply(change)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Describe("find undeclared indexes", func() {
+		It("returns the indexes of the databases that are not declared", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/projects/projet-123/databases/(default)/collectionGroups/-/indexes?")
+				},
+				ResponseBody: firestore.GoogleFirestoreAdminV1ListIndexesResponse{Indexes: []*firestore.GoogleFirestoreAdminV1Index{
+					liveIndex("declared", "customerId", "ASCENDING", "createdAt", "DESCENDING"),
+					liveIndex("by-hand", "status", "ASCENDING", "createdAt", "DESCENDING"),
+				}},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			undeclared, err := client.FindUndeclared([]string{"projects/projet-123/databases/(default)"}, []Index{index})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(undeclared).To(ConsistOf(parent + "/indexes/by-hand"))
+		})
+	})
+	Describe("delete", func() {
+		It("deletes the matching index and disables the TTL policy", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 2)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: firestore.GoogleFirestoreAdminV1ListIndexesResponse{Indexes: []*firestore.GoogleFirestoreAdminV1Index{
+					liveIndex("CICAgOjXh4EK", "customerId", "ASCENDING", "createdAt", "DESCENDING"),
+				}},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+parent+"/indexes/CICAgOjXh4EK")
+				},
+				Method: "delete",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			Expect(client.Delete(&Config{Firestore: Firestore{Indexes: map[string]Index{"orders-by-customer": index}}})).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,129 @@
+package firestore
+
+import (
+	"context"
+	"sort"
+
+	"github.com/spf13/viper"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/provider"
+)
+
+func init() {
+	provider.Register(&firestoreProvider{})
+}
+
+// firestoreProvider plugs the Firestore indexes and TTL policies into the
+// provider registry.
+type firestoreProvider struct {
+	client *Client
+}
+
+func (p *firestoreProvider) Key() string {
+	return Product
+}
+
+func (p *firestoreProvider) Init(ctx context.Context, opts ...option.ClientOption) error {
+	client, err := NewClient(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	p.client = client
+	return nil
+}
+
+func (p *firestoreProvider) GetConfig(viperConfig *viper.Viper, clientName string) (provider.Config, error) {
+	config, err := GetFirestoreConfig(viperConfig, clientName)
+	if err != nil || config == nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (p *firestoreProvider) ValidateConfig(config provider.Config) error {
+	return ValidateConfig(config.(*Config))
+}
+
+// Resources lists the indexes by their collection group, since their name is
+// only known once they are created.
+func (p *firestoreProvider) Resources(config provider.Config) []common.ResourceChange {
+	var resources []common.ResourceChange
+	for key, index := range config.(*Config).Firestore.Indexes {
+		resources = append(resources, common.ResourceChange{
+			Client:  index.ClientName,
+			Product: Product,
+			Key:     indexesKey + "." + key,
+			Name:    CollectionGroupName(index),
+			Project: index.ProjectId,
+		})
+	}
+	for key, ttlPolicy := range config.(*Config).Firestore.TtlPolicies {
+		resources = append(resources, common.ResourceChange{
+			Client:  ttlPolicy.ClientName,
+			Product: Product,
+			Key:     ttlPoliciesKey + "." + key,
+			Name:    FieldName(ttlPolicy),
+			Project: ttlPolicy.ProjectId,
+		})
+	}
+	return resources
+}
+
+func (p *firestoreProvider) Create(config provider.Config) ([]common.AppliedResource, error) {
+	return p.client.Create(config.(*Config))
+}
+
+func (p *firestoreProvider) Plan(config provider.Config) ([]common.ResourceChange, error) {
+	return p.client.Plan(config.(*Config))
+}
+
+func (p *firestoreProvider) Verify(change common.ResourceChange) error {
+	return p.client.Verify(change)
+}
+
+func (p *firestoreProvider) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	return p.client.Apply(change)
+}
+
+func (p *firestoreProvider) Delete(config provider.Config, opts provider.DeleteOptions) error {
+	return p.client.Delete(config.(*Config))
+}
+
+// FindOrphans reports the composite indexes of the databases of the configs
+// that no client declares. Indexes cannot carry an ownership label, so they
+// are never pruned.
+func (p *firestoreProvider) FindOrphans(clients []string, configs []provider.Config) ([]common.ResourceChange, error) {
+	databases := map[string]bool{}
+	var declared []Index
+	for _, config := range configs {
+		for _, index := range config.(*Config).Firestore.Indexes {
+			databases[DatabaseName(index.ProjectId, index.Database)] = true
+			declared = append(declared, index)
+		}
+	}
+	sortedDatabases := make([]string, 0, len(databases))
+	for database := range databases {
+		sortedDatabases = append(sortedDatabases, database)
+	}
+	sort.Strings(sortedDatabases)
+
+	undeclared, err := p.client.FindUndeclared(sortedDatabases, declared)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []common.ResourceChange
+	for _, name := range undeclared {
+		orphans = append(orphans, common.ResourceChange{
+			Product: Product,
+			Key:     indexesKey,
+			Name:    name,
+			Action:  common.ActionUnmanaged,
+		})
+	}
+	return orphans, nil
+}
+
+func (p *firestoreProvider) Prune(orphans []common.ResourceChange) error {
+	return nil
+}
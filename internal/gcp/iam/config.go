@@ -0,0 +1,158 @@
+// ©Copyright 2022 Metrio
+package iam
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/viper"
+	"metrio.net/fougere-lite/internal/gcp/cloudstorage"
+	"metrio.net/fougere-lite/internal/gcp/cloudtasks"
+)
+
+type Config struct {
+	IAM IAM `mapstructure:"iam" yaml:"iam"`
+}
+
+// IAM is the service accounts of a client and the roles granted on its buckets
+// and queues.
+type IAM struct {
+	// Mode is additive, the default, to only add the declared members to the
+	// roles, or authoritative to also remove the members of the declared roles
+	// that are not declared.
+	Mode            string                    `json:"mode" yaml:"mode,omitempty" validate:"omitempty,oneof=additive authoritative"`
+	ServiceAccounts map[string]ServiceAccount `json:"serviceAccounts" yaml:"serviceAccounts,omitempty" validate:"dive"`
+	Bindings        map[string]Binding        `json:"bindings" yaml:"bindings,omitempty" validate:"dive"`
+}
+
+type ServiceAccount struct {
+	// Name is the account id of the service account, <client>-<key>.
+	Name        string `json:"name" yaml:"-" validate:"required"`
+	ProjectId   string `json:"projectId" yaml:"projectId" validate:"required"`
+	DisplayName string `json:"displayName" yaml:"displayName,omitempty"`
+	Description string `json:"description" yaml:"description,omitempty"`
+	ClientName  string `yaml:"-"`
+}
+
+// Binding grants a role on one bucket or queue of the client to members.
+type Binding struct {
+	// Bucket is the key of a bucket of the client's storageBucket config.
+	Bucket string `json:"bucket" yaml:"bucket,omitempty"`
+	// Queue is the key of a queue of the client's cloudTasks config.
+	Queue string `json:"queue" yaml:"queue,omitempty"`
+	Role  string `json:"role" yaml:"role" validate:"required"`
+	// Members are the keys of the client's service accounts or IAM members
+	// such as group:team@example.com.
+	Members []string `json:"members" yaml:"members" validate:"required,min=1"`
+	// ResourceName is the name of the bucket or queue, resolved by
+	// GetIamConfig.
+	ResourceName string `json:"-" yaml:"-"`
+	// ProjectId is the project of the bucket or queue.
+	ProjectId string `json:"-" yaml:"-"`
+	// MemberNames are the resolved members, in the same order as Members.
+	MemberNames []string `json:"-" yaml:"-"`
+	ClientName  string   `yaml:"-"`
+}
+
+const (
+	ModeAdditive      = "additive"
+	ModeAuthoritative = "authoritative"
+)
+
+// accountIdPattern is the format GCP requires for the account id of a service
+// account.
+var accountIdPattern = regexp.MustCompile(`^[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+
+// GetIamConfig parses the IAM config of a client. The buckets, queues and
+// service accounts referenced by key are resolved with the config of the same
+// client.
+func GetIamConfig(viperConfig *viper.Viper, clientName string) (*Config, error) {
+	if viperConfig == nil {
+		return nil, nil
+	}
+
+	var iamConfig Config
+	err := viperConfig.Unmarshal(&iamConfig)
+	if err != nil {
+		return nil, err
+	}
+	storageConfig, err := cloudstorage.GetStorageConfig(viperConfig, clientName)
+	if err != nil {
+		return nil, err
+	}
+	taskConfig, err := cloudtasks.GetTaskConfig(viperConfig, clientName)
+	if err != nil {
+		return nil, err
+	}
+
+	if iamConfig.IAM.Mode == "" {
+		iamConfig.IAM.Mode = ModeAdditive
+	}
+	for name, serviceAccount := range iamConfig.IAM.ServiceAccounts {
+		serviceAccount.Name = clientName + "-" + name
+		serviceAccount.ClientName = clientName
+
+		iamConfig.IAM.ServiceAccounts[name] = serviceAccount
+	}
+	for name, binding := range iamConfig.IAM.Bindings {
+		binding.ClientName = clientName
+		binding.ResourceName, binding.ProjectId = "", ""
+		if bucket, ok := storageConfig.StorageBuckets[binding.Bucket]; ok && binding.Bucket != "" {
+			binding.ResourceName, binding.ProjectId = bucket.Name, bucket.ProjectId
+		} else if queue, ok := taskConfig.TaskQueues[binding.Queue]; ok && binding.Queue != "" {
+			binding.ResourceName, binding.ProjectId = cloudtasks.QueueName(queue), queue.ProjectId
+		}
+		binding.MemberNames = nil
+		for _, member := range binding.Members {
+			if strings.Contains(member, ":") {
+				binding.MemberNames = append(binding.MemberNames, member)
+			} else if serviceAccount, ok := iamConfig.IAM.ServiceAccounts[member]; ok {
+				binding.MemberNames = append(binding.MemberNames, "serviceAccount:"+ServiceAccountEmail(serviceAccount))
+			}
+		}
+
+		iamConfig.IAM.Bindings[name] = binding
+	}
+	return &iamConfig, nil
+}
+
+func ValidateConfig(config *Config) error {
+	v := validator.New()
+	if err := v.Struct(config); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			return fmt.Errorf("%s validate failed on the %s rule", err.Namespace(), err.Tag())
+		}
+	}
+	for key, serviceAccount := range config.IAM.ServiceAccounts {
+		if !accountIdPattern.MatchString(serviceAccount.Name) {
+			return fmt.Errorf("service account %s: account id %s must be 6 to 30 lowercase letters, digits or dashes", key, serviceAccount.Name)
+		}
+	}
+	for key, binding := range config.IAM.Bindings {
+		if (binding.Bucket == "") == (binding.Queue == "") {
+			return fmt.Errorf("binding %s: exactly one of bucket and queue must be set", key)
+		}
+		if binding.ResourceName == "" {
+			if binding.Bucket != "" {
+				return fmt.Errorf("binding %s: bucket %s is not declared in storageBucket", key, binding.Bucket)
+			}
+			return fmt.Errorf("binding %s: queue %s is not declared in cloudTasks", key, binding.Queue)
+		}
+		if len(binding.MemberNames) != len(binding.Members) {
+			return fmt.Errorf("binding %s: every member must be a declared service account or a full IAM member", key)
+		}
+	}
+	return nil
+}
+
+// ServiceAccountEmail returns the email of a service account.
+func ServiceAccountEmail(serviceAccount ServiceAccount) string {
+	return serviceAccount.Name + "@" + serviceAccount.ProjectId + ".iam.gserviceaccount.com"
+}
+
+// ServiceAccountName returns the full resource name of a service account.
+func ServiceAccountName(serviceAccount ServiceAccount) string {
+	return "projects/" + serviceAccount.ProjectId + "/serviceAccounts/" + ServiceAccountEmail(serviceAccount)
+}
@@ -0,0 +1,136 @@
+package iam
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+var validIamConfig = []byte(`
+storageBucket:
+  bucket1:
+    region: us-central1
+    projectId: some-project
+cloudTasks:
+  queue1:
+    region: us-central1
+    projectId: some-project
+iam:
+  mode: authoritative
+  serviceAccounts:
+    worker:
+      projectId: some-project
+      displayName: Worker
+  bindings:
+    bucket1-readers:
+      bucket: bucket1
+      role: roles/storage.objectViewer
+      members:
+        - worker
+        - group:data@example.com
+    queue1-enqueuers:
+      queue: queue1
+      role: roles/cloudtasks.enqueuer
+      members:
+        - worker`)
+
+var invalidConfig = []byte(`
+iam:
+  bindings:
+    some-binding:
+      role:
+        - should_not_be_an_array`)
+
+var _ = Describe("config", func() {
+	BeforeEach(func() {
+		viper.Reset()
+		viper.SetConfigType("yaml")
+	})
+	Describe("GetIamConfig", func() {
+		It("should successfully parse an IAM config and resolve its references", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(validIamConfig))
+			Expect(err).ToNot(HaveOccurred())
+			iamConfig, err := GetIamConfig(viper.GetViper(), "some-client")
+			Expect(err).To(BeNil())
+			Expect(iamConfig.IAM.Mode).To(Equal(ModeAuthoritative))
+			worker := iamConfig.IAM.ServiceAccounts["worker"]
+			Expect(worker.Name).To(Equal("some-client-worker"))
+			Expect(ServiceAccountEmail(worker)).To(Equal("some-client-worker@some-project.iam.gserviceaccount.com"))
+			readers := iamConfig.IAM.Bindings["bucket1-readers"]
+			Expect(readers.ResourceName).To(Equal("some-client-bucket1-some-project"))
+			Expect(readers.MemberNames).To(Equal([]string{
+				"serviceAccount:some-client-worker@some-project.iam.gserviceaccount.com",
+				"group:data@example.com",
+			}))
+			enqueuers := iamConfig.IAM.Bindings["queue1-enqueuers"]
+			Expect(enqueuers.ResourceName).To(Equal("projects/some-project/locations/us-central1/queues/queue1"))
+			Expect(ValidateConfig(iamConfig)).To(Succeed())
+		})
+		It("defaults to the additive mode", func() {
+			err := viper.ReadConfig(bytes.NewBuffer([]byte("iam: {}")))
+			Expect(err).ToNot(HaveOccurred())
+			iamConfig, err := GetIamConfig(viper.GetViper(), "some-client")
+			Expect(err).To(BeNil())
+			Expect(iamConfig.IAM.Mode).To(Equal(ModeAdditive))
+		})
+		It("returns an error if cannot parse the config", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(invalidConfig))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = GetIamConfig(viper.GetViper(), "some-client")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("validates the IAM config", func() {
+		var binding Binding
+		var serviceAccount ServiceAccount
+
+		BeforeEach(func() {
+			serviceAccount = ServiceAccount{Name: "banane-worker", ProjectId: "mock-project"}
+			binding = Binding{
+				Bucket:       "bucket1",
+				Role:         "roles/storage.objectViewer",
+				Members:      []string{"worker"},
+				ResourceName: "banane-bucket1-mock-project",
+				MemberNames:  []string{"serviceAccount:banane-worker@mock-project.iam.gserviceaccount.com"},
+			}
+		})
+		config := func() *Config {
+			return &Config{IAM: IAM{
+				Mode:            ModeAdditive,
+				ServiceAccounts: map[string]ServiceAccount{"worker": serviceAccount},
+				Bindings:        map[string]Binding{"foooo": binding},
+			}}
+		}
+
+		It("should not detect error", func() {
+			Expect(ValidateConfig(config())).To(Succeed())
+		})
+		It("should detect an invalid mode", func() {
+			invalid := config()
+			invalid.IAM.Mode = "exclusive"
+			Expect(ValidateConfig(invalid)).To(MatchError("Config.IAM.Mode validate failed on the oneof rule"))
+		})
+		It("should detect an account id that is too long", func() {
+			serviceAccount.Name = "banane-a-very-long-service-account-name"
+			Expect(ValidateConfig(config())).To(MatchError("service account worker: account id banane-a-very-long-service-account-name must be 6 to 30 lowercase letters, digits or dashes"))
+		})
+		It("should detect a binding on both a bucket and a queue", func() {
+			binding.Queue = "queue1"
+			Expect(ValidateConfig(config())).To(MatchError("binding foooo: exactly one of bucket and queue must be set"))
+		})
+		It("should detect a bucket that is not declared", func() {
+			binding.ResourceName = ""
+			Expect(ValidateConfig(config())).To(MatchError("binding foooo: bucket bucket1 is not declared in storageBucket"))
+		})
+		It("should detect a member that is not declared", func() {
+			binding.Members = append(binding.Members, "unknown")
+			Expect(ValidateConfig(config())).To(MatchError("binding foooo: every member must be a declared service account or a full IAM member"))
+		})
+		It("should detect a binding without members", func() {
+			binding.Members = nil
+			Expect(ValidateConfig(config())).To(MatchError("Config.IAM.Bindings[foooo].Members validate failed on the required rule"))
+		})
+	})
+})
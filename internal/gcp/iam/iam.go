@@ -0,0 +1,526 @@
+package iam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/cloudtasks/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/gcp/iam/iampolicy"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Product is the key of the IAM config in a client's config.
+const Product = "iam"
+
+// Prefixes of the keys of the service accounts and policies in the plan and
+// state, e.g. serviceAccounts.worker or buckets.bucket1.
+const (
+	serviceAccountsKey = "serviceAccounts"
+	bucketsKey         = "buckets"
+	queuesKey          = "queues"
+)
+
+// serviceAccountUpdateMask lists the properties of a service account that are
+// patched.
+const serviceAccountUpdateMask = "displayName,description"
+
+type Client struct {
+	iamService     *iam.Service
+	storageService *storage.Service
+	tasksService   *cloudtasks.Service
+}
+
+func NewClient(ctx context.Context, opts ...option.ClientOption) (*Client, error) {
+	iamService, err := iam.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	storageService, err := storage.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	tasksService, err := cloudtasks.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		iamService:     iamService,
+		storageService: storageService,
+		tasksService:   tasksService,
+	}, nil
+}
+
+// Create creates the service accounts of the config that do not exist and
+// updates the others, then grants the declared roles on the buckets and queues.
+// It returns the resources that were applied, even when some failed.
+func (c *Client) Create(config *Config) ([]common.AppliedResource, error) {
+	applied, err := c.createServiceAccounts(config)
+	if err != nil {
+		return applied, err
+	}
+	policiesApplied, err := c.createPolicies(config)
+	return append(applied, policiesApplied...), err
+}
+
+func (c *Client) createServiceAccounts(config *Config) ([]common.AppliedResource, error) {
+	createChannel := make(chan common.Response, len(config.IAM.ServiceAccounts))
+	for key, serviceAccount := range config.IAM.ServiceAccounts {
+		go func(resp chan common.Response, key string, serviceAccount ServiceAccount) {
+			spec := c.createServiceAccountSpec(serviceAccount)
+			_, err := c.getServiceAccount(spec.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+					utils.Logger.Debugf("[%s] service account not found", spec.Name)
+
+					if err := c.insertServiceAccount(spec); err != nil {
+						resp <- common.Response{Err: err}
+						return
+					}
+				} else {
+					utils.Logger.Errorf("[%s] error getting service account: %s", spec.Name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+			} else {
+				if err := c.patchServiceAccount(spec); err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+			}
+			resp <- common.Response{Applied: &common.AppliedResource{
+				Client:  serviceAccount.ClientName,
+				Product: Product,
+				Key:     serviceAccountsKey + "." + key,
+				Name:    spec.Name,
+				Spec:    spec,
+			}}
+		}(createChannel, key, serviceAccount)
+	}
+	var applied []common.AppliedResource
+	var createErr error
+	for range config.IAM.ServiceAccounts {
+		resp := <-createChannel
+		if resp.Err != nil {
+			createErr = resp.Err
+			continue
+		}
+		applied = append(applied, *resp.Applied)
+	}
+	return applied, createErr
+}
+
+func (c *Client) createPolicies(config *Config) ([]common.AppliedResource, error) {
+	policies := resourcePolicies(config)
+	createChannel := make(chan common.Response, len(policies))
+	for key, desired := range policies {
+		go func(resp chan common.Response, key string, desired *resourcePolicy) {
+			err := c.updatePolicy(desired.Kind, desired.Name, func(live *policy) *policy {
+				return withDeclared(live, desired)
+			})
+			if err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			resp <- common.Response{Applied: &common.AppliedResource{
+				Client:  desired.ClientName,
+				Product: Product,
+				Key:     key,
+				Name:    desired.Name,
+				Spec:    desired,
+			}}
+		}(createChannel, key, desired)
+	}
+	var applied []common.AppliedResource
+	var createErr error
+	for range policies {
+		resp := <-createChannel
+		if resp.Err != nil {
+			createErr = resp.Err
+			continue
+		}
+		applied = append(applied, *resp.Applied)
+	}
+	return applied, createErr
+}
+
+// Plan compares every service account and every policy of the config with its
+// live state and returns the change Create would make to each of them. The
+// policy of a bucket or queue that does not exist yet is planned as a create.
+func (c *Client) Plan(config *Config) ([]common.ResourceChange, error) {
+	policies := resourcePolicies(config)
+	total := len(config.IAM.ServiceAccounts) + len(policies)
+	planChannel := make(chan common.Response, total)
+	for key, serviceAccount := range config.IAM.ServiceAccounts {
+		go func(resp chan common.Response, key string, serviceAccount ServiceAccount) {
+			spec := c.createServiceAccountSpec(serviceAccount)
+			change := common.ResourceChange{
+				Client:  serviceAccount.ClientName,
+				Product: Product,
+				Key:     serviceAccountsKey + "." + key,
+				Name:    spec.Name,
+				Project: serviceAccount.ProjectId,
+			}
+			live, err := c.getServiceAccount(spec.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+					utils.Logger.Errorf("[%s] error getting service account: %s", spec.Name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+				resp <- common.PlanChange(change, spec, nil, nil)
+				return
+			}
+			resp <- common.PlanChange(change, spec, live, diffServiceAccount(live, spec))
+		}(planChannel, key, serviceAccount)
+	}
+	for key, desired := range policies {
+		go func(resp chan common.Response, key string, desired *resourcePolicy) {
+			change := common.ResourceChange{
+				Client:  desired.ClientName,
+				Product: Product,
+				Key:     key,
+				Name:    desired.Name,
+				Project: desired.ProjectId,
+			}
+			live, err := c.getPolicy(desired.Kind, desired.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+					utils.Logger.Errorf("[%s] error getting IAM policy: %s", desired.Name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+				resp <- common.PlanChange(change, desired, nil, diffPolicy(&policy{}, desired))
+				return
+			}
+			resp <- common.PlanChange(change, desired, live, diffPolicy(live, desired))
+		}(planChannel, key, desired)
+	}
+	var changes []common.ResourceChange
+	var planErr error
+	for i := 0; i < total; i++ {
+		resp := <-planChannel
+		if resp.Err != nil {
+			planErr = resp.Err
+			continue
+		}
+		changes = append(changes, resp.Change)
+	}
+	return changes, planErr
+}
+
+// Verify returns an error if the live service account or policy is not in the
+// state it was in when the change was planned.
+func (c *Client) Verify(change common.ResourceChange) error {
+	var live interface{}
+	var err error
+	if isServiceAccount(change) {
+		live, err = c.getServiceAccount(change.Name)
+	} else {
+		live, err = c.getPolicy(kindOf(change), change.Name)
+	}
+	liveHash := ""
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+			return err
+		}
+	} else {
+		if liveHash, err = common.HashResource(live); err != nil {
+			return err
+		}
+	}
+	if liveHash != change.LiveHash {
+		return fmt.Errorf("[%s] %s changed since the plan was made", change.Name, kindOf(change))
+	}
+	return nil
+}
+
+// Apply runs a planned change with the spec saved in the plan. Policies are
+// read again and only the planned roles are changed, so that the bindings
+// added since the plan are kept. It returns the applied resource, or nil when
+// there was nothing to do.
+func (c *Client) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	if change.Action == common.ActionNoop {
+		return nil, nil
+	}
+	var spec interface{}
+	if isServiceAccount(change) {
+		serviceAccount := &iam.ServiceAccount{}
+		if err := json.Unmarshal(change.Desired, serviceAccount); err != nil {
+			return nil, fmt.Errorf("[%s] invalid planned spec: %s", change.Name, err)
+		}
+		var err error
+		switch change.Action {
+		case common.ActionCreate:
+			err = c.insertServiceAccount(serviceAccount)
+		case common.ActionUpdate:
+			err = c.patchServiceAccount(serviceAccount)
+		default:
+			return nil, fmt.Errorf("[%s] unknown action %s", change.Name, change.Action)
+		}
+		if err != nil {
+			return nil, err
+		}
+		spec = serviceAccount
+	} else {
+		desired := &resourcePolicy{}
+		if err := json.Unmarshal(change.Desired, desired); err != nil {
+			return nil, fmt.Errorf("[%s] invalid planned spec: %s", change.Name, err)
+		}
+		if change.Action != common.ActionCreate && change.Action != common.ActionUpdate {
+			return nil, fmt.Errorf("[%s] unknown action %s", change.Name, change.Action)
+		}
+		err := c.updatePolicy(desired.Kind, desired.Name, func(live *policy) *policy {
+			return withDeclared(live, desired)
+		})
+		if err != nil {
+			return nil, err
+		}
+		spec = desired
+	}
+	return &common.AppliedResource{
+		Client:  change.Client,
+		Product: Product,
+		Key:     change.Key,
+		Name:    change.Name,
+		Spec:    spec,
+	}, nil
+}
+
+// Delete removes the declared members from the roles of the buckets and queues
+// then deletes the service accounts. Resources that do not exist are ignored.
+func (c *Client) Delete(config *Config) error {
+	policies := resourcePolicies(config)
+	deleteChannel := make(chan common.Response, len(policies))
+	for _, desired := range policies {
+		go func(resp chan common.Response, desired *resourcePolicy) {
+			err := c.updatePolicy(desired.Kind, desired.Name, func(live *policy) *policy {
+				return withoutDeclared(live, desired)
+			})
+			if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+				utils.Logger.Infof("[%s] %s already deleted", desired.Name, desired.Kind)
+				err = nil
+			}
+			resp <- common.Response{Err: err}
+		}(deleteChannel, desired)
+	}
+	var deleteErr error
+	for range policies {
+		resp := <-deleteChannel
+		if resp.Err != nil {
+			deleteErr = resp.Err
+		}
+	}
+	if deleteErr != nil {
+		return deleteErr
+	}
+
+	deleteChannel = make(chan common.Response, len(config.IAM.ServiceAccounts))
+	for _, serviceAccount := range config.IAM.ServiceAccounts {
+		go func(resp chan common.Response, serviceAccount ServiceAccount) {
+			resp <- common.Response{Err: c.deleteServiceAccount(ServiceAccountName(serviceAccount))}
+		}(deleteChannel, serviceAccount)
+	}
+	for range config.IAM.ServiceAccounts {
+		resp := <-deleteChannel
+		if resp.Err != nil {
+			deleteErr = resp.Err
+		}
+	}
+	return deleteErr
+}
+
+func (c *Client) getServiceAccount(name string) (*iam.ServiceAccount, error) {
+	utils.Logger.Debugf("[%s] getting service account", name)
+	serviceAccount, err := c.iamService.Projects.ServiceAccounts.Get(name).Do()
+	if err != nil {
+		return nil, err
+	}
+	return serviceAccount, nil
+}
+
+func (c *Client) insertServiceAccount(spec *iam.ServiceAccount) error {
+	utils.Logger.Infof("[%s] creating service account", spec.Name)
+	accountId := strings.Split(spec.Email, "@")[0]
+	_, err := c.iamService.Projects.ServiceAccounts.Create("projects/"+spec.ProjectId, &iam.CreateServiceAccountRequest{
+		AccountId: accountId,
+		ServiceAccount: &iam.ServiceAccount{
+			DisplayName: spec.DisplayName,
+			Description: spec.Description,
+		},
+	}).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error creating service account: %s", spec.Name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) patchServiceAccount(spec *iam.ServiceAccount) error {
+	utils.Logger.Infof("[%s] updating service account", spec.Name)
+	_, err := c.iamService.Projects.ServiceAccounts.Patch(spec.Name, &iam.PatchServiceAccountRequest{
+		ServiceAccount: spec,
+		UpdateMask:     serviceAccountUpdateMask,
+	}).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error updating service account: %s", spec.Name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) deleteServiceAccount(name string) error {
+	utils.Logger.Infof("[%s] deleting service account", name)
+	_, err := c.iamService.Projects.ServiceAccounts.Delete(name).Do()
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			utils.Logger.Infof("[%s] service account already deleted", name)
+			return nil
+		}
+		utils.Logger.Errorf("[%s] error deleting service account: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) getPolicy(kind string, name string) (*policy, error) {
+	utils.Logger.Debugf("[%s] getting IAM policy", name)
+	if kind == kindBucket {
+		bucketPolicy, err := c.storageService.Buckets.GetIamPolicy(name).OptionsRequestedPolicyVersion(policyVersion).Do()
+		if err != nil {
+			return nil, err
+		}
+		return fromBucketPolicy(bucketPolicy), nil
+	}
+	queuePolicy, err := c.tasksService.Projects.Locations.Queues.GetIamPolicy(name, &cloudtasks.GetIamPolicyRequest{
+		Options: &cloudtasks.GetPolicyOptions{RequestedPolicyVersion: policyVersion},
+	}).Do()
+	if err != nil {
+		return nil, err
+	}
+	return fromQueuePolicy(queuePolicy), nil
+}
+
+// setPolicy writes a policy with the etag it was read with, so that GCP
+// refuses it if the policy was changed in between.
+func (c *Client) setPolicy(kind string, name string, p *policy) error {
+	utils.Logger.Infof("[%s] setting IAM policy", name)
+	var err error
+	if kind == kindBucket {
+		_, err = c.storageService.Buckets.SetIamPolicy(name, toBucketPolicy(p)).Do()
+	} else {
+		_, err = c.tasksService.Projects.Locations.Queues.SetIamPolicy(name, &cloudtasks.SetIamPolicyRequest{
+			Policy: toQueuePolicy(p),
+		}).Do()
+	}
+	return err
+}
+
+// updatePolicy reads the policy of a bucket or queue, modifies it and writes it
+// back, see iampolicy.Update.
+func (c *Client) updatePolicy(kind string, name string, modify func(*policy) *policy) error {
+	return iampolicy.Update(name, func() error {
+		live, err := c.getPolicy(kind, name)
+		if err != nil {
+			return err
+		}
+		updated := modify(live)
+		if policyEqual(live, updated) {
+			utils.Logger.Debugf("[%s] IAM policy is up to date", name)
+			return nil
+		}
+		return c.setPolicy(kind, name, updated)
+	})
+}
+
+func (c *Client) createServiceAccountSpec(serviceAccount ServiceAccount) *iam.ServiceAccount {
+	return &iam.ServiceAccount{
+		Name:        ServiceAccountName(serviceAccount),
+		ProjectId:   serviceAccount.ProjectId,
+		Email:       ServiceAccountEmail(serviceAccount),
+		DisplayName: serviceAccount.DisplayName,
+		Description: serviceAccount.Description,
+	}
+}
+
+// resourcePolicies groups the bindings of the config by bucket and queue,
+// indexed by their key in the plan and state.
+func resourcePolicies(config *Config) map[string]*resourcePolicy {
+	policies := map[string]*resourcePolicy{}
+	for _, b := range config.IAM.Bindings {
+		key, kind := bucketsKey+"."+b.Bucket, kindBucket
+		if b.Queue != "" {
+			key, kind = queuesKey+"."+b.Queue, kindQueue
+		}
+		desired, ok := policies[key]
+		if !ok {
+			desired = &resourcePolicy{
+				Kind:       kind,
+				Name:       b.ResourceName,
+				Mode:       config.IAM.Mode,
+				Bindings:   map[string][]string{},
+				ClientName: b.ClientName,
+				ProjectId:  b.ProjectId,
+			}
+			policies[key] = desired
+		}
+		members := union(desired.Bindings[b.Role], b.MemberNames)
+		sort.Strings(members)
+		desired.Bindings[b.Role] = members
+	}
+	return policies
+}
+
+// diffServiceAccount lists the properties of the desired spec that differ from
+// the live service account.
+func diffServiceAccount(live *iam.ServiceAccount, desired *iam.ServiceAccount) []common.FieldDiff {
+	var diffs []common.FieldDiff
+	diffs = common.AppendDiff(diffs, "displayName", live.DisplayName, desired.DisplayName)
+	diffs = common.AppendDiff(diffs, "description", live.Description, desired.Description)
+	return diffs
+}
+
+// diffPolicy lists the members of the declared roles that would change.
+func diffPolicy(live *policy, desired *resourcePolicy) []common.FieldDiff {
+	updated := withDeclared(live, desired)
+	var roles []string
+	for role := range desired.Bindings {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	var diffs []common.FieldDiff
+	for _, role := range roles {
+		diffs = common.AppendDiff(diffs, "bindings."+role, roleMembers(live, role), roleMembers(updated, role))
+	}
+	return diffs
+}
+
+func policyEqual(a *policy, b *policy) bool {
+	hashA, errA := common.HashResource(a)
+	hashB, errB := common.HashResource(b)
+	return errA == nil && errB == nil && hashA == hashB
+}
+
+func isServiceAccount(change common.ResourceChange) bool {
+	return strings.HasPrefix(change.Key, serviceAccountsKey+".")
+}
+
+// kindOf returns the kind of the resource of a planned change.
+func kindOf(change common.ResourceChange) string {
+	switch {
+	case isServiceAccount(change):
+		return "service account"
+	case strings.HasPrefix(change.Key, queuesKey+"."):
+		return kindQueue
+	default:
+		return kindBucket
+	}
+}
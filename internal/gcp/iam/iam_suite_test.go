@@ -0,0 +1,13 @@
+package iam_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestIam(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Iam Suite")
+}
@@ -0,0 +1,208 @@
+package iam
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/cloudtasks/v2"
+	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Helper method to create client
+func getMockedClient(url string) *Client {
+	client, err := NewClient(context.Background(), option.WithoutAuthentication(), option.WithEndpoint(url))
+	if err != nil {
+		Fail(err.Error())
+	}
+	return client
+}
+
+var _ = Describe("IAM client", func() {
+	var config *Config
+	var bucketName string
+	var queueName string
+	var worker string
+
+	BeforeEach(func() {
+		bucketName = "banane-bucket1-projet-123"
+		queueName = "projects/projet-123/locations/us-central1/queues/queue1"
+		worker = "serviceAccount:banane-worker@projet-123.iam.gserviceaccount.com"
+		config = &Config{IAM: IAM{
+			Mode: ModeAdditive,
+			ServiceAccounts: map[string]ServiceAccount{
+				"worker": {Name: "banane-worker", ProjectId: "projet-123", DisplayName: "Worker", ClientName: "banane"},
+			},
+			Bindings: map[string]Binding{
+				"readers": {
+					Bucket:       "bucket1",
+					Role:         "roles/storage.objectViewer",
+					Members:      []string{"worker"},
+					ResourceName: bucketName,
+					ProjectId:    "projet-123",
+					MemberNames:  []string{worker},
+					ClientName:   "banane",
+				},
+			},
+		}}
+	})
+
+	Describe("merge declared bindings", func() {
+		var live *policy
+		var desired *resourcePolicy
+
+		BeforeEach(func() {
+			live = &policy{Etag: "abc", Bindings: []*binding{
+				{Role: "roles/storage.objectViewer", Members: []string{"user:someone@example.com"}},
+				{Role: "roles/storage.legacyBucketOwner", Members: []string{"projectOwner:projet-123"}},
+				{Role: "roles/storage.objectViewer", Members: []string{"user:temp@example.com"}, Condition: &condition{Expression: "request.time < timestamp('2030-01-01T00:00:00Z')"}},
+			}}
+			desired = &resourcePolicy{Mode: ModeAdditive, Bindings: map[string][]string{"roles/storage.objectViewer": {worker}}}
+		})
+
+		It("adds the members and keeps the others in additive mode", func() {
+			updated := withDeclared(live, desired)
+			Expect(roleMembers(updated, "roles/storage.objectViewer")).To(Equal([]string{worker, "user:someone@example.com"}))
+			Expect(roleMembers(updated, "roles/storage.legacyBucketOwner")).To(Equal([]string{"projectOwner:projet-123"}))
+			Expect(updated.Bindings).To(HaveLen(3))
+			Expect(updated.Etag).To(Equal("abc"))
+			Expect(live.Bindings[0].Members).To(HaveLen(1))
+		})
+		It("replaces the members of the declared roles in authoritative mode", func() {
+			desired.Mode = ModeAuthoritative
+			updated := withDeclared(live, desired)
+			Expect(roleMembers(updated, "roles/storage.objectViewer")).To(Equal([]string{worker}))
+			Expect(roleMembers(updated, "roles/storage.legacyBucketOwner")).To(Equal([]string{"projectOwner:projet-123"}))
+			Expect(updated.Bindings[2].Condition).ToNot(BeNil())
+		})
+		It("only removes the declared members", func() {
+			updated := withoutDeclared(withDeclared(live, desired), desired)
+			Expect(roleMembers(updated, "roles/storage.objectViewer")).To(Equal([]string{"user:someone@example.com"}))
+		})
+		It("lists the members that would change", func() {
+			desired.Mode = ModeAuthoritative
+			Expect(diffPolicy(live, desired)).To(ConsistOf(common.FieldDiff{
+				Field:   "bindings.roles/storage.objectViewer",
+				Current: []string{"user:someone@example.com"},
+				Desired: []string{worker},
+			}))
+		})
+	})
+	Describe("update policy", func() {
+		It("retries the read-modify-write when the etag no longer matches", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 4)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/"+bucketName+"/iam?") && strings.Contains(url, "optionsRequestedPolicyVersion=3")
+				},
+				ResponseBody: storage.Policy{Etag: "v1"},
+			}
+			mockServerCalls <- utils.MockServerCall{Method: "put", ResponseCode: 412}
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: storage.Policy{Etag: "v2", Bindings: []*storage.PolicyBindings{
+					{Role: "roles/storage.admin", Members: []string{"user:admin@example.com"}},
+				}},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/"+bucketName+"/iam?")
+				},
+				Method: "put",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			applied, err := client.Create(&Config{IAM: IAM{Mode: ModeAdditive, Bindings: config.IAM.Bindings}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(HaveLen(1))
+			Expect(applied[0].Key).To(Equal("buckets.bucket1"))
+			Expect(mockServerCalls).To(BeEmpty())
+		})
+		It("does not write a policy that is up to date", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v2/"+queueName+":getIamPolicy")
+				},
+				Method: "post",
+				ResponseBody: cloudtasks.Policy{Etag: "v1", Bindings: []*cloudtasks.Binding{
+					{Role: "roles/cloudtasks.enqueuer", Members: []string{worker}},
+				}},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			err := client.updatePolicy(kindQueue, queueName, func(live *policy) *policy {
+				return withDeclared(live, &resourcePolicy{Mode: ModeAdditive, Bindings: map[string][]string{"roles/cloudtasks.enqueuer": {worker}}})
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Describe("plan", func() {
+		It("plans the service account and the roles to grant", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 2)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/projects/projet-123/serviceAccounts/")
+				},
+				ResponseBody: iam.ServiceAccount{DisplayName: "Worker"},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(&Config{IAM: IAM{Mode: ModeAdditive, ServiceAccounts: config.IAM.ServiceAccounts}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(HaveLen(1))
+			Expect(changes[0].Key).To(Equal("serviceAccounts.worker"))
+			Expect(changes[0].Action).To(Equal(common.ActionNoop))
+		})
+		It("plans a create for the policy of a bucket that does not exist yet", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{ResponseCode: 404}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(&Config{IAM: IAM{Mode: ModeAdditive, Bindings: config.IAM.Bindings}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Action).To(Equal(common.ActionCreate))
+			Expect(changes[0].Name).To(Equal(bucketName))
+			Expect(changes[0].Diffs).To(ConsistOf(common.FieldDiff{Field: "bindings.roles/storage.objectViewer", Current: []string{}, Desired: []string{worker}}))
+		})
+	})
+	Describe("delete", func() {
+		It("removes the declared members then deletes the service accounts", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 3)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: storage.Policy{Etag: "v1", Bindings: []*storage.PolicyBindings{
+					{Role: "roles/storage.objectViewer", Members: []string{worker, "user:someone@example.com"}},
+				}},
+			}
+			mockServerCalls <- utils.MockServerCall{Method: "put"}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/projects/projet-123/serviceAccounts/banane-worker@")
+				},
+				Method: "delete",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			Expect(client.Delete(config)).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,42 @@
+// Package iampolicy updates the IAM policies and access lists that are shared
+// with other writers.
+package iampolicy
+
+import (
+	"fmt"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// MaxAttempts is the number of times a policy is read, modified and written
+// when another writer changes it concurrently.
+const MaxAttempts = 3
+
+// Update runs readModifyWrite, which reads a policy, modifies it and writes it
+// back with the etag it was read with. When GCP refuses the write because the
+// policy was changed concurrently, the whole read-modify-write is run again, so
+// that no edit is lost.
+func Update(name string, readModifyWrite func() error) error {
+	var err error
+	for attempt := 1; attempt <= MaxAttempts; attempt++ {
+		err = readModifyWrite()
+		if err == nil {
+			return nil
+		}
+		if !IsConcurrentChange(err) {
+			utils.Logger.Errorf("[%s] error updating IAM policy: %s", name, err)
+			return err
+		}
+		utils.Logger.Warnf("[%s] IAM policy changed concurrently, retrying (%d/%d)", name, attempt, MaxAttempts)
+	}
+	return fmt.Errorf("[%s] IAM policy kept changing concurrently: %s", name, err)
+}
+
+// IsConcurrentChange returns true if GCP refused a write because its etag no
+// longer matches.
+func IsConcurrentChange(err error) bool {
+	e, ok := err.(*googleapi.Error)
+	return ok && (e.Code == http.StatusConflict || e.Code == http.StatusPreconditionFailed)
+}
@@ -0,0 +1,43 @@
+package iampolicy
+
+import (
+	"errors"
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/googleapi"
+)
+
+var _ = Describe("update policy", func() {
+	It("runs the read-modify-write again when the policy changed concurrently", func() {
+		attempts := 0
+		err := Update("bucket1", func() error {
+			attempts++
+			if attempts == 1 {
+				return &googleapi.Error{Code: http.StatusPreconditionFailed}
+			}
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(attempts).To(Equal(2))
+	})
+	It("gives up after the maximum number of attempts", func() {
+		attempts := 0
+		err := Update("bucket1", func() error {
+			attempts++
+			return &googleapi.Error{Code: http.StatusConflict}
+		})
+		Expect(err).To(MatchError(ContainSubstring("[bucket1] IAM policy kept changing concurrently")))
+		Expect(attempts).To(Equal(MaxAttempts))
+	})
+	It("returns the other errors without retrying", func() {
+		attempts := 0
+		err := Update("bucket1", func() error {
+			attempts++
+			return errors.New("permission denied")
+		})
+		Expect(err).To(MatchError("permission denied"))
+		Expect(attempts).To(Equal(1))
+	})
+})
@@ -0,0 +1,199 @@
+package iam
+
+import (
+	"sort"
+
+	"google.golang.org/api/cloudtasks/v2"
+	"google.golang.org/api/storage/v1"
+)
+
+// Kinds of the resources IAM policies are managed on.
+const (
+	kindBucket = "bucket"
+	kindQueue  = "queue"
+)
+
+// policyVersion is requested when reading policies so that conditional
+// bindings are returned, and kept when writing them back.
+const policyVersion = 3
+
+// policy is an IAM policy of a bucket or a queue, in a form common to both
+// APIs.
+type policy struct {
+	Etag     string     `json:"etag"`
+	Version  int64      `json:"version"`
+	Bindings []*binding `json:"bindings"`
+}
+
+type binding struct {
+	Role      string     `json:"role"`
+	Members   []string   `json:"members"`
+	Condition *condition `json:"condition,omitempty"`
+}
+
+type condition struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Expression  string `json:"expression"`
+	Location    string `json:"location"`
+}
+
+// resourcePolicy is the roles declared on one bucket or queue, with their
+// sorted members. It is the desired spec saved in a plan.
+type resourcePolicy struct {
+	Kind       string              `json:"kind"`
+	Name       string              `json:"name"`
+	Mode       string              `json:"mode"`
+	Bindings   map[string][]string `json:"bindings"`
+	ClientName string              `json:"-"`
+	ProjectId  string              `json:"-"`
+}
+
+// withDeclared returns a copy of the policy with the declared roles granted to
+// their members. In authoritative mode the other members of the declared roles
+// are removed. Conditional bindings and undeclared roles are kept as they are.
+func withDeclared(live *policy, desired *resourcePolicy) *policy {
+	updated := copyPolicy(live)
+	for role, members := range desired.Bindings {
+		b := findBinding(updated, role)
+		if b == nil {
+			b = &binding{Role: role}
+			updated.Bindings = append(updated.Bindings, b)
+		}
+		if desired.Mode == ModeAuthoritative {
+			b.Members = append([]string{}, members...)
+		} else {
+			b.Members = union(b.Members, members)
+		}
+	}
+	return updated
+}
+
+// withoutDeclared returns a copy of the policy without the declared members of
+// the declared roles. Roles left without members are removed.
+func withoutDeclared(live *policy, desired *resourcePolicy) *policy {
+	updated := copyPolicy(live)
+	for role, members := range desired.Bindings {
+		b := findBinding(updated, role)
+		if b == nil {
+			continue
+		}
+		removed := map[string]bool{}
+		for _, member := range members {
+			removed[member] = true
+		}
+		var kept []string
+		for _, member := range b.Members {
+			if !removed[member] {
+				kept = append(kept, member)
+			}
+		}
+		b.Members = kept
+	}
+	var bindings []*binding
+	for _, b := range updated.Bindings {
+		if len(b.Members) > 0 {
+			bindings = append(bindings, b)
+		}
+	}
+	updated.Bindings = bindings
+	return updated
+}
+
+// roleMembers returns the sorted members of the unconditional binding of a
+// role, or an empty list.
+func roleMembers(p *policy, role string) []string {
+	members := []string{}
+	if b := findBinding(p, role); b != nil {
+		members = append(members, b.Members...)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func findBinding(p *policy, role string) *binding {
+	for _, b := range p.Bindings {
+		if b.Role == role && b.Condition == nil {
+			return b
+		}
+	}
+	return nil
+}
+
+func copyPolicy(p *policy) *policy {
+	updated := &policy{Etag: p.Etag, Version: p.Version}
+	for _, b := range p.Bindings {
+		updated.Bindings = append(updated.Bindings, &binding{
+			Role:      b.Role,
+			Members:   append([]string{}, b.Members...),
+			Condition: b.Condition,
+		})
+	}
+	return updated
+}
+
+func union(a []string, b []string) []string {
+	seen := map[string]bool{}
+	var members []string
+	for _, member := range append(append([]string{}, a...), b...) {
+		if !seen[member] {
+			seen[member] = true
+			members = append(members, member)
+		}
+	}
+	return members
+}
+
+func fromBucketPolicy(p *storage.Policy) *policy {
+	converted := &policy{Etag: p.Etag, Version: p.Version}
+	for _, b := range p.Bindings {
+		converted.Bindings = append(converted.Bindings, &binding{Role: b.Role, Members: b.Members, Condition: fromBucketCondition(b.Condition)})
+	}
+	return converted
+}
+
+func toBucketPolicy(p *policy) *storage.Policy {
+	converted := &storage.Policy{Etag: p.Etag, Version: p.Version}
+	for _, b := range p.Bindings {
+		bucketBinding := &storage.PolicyBindings{Role: b.Role, Members: b.Members}
+		if b.Condition != nil {
+			bucketBinding.Condition = &storage.Expr{Title: b.Condition.Title, Description: b.Condition.Description, Expression: b.Condition.Expression, Location: b.Condition.Location}
+		}
+		converted.Bindings = append(converted.Bindings, bucketBinding)
+	}
+	return converted
+}
+
+func fromBucketCondition(expr *storage.Expr) *condition {
+	if expr == nil {
+		return nil
+	}
+	return &condition{Title: expr.Title, Description: expr.Description, Expression: expr.Expression, Location: expr.Location}
+}
+
+func fromQueuePolicy(p *cloudtasks.Policy) *policy {
+	converted := &policy{Etag: p.Etag, Version: p.Version}
+	for _, b := range p.Bindings {
+		converted.Bindings = append(converted.Bindings, &binding{Role: b.Role, Members: b.Members, Condition: fromQueueCondition(b.Condition)})
+	}
+	return converted
+}
+
+func toQueuePolicy(p *policy) *cloudtasks.Policy {
+	converted := &cloudtasks.Policy{Etag: p.Etag, Version: p.Version}
+	for _, b := range p.Bindings {
+		queueBinding := &cloudtasks.Binding{Role: b.Role, Members: b.Members}
+		if b.Condition != nil {
+			queueBinding.Condition = &cloudtasks.Expr{Title: b.Condition.Title, Description: b.Condition.Description, Expression: b.Condition.Expression, Location: b.Condition.Location}
+		}
+		converted.Bindings = append(converted.Bindings, queueBinding)
+	}
+	return converted
+}
+
+func fromQueueCondition(expr *cloudtasks.Expr) *condition {
+	if expr == nil {
+		return nil
+	}
+	return &condition{Title: expr.Title, Description: expr.Description, Expression: expr.Expression, Location: expr.Location}
+}
@@ -0,0 +1,102 @@
+package iam
+
+import (
+	"context"
+
+	"github.com/spf13/viper"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/gcp/cloudstorage"
+	"metrio.net/fougere-lite/internal/gcp/cloudtasks"
+	"metrio.net/fougere-lite/internal/provider"
+)
+
+func init() {
+	provider.Register(&iamProvider{})
+}
+
+// iamProvider plugs the service accounts and IAM policies into the provider
+// registry.
+type iamProvider struct {
+	client *Client
+}
+
+func (p *iamProvider) Key() string {
+	return Product
+}
+
+// DependsOn creates the buckets and queues before granting roles on them.
+func (p *iamProvider) DependsOn() []string {
+	return []string{cloudstorage.Product, cloudtasks.Product}
+}
+
+// Before orders the service accounts before the policies granting them roles.
+func (p *iamProvider) Before(a, b common.ResourceChange) bool {
+	return isServiceAccount(a) && !isServiceAccount(b)
+}
+
+func (p *iamProvider) Init(ctx context.Context, opts ...option.ClientOption) error {
+	client, err := NewClient(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	p.client = client
+	return nil
+}
+
+func (p *iamProvider) GetConfig(viperConfig *viper.Viper, clientName string) (provider.Config, error) {
+	config, err := GetIamConfig(viperConfig, clientName)
+	if err != nil || config == nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (p *iamProvider) ValidateConfig(config provider.Config) error {
+	return ValidateConfig(config.(*Config))
+}
+
+func (p *iamProvider) Resources(config provider.Config) []common.ResourceChange {
+	var resources []common.ResourceChange
+	for key, serviceAccount := range config.(*Config).IAM.ServiceAccounts {
+		resources = append(resources, common.ResourceChange{
+			Client:  serviceAccount.ClientName,
+			Product: Product,
+			Key:     serviceAccountsKey + "." + key,
+			Name:    ServiceAccountName(serviceAccount),
+			Project: serviceAccount.ProjectId,
+		})
+	}
+	for key, desired := range resourcePolicies(config.(*Config)) {
+		resources = append(resources, common.ResourceChange{
+			Client:  desired.ClientName,
+			Product: Product,
+			Key:     key,
+			Name:    desired.Name,
+			Project: desired.ProjectId,
+		})
+	}
+	return resources
+}
+
+func (p *iamProvider) Create(config provider.Config) ([]common.AppliedResource, error) {
+	return p.client.Create(config.(*Config))
+}
+
+func (p *iamProvider) Plan(config provider.Config) ([]common.ResourceChange, error) {
+	return p.client.Plan(config.(*Config))
+}
+
+func (p *iamProvider) Verify(change common.ResourceChange) error {
+	return p.client.Verify(change)
+}
+
+func (p *iamProvider) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	return p.client.Apply(change)
+}
+
+// Delete only removes the declared members from the policies, the other
+// bindings of the buckets and queues are kept.
+func (p *iamProvider) Delete(config provider.Config, opts provider.DeleteOptions) error {
+	return p.client.Delete(config.(*Config))
+}
@@ -0,0 +1,139 @@
+// ©Copyright 2022 Metrio
+package kms
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/viper"
+	"metrio.net/fougere-lite/internal/common"
+)
+
+type Config struct {
+	Kms Kms `mapstructure:"kms" yaml:"kms"`
+}
+
+// Kms is the key rings of a client and the crypto keys used to encrypt its
+// resources.
+type Kms struct {
+	KeyRings   map[string]KeyRing   `json:"keyRings" yaml:"keyRings,omitempty" validate:"dive"`
+	CryptoKeys map[string]CryptoKey `json:"cryptoKeys" yaml:"cryptoKeys,omitempty" validate:"dive"`
+}
+
+type KeyRing struct {
+	// Name is the id of the key ring, <client>-<key>.
+	Name      string `json:"name" yaml:"-" validate:"required"`
+	ProjectId string `json:"projectId" yaml:"projectId" validate:"required"`
+	// Location is a region or a multi-region such as us. A bucket can only
+	// use a key of its own location.
+	Location   string `json:"location" yaml:"location" validate:"required"`
+	ClientName string `yaml:"-"`
+}
+
+// CryptoKey is a symmetric encryption key, as used for CMEK.
+type CryptoKey struct {
+	// Name is the id of the crypto key, <client>-<key>.
+	Name string `json:"name" yaml:"-" validate:"required"`
+	// KeyRing is the key of a key ring of the client's kms config.
+	KeyRing string `json:"keyRing" yaml:"keyRing" validate:"required"`
+	// RotationPeriod is the duration between two automatic rotations, of at
+	// least 24h. Keys without rotation period are not rotated.
+	RotationPeriod string `json:"rotationPeriod" yaml:"rotationPeriod,omitempty"`
+	// ProtectionLevel is SOFTWARE, the default, or HSM. It cannot be changed
+	// once the key is created.
+	ProtectionLevel string            `json:"protectionLevel" yaml:"protectionLevel,omitempty" validate:"omitempty,oneof=SOFTWARE HSM"`
+	Labels          map[string]string `json:"labels" yaml:"labels,omitempty"`
+	// KeyRingName is the full name of the key ring, resolved by GetKmsConfig.
+	KeyRingName string `json:"-" yaml:"-"`
+	ProjectId   string `json:"-" yaml:"-"`
+	ClientName  string `yaml:"-"`
+}
+
+const (
+	DefaultProtectionLevel = "SOFTWARE"
+	minRotationPeriod      = 24 * time.Hour
+)
+
+// GetKmsConfig parses the key rings and crypto keys of a client. The key rings
+// referenced by key are resolved with the same config.
+func GetKmsConfig(viperConfig *viper.Viper, clientName string) (*Config, error) {
+	if viperConfig == nil {
+		return nil, nil
+	}
+
+	var kmsConfig Config
+	err := viperConfig.Unmarshal(&kmsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, keyRing := range kmsConfig.Kms.KeyRings {
+		keyRing.Name = clientName + "-" + name
+		keyRing.ClientName = clientName
+
+		kmsConfig.Kms.KeyRings[name] = keyRing
+	}
+	for name, cryptoKey := range kmsConfig.Kms.CryptoKeys {
+		cryptoKey.Name = clientName + "-" + name
+		cryptoKey.ClientName = clientName
+		if cryptoKey.ProtectionLevel == "" {
+			cryptoKey.ProtectionLevel = DefaultProtectionLevel
+		}
+		cryptoKey.KeyRingName, cryptoKey.ProjectId = "", ""
+		if keyRing, ok := kmsConfig.Kms.KeyRings[cryptoKey.KeyRing]; ok {
+			cryptoKey.KeyRingName, cryptoKey.ProjectId = KeyRingName(keyRing), keyRing.ProjectId
+		}
+
+		kmsConfig.Kms.CryptoKeys[name] = cryptoKey
+	}
+	return &kmsConfig, nil
+}
+
+func ValidateConfig(config *Config) error {
+	v := validator.New()
+	if err := v.Struct(config); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			return fmt.Errorf("%s validate failed on the %s rule", err.Namespace(), err.Tag())
+		}
+	}
+	for key, cryptoKey := range config.Kms.CryptoKeys {
+		if err := common.ValidateLabels(cryptoKey.Labels); err != nil {
+			return fmt.Errorf("crypto key %s: %s", key, err)
+		}
+		if cryptoKey.KeyRingName == "" {
+			return fmt.Errorf("crypto key %s: key ring %s is not declared in kms", key, cryptoKey.KeyRing)
+		}
+		if cryptoKey.RotationPeriod != "" {
+			period, err := time.ParseDuration(cryptoKey.RotationPeriod)
+			if err != nil {
+				return fmt.Errorf("crypto key %s: invalid rotation period %s", key, cryptoKey.RotationPeriod)
+			}
+			if period < minRotationPeriod {
+				return fmt.Errorf("crypto key %s: rotation period must be at least %s", key, minRotationPeriod)
+			}
+		}
+	}
+	return nil
+}
+
+// KeyRingName returns the full resource name of a key ring.
+func KeyRingName(keyRing KeyRing) string {
+	return fmt.Sprintf("projects/%s/locations/%s/keyRings/%s", keyRing.ProjectId, keyRing.Location, keyRing.Name)
+}
+
+// CryptoKeyName returns the full resource name of a crypto key.
+func CryptoKeyName(cryptoKey CryptoKey) string {
+	return cryptoKey.KeyRingName + "/cryptoKeys/" + cryptoKey.Name
+}
+
+// KeyLocation returns the location of a crypto key from its full name, or an
+// empty string when the name is not a crypto key name.
+func KeyLocation(cryptoKeyName string) string {
+	parts := strings.Split(cryptoKeyName, "/")
+	if len(parts) != 8 || parts[0] != "projects" || parts[2] != "locations" || parts[4] != "keyRings" || parts[6] != "cryptoKeys" {
+		return ""
+	}
+	return parts[3]
+}
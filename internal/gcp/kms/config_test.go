@@ -0,0 +1,99 @@
+package kms
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+var validKmsConfig = []byte(`
+kms:
+  keyRings:
+    main:
+      projectId: some-project
+      location: us-central1
+  cryptoKeys:
+    buckets:
+      keyRing: main
+      rotationPeriod: 2160h
+    signing:
+      keyRing: main
+      protectionLevel: HSM
+      labels:
+        team: security`)
+
+var invalidConfig = []byte(`
+kms:
+  keyRings:
+    main:
+      location:
+        - should_not_be_an_array`)
+
+var _ = Describe("config", func() {
+	BeforeEach(func() {
+		viper.Reset()
+		viper.SetConfigType("yaml")
+	})
+	Describe("GetKmsConfig", func() {
+		It("should successfully parse a KMS config", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(validKmsConfig))
+			Expect(err).ToNot(HaveOccurred())
+			kmsConfig, err := GetKmsConfig(viper.GetViper(), "some-client")
+			Expect(err).To(BeNil())
+			keyRing := kmsConfig.Kms.KeyRings["main"]
+			Expect(KeyRingName(keyRing)).To(Equal("projects/some-project/locations/us-central1/keyRings/some-client-main"))
+			cryptoKey := kmsConfig.Kms.CryptoKeys["buckets"]
+			Expect(cryptoKey.ProtectionLevel).To(Equal("SOFTWARE"))
+			Expect(cryptoKey.ProjectId).To(Equal("some-project"))
+			Expect(CryptoKeyName(cryptoKey)).To(Equal("projects/some-project/locations/us-central1/keyRings/some-client-main/cryptoKeys/some-client-buckets"))
+			Expect(KeyLocation(CryptoKeyName(cryptoKey))).To(Equal("us-central1"))
+			Expect(kmsConfig.Kms.CryptoKeys["signing"].ProtectionLevel).To(Equal("HSM"))
+			Expect(ValidateConfig(kmsConfig)).To(Succeed())
+		})
+		It("returns an error if cannot parse the config", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(invalidConfig))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = GetKmsConfig(viper.GetViper(), "some-client")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("validates crypto keys", func() {
+		var cryptoKey CryptoKey
+
+		BeforeEach(func() {
+			cryptoKey = CryptoKey{
+				Name:            "client-foooo",
+				KeyRing:         "main",
+				KeyRingName:     "projects/mock-project/locations/us-central1/keyRings/client-main",
+				ProtectionLevel: "SOFTWARE",
+				RotationPeriod:  "720h",
+			}
+		})
+
+		It("should not detect error", func() {
+			Expect(ValidateConfig(&Config{Kms: Kms{CryptoKeys: map[string]CryptoKey{"foooo": cryptoKey}}})).To(Succeed())
+		})
+		It("should detect an undeclared key ring", func() {
+			cryptoKey.KeyRingName = ""
+			err := ValidateConfig(&Config{Kms: Kms{CryptoKeys: map[string]CryptoKey{"foooo": cryptoKey}}})
+			Expect(err).To(MatchError("crypto key foooo: key ring main is not declared in kms"))
+		})
+		It("should detect a rotation period that is too short", func() {
+			cryptoKey.RotationPeriod = "1h"
+			err := ValidateConfig(&Config{Kms: Kms{CryptoKeys: map[string]CryptoKey{"foooo": cryptoKey}}})
+			Expect(err).To(MatchError("crypto key foooo: rotation period must be at least 24h0m0s"))
+		})
+		It("should detect an invalid protection level", func() {
+			cryptoKey.ProtectionLevel = "EXTERNAL"
+			err := ValidateConfig(&Config{Kms: Kms{CryptoKeys: map[string]CryptoKey{"foooo": cryptoKey}}})
+			Expect(err).To(MatchError("Config.Kms.CryptoKeys[foooo].ProtectionLevel validate failed on the oneof rule"))
+		})
+		It("should detect a key ring without location", func() {
+			keyRing := KeyRing{Name: "client-main", ProjectId: "mock-project"}
+			err := ValidateConfig(&Config{Kms: Kms{KeyRings: map[string]KeyRing{"main": keyRing}}})
+			Expect(err).To(MatchError("Config.Kms.KeyRings[main].Location validate failed on the required rule"))
+		})
+	})
+})
@@ -0,0 +1,524 @@
+package kms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Product is the key of the KMS config in a client's config.
+const Product = "kms"
+
+// Prefixes of the keys of the key rings and crypto keys in the plan and state,
+// e.g. keyRings.main or cryptoKeys.buckets.
+const (
+	keyRingsKey   = "keyRings"
+	cryptoKeysKey = "cryptoKeys"
+)
+
+// cryptoKeyUpdateMask lists the properties of a crypto key that can be
+// patched. The protection level of a key cannot be changed.
+const cryptoKeyUpdateMask = "labels,rotationPeriod,nextRotationTime"
+
+const (
+	purposeEncryptDecrypt = "ENCRYPT_DECRYPT"
+	algorithmSymmetric    = "GOOGLE_SYMMETRIC_ENCRYPTION"
+	versionEnabled        = "ENABLED"
+	versionDisabled       = "DISABLED"
+)
+
+type Client struct {
+	kmsService *cloudkms.Service
+}
+
+func NewClient(ctx context.Context, opts ...option.ClientOption) (*Client, error) {
+	kmsService, err := cloudkms.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		kmsService: kmsService,
+	}, nil
+}
+
+// Create creates the key rings and crypto keys of the config that do not exist
+// and updates the crypto keys that do. Key rings have no property to update.
+// It returns the resources that were applied, even when some failed.
+func (c *Client) Create(config *Config) ([]common.AppliedResource, error) {
+	applied, err := c.createKeyRings(config)
+	if err != nil {
+		return applied, err
+	}
+	keysApplied, err := c.createCryptoKeys(config)
+	return append(applied, keysApplied...), err
+}
+
+func (c *Client) createKeyRings(config *Config) ([]common.AppliedResource, error) {
+	createChannel := make(chan common.Response, len(config.Kms.KeyRings))
+	for key, keyRing := range config.Kms.KeyRings {
+		go func(resp chan common.Response, key string, keyRing KeyRing) {
+			name := KeyRingName(keyRing)
+			_, err := c.getKeyRing(name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+					utils.Logger.Debugf("[%s] key ring not found", name)
+
+					if err := c.insertKeyRing(name); err != nil {
+						resp <- common.Response{Err: err}
+						return
+					}
+				} else {
+					utils.Logger.Errorf("[%s] error getting key ring: %s", name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+			}
+			resp <- common.Response{Applied: &common.AppliedResource{
+				Client:  keyRing.ClientName,
+				Product: Product,
+				Key:     keyRingsKey + "." + key,
+				Name:    name,
+				Spec:    &cloudkms.KeyRing{Name: name},
+			}}
+		}(createChannel, key, keyRing)
+	}
+	var applied []common.AppliedResource
+	var createErr error
+	for range config.Kms.KeyRings {
+		resp := <-createChannel
+		if resp.Err != nil {
+			createErr = resp.Err
+			continue
+		}
+		applied = append(applied, *resp.Applied)
+	}
+	return applied, createErr
+}
+
+func (c *Client) createCryptoKeys(config *Config) ([]common.AppliedResource, error) {
+	createChannel := make(chan common.Response, len(config.Kms.CryptoKeys))
+	for key, cryptoKey := range config.Kms.CryptoKeys {
+		go func(resp chan common.Response, key string, cryptoKey CryptoKey) {
+			spec := c.createCryptoKeySpec(cryptoKey)
+			live, err := c.getCryptoKey(spec.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+					utils.Logger.Debugf("[%s] crypto key not found", spec.Name)
+
+					if err := c.insertCryptoKey(spec); err != nil {
+						resp <- common.Response{Err: err}
+						return
+					}
+				} else {
+					utils.Logger.Errorf("[%s] error getting crypto key: %s", spec.Name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+			} else {
+				keepNextRotationTime(live, spec)
+				spec.Labels = common.MergeLabels(live.Labels, spec.Labels)
+				if err := checkImmutable(spec.Name, diffCryptoKey(live, spec)); err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+				if err := c.patchCryptoKey(spec); err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+			}
+			resp <- common.Response{Applied: &common.AppliedResource{
+				Client:  cryptoKey.ClientName,
+				Product: Product,
+				Key:     cryptoKeysKey + "." + key,
+				Name:    spec.Name,
+				Spec:    spec,
+			}}
+		}(createChannel, key, cryptoKey)
+	}
+	var applied []common.AppliedResource
+	var createErr error
+	for range config.Kms.CryptoKeys {
+		resp := <-createChannel
+		if resp.Err != nil {
+			createErr = resp.Err
+			continue
+		}
+		applied = append(applied, *resp.Applied)
+	}
+	return applied, createErr
+}
+
+// Plan compares every key ring and crypto key of the config with its live
+// state and returns the change Create would make to each of them.
+func (c *Client) Plan(config *Config) ([]common.ResourceChange, error) {
+	total := len(config.Kms.KeyRings) + len(config.Kms.CryptoKeys)
+	planChannel := make(chan common.Response, total)
+	for key, keyRing := range config.Kms.KeyRings {
+		go func(resp chan common.Response, key string, keyRing KeyRing) {
+			spec := &cloudkms.KeyRing{Name: KeyRingName(keyRing)}
+			change := common.ResourceChange{
+				Client:  keyRing.ClientName,
+				Product: Product,
+				Key:     keyRingsKey + "." + key,
+				Name:    spec.Name,
+				Project: keyRing.ProjectId,
+			}
+			live, err := c.getKeyRing(spec.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+					utils.Logger.Errorf("[%s] error getting key ring: %s", spec.Name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+				resp <- common.PlanChange(change, spec, nil, nil)
+				return
+			}
+			resp <- common.PlanChange(change, spec, live, nil)
+		}(planChannel, key, keyRing)
+	}
+	for key, cryptoKey := range config.Kms.CryptoKeys {
+		go func(resp chan common.Response, key string, cryptoKey CryptoKey) {
+			spec := c.createCryptoKeySpec(cryptoKey)
+			change := common.ResourceChange{
+				Client:  cryptoKey.ClientName,
+				Product: Product,
+				Key:     cryptoKeysKey + "." + key,
+				Name:    spec.Name,
+				Project: cryptoKey.ProjectId,
+			}
+			live, err := c.getCryptoKey(spec.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+					utils.Logger.Errorf("[%s] error getting crypto key: %s", spec.Name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+				resp <- common.PlanChange(change, spec, nil, nil)
+				return
+			}
+			keepNextRotationTime(live, spec)
+			spec.Labels = common.MergeLabels(live.Labels, spec.Labels)
+			resp <- common.PlanChange(change, spec, live, diffCryptoKey(live, spec))
+		}(planChannel, key, cryptoKey)
+	}
+	var changes []common.ResourceChange
+	var planErr error
+	for i := 0; i < total; i++ {
+		resp := <-planChannel
+		if resp.Err != nil {
+			planErr = resp.Err
+			continue
+		}
+		changes = append(changes, resp.Change)
+	}
+	return changes, planErr
+}
+
+// Verify returns an error if the live key ring or crypto key is not in the
+// state it was in when the change was planned.
+func (c *Client) Verify(change common.ResourceChange) error {
+	var live interface{}
+	var err error
+	if isKeyRing(change) {
+		live, err = c.getKeyRing(change.Name)
+	} else {
+		live, err = c.getCryptoKey(change.Name)
+	}
+	liveHash := ""
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+			return err
+		}
+	} else {
+		if liveHash, err = common.HashResource(live); err != nil {
+			return err
+		}
+	}
+	if liveHash != change.LiveHash {
+		return fmt.Errorf("[%s] %s changed since the plan was made", change.Name, kindOf(change))
+	}
+	return nil
+}
+
+// Apply runs a planned change with the spec saved in the plan. It returns the
+// applied resource, or nil when there was nothing to do.
+func (c *Client) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	if change.Action == common.ActionNoop {
+		return nil, nil
+	}
+	var spec interface{}
+	var err error
+	if isKeyRing(change) {
+		if change.Action != common.ActionCreate {
+			return nil, fmt.Errorf("[%s] unknown action %s", change.Name, change.Action)
+		}
+		spec = &cloudkms.KeyRing{Name: change.Name}
+		err = c.insertKeyRing(change.Name)
+	} else {
+		cryptoKey := &cloudkms.CryptoKey{}
+		if err := json.Unmarshal(change.Desired, cryptoKey); err != nil {
+			return nil, fmt.Errorf("[%s] invalid planned spec: %s", change.Name, err)
+		}
+		switch change.Action {
+		case common.ActionCreate:
+			err = c.insertCryptoKey(cryptoKey)
+		case common.ActionUpdate:
+			if err := checkImmutable(change.Name, change.Diffs); err != nil {
+				return nil, err
+			}
+			err = c.patchCryptoKey(cryptoKey)
+		default:
+			return nil, fmt.Errorf("[%s] unknown action %s", change.Name, change.Action)
+		}
+		spec = cryptoKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &common.AppliedResource{
+		Client:  change.Client,
+		Product: Product,
+		Key:     change.Key,
+		Name:    change.Name,
+		Spec:    spec,
+	}, nil
+}
+
+// Delete schedules the destruction of the versions of every crypto key of the
+// config and stops their rotation. The data encrypted with a destroyed version
+// cannot be decrypted anymore, so keys that still have versions are only
+// destroyed with destroyKeys. Key rings and crypto keys themselves cannot be deleted
+// from GCP and are kept.
+func (c *Client) Delete(config *Config, destroyKeys bool) error {
+	deleteChannel := make(chan common.Response, len(config.Kms.CryptoKeys))
+	for _, cryptoKey := range config.Kms.CryptoKeys {
+		go func(resp chan common.Response, cryptoKey CryptoKey) {
+			name := CryptoKeyName(cryptoKey)
+			versions, err := c.listActiveVersions(name)
+			if err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			if len(versions) > 0 && !destroyKeys {
+				resp <- common.Response{Err: fmt.Errorf("[%s] crypto key has %d versions, use --destroy-keys to destroy them", name, len(versions))}
+				return
+			}
+			for _, version := range versions {
+				if err := c.destroyVersion(version); err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+			}
+			if len(versions) > 0 {
+				err = c.stopRotation(name)
+			}
+			resp <- common.Response{Err: err}
+		}(deleteChannel, cryptoKey)
+	}
+	var deleteErr error
+	for range config.Kms.CryptoKeys {
+		resp := <-deleteChannel
+		if resp.Err != nil {
+			deleteErr = resp.Err
+		}
+	}
+	for _, keyRing := range config.Kms.KeyRings {
+		utils.Logger.Warnf("[%s] key rings cannot be deleted, kept", KeyRingName(keyRing))
+	}
+	return deleteErr
+}
+
+func (c *Client) getKeyRing(name string) (*cloudkms.KeyRing, error) {
+	utils.Logger.Debugf("[%s] getting key ring", name)
+	keyRing, err := c.kmsService.Projects.Locations.KeyRings.Get(name).Do()
+	if err != nil {
+		return nil, err
+	}
+	return keyRing, nil
+}
+
+func (c *Client) insertKeyRing(name string) error {
+	utils.Logger.Infof("[%s] creating key ring", name)
+	parent, keyRingId, _ := strings.Cut(name, "/keyRings/")
+	_, err := c.kmsService.Projects.Locations.KeyRings.Create(parent, &cloudkms.KeyRing{}).KeyRingId(keyRingId).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error creating key ring: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) getCryptoKey(name string) (*cloudkms.CryptoKey, error) {
+	utils.Logger.Debugf("[%s] getting crypto key", name)
+	cryptoKey, err := c.kmsService.Projects.Locations.KeyRings.CryptoKeys.Get(name).Do()
+	if err != nil {
+		return nil, err
+	}
+	return cryptoKey, nil
+}
+
+func (c *Client) insertCryptoKey(spec *cloudkms.CryptoKey) error {
+	utils.Logger.Infof("[%s] creating crypto key", spec.Name)
+	parent, cryptoKeyId, _ := strings.Cut(spec.Name, "/cryptoKeys/")
+	cryptoKey := *spec
+	cryptoKey.Name = ""
+	_, err := c.kmsService.Projects.Locations.KeyRings.CryptoKeys.Create(parent, &cryptoKey).CryptoKeyId(cryptoKeyId).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error creating crypto key: %s", spec.Name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) patchCryptoKey(spec *cloudkms.CryptoKey) error {
+	utils.Logger.Infof("[%s] updating crypto key", spec.Name)
+	_, err := c.kmsService.Projects.Locations.KeyRings.CryptoKeys.Patch(spec.Name, spec).UpdateMask(cryptoKeyUpdateMask).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error updating crypto key: %s", spec.Name, err)
+		return err
+	}
+	return nil
+}
+
+// stopRotation removes the rotation period of a crypto key so that no new
+// version is created once its versions are destroyed.
+func (c *Client) stopRotation(name string) error {
+	utils.Logger.Infof("[%s] stopping crypto key rotation", name)
+	_, err := c.kmsService.Projects.Locations.KeyRings.CryptoKeys.Patch(name, &cloudkms.CryptoKey{}).UpdateMask("rotationPeriod,nextRotationTime").Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error stopping crypto key rotation: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+// listActiveVersions returns the names of the versions of a crypto key that
+// are enabled or disabled, i.e. not destroyed or scheduled for destruction.
+// It returns no version when the key does not exist.
+func (c *Client) listActiveVersions(name string) ([]string, error) {
+	var versions []string
+	err := c.kmsService.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.List(name).Pages(context.Background(), func(page *cloudkms.ListCryptoKeyVersionsResponse) error {
+		for _, version := range page.CryptoKeyVersions {
+			if version.State == versionEnabled || version.State == versionDisabled {
+				versions = append(versions, version.Name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			return nil, nil
+		}
+		utils.Logger.Errorf("[%s] error listing crypto key versions: %s", name, err)
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (c *Client) destroyVersion(name string) error {
+	utils.Logger.Infof("[%s] scheduling crypto key version destruction", name)
+	_, err := c.kmsService.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.Destroy(name, &cloudkms.DestroyCryptoKeyVersionRequest{}).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error destroying crypto key version: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) createCryptoKeySpec(cryptoKey CryptoKey) *cloudkms.CryptoKey {
+	labels := map[string]string{}
+	for key, value := range cryptoKey.Labels {
+		labels[key] = value
+	}
+	for key, value := range common.OwnershipLabels(cryptoKey.ClientName) {
+		labels[key] = value
+	}
+	spec := &cloudkms.CryptoKey{
+		Name:    CryptoKeyName(cryptoKey),
+		Purpose: purposeEncryptDecrypt,
+		Labels:  labels,
+		VersionTemplate: &cloudkms.CryptoKeyVersionTemplate{
+			Algorithm:       algorithmSymmetric,
+			ProtectionLevel: cryptoKey.ProtectionLevel,
+		},
+	}
+	if cryptoKey.RotationPeriod != "" {
+		period, err := time.ParseDuration(cryptoKey.RotationPeriod)
+		if err == nil {
+			spec.RotationPeriod = fmt.Sprintf("%ds", int64(period.Seconds()))
+			spec.NextRotationTime = time.Now().UTC().Add(period).Format(time.RFC3339)
+		}
+	}
+	return spec
+}
+
+// keepNextRotationTime keeps the next rotation time of the live crypto key
+// when its rotation period does not change, so that it is not pushed back on
+// every update.
+func keepNextRotationTime(live *cloudkms.CryptoKey, spec *cloudkms.CryptoKey) {
+	if spec.RotationPeriod != "" && live.NextRotationTime != "" && sameDuration(live.RotationPeriod, spec.RotationPeriod) {
+		spec.NextRotationTime = live.NextRotationTime
+	}
+}
+
+// diffCryptoKey lists the properties of the desired spec that differ from the
+// live crypto key.
+func diffCryptoKey(live *cloudkms.CryptoKey, desired *cloudkms.CryptoKey) []common.FieldDiff {
+	var diffs []common.FieldDiff
+	diffs = common.AppendDiff(diffs, "protectionLevel", protectionLevel(live), protectionLevel(desired))
+	if !sameDuration(live.RotationPeriod, desired.RotationPeriod) {
+		diffs = append(diffs, common.FieldDiff{Field: "rotationPeriod", Current: live.RotationPeriod, Desired: desired.RotationPeriod})
+	}
+	diffs = common.AppendLabelDiffs(diffs, "labels", live.Labels, desired.Labels)
+	return diffs
+}
+
+// checkImmutable returns an error if the diffs change the protection level of
+// a crypto key, which cannot be patched.
+func checkImmutable(name string, diffs []common.FieldDiff) error {
+	for _, diff := range diffs {
+		if diff.Field == "protectionLevel" {
+			return fmt.Errorf("[%s] the protection level of a crypto key cannot be changed from %v to %v, declare a new key", name, diff.Current, diff.Desired)
+		}
+	}
+	return nil
+}
+
+func protectionLevel(cryptoKey *cloudkms.CryptoKey) string {
+	if cryptoKey.VersionTemplate == nil || cryptoKey.VersionTemplate.ProtectionLevel == "" {
+		return DefaultProtectionLevel
+	}
+	return cryptoKey.VersionTemplate.ProtectionLevel
+}
+
+// sameDuration compares two durations such as 86400s, GCP may return them
+// with a different precision.
+func sameDuration(a, b string) bool {
+	durationA, errA := time.ParseDuration(a)
+	durationB, errB := time.ParseDuration(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return durationA == durationB
+}
+
+func isKeyRing(change common.ResourceChange) bool {
+	return strings.HasPrefix(change.Key, keyRingsKey+".")
+}
+
+// kindOf returns the kind of the resource of a planned change.
+func kindOf(change common.ResourceChange) string {
+	if isKeyRing(change) {
+		return "key ring"
+	}
+	return "crypto key"
+}
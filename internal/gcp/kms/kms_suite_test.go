@@ -0,0 +1,13 @@
+package kms_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestKms(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Kms Suite")
+}
@@ -0,0 +1,219 @@
+package kms
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Helper method to create client
+func getMockedClient(url string) *Client {
+	client, err := NewClient(context.Background(), option.WithoutAuthentication(), option.WithEndpoint(url))
+	if err != nil {
+		Fail(err.Error())
+	}
+	return client
+}
+
+var _ = Describe("KMS client", func() {
+	var keyRing KeyRing
+	var cryptoKey CryptoKey
+	var keyRingName string
+	var cryptoKeyName string
+
+	BeforeEach(func() {
+		keyRing = KeyRing{
+			Name:       "banane-main",
+			ProjectId:  "projet-123",
+			Location:   "us-central1",
+			ClientName: "banane",
+		}
+		keyRingName = "projects/projet-123/locations/us-central1/keyRings/banane-main"
+		cryptoKey = CryptoKey{
+			Name:            "banane-buckets",
+			KeyRing:         "main",
+			RotationPeriod:  "720h",
+			ProtectionLevel: "SOFTWARE",
+			KeyRingName:     keyRingName,
+			ProjectId:       "projet-123",
+			ClientName:      "banane",
+		}
+		cryptoKeyName = keyRingName + "/cryptoKeys/banane-buckets"
+	})
+
+	Describe("create crypto key spec", func() {
+		It("creates a symmetric key rotated with the period of the config", func() {
+			client := getMockedClient("http://localhost")
+
+			spec := client.createCryptoKeySpec(cryptoKey)
+			Expect(spec.Name).To(Equal(cryptoKeyName))
+			Expect(spec.Purpose).To(Equal("ENCRYPT_DECRYPT"))
+			Expect(spec.VersionTemplate.ProtectionLevel).To(Equal("SOFTWARE"))
+			Expect(spec.RotationPeriod).To(Equal("2592000s"))
+			Expect(spec.NextRotationTime).ToNot(BeEmpty())
+			Expect(spec.Labels).To(HaveKeyWithValue(common.ClientLabel, "banane"))
+		})
+	})
+	Describe("create", func() {
+		It("creates the missing key ring and then its crypto key", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 4)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+keyRingName+"?")
+				},
+				ResponseCode: 404,
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/projects/projet-123/locations/us-central1/keyRings?") && strings.Contains(url, "keyRingId=banane-main")
+				},
+				Method: "post",
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+cryptoKeyName+"?")
+				},
+				ResponseCode: 404,
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+keyRingName+"/cryptoKeys?") && strings.Contains(url, "cryptoKeyId=banane-buckets")
+				},
+				Method: "post",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			applied, err := client.Create(&Config{Kms: Kms{
+				KeyRings:   map[string]KeyRing{"main": keyRing},
+				CryptoKeys: map[string]CryptoKey{"buckets": cryptoKey},
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(HaveLen(2))
+			Expect(applied[0].Key).To(Equal("keyRings.main"))
+			Expect(applied[1].Key).To(Equal("cryptoKeys.buckets"))
+			Expect(mockServerCalls).To(BeEmpty())
+		})
+		It("refuses to change the protection level of a crypto key", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: cloudkms.CryptoKey{
+					Name:            cryptoKeyName,
+					RotationPeriod:  "2592000s",
+					VersionTemplate: &cloudkms.CryptoKeyVersionTemplate{ProtectionLevel: "SOFTWARE"},
+				},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			cryptoKey.ProtectionLevel = "HSM"
+			_, err := client.Create(&Config{Kms: Kms{CryptoKeys: map[string]CryptoKey{"buckets": cryptoKey}}})
+			Expect(err).To(MatchError(ContainSubstring("the protection level of a crypto key cannot be changed from SOFTWARE to HSM")))
+		})
+	})
+	Describe("plan", func() {
+		It("plans an update of the rotation period and keeps the next rotation time", func() {
+			liveLabels := common.OwnershipLabels("banane")
+			liveLabels["owner"] = "security"
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: cloudkms.CryptoKey{
+					Name:             cryptoKeyName,
+					RotationPeriod:   "7776000s",
+					NextRotationTime: "2026-12-01T00:00:00Z",
+					Labels:           liveLabels,
+					VersionTemplate:  &cloudkms.CryptoKeyVersionTemplate{ProtectionLevel: "SOFTWARE"},
+				},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(&Config{Kms: Kms{CryptoKeys: map[string]CryptoKey{"buckets": cryptoKey}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(HaveLen(1))
+			Expect(changes[0].Action).To(Equal(common.ActionUpdate))
+			Expect(changes[0].Diffs).To(Equal([]common.FieldDiff{{Field: "rotationPeriod", Current: "7776000s", Desired: "2592000s"}}))
+			Expect(string(changes[0].Desired)).ToNot(ContainSubstring("2026-12-01"))
+			Expect(string(changes[0].Desired)).To(ContainSubstring(`"owner":"security"`))
+		})
+		It("plans a no-op for an existing key ring", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: cloudkms.KeyRing{Name: keyRingName},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(&Config{Kms: Kms{KeyRings: map[string]KeyRing{"main": keyRing}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Action).To(Equal(common.ActionNoop))
+			Expect(changes[0].Key).To(Equal("keyRings.main"))
+		})
+	})
+	Describe("delete", func() {
+		It("refuses to destroy the versions of a crypto key without destroyKeys", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+cryptoKeyName+"/cryptoKeyVersions?")
+				},
+				ResponseBody: cloudkms.ListCryptoKeyVersionsResponse{CryptoKeyVersions: []*cloudkms.CryptoKeyVersion{
+					{Name: cryptoKeyName + "/cryptoKeyVersions/1", State: "ENABLED"},
+					{Name: cryptoKeyName + "/cryptoKeyVersions/2", State: "DESTROYED"},
+				}},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			err := client.Delete(&Config{Kms: Kms{CryptoKeys: map[string]CryptoKey{"buckets": cryptoKey}}}, false)
+			Expect(err).To(MatchError(ContainSubstring("crypto key has 1 versions, use --destroy-keys to destroy them")))
+		})
+		It("destroys the versions and stops the rotation with destroyKeys", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 3)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: cloudkms.ListCryptoKeyVersionsResponse{CryptoKeyVersions: []*cloudkms.CryptoKeyVersion{
+					{Name: cryptoKeyName + "/cryptoKeyVersions/1", State: "ENABLED"},
+				}},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+cryptoKeyName+"/cryptoKeyVersions/1:destroy?")
+				},
+				Method: "post",
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+cryptoKeyName+"?") && strings.Contains(url, "updateMask=rotationPeriod")
+				},
+				Method: "patch",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			err := client.Delete(&Config{Kms: Kms{
+				KeyRings:   map[string]KeyRing{"main": keyRing},
+				CryptoKeys: map[string]CryptoKey{"buckets": cryptoKey},
+			}}, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mockServerCalls).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,107 @@
+package kms
+
+import (
+	"context"
+
+	"github.com/spf13/viper"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/provider"
+)
+
+func init() {
+	provider.Register(&kmsProvider{})
+}
+
+// kmsProvider plugs the KMS key rings and crypto keys into the provider
+// registry.
+type kmsProvider struct {
+	client *Client
+}
+
+func (p *kmsProvider) Key() string {
+	return Product
+}
+
+// Before orders the key rings before the crypto keys they hold.
+func (p *kmsProvider) Before(a, b common.ResourceChange) bool {
+	return isKeyRing(a) && !isKeyRing(b)
+}
+
+func (p *kmsProvider) Init(ctx context.Context, opts ...option.ClientOption) error {
+	client, err := NewClient(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	p.client = client
+	return nil
+}
+
+func (p *kmsProvider) GetConfig(viperConfig *viper.Viper, clientName string) (provider.Config, error) {
+	config, err := GetKmsConfig(viperConfig, clientName)
+	if err != nil || config == nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (p *kmsProvider) ValidateConfig(config provider.Config) error {
+	return ValidateConfig(config.(*Config))
+}
+
+func (p *kmsProvider) Resources(config provider.Config) []common.ResourceChange {
+	var resources []common.ResourceChange
+	for key, keyRing := range config.(*Config).Kms.KeyRings {
+		resources = append(resources, common.ResourceChange{
+			Client:  keyRing.ClientName,
+			Product: Product,
+			Key:     keyRingsKey + "." + key,
+			Name:    KeyRingName(keyRing),
+			Project: keyRing.ProjectId,
+		})
+	}
+	for key, cryptoKey := range config.(*Config).Kms.CryptoKeys {
+		resources = append(resources, common.ResourceChange{
+			Client:  cryptoKey.ClientName,
+			Product: Product,
+			Key:     cryptoKeysKey + "." + key,
+			Name:    CryptoKeyName(cryptoKey),
+			Project: cryptoKey.ProjectId,
+		})
+	}
+	return resources
+}
+
+func (p *kmsProvider) Create(config provider.Config) ([]common.AppliedResource, error) {
+	return p.client.Create(config.(*Config))
+}
+
+func (p *kmsProvider) Plan(config provider.Config) ([]common.ResourceChange, error) {
+	return p.client.Plan(config.(*Config))
+}
+
+func (p *kmsProvider) Verify(change common.ResourceChange) error {
+	return p.client.Verify(change)
+}
+
+func (p *kmsProvider) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	return p.client.Apply(change)
+}
+
+// Delete only destroys the versions of the crypto keys with DestroyKeys, key
+// rings and crypto keys are kept.
+func (p *kmsProvider) Delete(config provider.Config, opts provider.DeleteOptions) error {
+	return p.client.Delete(config.(*Config), opts.DestroyKeys)
+}
+
+// DescribeDelete shows that key rings are kept and that the versions of the
+// crypto keys are only destroyed with DestroyKeys.
+func (p *kmsProvider) DescribeDelete(resource common.ResourceChange, opts provider.DeleteOptions) string {
+	if isKeyRing(resource) {
+		return "keep"
+	}
+	if opts.DestroyKeys {
+		return "destroy the versions of"
+	}
+	return "keep"
+}
@@ -0,0 +1,191 @@
+// ©Copyright 2022 Metrio
+package logging
+
+import (
+	"fmt"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/viper"
+	"metrio.net/fougere-lite/internal/gcp/bigquery"
+	"metrio.net/fougere-lite/internal/gcp/cloudstorage"
+)
+
+type Config struct {
+	Logging Logging `mapstructure:"logging" yaml:"logging"`
+}
+
+// Logging is the sinks routing the logs of a client and its log-based metrics.
+type Logging struct {
+	Sinks   map[string]Sink   `json:"sinks" yaml:"sinks,omitempty" validate:"dive"`
+	Metrics map[string]Metric `json:"metrics" yaml:"metrics,omitempty" validate:"dive"`
+}
+
+// Sink routes the logs matching its filter to exactly one of a bucket, a
+// dataset and a destination.
+type Sink struct {
+	// Name is the id of the sink, <client>-<key>.
+	Name        string `json:"name" yaml:"-" validate:"required"`
+	ProjectId   string `json:"projectId" yaml:"projectId" validate:"required"`
+	Filter      string `json:"filter" yaml:"filter,omitempty"`
+	Description string `json:"description" yaml:"description,omitempty"`
+	Disabled    bool   `json:"disabled" yaml:"disabled,omitempty"`
+	// Bucket is the key of a bucket of the client's storageBucket config.
+	Bucket string `json:"bucket" yaml:"bucket,omitempty"`
+	// Dataset is the key of a dataset of the client's bigqueryDatasets config.
+	Dataset string `json:"dataset" yaml:"dataset,omitempty"`
+	// Destination is a full sink destination, e.g.
+	// pubsub.googleapis.com/projects/<project>/topics/<topic>. Access to it is
+	// not granted by fougere-lite.
+	Destination string `json:"destination" yaml:"destination,omitempty"`
+	// UsePartitionedTables writes to partitioned tables in a dataset.
+	UsePartitionedTables bool        `json:"usePartitionedTables" yaml:"usePartitionedTables,omitempty"`
+	Exclusions           []Exclusion `json:"exclusions" yaml:"exclusions,omitempty" validate:"dive"`
+	// Target is the bucket or dataset the writer identity of the sink is
+	// granted access on, resolved by GetLoggingConfig.
+	Target     *Target `json:"-" yaml:"-"`
+	ClientName string  `yaml:"-"`
+}
+
+// Exclusion drops the logs matching its filter from a sink.
+type Exclusion struct {
+	Name        string `json:"name" yaml:"name" validate:"required"`
+	Filter      string `json:"filter" yaml:"filter" validate:"required"`
+	Description string `json:"description" yaml:"description,omitempty"`
+	Disabled    bool   `json:"disabled" yaml:"disabled,omitempty"`
+}
+
+// Metric is a counter of the log entries matching its filter.
+type Metric struct {
+	// Name is the id of the metric, <client>-<key>.
+	Name        string `json:"name" yaml:"-" validate:"required"`
+	ProjectId   string `json:"projectId" yaml:"projectId" validate:"required"`
+	Filter      string `json:"filter" yaml:"filter" validate:"required"`
+	Description string `json:"description" yaml:"description,omitempty"`
+	// LabelExtractors maps the labels of the metric to the expressions
+	// extracting their value from the log entries, e.g.
+	// EXTRACT(jsonPayload.status).
+	LabelExtractors map[string]string `json:"labelExtractors" yaml:"labelExtractors,omitempty"`
+	ClientName      string            `yaml:"-"`
+}
+
+// Target is a managed bucket or dataset a sink writes to.
+type Target struct {
+	Kind      string `json:"kind"`
+	ProjectId string `json:"projectId"`
+	// Name is the name of the bucket or the id of the dataset.
+	Name string `json:"name"`
+}
+
+// Kinds of the destinations the writer identity of a sink is granted access
+// on.
+const (
+	TargetBucket  = "bucket"
+	TargetDataset = "dataset"
+)
+
+// GetLoggingConfig parses the sinks and metrics of a client. The buckets and
+// datasets referenced by key are resolved with the config of the same client.
+func GetLoggingConfig(viperConfig *viper.Viper, clientName string) (*Config, error) {
+	if viperConfig == nil {
+		return nil, nil
+	}
+
+	var loggingConfig Config
+	err := viperConfig.Unmarshal(&loggingConfig)
+	if err != nil {
+		return nil, err
+	}
+	storageConfig, err := cloudstorage.GetStorageConfig(viperConfig, clientName)
+	if err != nil {
+		return nil, err
+	}
+	bigqueryConfig, err := bigquery.GetBigQueryConfig(viperConfig, clientName)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, sink := range loggingConfig.Logging.Sinks {
+		sink.Name = clientName + "-" + name
+		sink.ClientName = clientName
+		sink.Target = nil
+		if bucket, ok := storageConfig.StorageBuckets[sink.Bucket]; ok && sink.Bucket != "" {
+			sink.Target = &Target{Kind: TargetBucket, ProjectId: bucket.ProjectId, Name: bucket.Name}
+		} else if dataset, ok := bigqueryConfig.Datasets[sink.Dataset]; ok && sink.Dataset != "" {
+			sink.Target = &Target{Kind: TargetDataset, ProjectId: dataset.ProjectId, Name: dataset.Name}
+		}
+
+		loggingConfig.Logging.Sinks[name] = sink
+	}
+	for name, metric := range loggingConfig.Logging.Metrics {
+		metric.Name = clientName + "-" + name
+		metric.ClientName = clientName
+
+		loggingConfig.Logging.Metrics[name] = metric
+	}
+	return &loggingConfig, nil
+}
+
+func ValidateConfig(config *Config) error {
+	v := validator.New()
+	if err := v.Struct(config); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			return fmt.Errorf("%s validate failed on the %s rule", err.Namespace(), err.Tag())
+		}
+	}
+	for key, sink := range config.Logging.Sinks {
+		set := 0
+		for _, destination := range []string{sink.Bucket, sink.Dataset, sink.Destination} {
+			if destination != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("sink %s: exactly one of bucket, dataset and destination must be set", key)
+		}
+		if sink.Bucket != "" && sink.Target == nil {
+			return fmt.Errorf("sink %s: bucket %s is not declared in storageBucket", key, sink.Bucket)
+		}
+		if sink.Dataset != "" && sink.Target == nil {
+			return fmt.Errorf("sink %s: dataset %s is not declared in bigqueryDatasets", key, sink.Dataset)
+		}
+		if sink.UsePartitionedTables && sink.Dataset == "" {
+			return fmt.Errorf("sink %s: usePartitionedTables requires a dataset", key)
+		}
+		names := map[string]bool{}
+		for _, exclusion := range sink.Exclusions {
+			if names[exclusion.Name] {
+				return fmt.Errorf("sink %s: exclusion %s is declared twice", key, exclusion.Name)
+			}
+			names[exclusion.Name] = true
+		}
+	}
+	return nil
+}
+
+// SinkName returns the full resource name of a sink.
+func SinkName(sink Sink) string {
+	return "projects/" + sink.ProjectId + "/sinks/" + sink.Name
+}
+
+// MetricName returns the full resource name of a log-based metric.
+func MetricName(metric Metric) string {
+	return "projects/" + metric.ProjectId + "/metrics/" + metric.Name
+}
+
+// MetricType returns the type of the Cloud Monitoring metric of a log-based
+// metric.
+func MetricType(metric Metric) string {
+	return "logging.googleapis.com/user/" + metric.Name
+}
+
+// SinkDestination returns the destination of a sink in the format of the
+// Logging API.
+func SinkDestination(sink Sink) string {
+	if sink.Target == nil {
+		return sink.Destination
+	}
+	if sink.Target.Kind == TargetBucket {
+		return "storage.googleapis.com/" + sink.Target.Name
+	}
+	return "bigquery.googleapis.com/projects/" + sink.Target.ProjectId + "/datasets/" + sink.Target.Name
+}
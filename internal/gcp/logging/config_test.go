@@ -0,0 +1,126 @@
+package logging
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+var validLoggingConfig = []byte(`
+storageBucket:
+  logs:
+    region: us-central1
+    projectId: some-project
+bigqueryDatasets:
+  analytics:
+    projectId: some-project
+    location: US
+logging:
+  sinks:
+    archive:
+      projectId: some-project
+      filter: labels.client="some-client"
+      bucket: logs
+      exclusions:
+        - name: no-debug
+          filter: severity<INFO
+    analytics:
+      projectId: some-project
+      filter: labels.client="some-client" AND severity>=WARNING
+      dataset: analytics
+      usePartitionedTables: true
+    alerts:
+      projectId: some-project
+      destination: pubsub.googleapis.com/projects/some-project/topics/alerts
+  metrics:
+    errors:
+      projectId: some-project
+      filter: labels.client="some-client" AND severity>=ERROR
+      labelExtractors:
+        queue: EXTRACT(jsonPayload.queue)`)
+
+var invalidConfig = []byte(`
+logging:
+  sinks:
+    archive:
+      filter:
+        - should_not_be_an_array`)
+
+var _ = Describe("config", func() {
+	BeforeEach(func() {
+		viper.Reset()
+		viper.SetConfigType("yaml")
+	})
+	Describe("GetLoggingConfig", func() {
+		It("should successfully parse a logging config", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(validLoggingConfig))
+			Expect(err).ToNot(HaveOccurred())
+			loggingConfig, err := GetLoggingConfig(viper.GetViper(), "some-client")
+			Expect(err).To(BeNil())
+			archive := loggingConfig.Logging.Sinks["archive"]
+			Expect(SinkName(archive)).To(Equal("projects/some-project/sinks/some-client-archive"))
+			Expect(archive.Target).To(Equal(&Target{Kind: TargetBucket, ProjectId: "some-project", Name: "some-client-logs-some-project"}))
+			Expect(SinkDestination(archive)).To(Equal("storage.googleapis.com/some-client-logs-some-project"))
+			Expect(archive.Exclusions).To(Equal([]Exclusion{{Name: "no-debug", Filter: "severity<INFO"}}))
+			analytics := loggingConfig.Logging.Sinks["analytics"]
+			Expect(SinkDestination(analytics)).To(Equal("bigquery.googleapis.com/projects/some-project/datasets/some_client_analytics"))
+			alerts := loggingConfig.Logging.Sinks["alerts"]
+			Expect(alerts.Target).To(BeNil())
+			Expect(SinkDestination(alerts)).To(Equal("pubsub.googleapis.com/projects/some-project/topics/alerts"))
+			errors := loggingConfig.Logging.Metrics["errors"]
+			Expect(MetricName(errors)).To(Equal("projects/some-project/metrics/some-client-errors"))
+			Expect(MetricType(errors)).To(Equal("logging.googleapis.com/user/some-client-errors"))
+			Expect(errors.LabelExtractors).To(HaveKeyWithValue("queue", "EXTRACT(jsonPayload.queue)"))
+			Expect(ValidateConfig(loggingConfig)).To(Succeed())
+		})
+		It("returns an error if cannot parse the config", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(invalidConfig))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = GetLoggingConfig(viper.GetViper(), "some-client")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("validates sinks", func() {
+		var sink Sink
+
+		BeforeEach(func() {
+			sink = Sink{
+				Name:      "client-foooo",
+				ProjectId: "mock-project",
+				Bucket:    "logs",
+				Target:    &Target{Kind: TargetBucket, ProjectId: "mock-project", Name: "client-logs-mock-project"},
+			}
+		})
+
+		It("should not detect error", func() {
+			Expect(ValidateConfig(&Config{Logging: Logging{Sinks: map[string]Sink{"foooo": sink}}})).To(Succeed())
+		})
+		It("should detect a sink with two destinations", func() {
+			sink.Destination = "pubsub.googleapis.com/projects/mock-project/topics/logs"
+			err := ValidateConfig(&Config{Logging: Logging{Sinks: map[string]Sink{"foooo": sink}}})
+			Expect(err).To(MatchError("sink foooo: exactly one of bucket, dataset and destination must be set"))
+		})
+		It("should detect an undeclared bucket", func() {
+			sink.Target = nil
+			err := ValidateConfig(&Config{Logging: Logging{Sinks: map[string]Sink{"foooo": sink}}})
+			Expect(err).To(MatchError("sink foooo: bucket logs is not declared in storageBucket"))
+		})
+		It("should detect partitioned tables without dataset", func() {
+			sink.UsePartitionedTables = true
+			err := ValidateConfig(&Config{Logging: Logging{Sinks: map[string]Sink{"foooo": sink}}})
+			Expect(err).To(MatchError("sink foooo: usePartitionedTables requires a dataset"))
+		})
+		It("should detect an exclusion declared twice", func() {
+			sink.Exclusions = []Exclusion{{Name: "debug", Filter: "severity<INFO"}, {Name: "debug", Filter: "severity=DEBUG"}}
+			err := ValidateConfig(&Config{Logging: Logging{Sinks: map[string]Sink{"foooo": sink}}})
+			Expect(err).To(MatchError("sink foooo: exclusion debug is declared twice"))
+		})
+		It("should detect a metric without filter", func() {
+			metric := Metric{Name: "client-errors", ProjectId: "mock-project"}
+			err := ValidateConfig(&Config{Logging: Logging{Metrics: map[string]Metric{"errors": metric}}})
+			Expect(err).To(MatchError("Config.Logging.Metrics[errors].Filter validate failed on the required rule"))
+		})
+	})
+})
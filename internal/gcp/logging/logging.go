@@ -0,0 +1,641 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/logging/v2"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/gcp/iam/iampolicy"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Product is the key of the logging config in a client's config.
+const Product = "logging"
+
+// Prefixes of the keys of the sinks and metrics in the plan and state, e.g.
+// sinks.audit or metrics.errors.
+const (
+	sinksKey   = "sinks"
+	metricsKey = "metrics"
+)
+
+// sinkUpdateMask lists the properties of a sink that are updated. Properties of
+// the mask that are empty in the spec are cleared.
+const sinkUpdateMask = "destination,filter,description,disabled,exclusions,bigqueryOptions"
+
+// Access granted to the writer identity of a sink on its bucket or dataset.
+const (
+	bucketWriterRole  = "roles/storage.objectCreator"
+	datasetWriterRole = "WRITER"
+)
+
+type Client struct {
+	loggingService  *logging.Service
+	storageService  *storage.Service
+	bigqueryService *bigquery.Service
+}
+
+func NewClient(ctx context.Context, opts ...option.ClientOption) (*Client, error) {
+	loggingService, err := logging.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	storageService, err := storage.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	bigqueryService, err := bigquery.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		loggingService:  loggingService,
+		storageService:  storageService,
+		bigqueryService: bigqueryService,
+	}, nil
+}
+
+// plannedSink is the desired state of a sink saved in a plan, with the bucket
+// or dataset its writer identity is granted access on.
+type plannedSink struct {
+	Sink   *logging.LogSink `json:"sink"`
+	Target *Target          `json:"target,omitempty"`
+}
+
+// Create creates the sinks and metrics of the config that do not exist and
+// updates the others. The writer identity of each sink is then granted access
+// on its bucket or dataset, without which the sink drops its logs. It returns
+// the resources that were applied, even when some failed.
+func (c *Client) Create(config *Config) ([]common.AppliedResource, error) {
+	total := len(config.Logging.Sinks) + len(config.Logging.Metrics)
+	createChannel := make(chan common.Response, total)
+	for key, sink := range config.Logging.Sinks {
+		go func(resp chan common.Response, key string, sink Sink) {
+			name := SinkName(sink)
+			spec := c.createSinkSpec(sink)
+			_, err := c.getSink(name)
+			var applied *logging.LogSink
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+					utils.Logger.Debugf("[%s] sink not found", name)
+
+					applied, err = c.insertSink(sink.ProjectId, spec)
+				} else {
+					utils.Logger.Errorf("[%s] error getting sink: %s", name, err)
+				}
+			} else {
+				applied, err = c.updateSink(name, spec)
+			}
+			if err == nil {
+				err = c.grantWriter(name, applied.WriterIdentity, sink.Target)
+			}
+			if err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			resp <- common.Response{Applied: &common.AppliedResource{
+				Client:  sink.ClientName,
+				Product: Product,
+				Key:     sinksKey + "." + key,
+				Name:    name,
+				Spec:    &plannedSink{Sink: spec, Target: sink.Target},
+			}}
+		}(createChannel, key, sink)
+	}
+	for key, metric := range config.Logging.Metrics {
+		go func(resp chan common.Response, key string, metric Metric) {
+			name := MetricName(metric)
+			spec := c.createMetricSpec(metric)
+			_, err := c.getMetric(name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+					utils.Logger.Debugf("[%s] metric not found", name)
+
+					err = c.insertMetric(metric.ProjectId, spec)
+				} else {
+					utils.Logger.Errorf("[%s] error getting metric: %s", name, err)
+				}
+			} else {
+				err = c.updateMetric(name, spec)
+			}
+			if err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			resp <- common.Response{Applied: &common.AppliedResource{
+				Client:  metric.ClientName,
+				Product: Product,
+				Key:     metricsKey + "." + key,
+				Name:    name,
+				Spec:    spec,
+			}}
+		}(createChannel, key, metric)
+	}
+	var applied []common.AppliedResource
+	var createErr error
+	for i := 0; i < total; i++ {
+		resp := <-createChannel
+		if resp.Err != nil {
+			createErr = resp.Err
+			continue
+		}
+		applied = append(applied, *resp.Applied)
+	}
+	return applied, createErr
+}
+
+// Plan compares every sink and metric of the config with its live state and
+// returns the change Create would make to each of them. A sink whose writer
+// identity has no access on its bucket or dataset is planned as an update.
+func (c *Client) Plan(config *Config) ([]common.ResourceChange, error) {
+	total := len(config.Logging.Sinks) + len(config.Logging.Metrics)
+	planChannel := make(chan common.Response, total)
+	for key, sink := range config.Logging.Sinks {
+		go func(resp chan common.Response, key string, sink Sink) {
+			spec := c.createSinkSpec(sink)
+			change := common.ResourceChange{
+				Client:  sink.ClientName,
+				Product: Product,
+				Key:     sinksKey + "." + key,
+				Name:    SinkName(sink),
+				Project: sink.ProjectId,
+			}
+			planned := &plannedSink{Sink: spec, Target: sink.Target}
+			live, err := c.getSink(change.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+					utils.Logger.Errorf("[%s] error getting sink: %s", change.Name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+				resp <- common.PlanChange(change, planned, nil, nil)
+				return
+			}
+			diffs := diffSink(live, spec)
+			if sink.Target != nil {
+				granted, err := c.hasWriter(live.WriterIdentity, sink.Target)
+				if err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+				if !granted {
+					diffs = append(diffs, common.FieldDiff{Field: "writerIdentity", Current: "no access", Desired: "access on " + sink.Target.Kind + " " + sink.Target.Name})
+				}
+			}
+			resp <- common.PlanChange(change, planned, live, diffs)
+		}(planChannel, key, sink)
+	}
+	for key, metric := range config.Logging.Metrics {
+		go func(resp chan common.Response, key string, metric Metric) {
+			spec := c.createMetricSpec(metric)
+			change := common.ResourceChange{
+				Client:  metric.ClientName,
+				Product: Product,
+				Key:     metricsKey + "." + key,
+				Name:    MetricName(metric),
+				Project: metric.ProjectId,
+			}
+			live, err := c.getMetric(change.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+					utils.Logger.Errorf("[%s] error getting metric: %s", change.Name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+				resp <- common.PlanChange(change, spec, nil, nil)
+				return
+			}
+			resp <- common.PlanChange(change, spec, live, diffMetric(live, spec))
+		}(planChannel, key, metric)
+	}
+	var changes []common.ResourceChange
+	var planErr error
+	for i := 0; i < total; i++ {
+		resp := <-planChannel
+		if resp.Err != nil {
+			planErr = resp.Err
+			continue
+		}
+		changes = append(changes, resp.Change)
+	}
+	return changes, planErr
+}
+
+// Verify returns an error if the live sink or metric is not in the state it
+// was in when the change was planned.
+func (c *Client) Verify(change common.ResourceChange) error {
+	var live interface{}
+	var err error
+	if isSink(change) {
+		live, err = c.getSink(change.Name)
+	} else {
+		live, err = c.getMetric(change.Name)
+	}
+	liveHash := ""
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+			return err
+		}
+	} else {
+		if liveHash, err = common.HashResource(live); err != nil {
+			return err
+		}
+	}
+	if liveHash != change.LiveHash {
+		return fmt.Errorf("[%s] %s changed since the plan was made", change.Name, kindOf(change))
+	}
+	return nil
+}
+
+// Apply runs a planned change with the spec saved in the plan. The writer
+// identity of a sink is granted access on its bucket or dataset. It returns the
+// applied resource, or nil when there was nothing to do.
+func (c *Client) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	if change.Action == common.ActionNoop {
+		return nil, nil
+	}
+	if change.Action != common.ActionCreate && change.Action != common.ActionUpdate {
+		return nil, fmt.Errorf("[%s] unknown action %s", change.Name, change.Action)
+	}
+	var spec interface{}
+	if isSink(change) {
+		planned := &plannedSink{}
+		if err := json.Unmarshal(change.Desired, planned); err != nil || planned.Sink == nil {
+			return nil, fmt.Errorf("[%s] invalid planned spec: %v", change.Name, err)
+		}
+		var applied *logging.LogSink
+		var err error
+		if change.Action == common.ActionCreate {
+			applied, err = c.insertSink(change.Project, planned.Sink)
+		} else {
+			applied, err = c.updateSink(change.Name, planned.Sink)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := c.grantWriter(change.Name, applied.WriterIdentity, planned.Target); err != nil {
+			return nil, err
+		}
+		spec = planned
+	} else {
+		metric := &logging.LogMetric{}
+		if err := json.Unmarshal(change.Desired, metric); err != nil {
+			return nil, fmt.Errorf("[%s] invalid planned spec: %s", change.Name, err)
+		}
+		var err error
+		if change.Action == common.ActionCreate {
+			err = c.insertMetric(change.Project, metric)
+		} else {
+			err = c.updateMetric(change.Name, metric)
+		}
+		if err != nil {
+			return nil, err
+		}
+		spec = metric
+	}
+	return &common.AppliedResource{
+		Client:  change.Client,
+		Product: Product,
+		Key:     change.Key,
+		Name:    change.Name,
+		Spec:    spec,
+	}, nil
+}
+
+// Delete deletes every sink and metric of the config. The access granted to
+// the writer identities is left on the buckets and datasets, the identities
+// are deleted with their sink. Resources that do not exist are ignored.
+func (c *Client) Delete(config *Config) error {
+	var names []string
+	for _, sink := range config.Logging.Sinks {
+		names = append(names, SinkName(sink))
+	}
+	for _, metric := range config.Logging.Metrics {
+		names = append(names, MetricName(metric))
+	}
+	deleteChannel := make(chan common.Response, len(names))
+	for _, name := range names {
+		go func(resp chan common.Response, name string) {
+			resp <- common.Response{Err: c.delete(name)}
+		}(deleteChannel, name)
+	}
+	var deleteErr error
+	for range names {
+		resp := <-deleteChannel
+		if resp.Err != nil {
+			deleteErr = resp.Err
+		}
+	}
+	return deleteErr
+}
+
+func (c *Client) getSink(name string) (*logging.LogSink, error) {
+	utils.Logger.Debugf("[%s] getting sink", name)
+	sink, err := c.loggingService.Projects.Sinks.Get(name).Do()
+	if err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// insertSink creates a sink with its own writer identity, so that access is
+// only granted to the sinks of the client.
+func (c *Client) insertSink(projectId string, spec *logging.LogSink) (*logging.LogSink, error) {
+	name := "projects/" + projectId + "/sinks/" + spec.Name
+	utils.Logger.Infof("[%s] creating sink", name)
+	sink, err := c.loggingService.Projects.Sinks.Create("projects/"+projectId, spec).UniqueWriterIdentity(true).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error creating sink: %s", name, err)
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (c *Client) updateSink(name string, spec *logging.LogSink) (*logging.LogSink, error) {
+	utils.Logger.Infof("[%s] updating sink", name)
+	sink, err := c.loggingService.Projects.Sinks.Update(name, spec).UniqueWriterIdentity(true).UpdateMask(sinkUpdateMask).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error updating sink: %s", name, err)
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (c *Client) getMetric(name string) (*logging.LogMetric, error) {
+	utils.Logger.Debugf("[%s] getting metric", name)
+	metric, err := c.loggingService.Projects.Metrics.Get(name).Do()
+	if err != nil {
+		return nil, err
+	}
+	return metric, nil
+}
+
+func (c *Client) insertMetric(projectId string, spec *logging.LogMetric) error {
+	name := "projects/" + projectId + "/metrics/" + spec.Name
+	utils.Logger.Infof("[%s] creating metric", name)
+	_, err := c.loggingService.Projects.Metrics.Create("projects/"+projectId, spec).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error creating metric: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) updateMetric(name string, spec *logging.LogMetric) error {
+	utils.Logger.Infof("[%s] updating metric", name)
+	_, err := c.loggingService.Projects.Metrics.Update(name, spec).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error updating metric: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+// delete deletes a sink or a metric from its full name.
+func (c *Client) delete(name string) error {
+	var err error
+	if strings.Contains(name, "/sinks/") {
+		utils.Logger.Infof("[%s] deleting sink", name)
+		_, err = c.loggingService.Projects.Sinks.Delete(name).Do()
+	} else {
+		utils.Logger.Infof("[%s] deleting metric", name)
+		_, err = c.loggingService.Projects.Metrics.Delete(name).Do()
+	}
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			utils.Logger.Infof("[%s] already deleted", name)
+			return nil
+		}
+		utils.Logger.Errorf("[%s] error deleting: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+// hasWriter returns true if the writer identity of a sink has access on its
+// bucket or dataset. It returns false when the bucket or dataset does not exist
+// yet.
+func (c *Client) hasWriter(writerIdentity string, target *Target) (bool, error) {
+	if writerIdentity == "" {
+		return false, nil
+	}
+	var granted bool
+	var err error
+	if target.Kind == TargetBucket {
+		utils.Logger.Debugf("[%s] getting IAM policy", target.Name)
+		var policy *storage.Policy
+		if policy, err = c.storageService.Buckets.GetIamPolicy(target.Name).OptionsRequestedPolicyVersion(3).Do(); err == nil {
+			granted = !grantBucketMember(policy, writerIdentity)
+		}
+	} else {
+		utils.Logger.Debugf("[%s] getting dataset", target.Name)
+		var dataset *bigquery.Dataset
+		if dataset, err = c.bigqueryService.Datasets.Get(target.ProjectId, target.Name).Do(); err == nil {
+			granted = !grantDatasetMember(dataset, writerIdentity)
+		}
+	}
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			return false, nil
+		}
+		utils.Logger.Errorf("[%s] error getting the access of the %s: %s", target.Name, target.Kind, err)
+		return false, err
+	}
+	return granted, nil
+}
+
+// grantWriter grants the writer identity of a sink access on its bucket or
+// dataset with a read-modify-write on the etag of its policy or access
+// entries, retried when another writer changed them in between.
+func (c *Client) grantWriter(sinkName string, writerIdentity string, target *Target) error {
+	if target == nil {
+		utils.Logger.Warnf("[%s] destination is not managed, grant %s access on it", sinkName, writerIdentity)
+		return nil
+	}
+	if writerIdentity == "" {
+		return fmt.Errorf("[%s] sink has no writer identity", sinkName)
+	}
+	return iampolicy.Update(target.Name, func() error {
+		if target.Kind == TargetBucket {
+			return c.grantBucketWriter(target.Name, writerIdentity)
+		}
+		return c.grantDatasetWriter(target.ProjectId, target.Name, writerIdentity)
+	})
+}
+
+func (c *Client) grantBucketWriter(bucket string, writerIdentity string) error {
+	utils.Logger.Debugf("[%s] getting IAM policy", bucket)
+	policy, err := c.storageService.Buckets.GetIamPolicy(bucket).OptionsRequestedPolicyVersion(3).Do()
+	if err != nil {
+		return err
+	}
+	if !grantBucketMember(policy, writerIdentity) {
+		return nil
+	}
+	utils.Logger.Infof("[%s] granting %s to %s", bucket, bucketWriterRole, writerIdentity)
+	_, err = c.storageService.Buckets.SetIamPolicy(bucket, policy).Do()
+	return err
+}
+
+func (c *Client) grantDatasetWriter(projectId string, datasetId string, writerIdentity string) error {
+	utils.Logger.Debugf("[%s] getting dataset", datasetId)
+	dataset, err := c.bigqueryService.Datasets.Get(projectId, datasetId).Do()
+	if err != nil {
+		return err
+	}
+	if !grantDatasetMember(dataset, writerIdentity) {
+		return nil
+	}
+	utils.Logger.Infof("[%s] granting %s to %s", datasetId, datasetWriterRole, writerIdentity)
+	call := c.bigqueryService.Datasets.Patch(projectId, datasetId, &bigquery.Dataset{Access: dataset.Access})
+	call.Header().Set("If-Match", dataset.Etag)
+	_, err = call.Do()
+	return err
+}
+
+// grantBucketMember adds the writer identity to the unconditional binding of
+// the writer role. It returns false when it already had the role.
+func grantBucketMember(policy *storage.Policy, writerIdentity string) bool {
+	for _, binding := range policy.Bindings {
+		if binding.Role != bucketWriterRole || binding.Condition != nil {
+			continue
+		}
+		for _, member := range binding.Members {
+			if member == writerIdentity {
+				return false
+			}
+		}
+		binding.Members = append(binding.Members, writerIdentity)
+		return true
+	}
+	policy.Bindings = append(policy.Bindings, &storage.PolicyBindings{Role: bucketWriterRole, Members: []string{writerIdentity}})
+	return true
+}
+
+// grantDatasetMember adds a writer access entry for the writer identity. It
+// returns false when the dataset already had one.
+func grantDatasetMember(dataset *bigquery.Dataset, writerIdentity string) bool {
+	email := strings.TrimPrefix(writerIdentity, "serviceAccount:")
+	for _, entry := range dataset.Access {
+		if strings.EqualFold(entry.UserByEmail, email) && (entry.Role == datasetWriterRole || entry.Role == "OWNER" || entry.Role == "roles/bigquery.dataEditor" || entry.Role == "roles/bigquery.dataOwner") {
+			return false
+		}
+	}
+	dataset.Access = append(dataset.Access, &bigquery.DatasetAccess{Role: datasetWriterRole, UserByEmail: email})
+	return true
+}
+
+func (c *Client) createSinkSpec(sink Sink) *logging.LogSink {
+	spec := &logging.LogSink{
+		Name:        sink.Name,
+		Destination: SinkDestination(sink),
+		Filter:      sink.Filter,
+		Description: sink.Description,
+		Disabled:    sink.Disabled,
+	}
+	for _, exclusion := range sink.Exclusions {
+		spec.Exclusions = append(spec.Exclusions, &logging.LogExclusion{
+			Name:        exclusion.Name,
+			Filter:      exclusion.Filter,
+			Description: exclusion.Description,
+			Disabled:    exclusion.Disabled,
+		})
+	}
+	if sink.UsePartitionedTables {
+		spec.BigqueryOptions = &logging.BigQueryOptions{UsePartitionedTables: true}
+	}
+	return spec
+}
+
+func (c *Client) createMetricSpec(metric Metric) *logging.LogMetric {
+	spec := &logging.LogMetric{
+		Name:            metric.Name,
+		Filter:          metric.Filter,
+		Description:     metric.Description,
+		LabelExtractors: metric.LabelExtractors,
+		MetricDescriptor: &logging.MetricDescriptor{
+			MetricKind: "DELTA",
+			ValueType:  "INT64",
+		},
+	}
+	for _, label := range sortedKeys(metric.LabelExtractors) {
+		spec.MetricDescriptor.Labels = append(spec.MetricDescriptor.Labels, &logging.LabelDescriptor{Key: label, ValueType: "STRING"})
+	}
+	return spec
+}
+
+// diffSink lists the properties of the desired spec that differ from the live
+// sink.
+func diffSink(live *logging.LogSink, desired *logging.LogSink) []common.FieldDiff {
+	var diffs []common.FieldDiff
+	diffs = common.AppendDiff(diffs, "destination", live.Destination, desired.Destination)
+	diffs = common.AppendDiff(diffs, "filter", live.Filter, desired.Filter)
+	diffs = common.AppendDiff(diffs, "description", live.Description, desired.Description)
+	diffs = common.AppendDiff(diffs, "disabled", live.Disabled, desired.Disabled)
+	diffs = common.AppendDiff(diffs, "exclusions", exclusions(live), exclusions(desired))
+	diffs = common.AppendDiff(diffs, "bigqueryOptions.usePartitionedTables", usePartitionedTables(live), usePartitionedTables(desired))
+	return diffs
+}
+
+// diffMetric lists the properties of the desired spec that differ from the
+// live metric.
+func diffMetric(live *logging.LogMetric, desired *logging.LogMetric) []common.FieldDiff {
+	var diffs []common.FieldDiff
+	diffs = common.AppendDiff(diffs, "filter", live.Filter, desired.Filter)
+	diffs = common.AppendDiff(diffs, "description", live.Description, desired.Description)
+	liveExtractors := live.LabelExtractors
+	if liveExtractors == nil {
+		liveExtractors = map[string]string{}
+	}
+	desiredExtractors := desired.LabelExtractors
+	if desiredExtractors == nil {
+		desiredExtractors = map[string]string{}
+	}
+	diffs = common.AppendDiff(diffs, "labelExtractors", liveExtractors, desiredExtractors)
+	return diffs
+}
+
+// exclusions returns the exclusions of a sink in a comparable form, without
+// their creation and update times.
+func exclusions(sink *logging.LogSink) []string {
+	excluded := []string{}
+	for _, exclusion := range sink.Exclusions {
+		excluded = append(excluded, fmt.Sprintf("%s: %s (disabled: %t, %s)", exclusion.Name, exclusion.Filter, exclusion.Disabled, exclusion.Description))
+	}
+	sort.Strings(excluded)
+	return excluded
+}
+
+func usePartitionedTables(sink *logging.LogSink) bool {
+	return sink.BigqueryOptions != nil && sink.BigqueryOptions.UsePartitionedTables
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isSink(change common.ResourceChange) bool {
+	return strings.HasPrefix(change.Key, sinksKey+".")
+}
+
+// kindOf returns the kind of the resource of a planned change.
+func kindOf(change common.ResourceChange) string {
+	if isSink(change) {
+		return "sink"
+	}
+	return "metric"
+}
@@ -0,0 +1,359 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/logging/v2"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+	"metrio.net/fougere-lite/internal/common"
+	bq "metrio.net/fougere-lite/internal/gcp/bigquery"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Helper method to create client
+func getMockedClient(url string) *Client {
+	client, err := NewClient(context.Background(), option.WithoutAuthentication(), option.WithEndpoint(url))
+	if err != nil {
+		Fail(err.Error())
+	}
+	return client
+}
+
+var _ = Describe("Logging client", func() {
+	var sink Sink
+	var metric Metric
+	var sinkName string
+	var writerIdentity string
+
+	BeforeEach(func() {
+		sink = Sink{
+			Name:       "banane-archive",
+			ProjectId:  "projet-123",
+			Filter:     `labels.client="banane"`,
+			Bucket:     "logs",
+			Exclusions: []Exclusion{{Name: "no-debug", Filter: "severity<INFO"}},
+			Target:     &Target{Kind: TargetBucket, ProjectId: "projet-123", Name: "banane-logs-projet-123"},
+			ClientName: "banane",
+		}
+		metric = Metric{
+			Name:            "banane-errors",
+			ProjectId:       "projet-123",
+			Filter:          `labels.client="banane" AND severity>=ERROR`,
+			LabelExtractors: map[string]string{"queue": "EXTRACT(jsonPayload.queue)"},
+			ClientName:      "banane",
+		}
+		sinkName = "projects/projet-123/sinks/banane-archive"
+		writerIdentity = "serviceAccount:service-42@gcp-sa-logging.iam.gserviceaccount.com"
+	})
+
+	Describe("create metric spec", func() {
+		It("declares a string label for each label extractor", func() {
+			client := getMockedClient("http://localhost")
+
+			spec := client.createMetricSpec(metric)
+			Expect(spec.MetricDescriptor.MetricKind).To(Equal("DELTA"))
+			Expect(spec.MetricDescriptor.ValueType).To(Equal("INT64"))
+			Expect(spec.MetricDescriptor.Labels).To(Equal([]*logging.LabelDescriptor{{Key: "queue", ValueType: "STRING"}}))
+		})
+	})
+	Describe("create sink", func() {
+		It("creates the sink with a unique writer identity and grants it on the bucket", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 4)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v2/"+sinkName+"?")
+				},
+				ResponseCode: 404,
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v2/projects/projet-123/sinks?") && strings.Contains(url, "uniqueWriterIdentity=true")
+				},
+				Method:       "post",
+				ResponseBody: logging.LogSink{Name: "banane-archive", WriterIdentity: writerIdentity},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/banane-logs-projet-123/iam?")
+				},
+				ResponseBody: storage.Policy{Etag: "CAE=", Bindings: []*storage.PolicyBindings{
+					{Role: "roles/storage.admin", Members: []string{"group:ops@example.com"}},
+				}},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/banane-logs-projet-123/iam?")
+				},
+				Method: "put",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			applied, err := client.Create(&Config{Logging: Logging{Sinks: map[string]Sink{"archive": sink}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(HaveLen(1))
+			Expect(applied[0].Key).To(Equal("sinks.archive"))
+			Expect(mockServerCalls).To(BeEmpty())
+		})
+		It("retries the grant when the dataset changed concurrently", func() {
+			sink.Bucket, sink.Dataset = "", "analytics"
+			sink.Target = &Target{Kind: TargetDataset, ProjectId: "projet-123", Name: "banane_analytics"}
+			mockServerCalls := make(chan utils.MockServerCall, 6)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: logging.LogSink{Name: "banane-archive", WriterIdentity: writerIdentity},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v2/"+sinkName+"?") && strings.Contains(url, "updateMask=")
+				},
+				Method:       "put",
+				ResponseBody: logging.LogSink{Name: "banane-archive", WriterIdentity: writerIdentity},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.Contains(url, "/projects/projet-123/datasets/banane_analytics?")
+				},
+				ResponseBody: bigquery.Dataset{Etag: "1"},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				Method:       "patch",
+				ResponseCode: 412,
+			}
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: bigquery.Dataset{Etag: "2"},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.Contains(url, "/projects/projet-123/datasets/banane_analytics?")
+				},
+				Method: "patch",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			_, err := client.Create(&Config{Logging: Logging{Sinks: map[string]Sink{"archive": sink}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mockServerCalls).To(BeEmpty())
+		})
+	})
+	Describe("plan", func() {
+		It("plans an update when the writer identity has no access on the bucket", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 2)
+			mockServerCalls <- utils.MockServerCall{
+				ResponseBody: logging.LogSink{
+					Name:           "banane-archive",
+					Destination:    "storage.googleapis.com/banane-logs-projet-123",
+					Filter:         `labels.client="banane"`,
+					Exclusions:     []*logging.LogExclusion{{Name: "no-debug", Filter: "severity<INFO", CreateTime: "2026-01-01T00:00:00Z"}},
+					WriterIdentity: writerIdentity,
+				},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/banane-logs-projet-123/iam?")
+				},
+				ResponseBody: storage.Policy{Etag: "CAE="},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(&Config{Logging: Logging{Sinks: map[string]Sink{"archive": sink}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(HaveLen(1))
+			Expect(changes[0].Action).To(Equal(common.ActionUpdate))
+			Expect(changes[0].Diffs).To(Equal([]common.FieldDiff{{Field: "writerIdentity", Current: "no access", Desired: "access on bucket banane-logs-projet-123"}}))
+		})
+		It("plans the update of a metric filter", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v2/projects/projet-123/metrics/banane-errors?")
+				},
+				ResponseBody: logging.LogMetric{
+					Name:            "banane-errors",
+					Filter:          "severity>=ERROR",
+					LabelExtractors: map[string]string{"queue": "EXTRACT(jsonPayload.queue)"},
+				},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(&Config{Logging: Logging{Metrics: map[string]Metric{"errors": metric}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Action).To(Equal(common.ActionUpdate))
+			Expect(changes[0].Diffs).To(HaveLen(1))
+			Expect(changes[0].Diffs[0].Field).To(Equal("filter"))
+		})
+	})
+	Describe("apply planned change", func() {
+		It("creates the metric", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v2/projects/projet-123/metrics?")
+				},
+				Method: "post",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			applied, err := client.Apply(common.ResourceChange{
+				Client:  "banane",
+				Product: Product,
+				Key:     "metrics.errors",
+				Name:    "projects/projet-123/metrics/banane-errors",
+				Project: "projet-123",
+				Action:  common.ActionCreate,
+				Desired: []byte(`{"name":"banane-errors","filter":"severity>=ERROR"}`),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied.Key).To(Equal("metrics.errors"))
+		})
+	})
+	Describe("delete", func() {
+		It("deletes the sinks and metrics and ignores the missing ones", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 2)
+			mockServerCalls <- utils.MockServerCall{
+				Method:       "delete",
+				ResponseCode: 404,
+			}
+			mockServerCalls <- utils.MockServerCall{
+				Method: "delete",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			err := client.Delete(&Config{Logging: Logging{
+				Sinks:   map[string]Sink{"archive": sink},
+				Metrics: map[string]Metric{"errors": metric},
+			}})
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	Describe("with the bigquery provider", func() {
+		It("keeps the access of the sink writer when the dataset is updated", func() {
+			server := newDatasetServer(writerIdentity)
+			defer server.Close()
+			client := getMockedClient(server.URL)
+			datasets, err := bq.NewClient(context.Background(), option.WithoutAuthentication(), option.WithEndpoint(server.URL))
+			Expect(err).ToNot(HaveOccurred())
+
+			datasetConfig := &bq.Config{Datasets: map[string]bq.Dataset{"analytics": {
+				Name:       "banane_analytics",
+				ProjectId:  "projet-123",
+				Location:   "US",
+				Access:     []bq.AccessEntry{{Role: "OWNER", UserByEmail: "owner@metrio.net"}},
+				ClientName: "banane",
+			}}}
+			sink.Bucket, sink.Dataset = "", "analytics"
+			sink.Target = &Target{Kind: TargetDataset, ProjectId: "projet-123", Name: "banane_analytics"}
+			sinkConfig := &Config{Logging: Logging{Sinks: map[string]Sink{"archive": sink}}}
+
+			_, err = datasets.Create(datasetConfig)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = client.Create(sinkConfig)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = datasets.Create(datasetConfig)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(server.access()).To(ConsistOf(
+				&bigquery.DatasetAccess{Role: "OWNER", UserByEmail: "owner@metrio.net"},
+				&bigquery.DatasetAccess{Role: datasetWriterRole, UserByEmail: "service-42@gcp-sa-logging.iam.gserviceaccount.com"},
+			))
+			datasetChanges, err := datasets.Plan(datasetConfig)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(datasetChanges).To(HaveLen(1))
+			Expect(datasetChanges[0].Action).To(Equal(common.ActionNoop))
+			sinkChanges, err := client.Plan(sinkConfig)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sinkChanges).To(HaveLen(1))
+			Expect(sinkChanges[0].Action).To(Equal(common.ActionNoop))
+		})
+	})
+})
+
+// datasetServer keeps one sink and one dataset, so that the logging and
+// bigquery clients can both update the dataset. A patch only replaces the
+// properties it sets.
+type datasetServer struct {
+	*httptest.Server
+	mutex          sync.Mutex
+	writerIdentity string
+	sink           *logging.LogSink
+	dataset        map[string]interface{}
+}
+
+func newDatasetServer(writerIdentity string) *datasetServer {
+	server := &datasetServer{writerIdentity: writerIdentity}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+	return server
+}
+
+func (s *datasetServer) access() []*bigquery.DatasetAccess {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	body, err := json.Marshal(s.dataset)
+	Expect(err).ToNot(HaveOccurred())
+	dataset := &bigquery.Dataset{}
+	Expect(json.Unmarshal(body, dataset)).To(Succeed())
+	return dataset.Access
+}
+
+func (s *datasetServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var current interface{}
+	switch {
+	case strings.Contains(r.URL.Path, "/sinks"):
+		if r.Method != http.MethodGet {
+			s.sink = &logging.LogSink{}
+			Expect(json.NewDecoder(r.Body).Decode(s.sink)).To(Succeed())
+			s.sink.WriterIdentity = s.writerIdentity
+		}
+		if s.sink != nil {
+			current = s.sink
+		}
+	case strings.Contains(r.URL.Path, "/datasets"):
+		if r.Method != http.MethodGet {
+			update := map[string]interface{}{}
+			Expect(json.NewDecoder(r.Body).Decode(&update)).To(Succeed())
+			if s.dataset == nil {
+				s.dataset = map[string]interface{}{}
+			}
+			for key, value := range update {
+				s.dataset[key] = value
+			}
+		}
+		if s.dataset != nil {
+			current = s.dataset
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if current == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": {"code": 404, "message": "not found"}}`))
+		return
+	}
+	Expect(json.NewEncoder(w).Encode(current)).To(Succeed())
+}
@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/spf13/viper"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/gcp/bigquery"
+	"metrio.net/fougere-lite/internal/gcp/cloudstorage"
+	"metrio.net/fougere-lite/internal/provider"
+)
+
+func init() {
+	provider.Register(&loggingProvider{})
+}
+
+// loggingProvider plugs the log sinks and log-based metrics into the provider
+// registry.
+type loggingProvider struct {
+	client *Client
+}
+
+func (p *loggingProvider) Key() string {
+	return Product
+}
+
+// DependsOn creates the buckets and datasets before the sinks writing to them.
+func (p *loggingProvider) DependsOn() []string {
+	return []string{cloudstorage.Product, bigquery.Product}
+}
+
+func (p *loggingProvider) Init(ctx context.Context, opts ...option.ClientOption) error {
+	client, err := NewClient(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	p.client = client
+	return nil
+}
+
+func (p *loggingProvider) GetConfig(viperConfig *viper.Viper, clientName string) (provider.Config, error) {
+	config, err := GetLoggingConfig(viperConfig, clientName)
+	if err != nil || config == nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (p *loggingProvider) ValidateConfig(config provider.Config) error {
+	return ValidateConfig(config.(*Config))
+}
+
+func (p *loggingProvider) Resources(config provider.Config) []common.ResourceChange {
+	var resources []common.ResourceChange
+	for key, sink := range config.(*Config).Logging.Sinks {
+		resources = append(resources, common.ResourceChange{
+			Client:  sink.ClientName,
+			Product: Product,
+			Key:     sinksKey + "." + key,
+			Name:    SinkName(sink),
+			Project: sink.ProjectId,
+		})
+	}
+	for key, metric := range config.(*Config).Logging.Metrics {
+		resources = append(resources, common.ResourceChange{
+			Client:  metric.ClientName,
+			Product: Product,
+			Key:     metricsKey + "." + key,
+			Name:    MetricName(metric),
+			Project: metric.ProjectId,
+		})
+	}
+	return resources
+}
+
+func (p *loggingProvider) Create(config provider.Config) ([]common.AppliedResource, error) {
+	return p.client.Create(config.(*Config))
+}
+
+func (p *loggingProvider) Plan(config provider.Config) ([]common.ResourceChange, error) {
+	return p.client.Plan(config.(*Config))
+}
+
+func (p *loggingProvider) Verify(change common.ResourceChange) error {
+	return p.client.Verify(change)
+}
+
+func (p *loggingProvider) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	return p.client.Apply(change)
+}
+
+func (p *loggingProvider) Delete(config provider.Config, opts provider.DeleteOptions) error {
+	return p.client.Delete(config.(*Config))
+}
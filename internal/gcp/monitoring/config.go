@@ -0,0 +1,239 @@
+// ©Copyright 2022 Metrio
+package monitoring
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/viper"
+	"metrio.net/fougere-lite/internal/gcp/cloudstorage"
+	"metrio.net/fougere-lite/internal/gcp/cloudtasks"
+	"metrio.net/fougere-lite/internal/gcp/logging"
+)
+
+type Config struct {
+	Monitoring Monitoring `mapstructure:"monitoring" yaml:"monitoring"`
+}
+
+// Monitoring is the alert policies of a client and the channels they notify.
+// Both are matched with the live resources by display name.
+type Monitoring struct {
+	NotificationChannels map[string]NotificationChannel `json:"notificationChannels" yaml:"notificationChannels,omitempty" validate:"dive"`
+	AlertPolicies        map[string]AlertPolicy         `json:"alertPolicies" yaml:"alertPolicies,omitempty" validate:"dive"`
+}
+
+type NotificationChannel struct {
+	// DisplayName identifies the channel in its project, <client>-<key> by
+	// default.
+	DisplayName string `json:"displayName" yaml:"displayName,omitempty" validate:"required"`
+	ProjectId   string `json:"projectId" yaml:"projectId" validate:"required"`
+	// Type is the type of the channel, e.g. email, slack or pagerduty.
+	Type string `json:"type" yaml:"type" validate:"required"`
+	// Labels configure the channel, e.g. email_address for an email channel.
+	Labels      map[string]string `json:"labels" yaml:"labels,omitempty"`
+	Description string            `json:"description" yaml:"description,omitempty"`
+	Disabled    bool              `json:"disabled" yaml:"disabled,omitempty"`
+	ClientName  string            `yaml:"-"`
+}
+
+type AlertPolicy struct {
+	// DisplayName identifies the policy in its project, <client>-<key> by
+	// default. A policy created by another tool with the same display name is
+	// updated instead of duplicated.
+	DisplayName string `json:"displayName" yaml:"displayName,omitempty" validate:"required"`
+	ProjectId   string `json:"projectId" yaml:"projectId" validate:"required"`
+	// Combiner combines the conditions, OR by default.
+	Combiner   string      `json:"combiner" yaml:"combiner,omitempty" validate:"omitempty,oneof=AND OR AND_WITH_MATCHING_RESOURCE"`
+	Conditions []Condition `json:"conditions" yaml:"conditions" validate:"required,min=1,dive"`
+	// Documentation is the markdown sent with the notifications. It is a
+	// template, see GetMonitoringConfig.
+	Documentation string `json:"documentation" yaml:"documentation,omitempty"`
+	// NotificationChannels are the keys of the channels of the client's
+	// monitoring config.
+	NotificationChannels []string `json:"notificationChannels" yaml:"notificationChannels,omitempty"`
+	Disabled             bool     `json:"disabled" yaml:"disabled,omitempty"`
+	// ChannelDisplayNames are the display names of the notification channels,
+	// resolved by GetMonitoringConfig.
+	ChannelDisplayNames []string `json:"-" yaml:"-"`
+	ClientName          string   `yaml:"-"`
+}
+
+// Condition is met when the time series matching the filter cross the
+// threshold for the duration.
+type Condition struct {
+	DisplayName string `json:"displayName" yaml:"displayName" validate:"required"`
+	// Filter selects the time series, it is a template, see
+	// GetMonitoringConfig.
+	Filter     string  `json:"filter" yaml:"filter" validate:"required"`
+	Comparison string  `json:"comparison" yaml:"comparison" validate:"required,oneof=COMPARISON_GT COMPARISON_GE COMPARISON_LT COMPARISON_LE COMPARISON_EQ COMPARISON_NE"`
+	Threshold  float64 `json:"threshold" yaml:"threshold"`
+	// Duration is how long the threshold must be crossed, e.g. 5m.
+	Duration           string   `json:"duration" yaml:"duration,omitempty"`
+	AlignmentPeriod    string   `json:"alignmentPeriod" yaml:"alignmentPeriod,omitempty"`
+	PerSeriesAligner   string   `json:"perSeriesAligner" yaml:"perSeriesAligner,omitempty"`
+	CrossSeriesReducer string   `json:"crossSeriesReducer" yaml:"crossSeriesReducer,omitempty"`
+	GroupByFields      []string `json:"groupByFields" yaml:"groupByFields,omitempty"`
+}
+
+// TemplateData is what the filters and documentation of the alert policies of
+// a client can refer to, e.g. {{ .Queues.queue1 }} or
+// {{ index .Buckets "raw-exports" }}.
+type TemplateData struct {
+	Client string
+	// Queues maps the keys of the client's queues to their queue id.
+	Queues map[string]string
+	// Buckets maps the keys of the client's buckets to their name.
+	Buckets map[string]string
+	// Metrics maps the keys of the client's log-based metrics to their metric
+	// type.
+	Metrics map[string]string
+}
+
+const DefaultCombiner = "OR"
+
+// GetMonitoringConfig parses the channels and alert policies of a client. The
+// filters and documentation of the policies are rendered as Go templates with
+// the TemplateData of the client, a reference to an undeclared key is an
+// error.
+func GetMonitoringConfig(viperConfig *viper.Viper, clientName string) (*Config, error) {
+	if viperConfig == nil {
+		return nil, nil
+	}
+
+	var monitoringConfig Config
+	err := viperConfig.Unmarshal(&monitoringConfig)
+	if err != nil {
+		return nil, err
+	}
+	data, err := templateData(viperConfig, clientName)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, channel := range monitoringConfig.Monitoring.NotificationChannels {
+		if channel.DisplayName == "" {
+			channel.DisplayName = clientName + "-" + name
+		}
+		channel.ClientName = clientName
+
+		monitoringConfig.Monitoring.NotificationChannels[name] = channel
+	}
+	for name, policy := range monitoringConfig.Monitoring.AlertPolicies {
+		if policy.DisplayName == "" {
+			policy.DisplayName = clientName + "-" + name
+		}
+		if policy.Combiner == "" {
+			policy.Combiner = DefaultCombiner
+		}
+		policy.ClientName = clientName
+		if policy.Documentation, err = render(policy.Documentation, data); err != nil {
+			return nil, fmt.Errorf("alert policy %s: documentation: %s", name, err)
+		}
+		var conditions []Condition
+		for _, condition := range policy.Conditions {
+			if condition.Filter, err = render(condition.Filter, data); err != nil {
+				return nil, fmt.Errorf("alert policy %s: condition %s: %s", name, condition.DisplayName, err)
+			}
+			conditions = append(conditions, condition)
+		}
+		policy.Conditions = conditions
+		policy.ChannelDisplayNames = nil
+		for _, channel := range policy.NotificationChannels {
+			if declared, ok := monitoringConfig.Monitoring.NotificationChannels[channel]; ok {
+				policy.ChannelDisplayNames = append(policy.ChannelDisplayNames, declared.DisplayName)
+			}
+		}
+
+		monitoringConfig.Monitoring.AlertPolicies[name] = policy
+	}
+	return &monitoringConfig, nil
+}
+
+// templateData collects the names of the queues, buckets and log-based metrics
+// of a client.
+func templateData(viperConfig *viper.Viper, clientName string) (*TemplateData, error) {
+	taskConfig, err := cloudtasks.GetTaskConfig(viperConfig, clientName)
+	if err != nil {
+		return nil, err
+	}
+	storageConfig, err := cloudstorage.GetStorageConfig(viperConfig, clientName)
+	if err != nil {
+		return nil, err
+	}
+	loggingConfig, err := logging.GetLoggingConfig(viperConfig, clientName)
+	if err != nil {
+		return nil, err
+	}
+	data := &TemplateData{
+		Client:  clientName,
+		Queues:  map[string]string{},
+		Buckets: map[string]string{},
+		Metrics: map[string]string{},
+	}
+	for key, queue := range taskConfig.TaskQueues {
+		data.Queues[key] = queue.Name
+	}
+	for key, bucket := range storageConfig.StorageBuckets {
+		data.Buckets[key] = bucket.Name
+	}
+	for key, metric := range loggingConfig.Logging.Metrics {
+		data.Metrics[key] = logging.MetricType(metric)
+	}
+	return data, nil
+}
+
+func render(text string, data *TemplateData) (string, error) {
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+func ValidateConfig(config *Config) error {
+	v := validator.New()
+	if err := v.Struct(config); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			return fmt.Errorf("%s validate failed on the %s rule", err.Namespace(), err.Tag())
+		}
+	}
+	displayNames := map[string]string{}
+	for key, policy := range config.Monitoring.AlertPolicies {
+		if other, ok := displayNames[policy.ProjectId+"/"+policy.DisplayName]; ok {
+			return fmt.Errorf("alert policies %s and %s have the same display name %s", other, key, policy.DisplayName)
+		}
+		displayNames[policy.ProjectId+"/"+policy.DisplayName] = key
+		if len(policy.ChannelDisplayNames) != len(policy.NotificationChannels) {
+			return fmt.Errorf("alert policy %s: every notification channel must be declared in monitoring", key)
+		}
+		for _, channel := range policy.NotificationChannels {
+			if config.Monitoring.NotificationChannels[channel].ProjectId != policy.ProjectId {
+				return fmt.Errorf("alert policy %s: notification channel %s must be in the project of the policy", key, channel)
+			}
+		}
+		for _, condition := range policy.Conditions {
+			for _, duration := range []string{condition.Duration, condition.AlignmentPeriod} {
+				if duration == "" {
+					continue
+				}
+				if _, err := time.ParseDuration(duration); err != nil {
+					return fmt.Errorf("alert policy %s: condition %s: invalid duration %s", key, condition.DisplayName, duration)
+				}
+			}
+		}
+	}
+	for key, channel := range config.Monitoring.NotificationChannels {
+		if other, ok := displayNames[channel.ProjectId+"/"+channel.DisplayName]; ok {
+			return fmt.Errorf("notification channels %s and %s have the same display name %s", other, key, channel.DisplayName)
+		}
+		displayNames[channel.ProjectId+"/"+channel.DisplayName] = key
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package monitoring
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+var validMonitoringConfig = []byte(`
+cloudTasks:
+  queue1:
+    region: us-central1
+    projectId: some-project
+storageBucket:
+  raw-exports:
+    region: us-central1
+    projectId: some-project
+logging:
+  metrics:
+    errors:
+      projectId: some-project
+      filter: severity>=ERROR
+monitoring:
+  notificationChannels:
+    oncall:
+      projectId: some-project
+      type: email
+      labels:
+        email_address: oncall@example.com
+  alertPolicies:
+    backlog:
+      projectId: some-project
+      conditions:
+        - displayName: backlog too large
+          filter: resource.type="cloud_tasks_queue" AND resource.labels.queue_id="{{ .Queues.queue1 }}"
+          comparison: COMPARISON_GT
+          threshold: 1000
+          duration: 5m
+      documentation: The queue {{ .Queues.queue1 }} of {{ .Client }} is late.
+      notificationChannels:
+        - oncall
+    errors:
+      displayName: Errors of some-client
+      projectId: some-project
+      combiner: AND
+      conditions:
+        - displayName: errors
+          filter: metric.type="{{ .Metrics.errors }}" AND resource.labels.bucket_name="{{ index .Buckets "raw-exports" }}"
+          comparison: COMPARISON_GT
+          threshold: 0`)
+
+var undeclaredQueueConfig = []byte(`
+monitoring:
+  alertPolicies:
+    backlog:
+      projectId: some-project
+      conditions:
+        - displayName: backlog too large
+          filter: resource.labels.queue_id="{{ .Queues.queue1 }}"
+          comparison: COMPARISON_GT`)
+
+var invalidConfig = []byte(`
+monitoring:
+  alertPolicies:
+    backlog:
+      conditions: should_be_an_array`)
+
+var _ = Describe("config", func() {
+	BeforeEach(func() {
+		viper.Reset()
+		viper.SetConfigType("yaml")
+	})
+	Describe("GetMonitoringConfig", func() {
+		It("should successfully parse a monitoring config", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(validMonitoringConfig))
+			Expect(err).ToNot(HaveOccurred())
+			monitoringConfig, err := GetMonitoringConfig(viper.GetViper(), "some-client")
+			Expect(err).To(BeNil())
+			oncall := monitoringConfig.Monitoring.NotificationChannels["oncall"]
+			Expect(oncall.DisplayName).To(Equal("some-client-oncall"))
+			Expect(oncall.Labels).To(HaveKeyWithValue("email_address", "oncall@example.com"))
+			backlog := monitoringConfig.Monitoring.AlertPolicies["backlog"]
+			Expect(backlog.DisplayName).To(Equal("some-client-backlog"))
+			Expect(backlog.Combiner).To(Equal(DefaultCombiner))
+			Expect(backlog.Conditions[0].Filter).To(Equal(`resource.type="cloud_tasks_queue" AND resource.labels.queue_id="queue1"`))
+			Expect(backlog.Documentation).To(Equal("The queue queue1 of some-client is late."))
+			Expect(backlog.ChannelDisplayNames).To(Equal([]string{"some-client-oncall"}))
+			errors := monitoringConfig.Monitoring.AlertPolicies["errors"]
+			Expect(errors.DisplayName).To(Equal("Errors of some-client"))
+			Expect(errors.Conditions[0].Filter).To(Equal(`metric.type="logging.googleapis.com/user/some-client-errors" AND resource.labels.bucket_name="some-client-raw-exports-some-project"`))
+			Expect(ValidateConfig(monitoringConfig)).To(Succeed())
+		})
+		It("returns an error if a template refers to an undeclared queue", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(undeclaredQueueConfig))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = GetMonitoringConfig(viper.GetViper(), "some-client")
+			Expect(err).To(MatchError(ContainSubstring("alert policy backlog: condition backlog too large:")))
+		})
+		It("returns an error if cannot parse the config", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(invalidConfig))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = GetMonitoringConfig(viper.GetViper(), "some-client")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("validates alert policies", func() {
+		var policy AlertPolicy
+		var channel NotificationChannel
+
+		BeforeEach(func() {
+			channel = NotificationChannel{
+				DisplayName: "client-oncall",
+				ProjectId:   "mock-project",
+				Type:        "email",
+			}
+			policy = AlertPolicy{
+				DisplayName:          "client-foooo",
+				ProjectId:            "mock-project",
+				Combiner:             DefaultCombiner,
+				Conditions:           []Condition{{DisplayName: "high", Filter: `metric.type="x"`, Comparison: "COMPARISON_GT", Duration: "5m"}},
+				NotificationChannels: []string{"oncall"},
+				ChannelDisplayNames:  []string{"client-oncall"},
+			}
+		})
+		config := func() *Config {
+			return &Config{Monitoring: Monitoring{
+				NotificationChannels: map[string]NotificationChannel{"oncall": channel},
+				AlertPolicies:        map[string]AlertPolicy{"foooo": policy},
+			}}
+		}
+
+		It("should not detect error", func() {
+			Expect(ValidateConfig(config())).To(Succeed())
+		})
+		It("should detect a policy without condition", func() {
+			policy.Conditions = nil
+			Expect(ValidateConfig(config())).To(MatchError("Config.Monitoring.AlertPolicies[foooo].Conditions validate failed on the required rule"))
+		})
+		It("should detect an invalid comparison", func() {
+			policy.Conditions[0].Comparison = ">"
+			Expect(ValidateConfig(config())).To(MatchError("Config.Monitoring.AlertPolicies[foooo].Conditions[0].Comparison validate failed on the oneof rule"))
+		})
+		It("should detect an undeclared channel", func() {
+			policy.ChannelDisplayNames = nil
+			Expect(ValidateConfig(config())).To(MatchError("alert policy foooo: every notification channel must be declared in monitoring"))
+		})
+		It("should detect a channel of another project", func() {
+			channel.ProjectId = "other-project"
+			Expect(ValidateConfig(config())).To(MatchError("alert policy foooo: notification channel oncall must be in the project of the policy"))
+		})
+		It("should detect an invalid duration", func() {
+			policy.Conditions[0].Duration = "five minutes"
+			Expect(ValidateConfig(config())).To(MatchError("alert policy foooo: condition high: invalid duration five minutes"))
+		})
+		It("should detect two policies with the same display name", func() {
+			cfg := config()
+			cfg.Monitoring.AlertPolicies["bar"] = policy
+			Expect(ValidateConfig(cfg)).To(MatchError(ContainSubstring("have the same display name client-foooo")))
+		})
+	})
+})
@@ -0,0 +1,641 @@
+package monitoring
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	monitoring "google.golang.org/api/monitoring/v3"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Product is the key of the monitoring config in a client's config.
+const Product = "monitoring"
+
+// Prefixes of the keys of the channels and policies in the plan and state, e.g.
+// notificationChannels.oncall or alertPolicies.backlog.
+const (
+	channelsKey = "notificationChannels"
+	policiesKey = "alertPolicies"
+)
+
+// Properties of the channels and policies that are updated. Properties of the
+// masks that are empty in the spec are cleared.
+const (
+	channelUpdateMask = "displayName,description,labels,enabled,userLabels"
+	policyUpdateMask  = "displayName,documentation,conditions,combiner,enabled,notificationChannels,userLabels"
+)
+
+const documentationMimeType = "text/markdown"
+
+type Client struct {
+	monitoringService *monitoring.Service
+}
+
+func NewClient(ctx context.Context, opts ...option.ClientOption) (*Client, error) {
+	monitoringService, err := monitoring.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		monitoringService: monitoringService,
+	}, nil
+}
+
+// plannedPolicy is the desired state of an alert policy saved in a plan. The
+// channels are saved by display name since they may not exist yet, they are
+// resolved to their resource name when the policy is applied.
+type plannedPolicy struct {
+	Policy   *monitoring.AlertPolicy `json:"policy"`
+	Channels []string                `json:"channels"`
+}
+
+// Create creates the channels and then the alert policies of the config that
+// do not exist and updates the others, matching them by display name. It
+// returns the resources that were applied, even when some failed.
+func (c *Client) Create(config *Config) ([]common.AppliedResource, error) {
+	applied, err := c.createChannels(config)
+	if err != nil {
+		return applied, err
+	}
+	policies, err := c.createPolicies(config)
+	return append(applied, policies...), err
+}
+
+func (c *Client) createChannels(config *Config) ([]common.AppliedResource, error) {
+	createChannel := make(chan common.Response, len(config.Monitoring.NotificationChannels))
+	for key, channel := range config.Monitoring.NotificationChannels {
+		go func(resp chan common.Response, key string, channel NotificationChannel) {
+			spec := c.createChannelSpec(channel)
+			live, err := c.findChannel(channel.ProjectId, channel.DisplayName)
+			if err == nil {
+				if live == nil {
+					utils.Logger.Debugf("[%s] notification channel not found", channel.DisplayName)
+
+					err = c.insertChannel(channel.ProjectId, spec)
+				} else if err = checkChannelType(live, spec); err == nil {
+					err = c.updateChannel(live, spec)
+				}
+			}
+			if err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			resp <- common.Response{Applied: &common.AppliedResource{
+				Client:  channel.ClientName,
+				Product: Product,
+				Key:     channelsKey + "." + key,
+				Name:    channel.DisplayName,
+				Spec:    spec,
+			}}
+		}(createChannel, key, channel)
+	}
+	return collect(createChannel, len(config.Monitoring.NotificationChannels))
+}
+
+func (c *Client) createPolicies(config *Config) ([]common.AppliedResource, error) {
+	createChannel := make(chan common.Response, len(config.Monitoring.AlertPolicies))
+	for key, policy := range config.Monitoring.AlertPolicies {
+		go func(resp chan common.Response, key string, policy AlertPolicy) {
+			planned := &plannedPolicy{Policy: c.createPolicySpec(policy), Channels: policy.ChannelDisplayNames}
+			err := c.applyPolicy(policy.ProjectId, policy.DisplayName, planned)
+			if err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			resp <- common.Response{Applied: &common.AppliedResource{
+				Client:  policy.ClientName,
+				Product: Product,
+				Key:     policiesKey + "." + key,
+				Name:    policy.DisplayName,
+				Spec:    planned,
+			}}
+		}(createChannel, key, policy)
+	}
+	return collect(createChannel, len(config.Monitoring.AlertPolicies))
+}
+
+// collect drains the responses of the goroutines applying total resources.
+func collect(resps chan common.Response, total int) ([]common.AppliedResource, error) {
+	var applied []common.AppliedResource
+	var collectErr error
+	for i := 0; i < total; i++ {
+		resp := <-resps
+		if resp.Err != nil {
+			collectErr = resp.Err
+			continue
+		}
+		applied = append(applied, *resp.Applied)
+	}
+	return applied, collectErr
+}
+
+// Plan compares every channel and alert policy of the config with the live
+// one of the same display name and returns the change Create would make to
+// each of them.
+func (c *Client) Plan(config *Config) ([]common.ResourceChange, error) {
+	total := len(config.Monitoring.NotificationChannels) + len(config.Monitoring.AlertPolicies)
+	planChannel := make(chan common.Response, total)
+	for key, channel := range config.Monitoring.NotificationChannels {
+		go func(resp chan common.Response, key string, channel NotificationChannel) {
+			spec := c.createChannelSpec(channel)
+			change := common.ResourceChange{
+				Client:  channel.ClientName,
+				Product: Product,
+				Key:     channelsKey + "." + key,
+				Name:    channel.DisplayName,
+				Project: channel.ProjectId,
+			}
+			live, err := c.findChannel(channel.ProjectId, channel.DisplayName)
+			if err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			if live == nil {
+				resp <- common.PlanChange(change, spec, nil, nil)
+				return
+			}
+			if err := checkChannelType(live, spec); err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			resp <- common.PlanChange(change, spec, live, diffChannel(live, spec))
+		}(planChannel, key, channel)
+	}
+	for key, policy := range config.Monitoring.AlertPolicies {
+		go func(resp chan common.Response, key string, policy AlertPolicy) {
+			planned := &plannedPolicy{Policy: c.createPolicySpec(policy), Channels: policy.ChannelDisplayNames}
+			change := common.ResourceChange{
+				Client:  policy.ClientName,
+				Product: Product,
+				Key:     policiesKey + "." + key,
+				Name:    policy.DisplayName,
+				Project: policy.ProjectId,
+			}
+			live, err := c.findPolicy(policy.ProjectId, policy.DisplayName)
+			if err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			if live == nil {
+				resp <- common.PlanChange(change, planned, nil, nil)
+				return
+			}
+			channels, err := c.listChannels(policy.ProjectId)
+			if err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			resp <- common.PlanChange(change, planned, live, diffPolicy(live, planned, channels))
+		}(planChannel, key, policy)
+	}
+	var changes []common.ResourceChange
+	var planErr error
+	for i := 0; i < total; i++ {
+		resp := <-planChannel
+		if resp.Err != nil {
+			planErr = resp.Err
+			continue
+		}
+		changes = append(changes, resp.Change)
+	}
+	return changes, planErr
+}
+
+// Verify returns an error if the live channel or alert policy with the display
+// name of the change is not in the state it was in when the change was
+// planned.
+func (c *Client) Verify(change common.ResourceChange) error {
+	var live interface{}
+	var err error
+	if isPolicy(change) {
+		var policy *monitoring.AlertPolicy
+		if policy, err = c.findPolicy(change.Project, change.Name); policy != nil {
+			live = policy
+		}
+	} else {
+		var channel *monitoring.NotificationChannel
+		if channel, err = c.findChannel(change.Project, change.Name); channel != nil {
+			live = channel
+		}
+	}
+	if err != nil {
+		return err
+	}
+	liveHash := ""
+	if live != nil {
+		if liveHash, err = common.HashResource(live); err != nil {
+			return err
+		}
+	}
+	if liveHash != change.LiveHash {
+		return fmt.Errorf("[%s] %s changed since the plan was made", change.Name, kindOf(change))
+	}
+	return nil
+}
+
+// Apply runs a planned change with the spec saved in the plan. The channels of
+// an alert policy are resolved from their display name. It returns the applied
+// resource, or nil when there was nothing to do.
+func (c *Client) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	if change.Action == common.ActionNoop {
+		return nil, nil
+	}
+	if change.Action != common.ActionCreate && change.Action != common.ActionUpdate {
+		return nil, fmt.Errorf("[%s] unknown action %s", change.Name, change.Action)
+	}
+	var spec interface{}
+	if isPolicy(change) {
+		planned := &plannedPolicy{}
+		if err := json.Unmarshal(change.Desired, planned); err != nil || planned.Policy == nil {
+			return nil, fmt.Errorf("[%s] invalid planned spec: %v", change.Name, err)
+		}
+		if err := c.applyPolicy(change.Project, change.Name, planned); err != nil {
+			return nil, err
+		}
+		spec = planned
+	} else {
+		channel := &monitoring.NotificationChannel{}
+		if err := json.Unmarshal(change.Desired, channel); err != nil {
+			return nil, fmt.Errorf("[%s] invalid planned spec: %s", change.Name, err)
+		}
+		live, err := c.findChannel(change.Project, change.Name)
+		if err != nil {
+			return nil, err
+		}
+		if live == nil {
+			err = c.insertChannel(change.Project, channel)
+		} else {
+			err = c.updateChannel(live, channel)
+		}
+		if err != nil {
+			return nil, err
+		}
+		spec = channel
+	}
+	return &common.AppliedResource{
+		Client:  change.Client,
+		Product: Product,
+		Key:     change.Key,
+		Name:    change.Name,
+		Spec:    spec,
+	}, nil
+}
+
+// applyPolicy creates the alert policy with the display name, or updates the
+// one that has it, with its channels resolved from their display name.
+func (c *Client) applyPolicy(projectId string, displayName string, planned *plannedPolicy) error {
+	spec := *planned.Policy
+	spec.NotificationChannels = nil
+	if len(planned.Channels) > 0 {
+		channels, err := c.listChannels(projectId)
+		if err != nil {
+			return err
+		}
+		names := map[string]string{}
+		for _, channel := range channels {
+			names[channel.DisplayName] = channel.Name
+		}
+		for _, channel := range planned.Channels {
+			name, ok := names[channel]
+			if !ok {
+				return fmt.Errorf("[%s] notification channel %s not found", displayName, channel)
+			}
+			spec.NotificationChannels = append(spec.NotificationChannels, name)
+		}
+	}
+	live, err := c.findPolicy(projectId, displayName)
+	if err != nil {
+		return err
+	}
+	if live == nil {
+		utils.Logger.Debugf("[%s] alert policy not found", displayName)
+
+		return c.insertPolicy(projectId, &spec)
+	}
+	return c.updatePolicy(live, &spec)
+}
+
+// Delete deletes the alert policies and then the channels with the display
+// names of the config. Channels still used by other policies are only deleted
+// with force. Resources that do not exist are ignored.
+func (c *Client) Delete(config *Config, force bool) error {
+	var deleteErr error
+	for _, policy := range config.Monitoring.AlertPolicies {
+		live, err := c.findPolicy(policy.ProjectId, policy.DisplayName)
+		if err == nil && live != nil {
+			utils.Logger.Infof("[%s] deleting alert policy", policy.DisplayName)
+			_, err = c.monitoringService.Projects.AlertPolicies.Delete(live.Name).Do()
+		}
+		if err = ignoreNotFound(policy.DisplayName, err); err != nil {
+			deleteErr = err
+		}
+	}
+	for _, channel := range config.Monitoring.NotificationChannels {
+		live, err := c.findChannel(channel.ProjectId, channel.DisplayName)
+		if err == nil && live != nil {
+			utils.Logger.Infof("[%s] deleting notification channel", channel.DisplayName)
+			_, err = c.monitoringService.Projects.NotificationChannels.Delete(live.Name).Force(force).Do()
+		}
+		if err = ignoreNotFound(channel.DisplayName, err); err != nil {
+			deleteErr = err
+		}
+	}
+	return deleteErr
+}
+
+func ignoreNotFound(displayName string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+		utils.Logger.Infof("[%s] already deleted", displayName)
+		return nil
+	}
+	utils.Logger.Errorf("[%s] error deleting: %s", displayName, err)
+	return err
+}
+
+// findPolicy returns the alert policy of the project with the display name, or
+// nil when there is none. Several policies with the display name are an error
+// since the one to update cannot be told apart.
+func (c *Client) findPolicy(projectId string, displayName string) (*monitoring.AlertPolicy, error) {
+	utils.Logger.Debugf("[%s] listing alert policies", displayName)
+	var found []*monitoring.AlertPolicy
+	err := c.monitoringService.Projects.AlertPolicies.List("projects/"+projectId).Filter(displayNameFilter(displayName)).Pages(context.Background(), func(page *monitoring.ListAlertPoliciesResponse) error {
+		for _, policy := range page.AlertPolicies {
+			if policy.DisplayName == displayName {
+				found = append(found, policy)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		utils.Logger.Errorf("[%s] error listing alert policies: %s", displayName, err)
+		return nil, err
+	}
+	if len(found) > 1 {
+		return nil, fmt.Errorf("[%s] %d alert policies of project %s have this display name, keep only one", displayName, len(found), projectId)
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+	return found[0], nil
+}
+
+// findChannel returns the notification channel of the project with the display
+// name, or nil when there is none.
+func (c *Client) findChannel(projectId string, displayName string) (*monitoring.NotificationChannel, error) {
+	utils.Logger.Debugf("[%s] listing notification channels", displayName)
+	var found []*monitoring.NotificationChannel
+	err := c.monitoringService.Projects.NotificationChannels.List("projects/"+projectId).Filter(displayNameFilter(displayName)).Pages(context.Background(), func(page *monitoring.ListNotificationChannelsResponse) error {
+		for _, channel := range page.NotificationChannels {
+			if channel.DisplayName == displayName {
+				found = append(found, channel)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		utils.Logger.Errorf("[%s] error listing notification channels: %s", displayName, err)
+		return nil, err
+	}
+	if len(found) > 1 {
+		return nil, fmt.Errorf("[%s] %d notification channels of project %s have this display name, keep only one", displayName, len(found), projectId)
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+	return found[0], nil
+}
+
+// listChannels returns every notification channel of a project.
+func (c *Client) listChannels(projectId string) ([]*monitoring.NotificationChannel, error) {
+	var channels []*monitoring.NotificationChannel
+	err := c.monitoringService.Projects.NotificationChannels.List("projects/"+projectId).Pages(context.Background(), func(page *monitoring.ListNotificationChannelsResponse) error {
+		channels = append(channels, page.NotificationChannels...)
+		return nil
+	})
+	if err != nil {
+		utils.Logger.Errorf("[%s] error listing notification channels: %s", projectId, err)
+		return nil, err
+	}
+	return channels, nil
+}
+
+func displayNameFilter(displayName string) string {
+	return "display_name = " + strconv.Quote(displayName)
+}
+
+// insertPolicy creates an alert policy. Enabled is always sent since the API
+// enables a policy that does not set it.
+func (c *Client) insertPolicy(projectId string, spec *monitoring.AlertPolicy) error {
+	utils.Logger.Infof("[%s] creating alert policy", spec.DisplayName)
+	spec.ForceSendFields = append(spec.ForceSendFields, "Enabled")
+	_, err := c.monitoringService.Projects.AlertPolicies.Create("projects/"+projectId, spec).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error creating alert policy: %s", spec.DisplayName, err)
+		return err
+	}
+	return nil
+}
+
+// updatePolicy patches a live alert policy, keeping the user labels set by
+// other tools.
+func (c *Client) updatePolicy(live *monitoring.AlertPolicy, spec *monitoring.AlertPolicy) error {
+	utils.Logger.Infof("[%s] updating alert policy", spec.DisplayName)
+	spec.UserLabels = common.MergeLabels(live.UserLabels, spec.UserLabels)
+	_, err := c.monitoringService.Projects.AlertPolicies.Patch(live.Name, spec).UpdateMask(policyUpdateMask).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error updating alert policy: %s", spec.DisplayName, err)
+		return err
+	}
+	return nil
+}
+
+// insertChannel creates a notification channel. Enabled is always sent since
+// the API enables a channel that does not set it.
+func (c *Client) insertChannel(projectId string, spec *monitoring.NotificationChannel) error {
+	utils.Logger.Infof("[%s] creating notification channel", spec.DisplayName)
+	spec.ForceSendFields = append(spec.ForceSendFields, "Enabled")
+	_, err := c.monitoringService.Projects.NotificationChannels.Create("projects/"+projectId, spec).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error creating notification channel: %s", spec.DisplayName, err)
+		return err
+	}
+	return nil
+}
+
+// updateChannel patches a live notification channel, keeping the user labels
+// set by other tools.
+func (c *Client) updateChannel(live *monitoring.NotificationChannel, spec *monitoring.NotificationChannel) error {
+	utils.Logger.Infof("[%s] updating notification channel", spec.DisplayName)
+	spec.UserLabels = common.MergeLabels(live.UserLabels, spec.UserLabels)
+	_, err := c.monitoringService.Projects.NotificationChannels.Patch(live.Name, spec).UpdateMask(channelUpdateMask).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error updating notification channel: %s", spec.DisplayName, err)
+		return err
+	}
+	return nil
+}
+
+// checkChannelType returns an error if the desired type of a channel differs
+// from the live one, the type of a channel cannot be changed.
+func checkChannelType(live *monitoring.NotificationChannel, desired *monitoring.NotificationChannel) error {
+	if live.Type != desired.Type {
+		return fmt.Errorf("[%s] the type of a notification channel cannot be changed from %s to %s", desired.DisplayName, live.Type, desired.Type)
+	}
+	return nil
+}
+
+func (c *Client) createChannelSpec(channel NotificationChannel) *monitoring.NotificationChannel {
+	return &monitoring.NotificationChannel{
+		DisplayName: channel.DisplayName,
+		Type:        channel.Type,
+		Labels:      channel.Labels,
+		Description: channel.Description,
+		Enabled:     !channel.Disabled,
+		UserLabels:  common.OwnershipLabels(channel.ClientName),
+	}
+}
+
+func (c *Client) createPolicySpec(policy AlertPolicy) *monitoring.AlertPolicy {
+	spec := &monitoring.AlertPolicy{
+		DisplayName: policy.DisplayName,
+		Combiner:    policy.Combiner,
+		Enabled:     !policy.Disabled,
+		UserLabels:  common.OwnershipLabels(policy.ClientName),
+	}
+	if policy.Documentation != "" {
+		spec.Documentation = &monitoring.Documentation{Content: policy.Documentation, MimeType: documentationMimeType}
+	}
+	for _, condition := range policy.Conditions {
+		threshold := &monitoring.MetricThreshold{
+			Filter:         condition.Filter,
+			Comparison:     condition.Comparison,
+			ThresholdValue: condition.Threshold,
+			Duration:       seconds(condition.Duration),
+		}
+		if condition.AlignmentPeriod != "" || condition.PerSeriesAligner != "" || condition.CrossSeriesReducer != "" || len(condition.GroupByFields) > 0 {
+			threshold.Aggregations = []*monitoring.Aggregation{{
+				AlignmentPeriod:    seconds(condition.AlignmentPeriod),
+				PerSeriesAligner:   condition.PerSeriesAligner,
+				CrossSeriesReducer: condition.CrossSeriesReducer,
+				GroupByFields:      condition.GroupByFields,
+			}}
+		}
+		spec.Conditions = append(spec.Conditions, &monitoring.Condition{
+			DisplayName:        condition.DisplayName,
+			ConditionThreshold: threshold,
+		})
+	}
+	return spec
+}
+
+// seconds converts a duration such as 5m to the format of the API, 300s. The
+// API requires a duration on threshold conditions, 0s when empty.
+func seconds(duration string) string {
+	parsed, err := time.ParseDuration(duration)
+	if err != nil {
+		return "0s"
+	}
+	return fmt.Sprintf("%ds", int64(parsed.Seconds()))
+}
+
+// diffChannel lists the properties of the desired spec that differ from the
+// live channel.
+func diffChannel(live *monitoring.NotificationChannel, desired *monitoring.NotificationChannel) []common.FieldDiff {
+	var diffs []common.FieldDiff
+	diffs = common.AppendDiff(diffs, "description", live.Description, desired.Description)
+	diffs = common.AppendDiff(diffs, "enabled", live.Enabled, desired.Enabled)
+	for _, key := range sortedKeys(desired.Labels) {
+		diffs = common.AppendDiff(diffs, "labels."+key, live.Labels[key], desired.Labels[key])
+	}
+	diffs = common.AppendLabelDiffs(diffs, "userLabels", live.UserLabels, desired.UserLabels)
+	return diffs
+}
+
+// diffPolicy lists the properties of the planned policy that differ from the
+// live one. The live channels are compared by display name.
+func diffPolicy(live *monitoring.AlertPolicy, planned *plannedPolicy, channels []*monitoring.NotificationChannel) []common.FieldDiff {
+	desired := planned.Policy
+	var diffs []common.FieldDiff
+	diffs = common.AppendDiff(diffs, "combiner", live.Combiner, desired.Combiner)
+	diffs = common.AppendDiff(diffs, "enabled", live.Enabled, desired.Enabled)
+	diffs = common.AppendDiff(diffs, "documentation", documentation(live), documentation(desired))
+	diffs = common.AppendDiff(diffs, "conditions", conditions(live), conditions(desired))
+
+	displayNames := map[string]string{}
+	for _, channel := range channels {
+		displayNames[channel.Name] = channel.DisplayName
+	}
+	liveChannels := []string{}
+	for _, name := range live.NotificationChannels {
+		if displayName, ok := displayNames[name]; ok {
+			name = displayName
+		}
+		liveChannels = append(liveChannels, name)
+	}
+	desiredChannels := append([]string{}, planned.Channels...)
+	sort.Strings(liveChannels)
+	sort.Strings(desiredChannels)
+	diffs = common.AppendDiff(diffs, "notificationChannels", liveChannels, desiredChannels)
+
+	diffs = common.AppendLabelDiffs(diffs, "userLabels", live.UserLabels, desired.UserLabels)
+	return diffs
+}
+
+func documentation(policy *monitoring.AlertPolicy) string {
+	if policy.Documentation == nil {
+		return ""
+	}
+	return policy.Documentation.Content
+}
+
+// conditions returns the threshold conditions of a policy in a comparable
+// form, without their generated names.
+func conditions(policy *monitoring.AlertPolicy) []string {
+	described := []string{}
+	for _, condition := range policy.Conditions {
+		threshold := condition.ConditionThreshold
+		if threshold == nil {
+			described = append(described, condition.DisplayName+": not a threshold condition")
+			continue
+		}
+		description := fmt.Sprintf("%s: %s %s %v for %s", condition.DisplayName, threshold.Filter, threshold.Comparison, threshold.ThresholdValue, threshold.Duration)
+		for _, aggregation := range threshold.Aggregations {
+			description += fmt.Sprintf(" (%s %s %s by %s)", aggregation.AlignmentPeriod, aggregation.PerSeriesAligner, aggregation.CrossSeriesReducer, strings.Join(aggregation.GroupByFields, ","))
+		}
+		described = append(described, description)
+	}
+	return described
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isPolicy(change common.ResourceChange) bool {
+	return strings.HasPrefix(change.Key, policiesKey+".")
+}
+
+// kindOf returns the kind of the resource of a planned change.
+func kindOf(change common.ResourceChange) string {
+	if isPolicy(change) {
+		return "alert policy"
+	}
+	return "notification channel"
+}
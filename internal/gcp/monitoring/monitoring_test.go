@@ -0,0 +1,298 @@
+package monitoring
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	monitoring "google.golang.org/api/monitoring/v3"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Helper method to create client
+func getMockedClient(url string) *Client {
+	client, err := NewClient(context.Background(), option.WithoutAuthentication(), option.WithEndpoint(url))
+	if err != nil {
+		Fail(err.Error())
+	}
+	return client
+}
+
+var _ = Describe("Monitoring client", func() {
+	var channel NotificationChannel
+	var policy AlertPolicy
+	var liveChannel *monitoring.NotificationChannel
+
+	BeforeEach(func() {
+		channel = NotificationChannel{
+			DisplayName: "banane-oncall",
+			ProjectId:   "projet-123",
+			Type:        "email",
+			Labels:      map[string]string{"email_address": "oncall@example.com"},
+			ClientName:  "banane",
+		}
+		policy = AlertPolicy{
+			DisplayName: "banane-backlog",
+			ProjectId:   "projet-123",
+			Combiner:    DefaultCombiner,
+			Conditions: []Condition{{
+				DisplayName: "backlog too large",
+				Filter:      `resource.labels.queue_id="queue1"`,
+				Comparison:  "COMPARISON_GT",
+				Threshold:   1000,
+				Duration:    "5m",
+			}},
+			Documentation:        "The queue queue1 is late.",
+			NotificationChannels: []string{"oncall"},
+			ChannelDisplayNames:  []string{"banane-oncall"},
+			ClientName:           "banane",
+		}
+		liveChannel = &monitoring.NotificationChannel{
+			Name:        "projects/projet-123/notificationChannels/42",
+			DisplayName: "banane-oncall",
+			Type:        "email",
+		}
+	})
+	listsPolicies := func(url string) bool {
+		return strings.HasPrefix(url, "/v3/projects/projet-123/alertPolicies?") && strings.Contains(url, "filter=display_name")
+	}
+	listsChannels := func(url string) bool {
+		return strings.HasPrefix(url, "/v3/projects/projet-123/notificationChannels?")
+	}
+
+	Describe("create policy spec", func() {
+		It("converts the durations and sets the ownership labels", func() {
+			client := getMockedClient("http://localhost")
+
+			spec := client.createPolicySpec(policy)
+			Expect(spec.Conditions[0].ConditionThreshold.Duration).To(Equal("300s"))
+			Expect(spec.Conditions[0].ConditionThreshold.Aggregations).To(BeEmpty())
+			Expect(spec.Documentation.MimeType).To(Equal("text/markdown"))
+			Expect(spec.UserLabels).To(Equal(common.OwnershipLabels("banane")))
+			Expect(spec.Enabled).To(BeTrue())
+		})
+	})
+	Describe("create", func() {
+		It("creates the channel and then the policy notifying it", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 5)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: listsChannels,
+				ResponseBody: monitoring.ListNotificationChannelsResponse{},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: listsChannels,
+				Method:       "post",
+				ResponseBody: liveChannel,
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: listsChannels,
+				ResponseBody: monitoring.ListNotificationChannelsResponse{NotificationChannels: []*monitoring.NotificationChannel{liveChannel}},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: listsPolicies,
+				ResponseBody: monitoring.ListAlertPoliciesResponse{},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v3/projects/projet-123/alertPolicies?")
+				},
+				Method: "post",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			applied, err := client.Create(&Config{Monitoring: Monitoring{
+				NotificationChannels: map[string]NotificationChannel{"oncall": channel},
+				AlertPolicies:        map[string]AlertPolicy{"backlog": policy},
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(HaveLen(2))
+			Expect(applied[0].Key).To(Equal("notificationChannels.oncall"))
+			Expect(applied[1].Key).To(Equal("alertPolicies.backlog"))
+			Expect(mockServerCalls).To(BeEmpty())
+		})
+	})
+	Describe("plan", func() {
+		It("plans the update of a policy created by another tool with the same display name", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 2)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: listsPolicies,
+				ResponseBody: monitoring.ListAlertPoliciesResponse{AlertPolicies: []*monitoring.AlertPolicy{
+					{Name: "projects/projet-123/alertPolicies/7", DisplayName: "banane-backlog-old"},
+					{
+						Name:          "projects/projet-123/alertPolicies/8",
+						DisplayName:   "banane-backlog",
+						Combiner:      "OR",
+						Enabled:       true,
+						Documentation: &monitoring.Documentation{Content: "The queue queue1 is late.", MimeType: "text/markdown"},
+						Conditions: []*monitoring.Condition{{
+							Name:        "projects/projet-123/alertPolicies/8/conditions/1",
+							DisplayName: "backlog too large",
+							ConditionThreshold: &monitoring.MetricThreshold{
+								Filter:         `resource.labels.queue_id="queue1"`,
+								Comparison:     "COMPARISON_GT",
+								ThresholdValue: 1000,
+								Duration:       "300s",
+							},
+						}},
+						NotificationChannels: []string{liveChannel.Name},
+						UserLabels:           map[string]string{"team": "data"},
+					},
+				}},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: listsChannels,
+				ResponseBody: monitoring.ListNotificationChannelsResponse{NotificationChannels: []*monitoring.NotificationChannel{liveChannel}},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(&Config{Monitoring: Monitoring{AlertPolicies: map[string]AlertPolicy{"backlog": policy}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(HaveLen(1))
+			Expect(changes[0].Action).To(Equal(common.ActionUpdate))
+			Expect(changes[0].Name).To(Equal("banane-backlog"))
+			Expect(changes[0].Diffs).To(ConsistOf(
+				common.FieldDiff{Field: "userLabels." + common.ClientLabel, Current: "", Desired: "banane"},
+				common.FieldDiff{Field: "userLabels." + common.ManagedByLabel, Current: "", Desired: common.ManagedByValue},
+			))
+			Expect(mockServerCalls).To(BeEmpty())
+		})
+		It("returns an error when several policies have the display name", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: listsPolicies,
+				ResponseBody: monitoring.ListAlertPoliciesResponse{AlertPolicies: []*monitoring.AlertPolicy{
+					{Name: "projects/projet-123/alertPolicies/7", DisplayName: "banane-backlog"},
+					{Name: "projects/projet-123/alertPolicies/8", DisplayName: "banane-backlog"},
+				}},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			_, err := client.Plan(&Config{Monitoring: Monitoring{AlertPolicies: map[string]AlertPolicy{"backlog": policy}}})
+			Expect(err).To(MatchError("[banane-backlog] 2 alert policies of project projet-123 have this display name, keep only one"))
+		})
+		It("returns an error when the type of a channel changes", func() {
+			liveChannel.Type = "slack"
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: listsChannels,
+				ResponseBody: monitoring.ListNotificationChannelsResponse{NotificationChannels: []*monitoring.NotificationChannel{liveChannel}},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			_, err := client.Plan(&Config{Monitoring: Monitoring{NotificationChannels: map[string]NotificationChannel{"oncall": channel}}})
+			Expect(err).To(MatchError("[banane-oncall] the type of a notification channel cannot be changed from slack to email"))
+		})
+	})
+	Describe("apply planned change", func() {
+		It("patches the live policy with the channels resolved by display name", func() {
+			client := getMockedClient("http://localhost")
+			desired, err := json.Marshal(&plannedPolicy{Policy: client.createPolicySpec(policy), Channels: policy.ChannelDisplayNames})
+			Expect(err).ToNot(HaveOccurred())
+
+			mockServerCalls := make(chan utils.MockServerCall, 3)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: listsChannels,
+				ResponseBody: monitoring.ListNotificationChannelsResponse{NotificationChannels: []*monitoring.NotificationChannel{liveChannel}},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: listsPolicies,
+				ResponseBody: monitoring.ListAlertPoliciesResponse{AlertPolicies: []*monitoring.AlertPolicy{
+					{Name: "projects/projet-123/alertPolicies/8", DisplayName: "banane-backlog"},
+				}},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v3/projects/projet-123/alertPolicies/8?") && strings.Contains(url, "updateMask=")
+				},
+				Method: "patch",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client = getMockedClient(mockServer.URL)
+
+			applied, err := client.Apply(common.ResourceChange{
+				Client:  "banane",
+				Product: Product,
+				Key:     "alertPolicies.backlog",
+				Name:    "banane-backlog",
+				Project: "projet-123",
+				Action:  common.ActionUpdate,
+				Desired: desired,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied.Key).To(Equal("alertPolicies.backlog"))
+			Expect(mockServerCalls).To(BeEmpty())
+		})
+		It("returns an error when a channel of the policy does not exist", func() {
+			client := getMockedClient("http://localhost")
+			desired, err := json.Marshal(&plannedPolicy{Policy: client.createPolicySpec(policy), Channels: policy.ChannelDisplayNames})
+			Expect(err).ToNot(HaveOccurred())
+
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: listsChannels,
+				ResponseBody: monitoring.ListNotificationChannelsResponse{},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client = getMockedClient(mockServer.URL)
+
+			_, err = client.Apply(common.ResourceChange{
+				Key:     "alertPolicies.backlog",
+				Name:    "banane-backlog",
+				Project: "projet-123",
+				Action:  common.ActionCreate,
+				Desired: desired,
+			})
+			Expect(err).To(MatchError("[banane-backlog] notification channel banane-oncall not found"))
+		})
+	})
+	Describe("delete", func() {
+		It("deletes the policies before the channels and ignores the missing ones", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 4)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: listsPolicies,
+				ResponseBody: monitoring.ListAlertPoliciesResponse{},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: listsChannels,
+				ResponseBody: monitoring.ListNotificationChannelsResponse{NotificationChannels: []*monitoring.NotificationChannel{liveChannel}},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v3/"+liveChannel.Name+"?") && strings.Contains(url, "force=true")
+				},
+				Method: "delete",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			err := client.Delete(&Config{Monitoring: Monitoring{
+				NotificationChannels: map[string]NotificationChannel{"oncall": channel},
+				AlertPolicies:        map[string]AlertPolicy{"backlog": policy},
+			}}, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mockServerCalls).To(BeEmpty())
+		})
+	})
+})
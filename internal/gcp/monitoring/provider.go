@@ -0,0 +1,104 @@
+package monitoring
+
+import (
+	"context"
+
+	"github.com/spf13/viper"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/gcp/cloudstorage"
+	"metrio.net/fougere-lite/internal/gcp/cloudtasks"
+	"metrio.net/fougere-lite/internal/gcp/logging"
+	"metrio.net/fougere-lite/internal/provider"
+)
+
+func init() {
+	provider.Register(&monitoringProvider{})
+}
+
+// monitoringProvider plugs the alert policies and notification channels into
+// the provider registry.
+type monitoringProvider struct {
+	client *Client
+}
+
+func (p *monitoringProvider) Key() string {
+	return Product
+}
+
+// DependsOn creates the queues, buckets and log-based metrics before the
+// policies watching them.
+func (p *monitoringProvider) DependsOn() []string {
+	return []string{cloudtasks.Product, cloudstorage.Product, logging.Product}
+}
+
+// Before orders the channels before the policies notifying them.
+func (p *monitoringProvider) Before(a, b common.ResourceChange) bool {
+	return !isPolicy(a) && isPolicy(b)
+}
+
+func (p *monitoringProvider) Init(ctx context.Context, opts ...option.ClientOption) error {
+	client, err := NewClient(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	p.client = client
+	return nil
+}
+
+func (p *monitoringProvider) GetConfig(viperConfig *viper.Viper, clientName string) (provider.Config, error) {
+	config, err := GetMonitoringConfig(viperConfig, clientName)
+	if err != nil || config == nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (p *monitoringProvider) ValidateConfig(config provider.Config) error {
+	return ValidateConfig(config.(*Config))
+}
+
+func (p *monitoringProvider) Resources(config provider.Config) []common.ResourceChange {
+	var resources []common.ResourceChange
+	for key, channel := range config.(*Config).Monitoring.NotificationChannels {
+		resources = append(resources, common.ResourceChange{
+			Client:  channel.ClientName,
+			Product: Product,
+			Key:     channelsKey + "." + key,
+			Name:    channel.DisplayName,
+			Project: channel.ProjectId,
+		})
+	}
+	for key, policy := range config.(*Config).Monitoring.AlertPolicies {
+		resources = append(resources, common.ResourceChange{
+			Client:  policy.ClientName,
+			Product: Product,
+			Key:     policiesKey + "." + key,
+			Name:    policy.DisplayName,
+			Project: policy.ProjectId,
+		})
+	}
+	return resources
+}
+
+func (p *monitoringProvider) Create(config provider.Config) ([]common.AppliedResource, error) {
+	return p.client.Create(config.(*Config))
+}
+
+func (p *monitoringProvider) Plan(config provider.Config) ([]common.ResourceChange, error) {
+	return p.client.Plan(config.(*Config))
+}
+
+func (p *monitoringProvider) Verify(change common.ResourceChange) error {
+	return p.client.Verify(change)
+}
+
+func (p *monitoringProvider) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	return p.client.Apply(change)
+}
+
+// Delete deletes the alert policies and then the channels, with force the
+// channels still used by other policies are deleted too.
+func (p *monitoringProvider) Delete(config provider.Config, opts provider.DeleteOptions) error {
+	return p.client.Delete(config.(*Config), opts.Force)
+}
@@ -0,0 +1,150 @@
+// ©Copyright 2022 Metrio
+package secretmanager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/viper"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/gcp/cloudpubsub"
+)
+
+type Config struct {
+	Secrets map[string]Secret `mapstructure:"secrets" yaml:"secrets" validate:"dive"`
+}
+
+type Secret struct {
+	Name      string `json:"name" yaml:"-" validate:"required"`
+	ProjectId string `json:"projectId" yaml:"projectId" validate:"required"`
+	// Locations are the regions the secret is replicated to. The replication
+	// is automatic when they are empty.
+	Locations []string          `json:"locations" yaml:"locations,omitempty"`
+	Labels    map[string]string `json:"labels" yaml:"labels,omitempty"`
+	// Topics are the keys of topics of the client's pubsub config, or full
+	// topic names, notified of the rotations of the secret.
+	Topics   []string  `json:"topics" yaml:"topics,omitempty"`
+	Rotation *Rotation `json:"rotation" yaml:"rotation,omitempty" validate:"omitempty"`
+	// Value is where the payload of the secret is read from. Payloads are never
+	// written in the config.
+	Value      *Value   `json:"value" yaml:"value,omitempty" validate:"omitempty"`
+	TopicNames []string `json:"-" yaml:"-"`
+	ClientName string   `yaml:"-"`
+}
+
+type Rotation struct {
+	// Period is the duration between two rotations, of at least 1h.
+	Period string `json:"period" yaml:"period" validate:"required"`
+	// NextRotationTime is an RFC 3339 time. It defaults to a period after the
+	// secret is created.
+	NextRotationTime string `json:"nextRotationTime" yaml:"nextRotationTime,omitempty"`
+}
+
+// Value reads the payload of a secret from exactly one of a file and an
+// environment variable.
+type Value struct {
+	// File is a path relative to the config file, resolved by
+	// GetSecretConfig.
+	File string `json:"file" yaml:"file,omitempty"`
+	Env  string `json:"env" yaml:"env,omitempty"`
+}
+
+const minRotationPeriod = time.Hour
+
+// GetSecretConfig parses the secrets of a client. The topics referenced by key
+// are resolved with the pubsub config of the same client.
+func GetSecretConfig(viperConfig *viper.Viper, clientName string) (*Config, error) {
+	if viperConfig == nil {
+		return nil, nil
+	}
+
+	var secretConfig Config
+	err := viperConfig.Unmarshal(&secretConfig)
+	if err != nil {
+		return nil, err
+	}
+	pubsubConfig, err := cloudpubsub.GetPubSubConfig(viperConfig, clientName)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, secret := range secretConfig.Secrets {
+		secret.Name = cloudpubsub.ResourceName(clientName, name)
+		secret.ClientName = clientName
+		if secret.Value != nil && secret.Value.File != "" && !filepath.IsAbs(secret.Value.File) && viper.ConfigFileUsed() != "" {
+			secret.Value.File = filepath.Join(filepath.Dir(viper.ConfigFileUsed()), secret.Value.File)
+		}
+		secret.TopicNames = nil
+		for _, topic := range secret.Topics {
+			if strings.Contains(topic, "/") {
+				secret.TopicNames = append(secret.TopicNames, topic)
+			} else if declared, ok := pubsubConfig.PubSub.Topics[topic]; ok {
+				secret.TopicNames = append(secret.TopicNames, cloudpubsub.TopicName(declared))
+			}
+		}
+
+		secretConfig.Secrets[name] = secret
+	}
+	return &secretConfig, nil
+}
+
+func ValidateConfig(config *Config) error {
+	v := validator.New()
+	if err := v.Struct(config); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			return fmt.Errorf("%s validate failed on the %s rule", err.Namespace(), err.Tag())
+		}
+	}
+	for key, secret := range config.Secrets {
+		if err := common.ValidateLabels(secret.Labels); err != nil {
+			return fmt.Errorf("secret %s: %s", key, err)
+		}
+		if len(secret.TopicNames) != len(secret.Topics) {
+			return fmt.Errorf("secret %s: every topic must be declared in pubsub or be a full topic name", key)
+		}
+		if secret.Rotation != nil {
+			if len(secret.Topics) == 0 {
+				return fmt.Errorf("secret %s: a rotation requires at least one topic", key)
+			}
+			period, err := time.ParseDuration(secret.Rotation.Period)
+			if err != nil {
+				return fmt.Errorf("secret %s: invalid rotation period %s", key, secret.Rotation.Period)
+			}
+			if period < minRotationPeriod {
+				return fmt.Errorf("secret %s: rotation period must be at least %s", key, minRotationPeriod)
+			}
+			if secret.Rotation.NextRotationTime != "" {
+				if _, err := time.Parse(time.RFC3339, secret.Rotation.NextRotationTime); err != nil {
+					return fmt.Errorf("secret %s: invalid next rotation time %s", key, secret.Rotation.NextRotationTime)
+				}
+			}
+		}
+		if secret.Value != nil && (secret.Value.File == "") == (secret.Value.Env == "") {
+			return fmt.Errorf("secret %s: value must have exactly one of file and env", key)
+		}
+	}
+	return nil
+}
+
+// read returns the payload, or nil when there is no value.
+func (v *Value) read() ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if v.Env != "" {
+		payload, ok := os.LookupEnv(v.Env)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", v.Env)
+		}
+		return []byte(payload), nil
+	}
+	payload, err := os.ReadFile(v.File)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the value file: %s", err)
+	}
+	return payload, nil
+}
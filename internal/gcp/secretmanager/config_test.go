@@ -0,0 +1,119 @@
+package secretmanager
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+var validSecretConfig = []byte(`
+pubsub:
+  topics:
+    rotations:
+      projectId: some-project
+secrets:
+  db-password:
+    projectId: some-project
+    locations:
+      - northamerica-northeast1
+      - us-east1
+    labels:
+      team: data
+    topics:
+      - rotations
+    rotation:
+      period: 720h
+    value:
+      env: DB_PASSWORD
+  api-key:
+    projectId: some-project
+    value:
+      file: /etc/api-key`)
+
+var invalidConfig = []byte(`
+secrets:
+  some-secret:
+    labels:
+      - should_not_be_an_array`)
+
+var _ = Describe("config", func() {
+	BeforeEach(func() {
+		viper.Reset()
+		viper.SetConfigType("yaml")
+	})
+	Describe("GetSecretConfig", func() {
+		It("should successfully parse a secret config and resolve its topics", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(validSecretConfig))
+			Expect(err).ToNot(HaveOccurred())
+			secretConfig, err := GetSecretConfig(viper.GetViper(), "some-client")
+			Expect(err).To(BeNil())
+			Expect(secretConfig.Secrets).To(HaveLen(2))
+			secret := secretConfig.Secrets["db-password"]
+			Expect(secret.Name).To(Equal("some-client-db-password"))
+			Expect(secret.Locations).To(Equal([]string{"northamerica-northeast1", "us-east1"}))
+			Expect(secret.Labels).To(Equal(map[string]string{"team": "data"}))
+			Expect(secret.TopicNames).To(Equal([]string{"projects/some-project/topics/some-client-rotations"}))
+			Expect(secret.Rotation.Period).To(Equal("720h"))
+			Expect(secret.Value.Env).To(Equal("DB_PASSWORD"))
+			Expect(secretConfig.Secrets["api-key"].Value.File).To(Equal("/etc/api-key"))
+			Expect(ValidateConfig(secretConfig)).To(Succeed())
+		})
+		It("returns an error if cannot parse the config", func() {
+			err := viper.ReadConfig(bytes.NewBuffer(invalidConfig))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = GetSecretConfig(viper.GetViper(), "some-client")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("validates secrets", func() {
+		var secret Secret
+
+		BeforeEach(func() {
+			secret = Secret{
+				Name:       "foooo",
+				ProjectId:  "mock-project",
+				Topics:     []string{"projects/mock-project/topics/rotations"},
+				TopicNames: []string{"projects/mock-project/topics/rotations"},
+				Rotation:   &Rotation{Period: "24h"},
+				Value:      &Value{Env: "FOOOO"},
+			}
+		})
+
+		It("should not detect error", func() {
+			Expect(ValidateConfig(&Config{Secrets: map[string]Secret{"foooo": secret}})).To(Succeed())
+		})
+		It("should detect a topic that is not declared", func() {
+			secret.Topics = append(secret.Topics, "unknown")
+			err := ValidateConfig(&Config{Secrets: map[string]Secret{"foooo": secret}})
+			Expect(err).To(MatchError("secret foooo: every topic must be declared in pubsub or be a full topic name"))
+		})
+		It("should detect a rotation without topic", func() {
+			secret.Topics = nil
+			secret.TopicNames = nil
+			err := ValidateConfig(&Config{Secrets: map[string]Secret{"foooo": secret}})
+			Expect(err).To(MatchError("secret foooo: a rotation requires at least one topic"))
+		})
+		It("should detect a rotation period that is too short", func() {
+			secret.Rotation.Period = "30m"
+			err := ValidateConfig(&Config{Secrets: map[string]Secret{"foooo": secret}})
+			Expect(err).To(MatchError("secret foooo: rotation period must be at least 1h0m0s"))
+		})
+		It("should detect an invalid next rotation time", func() {
+			secret.Rotation.NextRotationTime = "tomorrow"
+			err := ValidateConfig(&Config{Secrets: map[string]Secret{"foooo": secret}})
+			Expect(err).To(MatchError("secret foooo: invalid next rotation time tomorrow"))
+		})
+		It("should detect a value with both a file and an env", func() {
+			secret.Value.File = "/etc/foooo"
+			err := ValidateConfig(&Config{Secrets: map[string]Secret{"foooo": secret}})
+			Expect(err).To(MatchError("secret foooo: value must have exactly one of file and env"))
+		})
+		It("should detect a secret without project", func() {
+			secret.ProjectId = ""
+			err := ValidateConfig(&Config{Secrets: map[string]Secret{"foooo": secret}})
+			Expect(err).To(MatchError("Config.Secrets[foooo].ProjectId validate failed on the required rule"))
+		})
+	})
+})
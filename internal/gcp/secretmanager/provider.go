@@ -0,0 +1,85 @@
+package secretmanager
+
+import (
+	"context"
+
+	"github.com/spf13/viper"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/gcp/cloudpubsub"
+	"metrio.net/fougere-lite/internal/provider"
+)
+
+func init() {
+	provider.Register(&secretsProvider{})
+}
+
+// secretsProvider plugs the Secret Manager secrets into the provider registry.
+type secretsProvider struct {
+	client *Client
+}
+
+func (p *secretsProvider) Key() string {
+	return Product
+}
+
+// DependsOn creates the topics before the secrets notifying them.
+func (p *secretsProvider) DependsOn() []string {
+	return []string{cloudpubsub.Product}
+}
+
+func (p *secretsProvider) Init(ctx context.Context, opts ...option.ClientOption) error {
+	client, err := NewClient(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	p.client = client
+	return nil
+}
+
+func (p *secretsProvider) GetConfig(viperConfig *viper.Viper, clientName string) (provider.Config, error) {
+	config, err := GetSecretConfig(viperConfig, clientName)
+	if err != nil || config == nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (p *secretsProvider) ValidateConfig(config provider.Config) error {
+	return ValidateConfig(config.(*Config))
+}
+
+func (p *secretsProvider) Resources(config provider.Config) []common.ResourceChange {
+	var resources []common.ResourceChange
+	for key, secret := range config.(*Config).Secrets {
+		resources = append(resources, common.ResourceChange{
+			Client:  secret.ClientName,
+			Product: Product,
+			Key:     key,
+			Name:    SecretName(secret),
+			Project: secret.ProjectId,
+		})
+	}
+	return resources
+}
+
+func (p *secretsProvider) Create(config provider.Config) ([]common.AppliedResource, error) {
+	return p.client.Create(config.(*Config))
+}
+
+func (p *secretsProvider) Plan(config provider.Config) ([]common.ResourceChange, error) {
+	return p.client.Plan(config.(*Config))
+}
+
+func (p *secretsProvider) Verify(change common.ResourceChange) error {
+	return p.client.Verify(change)
+}
+
+func (p *secretsProvider) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	return p.client.Apply(change)
+}
+
+// Delete only deletes the secrets that still have versions with force.
+func (p *secretsProvider) Delete(config provider.Config, opts provider.DeleteOptions) error {
+	return p.client.Delete(config.(*Config), opts.Force)
+}
@@ -0,0 +1,591 @@
+package secretmanager
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/secretmanager/v1"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Product is the key of the secrets in a client's config.
+const Product = "secrets"
+
+// secretUpdateMask lists the properties of a secret that can be patched. The
+// replication of a secret cannot be changed.
+const secretUpdateMask = "labels,topics,rotation"
+
+const versionDestroyed = "DESTROYED"
+
+type Client struct {
+	secretmanagerService *secretmanager.Service
+}
+
+func NewClient(ctx context.Context, opts ...option.ClientOption) (*Client, error) {
+	secretmanagerService, err := secretmanager.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		secretmanagerService: secretmanagerService,
+	}, nil
+}
+
+// plannedSecret is the desired state of a secret saved in a plan. It holds
+// where the payload is read from and its sha256, never the payload itself.
+type plannedSecret struct {
+	Secret      *secretmanager.Secret `json:"secret"`
+	Value       *Value                `json:"value,omitempty"`
+	AddVersion  bool                  `json:"addVersion"`
+	PayloadHash string                `json:"payloadHash,omitempty"`
+}
+
+// liveSecret is the live state of a secret hashed in a plan: the secret and
+// its latest version, so that a version added after the plan is detected.
+type liveSecret struct {
+	Secret        *secretmanager.Secret `json:"secret"`
+	LatestVersion string                `json:"latestVersion"`
+}
+
+// Create creates the secrets of the config that do not exist and updates the
+// others. A new version is added when the payload differs from the latest
+// version, previous versions are kept. It returns the secrets that were
+// applied, even when some failed.
+func (c *Client) Create(config *Config) ([]common.AppliedResource, error) {
+	createChannel := make(chan common.Response, len(config.Secrets))
+	for key, secret := range config.Secrets {
+		go func(resp chan common.Response, key string, secret Secret) {
+			spec := c.createSecretSpec(secret)
+			payload, err := secret.Value.read()
+			if err != nil {
+				resp <- common.Response{Err: fmt.Errorf("[%s] %s", spec.Name, err)}
+				return
+			}
+			addVersion := payload != nil
+			live, err := c.get(spec.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+					utils.Logger.Debugf("[%s] secret not found", spec.Name)
+
+					if err := c.insert(spec); err != nil {
+						resp <- common.Response{Err: err}
+						return
+					}
+				} else {
+					utils.Logger.Errorf("[%s] error getting secret: %s", spec.Name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+			} else {
+				keepNextRotationTime(live, spec, secret)
+				spec.Labels = common.MergeLabels(live.Labels, spec.Labels)
+				if err := checkImmutable(spec.Name, diffSecret(live, spec)); err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+				if err := c.patch(spec); err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+				if payload != nil {
+					latest, err := c.latestPayload(spec.Name)
+					if err != nil {
+						resp <- common.Response{Err: err}
+						return
+					}
+					addVersion = latest == nil || !bytes.Equal(latest.data, payload)
+				}
+			}
+			if addVersion {
+				if err := c.addVersion(spec.Name, payload); err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+			}
+			resp <- common.Response{Applied: &common.AppliedResource{
+				Client:  secret.ClientName,
+				Product: Product,
+				Key:     key,
+				Name:    spec.Name,
+				Spec:    spec,
+			}}
+		}(createChannel, key, secret)
+	}
+	var applied []common.AppliedResource
+	var createErr error
+	for range config.Secrets {
+		resp := <-createChannel
+		if resp.Err != nil {
+			createErr = resp.Err
+			continue
+		}
+		applied = append(applied, *resp.Applied)
+	}
+	return applied, createErr
+}
+
+// Plan compares every secret of the config with its live state and returns the
+// change Create would make to each of them. A payload that differs from the
+// latest version is reported as a payload diff, without the payloads.
+func (c *Client) Plan(config *Config) ([]common.ResourceChange, error) {
+	planChannel := make(chan common.Response, len(config.Secrets))
+	for key, secret := range config.Secrets {
+		go func(resp chan common.Response, key string, secret Secret) {
+			spec := c.createSecretSpec(secret)
+			change := common.ResourceChange{
+				Client:  secret.ClientName,
+				Product: Product,
+				Key:     key,
+				Name:    spec.Name,
+				Project: secret.ProjectId,
+			}
+			payload, err := secret.Value.read()
+			if err != nil {
+				resp <- common.Response{Err: fmt.Errorf("[%s] %s", spec.Name, err)}
+				return
+			}
+			planned := plannedSecret{Secret: spec, Value: secret.Value, AddVersion: payload != nil, PayloadHash: payloadHash(payload)}
+			live, err := c.get(spec.Name)
+			if err != nil {
+				if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+					utils.Logger.Errorf("[%s] error getting secret: %s", spec.Name, err)
+					resp <- common.Response{Err: err}
+					return
+				}
+				change.Action = common.ActionCreate
+			} else {
+				keepNextRotationTime(live, spec, secret)
+				spec.Labels = common.MergeLabels(live.Labels, spec.Labels)
+				if change.LiveHash, err = c.liveHash(live); err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+				change.Diffs = diffSecret(live, spec)
+				if payload != nil {
+					latest, err := c.latestPayload(spec.Name)
+					if err != nil {
+						resp <- common.Response{Err: err}
+						return
+					}
+					planned.AddVersion = latest == nil || !bytes.Equal(latest.data, payload)
+					if planned.AddVersion {
+						current := "no version"
+						if latest != nil {
+							current = latest.version
+						}
+						change.Diffs = append(change.Diffs, common.FieldDiff{Field: "payload", Current: current, Desired: "new version"})
+					}
+				}
+				change.Action = common.ActionNoop
+				if len(change.Diffs) > 0 {
+					change.Action = common.ActionUpdate
+				}
+			}
+			if change.Desired, err = json.Marshal(planned); err != nil {
+				resp <- common.Response{Err: err}
+				return
+			}
+			resp <- common.Response{Change: change}
+		}(planChannel, key, secret)
+	}
+	var changes []common.ResourceChange
+	var planErr error
+	for range config.Secrets {
+		resp := <-planChannel
+		if resp.Err != nil {
+			planErr = resp.Err
+			continue
+		}
+		changes = append(changes, resp.Change)
+	}
+	return changes, planErr
+}
+
+// Verify returns an error if the live secret is not in the state it was in
+// when the change was planned.
+func (c *Client) Verify(change common.ResourceChange) error {
+	liveHash := ""
+	live, err := c.get(change.Name)
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+			return err
+		}
+	} else {
+		if liveHash, err = c.liveHash(live); err != nil {
+			return err
+		}
+	}
+	if liveHash != change.LiveHash {
+		return fmt.Errorf("[%s] secret changed since the plan was made", change.Name)
+	}
+	return nil
+}
+
+// Apply runs a planned change with the spec saved in the plan. The payload of
+// a new version is read when the plan is applied and must be the one that was
+// planned. It returns the applied secret, or nil when there was nothing to do.
+func (c *Client) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	if change.Action == common.ActionNoop {
+		return nil, nil
+	}
+	var planned plannedSecret
+	if err := json.Unmarshal(change.Desired, &planned); err != nil || planned.Secret == nil {
+		return nil, fmt.Errorf("[%s] invalid planned spec: %v", change.Name, err)
+	}
+	var payload []byte
+	if planned.AddVersion {
+		var err error
+		if payload, err = planned.Value.read(); err != nil {
+			return nil, fmt.Errorf("[%s] %s", change.Name, err)
+		}
+		if payloadHash(payload) != planned.PayloadHash {
+			return nil, fmt.Errorf("[%s] the value of the secret changed since the plan was made", change.Name)
+		}
+	}
+	var err error
+	switch change.Action {
+	case common.ActionCreate:
+		err = c.insert(planned.Secret)
+	case common.ActionUpdate:
+		if err := checkImmutable(change.Name, change.Diffs); err != nil {
+			return nil, err
+		}
+		err = c.patch(planned.Secret)
+	default:
+		return nil, fmt.Errorf("[%s] unknown action %s", change.Name, change.Action)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if planned.AddVersion {
+		if err := c.addVersion(change.Name, payload); err != nil {
+			return nil, err
+		}
+	}
+	return &common.AppliedResource{
+		Client:  change.Client,
+		Product: Product,
+		Key:     change.Key,
+		Name:    change.Name,
+		Spec:    planned.Secret,
+	}, nil
+}
+
+// Delete deletes every secret of the config with all its versions. Secrets
+// that still have versions that are not destroyed are only deleted with force.
+// Secrets that do not exist are ignored.
+func (c *Client) Delete(config *Config, force bool) error {
+	deleteChannel := make(chan common.Response, len(config.Secrets))
+	for _, secret := range config.Secrets {
+		go func(resp chan common.Response, secret Secret) {
+			name := SecretName(secret)
+			if !force {
+				versions, err := c.countVersions(name)
+				if err != nil {
+					resp <- common.Response{Err: err}
+					return
+				}
+				if versions > 0 {
+					resp <- common.Response{Err: fmt.Errorf("[%s] secret has %d versions, use --force to destroy them", name, versions)}
+					return
+				}
+			}
+			resp <- common.Response{Err: c.delete(name)}
+		}(deleteChannel, secret)
+	}
+	var deleteErr error
+	for range config.Secrets {
+		resp := <-deleteChannel
+		if resp.Err != nil {
+			deleteErr = resp.Err
+		}
+	}
+	return deleteErr
+}
+
+func (c *Client) get(name string) (*secretmanager.Secret, error) {
+	utils.Logger.Debugf("[%s] getting secret", name)
+	secret, err := c.secretmanagerService.Projects.Secrets.Get(name).Do()
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+func (c *Client) insert(spec *secretmanager.Secret) error {
+	utils.Logger.Infof("[%s] creating secret", spec.Name)
+	parent, secretId := splitSecretName(spec.Name)
+	secret := *spec
+	secret.Name = ""
+	_, err := c.secretmanagerService.Projects.Secrets.Create(parent, &secret).SecretId(secretId).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error creating secret: %s", spec.Name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) patch(spec *secretmanager.Secret) error {
+	utils.Logger.Infof("[%s] updating secret", spec.Name)
+	_, err := c.secretmanagerService.Projects.Secrets.Patch(spec.Name, spec).UpdateMask(secretUpdateMask).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error updating secret: %s", spec.Name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) delete(name string) error {
+	utils.Logger.Infof("[%s] deleting secret", name)
+	_, err := c.secretmanagerService.Projects.Secrets.Delete(name).Do()
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			utils.Logger.Infof("[%s] secret already deleted", name)
+			return nil
+		}
+		utils.Logger.Errorf("[%s] error deleting secret: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) addVersion(name string, payload []byte) error {
+	utils.Logger.Infof("[%s] adding secret version", name)
+	_, err := c.secretmanagerService.Projects.Secrets.AddVersion(name, &secretmanager.AddSecretVersionRequest{
+		Payload: &secretmanager.SecretPayload{Data: base64.StdEncoding.EncodeToString(payload)},
+	}).Do()
+	if err != nil {
+		utils.Logger.Errorf("[%s] error adding secret version: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+// versionPayload is the payload of a version and the version's name.
+type versionPayload struct {
+	version string
+	data    []byte
+}
+
+// latestPayload returns the payload of the latest version of the secret, or
+// nil if the secret has no enabled version.
+func (c *Client) latestPayload(name string) (*versionPayload, error) {
+	utils.Logger.Debugf("[%s] accessing latest secret version", name)
+	version, err := c.secretmanagerService.Projects.Secrets.Versions.Access(name + "/versions/latest").Do()
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && (e.Code == http.StatusNotFound || e.Code == http.StatusBadRequest) {
+			return nil, nil
+		}
+		utils.Logger.Errorf("[%s] error accessing latest secret version: %s", name, err)
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(version.Payload.Data)
+	if err != nil {
+		return nil, fmt.Errorf("[%s] invalid payload of version %s: %s", name, version.Name, err)
+	}
+	return &versionPayload{version: version.Name, data: data}, nil
+}
+
+// latestVersion returns the name of the latest version of the secret, empty
+// when it has none. Unlike latestPayload, it does not access the payload.
+func (c *Client) latestVersion(name string) (string, error) {
+	utils.Logger.Debugf("[%s] getting latest secret version", name)
+	version, err := c.secretmanagerService.Projects.Secrets.Versions.Get(name + "/versions/latest").Do()
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && (e.Code == http.StatusNotFound || e.Code == http.StatusBadRequest) {
+			return "", nil
+		}
+		utils.Logger.Errorf("[%s] error getting latest secret version: %s", name, err)
+		return "", err
+	}
+	return version.Name, nil
+}
+
+// liveHash returns the hash of the live secret and of its latest version.
+func (c *Client) liveHash(live *secretmanager.Secret) (string, error) {
+	latest, err := c.latestVersion(live.Name)
+	if err != nil {
+		return "", err
+	}
+	return common.HashResource(liveSecret{Secret: live, LatestVersion: latest})
+}
+
+// payloadHash returns the hex sha256 of a payload, empty when there is none.
+func payloadHash(payload []byte) string {
+	if payload == nil {
+		return ""
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// countVersions returns the number of versions of the secret that are not
+// destroyed.
+func (c *Client) countVersions(name string) (int, error) {
+	count := 0
+	err := c.secretmanagerService.Projects.Secrets.Versions.List(name).Pages(context.Background(), func(page *secretmanager.ListSecretVersionsResponse) error {
+		for _, version := range page.Versions {
+			if version.State != versionDestroyed {
+				count++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			return 0, nil
+		}
+		utils.Logger.Errorf("[%s] error listing secret versions: %s", name, err)
+		return 0, err
+	}
+	return count, nil
+}
+
+func (c *Client) createSecretSpec(secret Secret) *secretmanager.Secret {
+	labels := map[string]string{}
+	for key, value := range secret.Labels {
+		labels[key] = value
+	}
+	for key, value := range common.OwnershipLabels(secret.ClientName) {
+		labels[key] = value
+	}
+	spec := &secretmanager.Secret{
+		Name:        SecretName(secret),
+		Labels:      labels,
+		Replication: &secretmanager.Replication{Automatic: &secretmanager.Automatic{}},
+	}
+	if len(secret.Locations) > 0 {
+		var replicas []*secretmanager.Replica
+		for _, location := range secret.Locations {
+			replicas = append(replicas, &secretmanager.Replica{Location: location})
+		}
+		spec.Replication = &secretmanager.Replication{UserManaged: &secretmanager.UserManaged{Replicas: replicas}}
+	}
+	for _, topic := range secret.TopicNames {
+		spec.Topics = append(spec.Topics, &secretmanager.Topic{Name: topic})
+	}
+	if secret.Rotation != nil {
+		period, err := time.ParseDuration(secret.Rotation.Period)
+		if err == nil {
+			spec.Rotation = &secretmanager.Rotation{
+				RotationPeriod:   fmt.Sprintf("%ds", int64(period.Seconds())),
+				NextRotationTime: secret.Rotation.NextRotationTime,
+			}
+			if spec.Rotation.NextRotationTime == "" {
+				spec.Rotation.NextRotationTime = time.Now().UTC().Add(period).Format(time.RFC3339)
+			}
+		}
+	}
+	return spec
+}
+
+// keepNextRotationTime keeps the next rotation time of the live secret when the
+// config does not set one, so that it is not pushed back on every update.
+func keepNextRotationTime(live *secretmanager.Secret, spec *secretmanager.Secret, secret Secret) {
+	if spec.Rotation == nil || secret.Rotation.NextRotationTime != "" {
+		return
+	}
+	if live.Rotation != nil && live.Rotation.NextRotationTime != "" {
+		spec.Rotation.NextRotationTime = live.Rotation.NextRotationTime
+	}
+}
+
+// diffSecret lists the properties of the desired spec that differ from the
+// live secret.
+func diffSecret(live *secretmanager.Secret, desired *secretmanager.Secret) []common.FieldDiff {
+	var diffs []common.FieldDiff
+	diffs = common.AppendDiff(diffs, "replication", replicationLocations(live), replicationLocations(desired))
+	diffs = common.AppendDiff(diffs, "topics", topicNames(live), topicNames(desired))
+	liveRotation := live.Rotation
+	if liveRotation == nil {
+		liveRotation = &secretmanager.Rotation{}
+	}
+	desiredRotation := desired.Rotation
+	if desiredRotation == nil {
+		desiredRotation = &secretmanager.Rotation{}
+	}
+	if !sameDuration(liveRotation.RotationPeriod, desiredRotation.RotationPeriod) {
+		diffs = append(diffs, common.FieldDiff{Field: "rotation.rotationPeriod", Current: liveRotation.RotationPeriod, Desired: desiredRotation.RotationPeriod})
+	}
+	if !sameTime(liveRotation.NextRotationTime, desiredRotation.NextRotationTime) {
+		diffs = append(diffs, common.FieldDiff{Field: "rotation.nextRotationTime", Current: liveRotation.NextRotationTime, Desired: desiredRotation.NextRotationTime})
+	}
+	diffs = common.AppendLabelDiffs(diffs, "labels", live.Labels, desired.Labels)
+	return diffs
+}
+
+// checkImmutable returns an error if the diffs change the replication of a
+// secret, which cannot be patched.
+func checkImmutable(name string, diffs []common.FieldDiff) error {
+	for _, diff := range diffs {
+		if diff.Field == "replication" {
+			return fmt.Errorf("[%s] the replication of a secret cannot be changed from %v to %v, delete the secret first", name, diff.Current, diff.Desired)
+		}
+	}
+	return nil
+}
+
+// replicationLocations returns the sorted replica locations, or automatic.
+func replicationLocations(secret *secretmanager.Secret) []string {
+	if secret.Replication == nil || secret.Replication.UserManaged == nil {
+		return []string{"automatic"}
+	}
+	var locations []string
+	for _, replica := range secret.Replication.UserManaged.Replicas {
+		locations = append(locations, replica.Location)
+	}
+	sort.Strings(locations)
+	return locations
+}
+
+func topicNames(secret *secretmanager.Secret) []string {
+	names := []string{}
+	for _, topic := range secret.Topics {
+		names = append(names, topic.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sameDuration(a, b string) bool {
+	durationA, errA := time.ParseDuration(a)
+	durationB, errB := time.ParseDuration(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return durationA == durationB
+}
+
+// sameTime compares two RFC 3339 times, GCP may return them with a different
+// precision.
+func sameTime(a, b string) bool {
+	timeA, errA := time.Parse(time.RFC3339, a)
+	timeB, errB := time.Parse(time.RFC3339, b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return timeA.Equal(timeB)
+}
+
+// splitSecretName returns the parent project and the id of a secret.
+func splitSecretName(name string) (string, string) {
+	parent, secretId, _ := strings.Cut(name, "/secrets/")
+	return parent, secretId
+}
+
+// SecretName returns the full resource name of a secret.
+func SecretName(secret Secret) string {
+	return "projects/" + secret.ProjectId + "/secrets/" + secret.Name
+}
@@ -0,0 +1,13 @@
+package secretmanager_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestSecretmanager(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Secretmanager Suite")
+}
@@ -0,0 +1,279 @@
+package secretmanager
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/option"
+	"google.golang.org/api/secretmanager/v1"
+	"metrio.net/fougere-lite/internal/common"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Helper method to create client
+func getMockedClient(url string) *Client {
+	client, err := NewClient(context.Background(), option.WithoutAuthentication(), option.WithEndpoint(url))
+	if err != nil {
+		Fail(err.Error())
+	}
+	return client
+}
+
+var _ = Describe("Secret Manager client", func() {
+	var secret Secret
+	var secretName string
+	var config *Config
+
+	BeforeEach(func() {
+		valueFile := filepath.Join(GinkgoT().TempDir(), "value")
+		Expect(os.WriteFile(valueFile, []byte("s3cr3t"), 0o600)).To(Succeed())
+		secret = Secret{
+			Name:       "banane-password",
+			ProjectId:  "projet-123",
+			Labels:     map[string]string{"team": "data"},
+			Value:      &Value{File: valueFile},
+			ClientName: "banane",
+		}
+		secretName = "projects/projet-123/secrets/banane-password"
+		config = &Config{Secrets: map[string]Secret{"password": secret}}
+	})
+
+	liveSecret := func() secretmanager.Secret {
+		return secretmanager.Secret{
+			Name:        secretName,
+			Labels:      map[string]string{"team": "data", "managed-by": "fougere-lite", "fougere-lite-client": "banane"},
+			Replication: &secretmanager.Replication{Automatic: &secretmanager.Automatic{}},
+		}
+	}
+	latestVersion := func(payload string) secretmanager.AccessSecretVersionResponse {
+		return secretmanager.AccessSecretVersionResponse{
+			Name:    secretName + "/versions/3",
+			Payload: &secretmanager.SecretPayload{Data: base64.StdEncoding.EncodeToString([]byte(payload))},
+		}
+	}
+
+	latestVersionMetadata := func() secretmanager.SecretVersion {
+		return secretmanager.SecretVersion{Name: secretName + "/versions/3", State: "ENABLED"}
+	}
+
+	Describe("create secret spec", func() {
+		It("replicates the secret automatically without locations", func() {
+			client := &Client{}
+			spec := client.createSecretSpec(secret)
+			Expect(spec.Name).To(Equal(secretName))
+			Expect(spec.Replication.Automatic).ToNot(BeNil())
+			Expect(spec.Labels).To(HaveKeyWithValue("team", "data"))
+			Expect(spec.Labels).To(HaveKeyWithValue("managed-by", "fougere-lite"))
+		})
+		It("replicates the secret to its locations", func() {
+			secret.Locations = []string{"us-east1", "northamerica-northeast1"}
+			secret.TopicNames = []string{"projects/projet-123/topics/rotations"}
+			secret.Rotation = &Rotation{Period: "24h", NextRotationTime: "2030-01-01T00:00:00Z"}
+			client := &Client{}
+			spec := client.createSecretSpec(secret)
+			Expect(spec.Replication.UserManaged.Replicas).To(HaveLen(2))
+			Expect(spec.Topics[0].Name).To(Equal("projects/projet-123/topics/rotations"))
+			Expect(spec.Rotation.RotationPeriod).To(Equal("86400s"))
+			Expect(spec.Rotation.NextRotationTime).To(Equal("2030-01-01T00:00:00Z"))
+		})
+	})
+	Describe("create secret", func() {
+		It("creates the secret and adds the first version", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 3)
+			mockServerCalls <- utils.MockServerCall{ResponseCode: 404}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/projects/projet-123/secrets?") && strings.Contains(url, "secretId=banane-password")
+				},
+				Method: "post",
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+secretName+":addVersion")
+				},
+				Method: "post",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			applied, err := client.Create(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(HaveLen(1))
+			Expect(applied[0].Name).To(Equal(secretName))
+		})
+		It("does not add a version when the payload did not change", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 3)
+			mockServerCalls <- utils.MockServerCall{ResponseBody: liveSecret()}
+			mockServerCalls <- utils.MockServerCall{Method: "patch"}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+secretName+"/versions/latest:access")
+				},
+				ResponseBody: latestVersion("s3cr3t"),
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			_, err := client.Create(config)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("refuses to change the replication of a secret", func() {
+			secret.Locations = []string{"us-east1"}
+			config.Secrets["password"] = secret
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{ResponseBody: liveSecret()}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			_, err := client.Create(config)
+			Expect(err).To(MatchError(ContainSubstring("replication of a secret cannot be changed")))
+		})
+	})
+	Describe("plan secret", func() {
+		It("plans a new version without showing the payload", func() {
+			live := liveSecret()
+			live.Labels["owner"] = "security"
+			mockServerCalls := make(chan utils.MockServerCall, 3)
+			mockServerCalls <- utils.MockServerCall{ResponseBody: live}
+			mockServerCalls <- utils.MockServerCall{ResponseBody: latestVersionMetadata()}
+			mockServerCalls <- utils.MockServerCall{ResponseBody: latestVersion("old")}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(HaveLen(1))
+			Expect(changes[0].Action).To(Equal(common.ActionUpdate))
+			Expect(changes[0].Diffs).To(ConsistOf(common.FieldDiff{Field: "payload", Current: secretName + "/versions/3", Desired: "new version"}))
+			Expect(string(changes[0].Desired)).ToNot(ContainSubstring("s3cr3t"))
+			Expect(string(changes[0].Desired)).To(ContainSubstring(`"addVersion":true`))
+			Expect(string(changes[0].Desired)).To(ContainSubstring(`"owner":"security"`))
+			Expect(string(changes[0].Desired)).To(ContainSubstring(`"payloadHash":"` + payloadHash([]byte("s3cr3t")) + `"`))
+		})
+		It("plans a no-op when the secret is up to date", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 3)
+			mockServerCalls <- utils.MockServerCall{ResponseBody: liveSecret()}
+			mockServerCalls <- utils.MockServerCall{ResponseBody: latestVersionMetadata()}
+			mockServerCalls <- utils.MockServerCall{ResponseBody: latestVersion("s3cr3t")}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			changes, err := client.Plan(config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes[0].Action).To(Equal(common.ActionNoop))
+		})
+	})
+	Describe("verify planned change", func() {
+		It("refuses a secret whose latest version changed since the plan was made", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 4)
+			mockServerCalls <- utils.MockServerCall{ResponseBody: liveSecret()}
+			mockServerCalls <- utils.MockServerCall{ResponseBody: latestVersionMetadata()}
+			mockServerCalls <- utils.MockServerCall{ResponseBody: liveSecret()}
+			mockServerCalls <- utils.MockServerCall{ResponseBody: secretmanager.SecretVersion{Name: secretName + "/versions/4", State: "ENABLED"}}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			live, err := client.get(secretName)
+			Expect(err).ToNot(HaveOccurred())
+			liveHash, err := client.liveHash(live)
+			Expect(err).ToNot(HaveOccurred())
+			change := common.ResourceChange{Product: Product, Name: secretName, Action: common.ActionUpdate, LiveHash: liveHash}
+			Expect(client.Verify(change)).To(MatchError(ContainSubstring("secret changed since the plan was made")))
+		})
+	})
+	Describe("apply planned change", func() {
+		It("refuses a value that changed since the plan was made", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 0)
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			change := common.ResourceChange{
+				Product: Product,
+				Name:    secretName,
+				Action:  common.ActionUpdate,
+				Desired: []byte(`{"secret":{"name":"` + secretName + `"},"value":{"file":"` + secret.Value.File + `"},"addVersion":true,"payloadHash":"` + payloadHash([]byte("old")) + `"}`),
+			}
+			_, err := client.Apply(change)
+			Expect(err).To(MatchError("[" + secretName + "] the value of the secret changed since the plan was made"))
+		})
+		It("reads the value again to add the planned version", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 2)
+			mockServerCalls <- utils.MockServerCall{Method: "patch"}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+secretName+":addVersion")
+				},
+				Method: "post",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			change := common.ResourceChange{
+				Product: Product,
+				Name:    secretName,
+				Action:  common.ActionUpdate,
+				Desired: []byte(`{"secret":{"name":"` + secretName + `"},"value":{"file":"` + secret.Value.File + `"},"addVersion":true,"payloadHash":"` + payloadHash([]byte("s3cr3t")) + `"}`),
+			}
+			applied, err := client.Apply(change)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied.Name).To(Equal(secretName))
+		})
+	})
+	Describe("delete secret", func() {
+		It("refuses to delete a secret with versions without force", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+secretName+"/versions?")
+				},
+				ResponseBody: secretmanager.ListSecretVersionsResponse{Versions: []*secretmanager.SecretVersion{
+					{Name: secretName + "/versions/1", State: "DESTROYED"},
+					{Name: secretName + "/versions/2", State: "ENABLED"},
+				}},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			err := client.Delete(config, false)
+			Expect(err).To(MatchError(ContainSubstring("secret has 1 versions, use --force")))
+		})
+		It("deletes the secret with force", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/v1/"+secretName+"?")
+				},
+				Method: "delete",
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			client := getMockedClient(mockServer.URL)
+
+			Expect(client.Delete(config, true)).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,87 @@
+package plan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"metrio.net/fougere-lite/internal/common"
+)
+
+// FormatVersion is the version of the plan file format written by this binary.
+const FormatVersion = 1
+
+// Plan is a saved change set. It records the hash of the config file it was
+// made from so that it can only be applied against that exact config.
+type Plan struct {
+	FormatVersion int                     `json:"formatVersion"`
+	CreatedAt     time.Time               `json:"createdAt"`
+	ConfigFile    string                  `json:"configFile"`
+	ConfigHash    string                  `json:"configHash"`
+	Changes       []common.ResourceChange `json:"changes"`
+}
+
+func New(configFile string, changes []common.ResourceChange) (*Plan, error) {
+	if configFile == "" {
+		return nil, fmt.Errorf("a config file is required to save a plan")
+	}
+	configHash, err := HashFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Plan{
+		FormatVersion: FormatVersion,
+		CreatedAt:     time.Now().UTC(),
+		ConfigFile:    configFile,
+		ConfigHash:    configHash,
+		Changes:       changes,
+	}, nil
+}
+
+func Read(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("error parsing plan %s: %s", path, err)
+	}
+	if p.FormatVersion != FormatVersion {
+		return nil, fmt.Errorf("plan %s has format version %d, expected %d", path, p.FormatVersion, FormatVersion)
+	}
+	return &p, nil
+}
+
+func (p *Plan) Write(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// VerifyConfig returns an error if the config file no longer matches the one
+// the plan was made from.
+func (p *Plan) VerifyConfig(configFile string) error {
+	configHash, err := HashFile(configFile)
+	if err != nil {
+		return err
+	}
+	if configHash != p.ConfigHash {
+		return fmt.Errorf("config file %s changed since the plan was made", configFile)
+	}
+	return nil
+}
+
+func HashFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
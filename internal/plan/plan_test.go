@@ -0,0 +1,65 @@
+// ©Copyright 2022 Metrio
+package plan
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"metrio.net/fougere-lite/internal/common"
+)
+
+var _ = Describe("plan", func() {
+	var configFile string
+	var planFile string
+	var changes []common.ResourceChange
+
+	BeforeEach(func() {
+		dir := GinkgoT().TempDir()
+		configFile = filepath.Join(dir, "fougere-lite.yaml")
+		planFile = filepath.Join(dir, "plan.json")
+		Expect(os.WriteFile(configFile, []byte("clients: {}"), 0o644)).To(Succeed())
+		changes = []common.ResourceChange{{
+			Client:  "banane",
+			Product: "storageBucket",
+			Key:     "patate",
+			Name:    "banane-patate-projet-123",
+			Action:  common.ActionCreate,
+			Desired: []byte(`{"name":"banane-patate-projet-123"}`),
+		}}
+	})
+
+	It("writes and reads back a plan", func() {
+		p, err := New(configFile, changes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.Write(planFile)).To(Succeed())
+
+		read, err := Read(planFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read.ConfigHash).To(Equal(p.ConfigHash))
+		Expect(read.Changes).To(HaveLen(1))
+		Expect(read.Changes[0].Name).To(Equal("banane-patate-projet-123"))
+		Expect(string(read.Changes[0].Desired)).To(MatchJSON(`{"name":"banane-patate-projet-123"}`))
+	})
+	It("requires a config file", func() {
+		_, err := New("", changes)
+		Expect(err).To(HaveOccurred())
+	})
+	It("accepts the config file it was made from", func() {
+		p, err := New(configFile, changes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.VerifyConfig(configFile)).To(Succeed())
+	})
+	It("refuses a config file that changed since the plan was made", func() {
+		p, err := New(configFile, changes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.WriteFile(configFile, []byte("clients: {client1: {}}"), 0o644)).To(Succeed())
+		Expect(p.VerifyConfig(configFile)).To(MatchError(ContainSubstring("changed since the plan was made")))
+	})
+	It("refuses a plan with an unknown format version", func() {
+		Expect(os.WriteFile(planFile, []byte(`{"formatVersion": 99}`), 0o644)).To(Succeed())
+		_, err := Read(planFile)
+		Expect(err).To(MatchError(ContainSubstring("format version")))
+	})
+})
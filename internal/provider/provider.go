@@ -0,0 +1,181 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/viper"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+)
+
+// Config is the config of a product for one client, as returned by
+// Provider.GetConfig. Each provider only receives the configs it returned.
+type Config interface{}
+
+// DeleteOptions are the options of `clients delete`.
+type DeleteOptions struct {
+	// Force deletes the content of the resources that cannot be deleted while
+	// they are not empty, e.g. the objects of a bucket.
+	Force bool
+	// DestroyKeys schedules the destruction of the versions of the crypto
+	// keys. The data encrypted with them cannot be decrypted anymore.
+	DestroyKeys bool
+}
+
+// ApplyOptions are the options of `clients create`, `clients plan` and
+// `clients apply`.
+type ApplyOptions struct {
+	// ConfirmRetentionLock allows locking the retention policies of buckets,
+	// which cannot be undone.
+	ConfirmRetentionLock bool
+	// EnforceSecureDefaults fails the validation of the resources that would
+	// be public without an explicit override.
+	EnforceSecureDefaults bool
+}
+
+// Provider manages one GCP product for every client of the config. Products
+// register their provider from an init function, see Register.
+type Provider interface {
+	// Key is the key of the product in a client's config, e.g. storageBucket.
+	Key() string
+	// Init creates the GCP clients of the product.
+	Init(ctx context.Context, opts ...option.ClientOption) error
+	// GetConfig parses the config of the product from a client's config.
+	GetConfig(viperConfig *viper.Viper, clientName string) (Config, error)
+	ValidateConfig(config Config) error
+	// Resources lists the declared resources of the config, with their GCP name.
+	Resources(config Config) []common.ResourceChange
+	// Create creates the resources of the config that do not exist and updates
+	// the others. It returns the applied resources, even when some failed.
+	Create(config Config) ([]common.AppliedResource, error)
+	// Plan returns the change Create would make to each resource of the config.
+	Plan(config Config) ([]common.ResourceChange, error)
+	// Verify returns an error if the live resource changed since it was planned.
+	Verify(change common.ResourceChange) error
+	// Apply runs a planned change. It returns nil when there was nothing to do.
+	Apply(change common.ResourceChange) (*common.AppliedResource, error)
+	Delete(config Config, opts DeleteOptions) error
+}
+
+// Dependent is implemented by the providers whose resources reference the
+// resources of other products, which must then be created first.
+type Dependent interface {
+	DependsOn() []string
+}
+
+// Orderer is implemented by the providers whose resources depend on other
+// resources of the same product, e.g. a subscription on its topic.
+type Orderer interface {
+	// Before returns true if the change a must be applied before b. Changes
+	// are otherwise applied in the order of their keys.
+	Before(a, b common.ResourceChange) bool
+}
+
+// Configurable is implemented by the providers whose validation, create or
+// apply depend on the options of the command.
+type Configurable interface {
+	SetApplyOptions(opts ApplyOptions)
+}
+
+// DeleteDescriber is implemented by the providers whose resources are not
+// simply deleted by Delete, e.g. the crypto keys that GCP never deletes.
+type DeleteDescriber interface {
+	// DescribeDelete returns what Delete does to the resource with the
+	// options, e.g. "delete" or "keep".
+	DescribeDelete(resource common.ResourceChange, opts DeleteOptions) string
+}
+
+// Pruner is implemented by the providers able to find the resources they
+// manage that are no longer declared.
+type Pruner interface {
+	// FindOrphans receives the names of the clients of the config and the
+	// configs of the product of every client. It returns changes with
+	// ActionDelete for the resources these clients own, leaving out the ones
+	// of the clients of other config files, and ActionUnmanaged for the
+	// undeclared ones it cannot prove it owns.
+	FindOrphans(clients []string, configs []Config) ([]common.ResourceChange, error)
+	// Prune deletes the orphans with ActionDelete.
+	Prune(orphans []common.ResourceChange) error
+}
+
+// Imported is an existing resource mapped back to its client and key.
+type Imported struct {
+	Client string
+	Key    string
+	Config interface{}
+}
+
+// Importer is implemented by the providers able to adopt existing resources.
+type Importer interface {
+	// Import lists the resources of a project and region. clientNames are the
+	// known clients, used to map the resource names back to their client.
+	// When onlyClient is set, only its resources are returned.
+	Import(projectId string, region string, clientNames []string, onlyClient string) ([]Imported, error)
+}
+
+// Exporter is implemented by the providers able to read back the live config
+// of the declared resources.
+type Exporter interface {
+	// Export returns the live config of the resources, indexed by key.
+	Export(config Config) (map[string]interface{}, error)
+}
+
+var registry = map[string]Provider{}
+
+// Register makes a provider available to the CLI. It panics if a provider is
+// registered twice for the same key.
+func Register(p Provider) {
+	if _, ok := registry[p.Key()]; ok {
+		panic(fmt.Sprintf("provider %s registered twice", p.Key()))
+	}
+	registry[p.Key()] = p
+}
+
+// Rank returns the position of a product in the order of All, or -1 if no
+// provider is registered for it.
+func Rank(key string) int {
+	for i, p := range All() {
+		if p.Key() == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func Get(key string) (Provider, bool) {
+	p, ok := registry[key]
+	return p, ok
+}
+
+// All returns the registered providers, each one after the providers it
+// depends on.
+func All() []Provider {
+	keys := make([]string, 0, len(registry))
+	for key := range registry {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var ordered []Provider
+	visited := map[string]bool{}
+	var visit func(key string)
+	visit = func(key string) {
+		p, ok := registry[key]
+		if !ok || visited[key] {
+			return
+		}
+		visited[key] = true
+		if dependent, ok := p.(Dependent); ok {
+			for _, dependency := range dependent.DependsOn() {
+				visit(dependency)
+			}
+		}
+		ordered = append(ordered, p)
+	}
+	for _, key := range keys {
+		visit(key)
+	}
+	return ordered
+}
@@ -0,0 +1,83 @@
+// ©Copyright 2022 Metrio
+package provider
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+	"google.golang.org/api/option"
+	"metrio.net/fougere-lite/internal/common"
+)
+
+type fakeProvider struct {
+	key       string
+	dependsOn []string
+}
+
+func (p *fakeProvider) Key() string { return p.key }
+func (p *fakeProvider) Init(ctx context.Context, opts ...option.ClientOption) error {
+	return nil
+}
+func (p *fakeProvider) GetConfig(viperConfig *viper.Viper, clientName string) (Config, error) {
+	return nil, nil
+}
+func (p *fakeProvider) ValidateConfig(config Config) error              { return nil }
+func (p *fakeProvider) Resources(config Config) []common.ResourceChange { return nil }
+func (p *fakeProvider) Create(config Config) ([]common.AppliedResource, error) {
+	return nil, nil
+}
+func (p *fakeProvider) Plan(config Config) ([]common.ResourceChange, error) { return nil, nil }
+func (p *fakeProvider) Verify(change common.ResourceChange) error           { return nil }
+func (p *fakeProvider) Apply(change common.ResourceChange) (*common.AppliedResource, error) {
+	return nil, nil
+}
+func (p *fakeProvider) Delete(config Config, opts DeleteOptions) error { return nil }
+func (p *fakeProvider) DependsOn() []string                            { return p.dependsOn }
+
+func keys(providers []Provider) []string {
+	var keys []string
+	for _, p := range providers {
+		keys = append(keys, p.Key())
+	}
+	return keys
+}
+
+var _ = Describe("provider registry", func() {
+	BeforeEach(func() {
+		registry = map[string]Provider{}
+	})
+
+	It("should return a registered provider", func() {
+		Register(&fakeProvider{key: "storageBucket"})
+		p, ok := Get("storageBucket")
+		Expect(ok).To(BeTrue())
+		Expect(p.Key()).To(Equal("storageBucket"))
+		_, ok = Get("cloudTasks")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should panic when a provider is registered twice", func() {
+		Register(&fakeProvider{key: "storageBucket"})
+		Expect(func() { Register(&fakeProvider{key: "storageBucket"}) }).To(Panic())
+	})
+
+	It("should order the providers by key", func() {
+		Register(&fakeProvider{key: "storageBucket"})
+		Register(&fakeProvider{key: "cloudTasks"})
+		Expect(keys(All())).To(Equal([]string{"cloudTasks", "storageBucket"}))
+	})
+
+	It("should order a provider after its dependencies", func() {
+		Register(&fakeProvider{key: "aaa", dependsOn: []string{"zzz"}})
+		Register(&fakeProvider{key: "zzz", dependsOn: []string{"mmm"}})
+		Register(&fakeProvider{key: "mmm"})
+		Expect(keys(All())).To(Equal([]string{"mmm", "zzz", "aaa"}))
+	})
+
+	It("should ignore the dependencies that are not registered", func() {
+		Register(&fakeProvider{key: "aaa", dependsOn: []string{"unknown"}})
+		Expect(keys(All())).To(Equal([]string{"aaa"}))
+	})
+})
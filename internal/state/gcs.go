@@ -0,0 +1,155 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+const gcsScheme = "gs://"
+
+// GCSBackend keeps the state in an object of a GCS bucket. The lock is a second
+// object created with a generation precondition of 0, which GCS only accepts
+// when the object does not exist yet. The state itself is saved with a
+// precondition on the generation it was loaded at, so that a write made behind
+// the back of the lock is never overwritten.
+type GCSBackend struct {
+	storageService *storage.Service
+	bucket         string
+	object         string
+	generation     int64
+	lockGeneration int64
+}
+
+func NewGCSBackend(ctx context.Context, bucket string, object string, opts ...option.ClientOption) (*GCSBackend, error) {
+	storageService, err := storage.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &GCSBackend{
+		storageService: storageService,
+		bucket:         bucket,
+		object:         object,
+	}, nil
+}
+
+func parseGCSLocation(location string) (string, string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(location, gcsScheme), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid state location %s, expected gs://BUCKET/OBJECT", location)
+	}
+	return parts[0], parts[1], nil
+}
+
+func (b *GCSBackend) location() string {
+	return gcsScheme + b.bucket + "/" + b.object
+}
+
+func (b *GCSBackend) lockObject() string {
+	return b.object + ".lock"
+}
+
+// Load returns an empty state when the object does not exist yet.
+func (b *GCSBackend) Load() (*State, error) {
+	object, err := b.storageService.Objects.Get(b.bucket, b.object).Do()
+	if err != nil {
+		if isStatus(err, http.StatusNotFound) {
+			b.generation = 0
+			return New(), nil
+		}
+		return nil, err
+	}
+	data, err := b.download(b.object, object.Generation)
+	if err != nil {
+		return nil, err
+	}
+	s, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	b.generation = object.Generation
+	return s, nil
+}
+
+func (b *GCSBackend) Save(s *State) error {
+	data, err := encode(s)
+	if err != nil {
+		return err
+	}
+	object, err := b.storageService.Objects.Insert(b.bucket, &storage.Object{Name: b.object, ContentType: "application/json"}).
+		Media(bytes.NewReader(data)).
+		IfGenerationMatch(b.generation).
+		Do()
+	if err != nil {
+		if isStatus(err, http.StatusPreconditionFailed) {
+			return fmt.Errorf("state %s was changed by another run since it was loaded", b.location())
+		}
+		return err
+	}
+	b.generation = object.Generation
+	return nil
+}
+
+func (b *GCSBackend) Lock() error {
+	data, err := json.Marshal(newLockInfo())
+	if err != nil {
+		return err
+	}
+	object, err := b.storageService.Objects.Insert(b.bucket, &storage.Object{Name: b.lockObject(), ContentType: "application/json"}).
+		Media(bytes.NewReader(data)).
+		IfGenerationMatch(0).
+		Do()
+	if err != nil {
+		if isStatus(err, http.StatusPreconditionFailed) {
+			lockData, err := b.download(b.lockObject(), 0)
+			if err != nil {
+				utils.Logger.Warnf("[%s] could not read the lock: %s", b.location(), err)
+			}
+			return lockedError(b.location(), lockData)
+		}
+		return err
+	}
+	b.lockGeneration = object.Generation
+	return nil
+}
+
+// Unlock only removes the lock taken by this backend.
+func (b *GCSBackend) Unlock() error {
+	return b.storageService.Objects.Delete(b.bucket, b.lockObject()).IfGenerationMatch(b.lockGeneration).Do()
+}
+
+func (b *GCSBackend) ForceUnlock() error {
+	err := b.storageService.Objects.Delete(b.bucket, b.lockObject()).Do()
+	if err != nil && !isStatus(err, http.StatusNotFound) {
+		return err
+	}
+	return nil
+}
+
+// download returns the content of an object, at a given generation unless it is 0.
+func (b *GCSBackend) download(object string, generation int64) ([]byte, error) {
+	call := b.storageService.Objects.Get(b.bucket, object)
+	if generation != 0 {
+		call = call.Generation(generation)
+	}
+	res, err := call.Download()
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return io.ReadAll(res.Body)
+}
+
+func isStatus(err error, code int) bool {
+	e, ok := err.(*googleapi.Error)
+	return ok && e.Code == code
+}
@@ -0,0 +1,174 @@
+// ©Copyright 2022 Metrio
+package state
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+	"metrio.net/fougere-lite/internal/utils"
+)
+
+// Helper method to create backend
+func getMockedGCSBackend(url string) *GCSBackend {
+	backend, err := NewGCSBackend(context.Background(), "state-bucket", "state.json", option.WithoutAuthentication(), option.WithEndpoint(url))
+	if err != nil {
+		Fail(err.Error())
+	}
+	return backend
+}
+
+var _ = Describe("GCS backend", func() {
+	It("parses a gs:// location", func() {
+		bucket, object, err := parseGCSLocation("gs://state-bucket/fougere-lite/state.json")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bucket).To(Equal("state-bucket"))
+		Expect(object).To(Equal("fougere-lite/state.json"))
+
+		_, _, err = parseGCSLocation("gs://state-bucket")
+		Expect(err).To(HaveOccurred())
+	})
+	Describe("load and save", func() {
+		It("creates the state object when it does not exist", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 2)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/state-bucket/o/state.json?")
+				},
+				ResponseCode: 404,
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/upload/storage/v1/b/state-bucket/o?") && strings.Contains(url, "ifGenerationMatch=0")
+				},
+				Method:       "post",
+				ResponseBody: storage.Object{Name: "state.json", Generation: 1},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			backend := getMockedGCSBackend(mockServer.URL)
+
+			s, err := backend.Load()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Clients).To(BeEmpty())
+			Expect(backend.Save(s)).To(Succeed())
+			Expect(backend.generation).To(Equal(int64(1)))
+		})
+		It("saves the state on the generation it was loaded at", func() {
+			loaded := New()
+			loaded.Set("banane", Resource{Product: "storageBucket", Key: "patate", UpdatedAt: time.Now().UTC()})
+			mockServerCalls := make(chan utils.MockServerCall, 3)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/state-bucket/o/state.json?") && !strings.Contains(url, "alt=media")
+				},
+				ResponseBody: storage.Object{Name: "state.json", Generation: 7},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.Contains(url, "alt=media") && strings.Contains(url, "generation=7")
+				},
+				ResponseBody: loaded,
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/upload/storage/v1/b/state-bucket/o?") && strings.Contains(url, "ifGenerationMatch=7")
+				},
+				Method:       "post",
+				ResponseBody: storage.Object{Name: "state.json", Generation: 8},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			backend := getMockedGCSBackend(mockServer.URL)
+
+			s, err := backend.Load()
+			Expect(err).ToNot(HaveOccurred())
+			_, ok := s.Get("banane", "storageBucket.patate")
+			Expect(ok).To(BeTrue())
+			Expect(backend.Save(s)).To(Succeed())
+		})
+		It("refuses to overwrite a state changed by another run", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				Method:       "post",
+				ResponseCode: 412,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			backend := getMockedGCSBackend(mockServer.URL)
+
+			err := backend.Save(New())
+			Expect(err).To(MatchError(ContainSubstring("was changed by another run")))
+		})
+	})
+	Describe("lock", func() {
+		It("takes and releases the lock", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 2)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/upload/storage/v1/b/state-bucket/o?") && strings.Contains(url, "ifGenerationMatch=0")
+				},
+				Method:       "post",
+				ResponseBody: storage.Object{Name: "state.json.lock", Generation: 3},
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/state-bucket/o/state.json.lock?") && strings.Contains(url, "ifGenerationMatch=3")
+				},
+				Method:       "delete",
+				ResponseCode: 204,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			backend := getMockedGCSBackend(mockServer.URL)
+
+			Expect(backend.Lock()).To(Succeed())
+			Expect(backend.Unlock()).To(Succeed())
+		})
+		It("returns who holds the lock when it is taken", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 2)
+			mockServerCalls <- utils.MockServerCall{
+				Method:       "post",
+				ResponseCode: 412,
+			}
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/state-bucket/o/state.json.lock?") && strings.Contains(url, "alt=media")
+				},
+				ResponseBody: LockInfo{Who: "ci@runner-1 (pid 42)", Created: time.Now().UTC()},
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			backend := getMockedGCSBackend(mockServer.URL)
+
+			err := backend.Lock()
+			Expect(err).To(MatchError(ContainSubstring("locked by ci@runner-1 (pid 42)")))
+			Expect(err).To(MatchError(ContainSubstring("state unlock --force")))
+		})
+		It("force unlocks whoever holds the lock", func() {
+			mockServerCalls := make(chan utils.MockServerCall, 1)
+			mockServerCalls <- utils.MockServerCall{
+				UrlMatchFunc: func(url string) bool {
+					return strings.HasPrefix(url, "/b/state-bucket/o/state.json.lock?") && !strings.Contains(url, "ifGenerationMatch")
+				},
+				Method:       "delete",
+				ResponseCode: 204,
+			}
+			mockServer := utils.NewMockServer(mockServerCalls)
+			defer mockServer.Close()
+
+			backend := getMockedGCSBackend(mockServer.URL)
+
+			Expect(backend.ForceUnlock()).To(Succeed())
+		})
+	})
+})
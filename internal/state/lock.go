@@ -0,0 +1,40 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// LockInfo describes the run holding the lock of a state.
+type LockInfo struct {
+	Who     string    `json:"who"`
+	Created time.Time `json:"created"`
+}
+
+func newLockInfo() LockInfo {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	user := os.Getenv("USER")
+	if user == "" {
+		user = "unknown"
+	}
+	return LockInfo{
+		Who:     fmt.Sprintf("%s@%s (pid %d)", user, host, os.Getpid()),
+		Created: time.Now().UTC(),
+	}
+}
+
+// lockedError explains who holds the lock, data is the content of the lock and
+// may be empty if it could not be read.
+func lockedError(location string, data []byte) error {
+	var info LockInfo
+	if err := json.Unmarshal(data, &info); err != nil || info.Who == "" {
+		return fmt.Errorf("state %s is locked by another run, use `fougere-lite state unlock --force` if that run is gone", location)
+	}
+	return fmt.Errorf("state %s is locked by %s since %s, use `fougere-lite state unlock --force` if that run is gone",
+		location, info.Who, info.Created.Format(time.RFC3339))
+}
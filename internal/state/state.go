@@ -0,0 +1,197 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/option"
+)
+
+// FormatVersion is the version of the state format written by this binary.
+const FormatVersion = 1
+
+// Resource is the last applied state of a resource managed by fougere-lite.
+type Resource struct {
+	Product   string          `json:"product"`
+	Key       string          `json:"key"`
+	Name      string          `json:"name"`
+	Spec      json.RawMessage `json:"spec"`
+	Etag      string          `json:"etag,omitempty"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// ID returns the identifier of the resource within its client.
+func (r Resource) ID() string {
+	return ResourceID(r.Product, r.Key)
+}
+
+func ResourceID(product, key string) string {
+	return product + "." + key
+}
+
+// State records, per client and per resource, what fougere-lite applied.
+type State struct {
+	FormatVersion int                            `json:"formatVersion"`
+	Clients       map[string]map[string]Resource `json:"clients"`
+}
+
+func New() *State {
+	return &State{
+		FormatVersion: FormatVersion,
+		Clients:       map[string]map[string]Resource{},
+	}
+}
+
+func (s *State) Set(clientName string, resource Resource) {
+	if s.Clients[clientName] == nil {
+		s.Clients[clientName] = map[string]Resource{}
+	}
+	s.Clients[clientName][resource.ID()] = resource
+}
+
+func (s *State) Get(clientName string, id string) (Resource, bool) {
+	resource, ok := s.Clients[clientName][id]
+	return resource, ok
+}
+
+func (s *State) Remove(clientName string, id string) {
+	delete(s.Clients[clientName], id)
+	if len(s.Clients[clientName]) == 0 {
+		delete(s.Clients, clientName)
+	}
+}
+
+// ClientNames returns the names of the clients of the state in order.
+func (s *State) ClientNames() []string {
+	names := make([]string, 0, len(s.Clients))
+	for name := range s.Clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resources returns the resources of a client ordered by id.
+func (s *State) Resources(clientName string) []Resource {
+	resources := make([]Resource, 0, len(s.Clients[clientName]))
+	for _, resource := range s.Clients[clientName] {
+		resources = append(resources, resource)
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].ID() < resources[j].ID()
+	})
+	return resources
+}
+
+func decode(data []byte) (*State, error) {
+	s := New()
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("error parsing state: %s", err)
+	}
+	if s.FormatVersion != FormatVersion {
+		return nil, fmt.Errorf("state has format version %d, expected %d", s.FormatVersion, FormatVersion)
+	}
+	if s.Clients == nil {
+		s.Clients = map[string]map[string]Resource{}
+	}
+	return s, nil
+}
+
+func encode(s *State) ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// Backend loads and saves the state. A run that changes the state holds the
+// lock from before Load until after Save so that concurrent runs cannot both
+// write it.
+type Backend interface {
+	Load() (*State, error)
+	Save(s *State) error
+	Lock() error
+	Unlock() error
+	// ForceUnlock removes the lock whoever holds it.
+	ForceUnlock() error
+}
+
+// NewBackend returns the backend of a state location, either a gs://BUCKET/OBJECT
+// url or a local path.
+func NewBackend(ctx context.Context, location string, opts ...option.ClientOption) (Backend, error) {
+	if strings.HasPrefix(location, gcsScheme) {
+		bucket, object, err := parseGCSLocation(location)
+		if err != nil {
+			return nil, err
+		}
+		return NewGCSBackend(ctx, bucket, object, opts...)
+	}
+	return NewLocalBackend(location), nil
+}
+
+// LocalBackend keeps the state in a file on the local disk, locked by a lock
+// file next to it.
+type LocalBackend struct {
+	Path string
+}
+
+func NewLocalBackend(path string) *LocalBackend {
+	return &LocalBackend{Path: path}
+}
+
+func (b *LocalBackend) lockPath() string {
+	return b.Path + ".lock"
+}
+
+func (b *LocalBackend) Lock() error {
+	file, err := os.OpenFile(b.lockPath(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		if os.IsExist(err) {
+			data, _ := os.ReadFile(b.lockPath())
+			return lockedError(b.Path, data)
+		}
+		return err
+	}
+	defer file.Close()
+	return json.NewEncoder(file).Encode(newLockInfo())
+}
+
+func (b *LocalBackend) Unlock() error {
+	return os.Remove(b.lockPath())
+}
+
+func (b *LocalBackend) ForceUnlock() error {
+	err := os.Remove(b.lockPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Load returns an empty state when the file does not exist yet.
+func (b *LocalBackend) Load() (*State, error) {
+	data, err := os.ReadFile(b.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return New(), nil
+		}
+		return nil, err
+	}
+	return decode(data)
+}
+
+// Save writes the state to a temporary file first so that an interrupted save
+// does not leave a truncated state behind.
+func (b *LocalBackend) Save(s *State) error {
+	data, err := encode(s)
+	if err != nil {
+		return err
+	}
+	tmpPath := b.Path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, b.Path)
+}
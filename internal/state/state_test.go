@@ -0,0 +1,91 @@
+// ©Copyright 2022 Metrio
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("state", func() {
+	var resource Resource
+
+	BeforeEach(func() {
+		resource = Resource{
+			Product:   "storageBucket",
+			Key:       "patate",
+			Name:      "banane-patate-projet-123",
+			Spec:      []byte(`{"name":"banane-patate-projet-123"}`),
+			Etag:      "CAE=",
+			UpdatedAt: time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+		}
+	})
+
+	Describe("resources", func() {
+		It("sets, gets and removes a resource", func() {
+			s := New()
+			s.Set("banane", resource)
+			got, ok := s.Get("banane", "storageBucket.patate")
+			Expect(ok).To(BeTrue())
+			Expect(got.Name).To(Equal("banane-patate-projet-123"))
+			Expect(s.ClientNames()).To(Equal([]string{"banane"}))
+
+			s.Remove("banane", "storageBucket.patate")
+			_, ok = s.Get("banane", "storageBucket.patate")
+			Expect(ok).To(BeFalse())
+			Expect(s.ClientNames()).To(BeEmpty())
+		})
+		It("lists the resources of a client in order", func() {
+			s := New()
+			s.Set("banane", resource)
+			s.Set("banane", Resource{Product: "cloudTasks", Key: "queue1"})
+			resources := s.Resources("banane")
+			Expect(resources).To(HaveLen(2))
+			Expect(resources[0].ID()).To(Equal("cloudTasks.queue1"))
+			Expect(resources[1].ID()).To(Equal("storageBucket.patate"))
+		})
+	})
+
+	Describe("local backend", func() {
+		var backend *LocalBackend
+
+		BeforeEach(func() {
+			backend = NewLocalBackend(filepath.Join(GinkgoT().TempDir(), "fougere-lite.state.json"))
+		})
+
+		It("loads an empty state when the file does not exist", func() {
+			s, err := backend.Load()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Clients).To(BeEmpty())
+		})
+		It("saves and loads back the state", func() {
+			s := New()
+			s.Set("banane", resource)
+			Expect(backend.Save(s)).To(Succeed())
+
+			loaded, err := backend.Load()
+			Expect(err).ToNot(HaveOccurred())
+			got, ok := loaded.Get("banane", "storageBucket.patate")
+			Expect(ok).To(BeTrue())
+			Expect(got.Etag).To(Equal("CAE="))
+			Expect(got.UpdatedAt).To(Equal(resource.UpdatedAt))
+			Expect(string(got.Spec)).To(MatchJSON(`{"name":"banane-patate-projet-123"}`))
+		})
+		It("refuses a second lock until the first one is released", func() {
+			Expect(backend.Lock()).To(Succeed())
+			Expect(backend.Lock()).To(MatchError(ContainSubstring("is locked by")))
+			Expect(backend.Unlock()).To(Succeed())
+			Expect(backend.Lock()).To(Succeed())
+			Expect(backend.ForceUnlock()).To(Succeed())
+			Expect(backend.ForceUnlock()).To(Succeed())
+		})
+		It("returns an error for a state with an unknown format version", func() {
+			Expect(os.WriteFile(backend.Path, []byte(`{"formatVersion": 99}`), 0o644)).To(Succeed())
+			_, err := backend.Load()
+			Expect(err).To(MatchError(ContainSubstring("format version")))
+		})
+	})
+})